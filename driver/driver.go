@@ -57,6 +57,7 @@ func (o *Options) internalOptions() *plugin.Options {
 		UI:            o.UI,
 		HTTPServer:    httpServer,
 		HTTPTransport: o.HTTPTransport,
+		HTTPFetch:     (*plugin.HTTPFetchOptions)(o.HTTPFetch),
 	}
 }
 
@@ -64,6 +65,10 @@ func (o *Options) internalOptions() *plugin.Options {
 // is exporting a pprof web interface.
 type HTTPServerArgs plugin.HTTPServerArgs
 
+// HTTPFetchOptions configures the HTTP requests fetching profiles and
+// symbols: headers, proxy and retries.
+type HTTPFetchOptions plugin.HTTPFetchOptions
+
 // Options groups all the optional plugins into pprof.
 type Options struct {
 	Writer        Writer
@@ -74,6 +79,7 @@ type Options struct {
 	UI            UI
 	HTTPServer    func(*HTTPServerArgs) error
 	HTTPTransport http.RoundTripper
+	HTTPFetch     *HTTPFetchOptions
 }
 
 // Writer provides a mechanism to write data under a certain name,
@@ -6,6 +6,7 @@ require (
 	github.com/chromedp/chromedp v0.9.2
 	github.com/chzyer/readline v1.5.1
 	github.com/ianlancetaylor/demangle v0.0.0-20230524184225-eabc099b10ab
+	golang.org/x/arch v0.4.0
 )
 
 require (
@@ -32,6 +32,12 @@ const (
 	// addr2line may produce multiple lines of output. We
 	// use this sentinel to identify the end of the output.
 	sentinel = ^uint64(0)
+
+	// maxBatch is the maximum number of addresses written to a symbolizer
+	// before reading its output. It keeps the input written at once small
+	// enough to fit in the pipe buffer, so writing never blocks while the
+	// symbolizer waits for its output to be read.
+	maxBatch = 32
 )
 
 // addr2Liner is a connection to an addr2line command for obtaining
@@ -170,45 +176,71 @@ func (d *addr2Liner) readFrame() (plugin.Frame, bool) {
 		Line: linenumber}, false
 }
 
-func (d *addr2Liner) rawAddrInfo(addr uint64) ([]plugin.Frame, error) {
+// rawAddrInfos returns the stack frame information reported by addr2line
+// for each of addrs. The addresses are written in batches, reading the
+// output of each batch before writing the next one.
+func (d *addr2Liner) rawAddrInfos(addrs []uint64) ([][]plugin.Frame, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if err := d.rw.write(fmt.Sprintf("%x", addr-d.base)); err != nil {
-		return nil, err
-	}
+	stacks := make([][]plugin.Frame, 0, len(addrs))
+	for len(addrs) > 0 {
+		batch := addrs
+		if len(batch) > maxBatch {
+			batch = batch[:maxBatch]
+		}
+		addrs = addrs[len(batch):]
 
-	if err := d.rw.write(fmt.Sprintf("%x", sentinel)); err != nil {
-		return nil, err
-	}
+		for _, addr := range batch {
+			if err := d.rw.write(fmt.Sprintf("%x", addr-d.base)); err != nil {
+				return nil, err
+			}
+			if err := d.rw.write(fmt.Sprintf("%x", sentinel)); err != nil {
+				return nil, err
+			}
+		}
 
-	resp, err := d.rw.readLine()
-	if err != nil {
-		return nil, err
-	}
+		for range batch {
+			resp, err := d.rw.readLine()
+			if err != nil {
+				return nil, err
+			}
 
-	if !strings.HasPrefix(resp, "0x") {
-		return nil, fmt.Errorf("unexpected addr2line output: %s", resp)
-	}
+			if !strings.HasPrefix(resp, "0x") {
+				return nil, fmt.Errorf("unexpected addr2line output: %s", resp)
+			}
 
-	var stack []plugin.Frame
-	for {
-		frame, end := d.readFrame()
-		if end {
-			break
-		}
+			var stack []plugin.Frame
+			for {
+				frame, end := d.readFrame()
+				if end {
+					break
+				}
 
-		if frame != (plugin.Frame{}) {
-			stack = append(stack, frame)
+				if frame != (plugin.Frame{}) {
+					stack = append(stack, frame)
+				}
+			}
+			stacks = append(stacks, stack)
 		}
 	}
-	return stack, err
+	return stacks, nil
 }
 
 // addrInfo returns the stack frame information for a specific program
 // address. It returns nil if the address could not be identified.
 func (d *addr2Liner) addrInfo(addr uint64) ([]plugin.Frame, error) {
-	stack, err := d.rawAddrInfo(addr)
+	stacks, err := d.addrInfos([]uint64{addr})
+	if err != nil {
+		return nil, err
+	}
+	return stacks[0], nil
+}
+
+// addrInfos returns the stack frame information for each of addrs, as
+// addrInfo does, sending the addresses to addr2line in batches.
+func (d *addr2Liner) addrInfos(addrs []uint64) ([][]plugin.Frame, error) {
+	stacks, err := d.rawAddrInfos(addrs)
 	if err != nil {
 		return nil, err
 	}
@@ -216,8 +248,11 @@ func (d *addr2Liner) addrInfo(addr uint64) ([]plugin.Frame, error) {
 	// Certain versions of addr2line produce incomplete names due to
 	// https://sourceware.org/bugzilla/show_bug.cgi?id=17541. Attempt to replace
 	// the name with a better one from nm.
-	if len(stack) > 0 && d.nm != nil {
-		nm, err := d.nm.addrInfo(addr)
+	for i, stack := range stacks {
+		if len(stack) == 0 || d.nm == nil {
+			continue
+		}
+		nm, err := d.nm.addrInfo(addrs[i])
 		if err == nil && len(nm) > 0 {
 			// Last entry in frame list should match since it is non-inlined. As a
 			// simple heuristic, we only switch to the nm-based name if it is longer
@@ -234,5 +269,5 @@ func (d *addr2Liner) addrInfo(addr uint64) ([]plugin.Frame, error) {
 		}
 	}
 
-	return stack, nil
+	return stacks, nil
 }
@@ -0,0 +1,318 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// attrMIPSLinkageName is the pre-DWARF 4 attribute for linkage names, still
+// emitted by some compilers.
+const attrMIPSLinkageName dwarf.Attr = 0x2007
+
+// addr2LinerDWARF maps addresses to source locations, including inlined
+// functions, by reading the DWARF debug information of an ELF binary in
+// process. Compressed debug sections are supported.
+type addr2LinerDWARF struct {
+	sync.Mutex
+	data *dwarf.Data
+	syms *symbolTable
+	base uint64
+
+	// unitRanges maps address ranges to the units covering them, sorted
+	// by start address.
+	unitRanges []dwarfRange
+	names      map[dwarf.Offset]string // Function names by DIE offset.
+}
+
+// dwarfUnit holds the information about a compilation unit needed to
+// symbolize its addresses. It is read on first use.
+type dwarfUnit struct {
+	entry  *dwarf.Entry
+	loaded bool
+	lines  lineTable
+	files  []*dwarf.LineFile
+	// funcRanges maps address ranges to the outermost functions covering
+	// them, sorted by start address.
+	funcRanges []dwarfRange
+}
+
+// dwarfFunc is a subprogram, or a subroutine inlined into it.
+type dwarfFunc struct {
+	name    string
+	ranges  [][2]uint64
+	inlined []*dwarfFunc
+
+	// Location of the call for inlined subroutines.
+	callFile             string
+	callLine, callColumn int
+}
+
+// dwarfRange is an address range of a compilation unit or a function.
+type dwarfRange struct {
+	low, high uint64
+	unit      *dwarfUnit
+	fn        *dwarfFunc
+}
+
+// newAddr2LinerDWARF reads the debug information of the given ELF file.
+// If file is a shared library, base should be the address at which it was
+// mapped in the program under consideration.
+func newAddr2LinerDWARF(file string, base uint64) (*addr2LinerDWARF, error) {
+	ef, err := elfOpen(file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", file, err)
+	}
+	defer ef.Close()
+	d, err := ef.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("error reading DWARF data of %s: %v", file, err)
+	}
+	a := &addr2LinerDWARF{
+		data:  d,
+		syms:  newSymbolTable(ef),
+		base:  base,
+		names: map[dwarf.Offset]string{},
+	}
+	if err := a.readUnits(); err != nil {
+		return nil, fmt.Errorf("error reading DWARF data of %s: %v", file, err)
+	}
+	return a, nil
+}
+
+// hasDWARF reports whether the ELF file has DWARF debug information,
+// possibly compressed.
+func hasDWARF(ef *elf.File) bool {
+	return ef.Section(".debug_info") != nil || ef.Section(".zdebug_info") != nil
+}
+
+// readUnits indexes the compilation units by the addresses they cover.
+func (a *addr2LinerDWARF) readUnits() error {
+	r := a.data.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			break
+		}
+		r.SkipChildren()
+		if e.Tag != dwarf.TagCompileUnit {
+			continue
+		}
+		ranges, err := a.data.Ranges(e)
+		if err != nil {
+			continue
+		}
+		u := &dwarfUnit{entry: e}
+		for _, rng := range ranges {
+			a.unitRanges = append(a.unitRanges, dwarfRange{low: rng[0], high: rng[1], unit: u})
+		}
+	}
+	sortRanges(a.unitRanges)
+	return nil
+}
+
+// addrInfo returns the stack frame information for a specific program
+// address, innermost inlined function first. It returns nil if the
+// address could not be identified.
+func (a *addr2LinerDWARF) addrInfo(addr uint64) ([]plugin.Frame, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	addr -= a.base
+	var stack []plugin.Frame
+	var loc *dwarf.LineEntry
+	if r := findRange(a.unitRanges, addr); r != nil {
+		u := r.unit
+		if !u.loaded {
+			if err := a.loadUnit(u); err != nil {
+				return nil, err
+			}
+		}
+		loc = u.lines.find(addr)
+
+		// Chain of functions containing addr, outermost first.
+		var chain []*dwarfFunc
+		if r := findRange(u.funcRanges, addr); r != nil {
+			for fn := r.fn; fn != nil; {
+				chain = append(chain, fn)
+				fn = fn.find(addr)
+			}
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			frame := plugin.Frame{Func: chain[i].name}
+			if i == len(chain)-1 {
+				if loc != nil {
+					frame.File, frame.Line, frame.Column = loc.File.Name, loc.Line, loc.Column
+				}
+			} else {
+				callee := chain[i+1]
+				frame.File, frame.Line, frame.Column = callee.callFile, callee.callLine, callee.callColumn
+			}
+			stack = append(stack, frame)
+		}
+	}
+	if len(stack) == 0 {
+		// No function debug information, fall back to the symbol table.
+		var frame plugin.Frame
+		if s := a.syms.find(addr); s != nil {
+			frame.Func = s.Name
+		}
+		if loc != nil {
+			frame.File, frame.Line, frame.Column = loc.File.Name, loc.Line, loc.Column
+		}
+		if frame != (plugin.Frame{}) {
+			stack = append(stack, frame)
+		}
+	}
+	return stack, nil
+}
+
+// loadUnit reads the line table and the functions of a compilation unit.
+func (a *addr2LinerDWARF) loadUnit(u *dwarfUnit) error {
+	u.loaded = true
+	entries, files := readLineEntries(a.data, u.entry)
+	u.lines, u.files = lineTable(entries), files
+	u.lines.sort()
+
+	r := a.data.Reader()
+	r.Seek(u.entry.Offset)
+	if _, err := r.Next(); err != nil {
+		return err
+	}
+	if u.entry.Children {
+		if err := a.readFuncs(r, u, nil); err != nil {
+			return err
+		}
+	}
+	sortRanges(u.funcRanges)
+	return nil
+}
+
+// readFuncs reads the children of the current entry of r, adding the
+// subprograms with code to u and the inlined subroutines to parent.
+func (a *addr2LinerDWARF) readFuncs(r *dwarf.Reader, u *dwarfUnit, parent *dwarfFunc) error {
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil || e.Tag == 0 {
+			return nil
+		}
+		next := parent
+		switch e.Tag {
+		case dwarf.TagSubprogram, dwarf.TagInlinedSubroutine:
+			ranges, err := a.data.Ranges(e)
+			if err != nil || len(ranges) == 0 {
+				// Declarations and abstract instances have no code.
+				break
+			}
+			fn := &dwarfFunc{name: a.funcName(e), ranges: ranges}
+			if e.Tag == dwarf.TagInlinedSubroutine && parent != nil {
+				if i, ok := e.Val(dwarf.AttrCallFile).(int64); ok && i >= 0 && int(i) < len(u.files) && u.files[i] != nil {
+					fn.callFile = u.files[i].Name
+				}
+				if l, ok := e.Val(dwarf.AttrCallLine).(int64); ok {
+					fn.callLine = int(l)
+				}
+				if c, ok := e.Val(dwarf.AttrCallColumn).(int64); ok {
+					fn.callColumn = int(c)
+				}
+				parent.inlined = append(parent.inlined, fn)
+			} else {
+				for _, rng := range ranges {
+					u.funcRanges = append(u.funcRanges, dwarfRange{low: rng[0], high: rng[1], fn: fn})
+				}
+			}
+			next = fn
+		}
+		if e.Children {
+			if err := a.readFuncs(r, u, next); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// funcName returns the name of the function described by e. Like
+// llvm-symbolizer with demangling disabled, it prefers linkage names,
+// which are mangled for C++ functions.
+func (a *addr2LinerDWARF) funcName(e *dwarf.Entry) string {
+	if name, ok := a.names[e.Offset]; ok {
+		return name
+	}
+	var name string
+	for _, attr := range []dwarf.Attr{dwarf.AttrLinkageName, attrMIPSLinkageName, dwarf.AttrName} {
+		if n, ok := e.Val(attr).(string); ok {
+			name = n
+			break
+		}
+	}
+	if name == "" {
+		// Concrete instances of inlined or out-of-line functions refer to
+		// their abstract instance, and definitions to their declaration.
+		for _, attr := range []dwarf.Attr{dwarf.AttrAbstractOrigin, dwarf.AttrSpecification} {
+			off, ok := e.Val(attr).(dwarf.Offset)
+			if !ok {
+				continue
+			}
+			a.names[e.Offset] = "" // Guard against reference cycles.
+			r := a.data.Reader()
+			r.Seek(off)
+			if origin, err := r.Next(); err == nil && origin != nil {
+				name = a.funcName(origin)
+			}
+			break
+		}
+	}
+	a.names[e.Offset] = name
+	return name
+}
+
+// find returns the subroutine inlined into fn at addr, or nil if there is
+// none.
+func (fn *dwarfFunc) find(addr uint64) *dwarfFunc {
+	for _, in := range fn.inlined {
+		for _, rng := range in.ranges {
+			if addr >= rng[0] && addr < rng[1] {
+				return in
+			}
+		}
+	}
+	return nil
+}
+
+func sortRanges(ranges []dwarfRange) {
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].low < ranges[j].low })
+}
+
+// findRange returns the range containing addr in a sorted slice of
+// non-overlapping ranges, or nil if there is none.
+func findRange(ranges []dwarfRange, addr uint64) *dwarfRange {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].low > addr }) - 1
+	if i < 0 || addr >= ranges[i].high {
+		return nil
+	}
+	return &ranges[i]
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// The expected frames match the output of llvm-symbolizer --inlining.
+var dwarfTestCases = []struct {
+	file string
+	addr uint64
+	want []plugin.Frame
+}{
+	{"exe_linux_64", 0x40052d, []plugin.Frame{
+		{Func: "main", File: "/tmp/hello.c", Line: 3},
+	}},
+	{"exe_linux_64", 0x400531, []plugin.Frame{
+		{Func: "main", File: "/tmp/hello.c", Line: 4},
+	}},
+	{"exe_linux_64_inline", 0x1139, []plugin.Frame{
+		{Func: "main", File: "/tmp/inline.c", Line: 15, Column: 33},
+	}},
+	{"exe_linux_64_inline", 0x1150, []plugin.Frame{
+		{Func: "square", File: "/tmp/inline.c", Line: 4, Column: 12},
+		{Func: "sum_squares", File: "/tmp/inline.c", Line: 10, Column: 10},
+		{Func: "main", File: "/tmp/inline.c", Line: 16, Column: 3},
+	}},
+	{"exe_linux_64_inline", 0x1155, []plugin.Frame{
+		{Func: "sum_squares", File: "/tmp/inline.c", Line: 9, Column: 27},
+		{Func: "main", File: "/tmp/inline.c", Line: 16, Column: 3},
+	}},
+	{"exe_linux_64_inline", 0x115c, []plugin.Frame{
+		{Func: "main", File: "/tmp/inline.c", Line: 16, Column: 3},
+	}},
+	// PLT entries have neither debug information nor symbols of their own.
+	{"exe_linux_64_inline", 0x1030, []plugin.Frame{
+		{Func: "_init"},
+	}},
+	{"exe_linux_64_inline", 0x10, nil},
+}
+
+func TestAddr2LinerDWARF(t *testing.T) {
+	liners := map[string]*addr2LinerDWARF{}
+	for _, tc := range dwarfTestCases {
+		a := liners[tc.file]
+		if a == nil {
+			var err error
+			// exe_linux_64_inline has compressed debug sections.
+			if a, err = newAddr2LinerDWARF(filepath.Join("testdata", tc.file), 0); err != nil {
+				t.Fatalf("newAddr2LinerDWARF(%s): %v", tc.file, err)
+			}
+			liners[tc.file] = a
+		}
+		got, err := a.addrInfo(tc.addr)
+		if err != nil {
+			t.Fatalf("%s %#x: %v", tc.file, tc.addr, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %#x: got %v, want %v", tc.file, tc.addr, got, tc.want)
+		}
+	}
+
+	if _, err := newAddr2LinerDWARF(filepath.Join("testdata", "malformed_elf"), 0); err == nil {
+		t.Error("newAddr2LinerDWARF(malformed_elf): got no error")
+	}
+}
+
+func TestOpenWithoutSymbolizer(t *testing.T) {
+	// No addr2line or llvm-symbolizer available.
+	bu := &Binutils{rep: &binrep{}}
+	// A mapping of the text segment of the binary, loaded as a position
+	// independent executable.
+	f, err := bu.Open(filepath.Join("testdata", "exe_linux_64_inline"), 0x7f0000001000, 0x7f0000002000, 0x1000, "")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if _, ok := f.(*fileDWARF); !ok {
+		t.Fatalf("Open: got %T, want *fileDWARF", f)
+	}
+	got, err := f.SourceLine(0x7f0000001155)
+	if err != nil {
+		t.Fatalf("SourceLine: %v", err)
+	}
+	want := []plugin.Frame{
+		{Func: "sum_squares", File: "/tmp/inline.c", Line: 9, Column: 27},
+		{Func: "main", File: "/tmp/inline.c", Line: 16, Column: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceLine: got %v, want %v", got, want)
+	}
+	f.Close()
+	if _, err := f.SourceLine(0x7f0000001155); err == nil {
+		t.Error("SourceLine after Close: got no error")
+	}
+
+	bu.SetFastSymbolization(true)
+	if f, err = bu.Open(filepath.Join("testdata", "exe_linux_64_inline"), 0x7f0000001000, 0x7f0000002000, 0x1000, ""); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, ok := f.(*fileNM); !ok {
+		t.Errorf("Open with fast symbolization: got %T, want *fileNM", f)
+	}
+}
+
+func BenchmarkSourceLine(b *testing.B) {
+	llvmSymbolizer, llvmSymbolizerFound := chooseExe([]string{"llvm-symbolizer"}, []string{}, []string{""})
+	for _, tc := range []struct {
+		name      string
+		available bool
+		open      func(file string) (func(addr uint64) ([]plugin.Frame, error), func(), error)
+	}{
+		{"dwarf", true, func(file string) (func(addr uint64) ([]plugin.Frame, error), func(), error) {
+			a, err := newAddr2LinerDWARF(file, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			return a.addrInfo, func() {}, nil
+		}},
+		{"llvm-symbolizer", llvmSymbolizerFound, func(file string) (func(addr uint64) ([]plugin.Frame, error), func(), error) {
+			a, err := newLLVMSymbolizer(llvmSymbolizer, file, 0, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			return a.addrInfo, a.rw.close, nil
+		}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			if !tc.available {
+				b.Skipf("%s not available", tc.name)
+			}
+			addrInfo := map[string]func(addr uint64) ([]plugin.Frame, error){}
+			for _, c := range dwarfTestCases {
+				if addrInfo[c.file] != nil {
+					continue
+				}
+				f, done, err := tc.open(filepath.Join("testdata", c.file))
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer done()
+				addrInfo[c.file] = f
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c := dwarfTestCases[i%len(dwarfTestCases)]
+				if _, err := addrInfo[c.file](c.addr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
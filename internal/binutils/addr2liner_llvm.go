@@ -164,24 +164,48 @@ func (d *llvmSymbolizer) readFrame() (plugin.Frame, bool) {
 // addrInfo returns the stack frame information for a specific program
 // address. It returns nil if the address could not be identified.
 func (d *llvmSymbolizer) addrInfo(addr uint64) ([]plugin.Frame, error) {
+	stacks, err := d.addrInfos([]uint64{addr})
+	if err != nil {
+		return nil, err
+	}
+	return stacks[0], nil
+}
+
+// addrInfos returns the stack frame information for each of addrs, as
+// addrInfo does, sending the addresses to llvm-symbolizer in batches.
+func (d *llvmSymbolizer) addrInfos(addrs []uint64) ([][]plugin.Frame, error) {
 	d.Lock()
 	defer d.Unlock()
 
-	if err := d.rw.write(fmt.Sprintf("%s 0x%x", d.filename, addr-d.base)); err != nil {
-		return nil, err
-	}
+	stacks := make([][]plugin.Frame, 0, len(addrs))
+	for len(addrs) > 0 {
+		batch := addrs
+		if len(batch) > maxBatch {
+			batch = batch[:maxBatch]
+		}
+		addrs = addrs[len(batch):]
 
-	var stack []plugin.Frame
-	for {
-		frame, end := d.readFrame()
-		if end {
-			break
+		for _, addr := range batch {
+			if err := d.rw.write(fmt.Sprintf("%s 0x%x", d.filename, addr-d.base)); err != nil {
+				return nil, err
+			}
 		}
 
-		if frame != (plugin.Frame{}) {
-			stack = append(stack, frame)
+		for range batch {
+			var stack []plugin.Frame
+			for {
+				frame, end := d.readFrame()
+				if end {
+					break
+				}
+
+				if frame != (plugin.Frame{}) {
+					stack = append(stack, frame)
+				}
+			}
+			stacks = append(stacks, stack)
 		}
 	}
 
-	return stack, nil
+	return stacks, nil
 }
@@ -255,11 +255,12 @@ func findExe(cmd string, paths []string) (string, bool) {
 }
 
 // Disasm returns the assembly instructions for the specified address range
-// of a binary.
+// of a binary. If no objdump is available, x86-64 and arm64 ELF binaries
+// are disassembled in process.
 func (bu *Binutils) Disasm(file string, start, end uint64, intelSyntax bool) ([]plugin.Inst, error) {
 	b := bu.get()
 	if !b.objdumpFound {
-		return nil, errors.New("cannot disasm: no objdump tool available")
+		return disassembleNative(file, start, end, intelSyntax)
 	}
 	args := []string{"--disassemble", "--demangle", "--no-show-raw-insn",
 		"--line-numbers", fmt.Sprintf("--start-address=%#x", start),
@@ -482,6 +483,14 @@ func (b *binrep) openELF(name string, start, limit, offset uint64, relocationSym
 	}
 
 	if b.fast || (!b.addr2lineFound && !b.llvmSymbolizerFound) {
+		if !b.fast && hasDWARF(ef) {
+			return &fileDWARF{file: file{
+				b:       b,
+				name:    name,
+				buildID: buildID,
+				m:       &elfMapping{start: start, limit: limit, offset: offset, kernelOffset: kernelOffset},
+			}}, nil
+		}
 		return &fileNM{file: file{
 			b:       b,
 			name:    name,
@@ -680,6 +689,44 @@ func (f *fileNM) SourceLine(addr uint64) ([]plugin.Frame, error) {
 	return f.addr2linernm.addrInfo(addr)
 }
 
+// fileDWARF implements the binutils.ObjFile interface, reading the DWARF
+// debug information of ELF files in process to map addresses to symbols
+// (with file/line number information). It is used when neither
+// llvm-symbolizer nor addr2line are available.
+type fileDWARF struct {
+	once sync.Once
+	file
+	addr2liner *addr2LinerDWARF
+	initErr    error
+}
+
+func (f *fileDWARF) SourceLine(addr uint64) ([]plugin.Frame, error) {
+	f.baseOnce.Do(func() { f.baseErr = f.computeBase(addr) })
+	if f.baseErr != nil {
+		return nil, f.baseErr
+	}
+	f.once.Do(func() { f.addr2liner, f.initErr = newAddr2LinerDWARF(f.name, f.base) })
+	if f.initErr != nil {
+		return nil, f.initErr
+	}
+	if f.addr2liner == nil {
+		return nil, fmt.Errorf("%s: file is closed", f.name)
+	}
+	return f.addr2liner.addrInfo(addr)
+}
+
+// Symbolizer describes how the source lines are looked up.
+func (f *fileDWARF) Symbolizer() string {
+	return "dwarf"
+}
+
+func (f *fileDWARF) Close() error {
+	// Keep a later SourceLine from reading the debug information again.
+	f.once.Do(func() {})
+	f.addr2liner = nil
+	return nil
+}
+
 // fileAddr2Line implements the binutils.ObjFile interface, using
 // llvm-symbolizer, if that's available, or addr2line to map addresses to
 // symbols (with file/line number information). It can be slow for large
@@ -707,6 +754,36 @@ func (f *fileAddr2Line) SourceLine(addr uint64) ([]plugin.Frame, error) {
 	return nil, fmt.Errorf("could not find local addr2liner")
 }
 
+// SourceLines reports the source line information for each of addrs, as
+// SourceLine does, looking them up in batches to save round trips to the
+// symbolizer.
+func (f *fileAddr2Line) SourceLines(addrs []uint64) ([][]plugin.Frame, error) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	f.baseOnce.Do(func() { f.baseErr = f.computeBase(addrs[0]) })
+	if f.baseErr != nil {
+		return nil, f.baseErr
+	}
+	f.once.Do(f.init)
+	if f.llvmSymbolizer != nil {
+		return f.llvmSymbolizer.addrInfos(addrs)
+	}
+	if f.addr2liner != nil {
+		return f.addr2liner.addrInfos(addrs)
+	}
+	return nil, fmt.Errorf("could not find local addr2liner")
+}
+
+// Symbolizer describes the tool and options used to look up the source
+// lines, llvm-symbolizer if available or else addr2line.
+func (f *fileAddr2Line) Symbolizer() string {
+	if f.b.llvmSymbolizerFound {
+		return "llvm-symbolizer --inlining -demangle=false"
+	}
+	return "addr2line -aif"
+}
+
 func (f *fileAddr2Line) init() {
 	if llvmSymbolizer, err := newLLVMSymbolizer(f.b.llvmSymbolizer, f.name, f.base, f.isData); err == nil {
 		f.llvmSymbolizer = llvmSymbolizer
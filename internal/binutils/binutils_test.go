@@ -76,6 +76,35 @@ func TestAddr2Liner(t *testing.T) {
 	a.rw.close()
 }
 
+func TestAddr2LinerBatch(t *testing.T) {
+	const offset = 0x500
+
+	// More addresses than fit in a batch, with some repeated and some
+	// unknown.
+	var addrs []uint64
+	for i := 0; i < 3*maxBatch; i++ {
+		addrs = append(addrs, uint64(i%12*0x1000+offset))
+	}
+	a := addr2Liner{rw: &mockAddr2liner{}, base: offset}
+	defer a.rw.close()
+	got, err := a.addrInfos(addrs)
+	if err != nil {
+		t.Fatalf("addrInfos: %v", err)
+	}
+	if len(got) != len(addrs) {
+		t.Fatalf("addrInfos: got %d stacks, want %d", len(got), len(addrs))
+	}
+	for i, addr := range addrs {
+		want, err := a.addrInfo(addr)
+		if err != nil {
+			t.Fatalf("addrInfo(%#x): %v", addr, err)
+		}
+		if !reflect.DeepEqual(got[i], want) {
+			t.Errorf("addrInfos()[%d] for %#x: got %v, want %v", i, addr, got[i], want)
+		}
+	}
+}
+
 type mockAddr2liner struct {
 	output []string
 }
@@ -490,6 +519,39 @@ func TestLLVMSymbolizer(t *testing.T) {
 	}
 }
 
+func TestLLVMSymbolizerBatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("testtdata/llvm-symbolizer has only been tested on linux")
+	}
+
+	symbolizer, err := newLLVMSymbolizer(filepath.Join("testdata", "fake-llvm-symbolizer"), "foo", 0, false)
+	if err != nil {
+		t.Fatalf("newLLVMSymbolizer: unexpected error %v", err)
+	}
+	defer symbolizer.rw.close()
+
+	var addrs []uint64
+	for i := 0; i < 2*maxBatch+1; i++ {
+		addrs = append(addrs, uint64(i*0x10))
+	}
+	stacks, err := symbolizer.addrInfos(addrs)
+	if err != nil {
+		t.Fatalf("addrInfos: unexpected error %v", err)
+	}
+	if len(stacks) != len(addrs) {
+		t.Fatalf("addrInfos: got %d stacks, want %d", len(stacks), len(addrs))
+	}
+	for i, addr := range addrs {
+		want := []plugin.Frame{
+			{Func: fmt.Sprintf("Inlined_%#x", addr), File: "foo.h"},
+			{Func: fmt.Sprintf("Func_%#x", addr), File: "foo.c", Line: 2, Column: 1},
+		}
+		if !reflect.DeepEqual(stacks[i], want) {
+			t.Errorf("addrInfos()[%d]: got %v, want %v", i, stacks[i], want)
+		}
+	}
+}
+
 func TestPEFile(t *testing.T) {
 	// If this test fails, check the address for main function in testdata/exe_windows_64.exe
 	// using the command 'nm -n '. Update the hardcoded addresses below to match
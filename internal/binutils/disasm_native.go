@@ -0,0 +1,248 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/ianlancetaylor/demangle"
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// disassembleNative disassembles the specified address range of an x86-64
+// or arm64 ELF binary without relying on an external objdump. Source
+// file and line information is read from the DWARF line tables of the
+// binary, if present.
+func disassembleNative(file string, start, end uint64, intelSyntax bool) ([]plugin.Inst, error) {
+	ef, err := elfOpen(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot disasm: no objdump tool available and %s is not an ELF file: %v", file, err)
+	}
+	defer ef.Close()
+
+	switch ef.Machine {
+	case elf.EM_X86_64, elf.EM_AARCH64:
+	default:
+		return nil, fmt.Errorf("cannot disasm: no objdump tool available and %s machine %v is not supported", file, ef.Machine)
+	}
+
+	syms := newSymbolTable(ef)
+	var lines lineTable
+	if d, err := ef.DWARF(); err == nil {
+		lines = newLineTable(d, start, end)
+	}
+
+	var assembly []plugin.Inst
+	for _, s := range ef.Sections {
+		if s.Type != elf.SHT_PROGBITS || s.Flags&(elf.SHF_ALLOC|elf.SHF_EXECINSTR) != elf.SHF_ALLOC|elf.SHF_EXECINSTR {
+			continue
+		}
+		if s.Addr+s.Size <= start || s.Addr > end {
+			continue
+		}
+		code, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s section %s: %v", file, s.Name, err)
+		}
+		from := uint64(0)
+		if start > s.Addr {
+			from = start - s.Addr
+		}
+		for _, inst := range decode(ef.Machine, code[from:], s.Addr+from, end, intelSyntax, syms.lookup) {
+			inst.Function, _ = syms.lookup(inst.Addr)
+			inst.File, inst.Line = lines.lookup(inst.Addr)
+			assembly = append(assembly, inst)
+		}
+	}
+	return assembly, nil
+}
+
+// decode decodes the instructions in code, which is located at address
+// addr, up to and including the instruction starting at address end.
+// Undecodable bytes are reported as "(bad)" instructions, like objdump does.
+func decode(machine elf.Machine, code []byte, addr, end uint64, intelSyntax bool, symname func(uint64) (string, uint64)) []plugin.Inst {
+	var assembly []plugin.Inst
+	for len(code) > 0 && addr <= end {
+		text, size := "(bad)", 1
+		switch machine {
+		case elf.EM_X86_64:
+			if inst, err := x86asm.Decode(code, 64); err == nil {
+				size = inst.Len
+				if intelSyntax {
+					text = x86asm.IntelSyntax(inst, addr, symname)
+				} else {
+					text = x86asm.GNUSyntax(inst, addr, symname)
+				}
+			}
+		case elf.EM_AARCH64:
+			size = 4
+			if inst, err := arm64asm.Decode(code); err == nil {
+				text = strings.TrimSpace(arm64asm.GNUSyntax(inst))
+			}
+		}
+		if size > len(code) {
+			size = len(code)
+		}
+		assembly = append(assembly, plugin.Inst{Addr: addr, Text: text})
+		code = code[size:]
+		if addr+uint64(size) < addr {
+			break // Address overflow.
+		}
+		addr += uint64(size)
+	}
+	return assembly
+}
+
+// symbolTable maps addresses to the ELF symbols containing them.
+type symbolTable struct {
+	syms      []elf.Symbol // Sorted by address.
+	demangled map[string]string
+}
+
+func newSymbolTable(ef *elf.File) *symbolTable {
+	syms, err := ef.Symbols()
+	if err != nil || len(syms) == 0 {
+		syms, _ = ef.DynamicSymbols()
+	}
+	t := &symbolTable{demangled: map[string]string{}}
+	for _, s := range syms {
+		if typ := elf.ST_TYPE(s.Info); (typ == elf.STT_FUNC || typ == elf.STT_OBJECT) && s.Value != 0 {
+			t.syms = append(t.syms, s)
+		}
+	}
+	sort.SliceStable(t.syms, func(i, j int) bool { return t.syms[i].Value < t.syms[j].Value })
+	return t
+}
+
+// find returns the symbol containing addr, or nil if there is none.
+func (t *symbolTable) find(addr uint64) *elf.Symbol {
+	i := sort.Search(len(t.syms), func(i int) bool { return t.syms[i].Value > addr }) - 1
+	if i < 0 {
+		return nil
+	}
+	s := &t.syms[i]
+	if s.Size != 0 && addr >= s.Value+s.Size {
+		return nil
+	}
+	return s
+}
+
+// lookup returns the demangled name and the address of the symbol
+// containing addr, or "" if there is none. It has the signature expected
+// by the instruction formatters.
+func (t *symbolTable) lookup(addr uint64) (string, uint64) {
+	s := t.find(addr)
+	if s == nil {
+		return "", 0
+	}
+	name, ok := t.demangled[s.Name]
+	if !ok {
+		name = demangle.Filter(s.Name)
+		t.demangled[s.Name] = name
+	}
+	return name, s.Value
+}
+
+// lineTable maps addresses to source lines, as described by the DWARF
+// line tables.
+type lineTable []dwarf.LineEntry // Sorted by address.
+
+// newLineTable returns the line table entries of the compilation units
+// covering any address in [start, end].
+func newLineTable(d *dwarf.Data, start, end uint64) lineTable {
+	var lines lineTable
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		r.SkipChildren()
+		if ranges, err := d.Ranges(e); err == nil && len(ranges) > 0 && !overlaps(ranges, start, end) {
+			continue
+		}
+		entries, _ := readLineEntries(d, e)
+		lines = append(lines, entries...)
+	}
+	lines.sort()
+	return lines
+}
+
+// readLineEntries returns the line table entries of a compilation unit
+// and the files they refer to.
+func readLineEntries(d *dwarf.Data, cu *dwarf.Entry) ([]dwarf.LineEntry, []*dwarf.LineFile) {
+	lr, err := d.LineReader(cu)
+	if err != nil || lr == nil {
+		return nil, nil
+	}
+	var lines []dwarf.LineEntry
+	var le dwarf.LineEntry
+	for lr.Next(&le) == nil {
+		lines = append(lines, le)
+	}
+	return lines, lr.Files()
+}
+
+// sort sorts the line table by address.
+func (t lineTable) sort() {
+	// The end of a sequence may share its address with the start of the
+	// next one, which must take precedence.
+	sort.SliceStable(t, func(i, j int) bool {
+		if t[i].Address != t[j].Address {
+			return t[i].Address < t[j].Address
+		}
+		return t[i].EndSequence && !t[j].EndSequence
+	})
+}
+
+// find returns the line table entry describing the instruction at addr,
+// or nil if there is none.
+func (t lineTable) find(addr uint64) *dwarf.LineEntry {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Address > addr }) - 1
+	if i < 0 || t[i].EndSequence || t[i].File == nil || t[i].Line == 0 {
+		return nil
+	}
+	return &t[i]
+}
+
+// lookup returns the source file and line of the instruction at addr, or
+// "" and 0 if they are not known.
+func (t lineTable) lookup(addr uint64) (string, int) {
+	le := t.find(addr)
+	if le == nil {
+		return "", 0
+	}
+	return le.File.Name, le.Line
+}
+
+// overlaps reports whether any of the address ranges overlaps [start, end].
+func overlaps(ranges [][2]uint64, start, end uint64) bool {
+	for _, r := range ranges {
+		if r[0] <= end && r[1] > start {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"debug/elf"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+func TestDisasmNative(t *testing.T) {
+	// No objdump tool available.
+	bu := &Binutils{rep: &binrep{}}
+	for _, tc := range []struct {
+		intelSyntax bool
+		want        []plugin.Inst
+	}{
+		{
+			intelSyntax: false,
+			want: []plugin.Inst{
+				{Addr: 0x40052d, Text: "push %rbp", Function: "main", File: "/tmp/hello.c", Line: 3},
+				{Addr: 0x40052e, Text: "mov %rsp,%rbp", Function: "main", File: "/tmp/hello.c", Line: 3},
+				{Addr: 0x400531, Text: "mov $0x4005c4,%edi", Function: "main", File: "/tmp/hello.c", Line: 4},
+				{Addr: 0x400536, Text: "callq 0x400410", Function: "main", File: "/tmp/hello.c", Line: 4},
+				{Addr: 0x40053b, Text: "pop %rbp", Function: "main", File: "/tmp/hello.c", Line: 5},
+				{Addr: 0x40053c, Text: "retq", Function: "main", File: "/tmp/hello.c", Line: 5},
+			},
+		},
+		{
+			intelSyntax: true,
+			want: []plugin.Inst{
+				{Addr: 0x40052d, Text: "push rbp", Function: "main", File: "/tmp/hello.c", Line: 3},
+				{Addr: 0x40052e, Text: "mov rbp, rsp", Function: "main", File: "/tmp/hello.c", Line: 3},
+				{Addr: 0x400531, Text: "mov edi, 0x4005c4", Function: "main", File: "/tmp/hello.c", Line: 4},
+				{Addr: 0x400536, Text: "call 0x400410", Function: "main", File: "/tmp/hello.c", Line: 4},
+				{Addr: 0x40053b, Text: "pop rbp", Function: "main", File: "/tmp/hello.c", Line: 5},
+				{Addr: 0x40053c, Text: "ret", Function: "main", File: "/tmp/hello.c", Line: 5},
+			},
+		},
+	} {
+		// The range of main, as reported by nm.
+		got, err := bu.Disasm(filepath.Join("testdata", "exe_linux_64"), 0x40052d, 0x40053c, tc.intelSyntax)
+		if err != nil {
+			t.Fatalf("Disasm(intelSyntax=%v): %v", tc.intelSyntax, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Disasm(intelSyntax=%v): got %v, want %v", tc.intelSyntax, got, tc.want)
+		}
+	}
+
+	if _, err := bu.Disasm(filepath.Join("testdata", "exe_mac_64"), 0, 0x1000, false); err == nil {
+		t.Error("Disasm of a Mach-O binary without objdump: got no error")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	symname := func(addr uint64) (string, uint64) {
+		if addr >= 0x2000 && addr < 0x2010 {
+			return "foo", 0x2000
+		}
+		return "", 0
+	}
+	for _, tc := range []struct {
+		desc    string
+		machine elf.Machine
+		code    []byte
+		end     uint64
+		want    []string
+	}{
+		{
+			desc:    "x86-64",
+			machine: elf.EM_X86_64,
+			// call foo; ret
+			code: []byte{0xe8, 0xfb, 0x0f, 0x00, 0x00, 0xc3},
+			end:  0x1005,
+			want: []string{"callq foo", "retq"},
+		},
+		{
+			desc:    "x86-64 bad instruction",
+			machine: elf.EM_X86_64,
+			code:    []byte{0x06, 0x90},
+			end:     0x1001,
+			want:    []string{"(bad)", "nop"},
+		},
+		{
+			desc:    "x86-64 end of range",
+			machine: elf.EM_X86_64,
+			code:    []byte{0x90, 0x90, 0x90},
+			end:     0x1001,
+			want:    []string{"nop", "nop"},
+		},
+		{
+			desc:    "arm64",
+			machine: elf.EM_AARCH64,
+			// nop; ret; udf #0
+			code: []byte{0x1f, 0x20, 0x03, 0xd5, 0xc0, 0x03, 0x5f, 0xd6, 0x00, 0x00, 0x00, 0x00},
+			end:  0x1008,
+			want: []string{"nop", "ret", "(bad)"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			for i, inst := range decode(tc.machine, tc.code, 0x1000, tc.end, false, symname) {
+				if i == 0 && inst.Addr != 0x1000 {
+					t.Errorf("first instruction at %#x, want 0x1000", inst.Addr)
+				}
+				got = append(got, inst.Text)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("decode: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -47,6 +47,14 @@ func main() {
 			log.Fatal(err)
 		}
 
+		// An optimized binary with inlined functions and compressed debug
+		// sections.
+		out, err = exec.Command("cc", "-O1", "-fno-tree-vectorize", "-g", "-gz", "-ffile-prefix-map="+wd+"="+"/tmp", "-o", "exe_linux_64_inline", "inline.c").CombinedOutput()
+		log.Println(string(out))
+		if err != nil {
+			log.Fatal(err)
+		}
+
 	case "darwin":
 		if err := removeGlob("exe_mac_64*", "lib_mac_64"); err != nil {
 			log.Fatal(err)
@@ -0,0 +1,18 @@
+#include <stdio.h>
+
+static inline __attribute__((always_inline)) int square(int x) {
+  return x * x;
+}
+
+static inline __attribute__((always_inline)) int sum_squares(int n) {
+  int s = 0;
+  for (int i = 0; i < n; i++) {
+    s += square(i);
+  }
+  return s;
+}
+
+int main(int argc, char **argv) {
+  printf("%d\n", sum_squares(argc * 100));
+  return 0;
+}
@@ -0,0 +1,530 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// checkRule is a threshold rule of a -check file.
+type checkRule struct {
+	Name        string
+	SampleIndex string
+	Focus       string
+	Metric      string // total, flat, cum or new_flat.
+	Match       string // Regexp of the functions of the flat and cum metrics.
+	Max         string // Limit of the metric, as a quantity or a percentage of the total.
+	MaxGrowth   string // Limit of the growth from the base, as a quantity or a percentage.
+
+	line  int
+	match *regexp.Regexp
+}
+
+// The metrics of the check rules.
+const (
+	metricTotal   = "total"
+	metricFlat    = "flat"
+	metricCum     = "cum"
+	metricNewFlat = "new_flat"
+)
+
+// fields returns the fields of the rule by key in the check file.
+func (r *checkRule) fields() map[string]*string {
+	return map[string]*string{
+		"name":         &r.Name,
+		"sample_index": &r.SampleIndex,
+		"focus":        &r.Focus,
+		"metric":       &r.Metric,
+		"match":        &r.Match,
+		"max":          &r.Max,
+		"max_growth":   &r.MaxGrowth,
+	}
+}
+
+// checkRules are the rules of a -check file.
+type checkRules struct {
+	SampleIndex string // Default sample index of the rules.
+	Rules       []*checkRule
+}
+
+// readCheckRules reads the rules of the named -check file.
+func readCheckRules(name string) (*checkRules, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := parseCheckRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return rules, nil
+}
+
+// parseCheckRules parses check rules written in a subset of YAML: comments,
+// a top-level sample_index and a top-level list of rules, each a mapping of
+// scalars, such as
+//
+//	sample_index: alloc_space
+//	rules:
+//	  - name: json encoding
+//	    match: ^encoding/json\.
+//	    max_growth: 5%
+//	  - metric: total
+//	    max: 2GiB
+func parseCheckRules(r io.Reader) (*checkRules, error) {
+	rules := &checkRules{}
+	inRules := false
+	var rule *checkRule
+	ruleIndent := 0
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimRight(s.Text(), " \t\r")
+		item := strings.TrimLeft(text, " ")
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+		if strings.HasPrefix(item, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", line)
+		}
+		indent := len(text) - len(item)
+		if indent == 0 && !strings.HasPrefix(item, "-") {
+			key, value, err := parseCheckField(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			inRules, rule = false, nil
+			switch key {
+			case "rules":
+				if value != "" {
+					return nil, fmt.Errorf("line %d: rules must be a list", line)
+				}
+				inRules = true
+			case "sample_index":
+				rules.SampleIndex = value
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q", line, key)
+			}
+			continue
+		}
+		if !inRules {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line)
+		}
+		if item == "-" || strings.HasPrefix(item, "- ") {
+			rule = &checkRule{line: line}
+			rules.Rules = append(rules.Rules, rule)
+			ruleIndent = indent
+			if item = strings.TrimSpace(item[1:]); item == "" {
+				continue
+			}
+		} else if rule == nil || indent <= ruleIndent {
+			return nil, fmt.Errorf("line %d: rules must start with -", line)
+		}
+		key, value, err := parseCheckField(item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		field := rule.fields()[key]
+		if field == nil {
+			return nil, fmt.Errorf("line %d: unknown rule key %q", line, key)
+		}
+		*field = value
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(rules.Rules) == 0 {
+		return nil, errors.New("no rules")
+	}
+	for _, rule := range rules.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", rule.line, err)
+		}
+	}
+	return rules, nil
+}
+
+// parseCheckField parses a "key: value" line of a check file.
+func parseCheckField(text string) (key, value string, err error) {
+	key, value, ok := strings.Cut(text, ":")
+	if !ok {
+		return "", "", fmt.Errorf("want key: value, got %q", text)
+	}
+	value, err = parseCheckScalar(strings.TrimSpace(value))
+	return strings.TrimSpace(key), value, err
+}
+
+// parseCheckScalar parses a plain, single-quoted or double-quoted scalar,
+// followed by an optional comment.
+func parseCheckScalar(text string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(text, `"`):
+		quoted, err := strconv.QuotedPrefix(text)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %s", text)
+		}
+		if value, err = strconv.Unquote(quoted); err != nil {
+			return "", fmt.Errorf("invalid quoted value %s", text)
+		}
+		rest = text[len(quoted):]
+	case strings.HasPrefix(text, "'"):
+		// Quotes are doubled in single-quoted values.
+		end := -1
+		for i := 1; i < len(text); i++ {
+			if text[i] != '\'' {
+				continue
+			}
+			if i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			end = i
+			break
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", text)
+		}
+		value, rest = strings.ReplaceAll(text[1:end], "''", "'"), text[end+1:]
+	default:
+		if i := strings.Index(text, " #"); i >= 0 {
+			text = text[:i]
+		}
+		return strings.TrimSpace(text), nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %s after quoted value", rest)
+	}
+	return value, nil
+}
+
+// validate checks the fields of the rule and sets their defaults.
+func (r *checkRule) validate() error {
+	if r.Metric == "" {
+		r.Metric = metricTotal
+		if r.Match != "" {
+			r.Metric = metricCum
+		}
+	}
+	switch r.Metric {
+	case metricTotal, metricNewFlat:
+		if r.Match != "" {
+			return fmt.Errorf("metric %s does not take a match", r.Metric)
+		}
+	case metricFlat, metricCum:
+		if r.Match == "" {
+			return fmt.Errorf("metric %s needs a match", r.Metric)
+		}
+		var err error
+		if r.match, err = regexp.Compile(r.Match); err != nil {
+			return fmt.Errorf("invalid match: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown metric %q, want total, flat, cum or new_flat", r.Metric)
+	}
+	switch {
+	case r.Metric == metricNewFlat && (r.Max == "" || r.MaxGrowth != ""):
+		return errors.New("metric new_flat takes a max only")
+	case r.Max == "" && r.MaxGrowth == "":
+		return errors.New("rule needs a max or a max_growth")
+	}
+	if r.Name == "" {
+		r.Name = strings.TrimSpace(r.Metric + " " + r.Match)
+	}
+	return nil
+}
+
+// runChecks evaluates the rules of the -check file against the profiles of
+// the source, compared to its base profiles, and prints a report of the
+// results. It returns an error if any rule is violated.
+func runChecks(src *source, o *plugin.Options) error {
+	rules, err := readCheckRules(src.Check)
+	if err != nil {
+		return err
+	}
+	// Fetch the profiles separately, rather than subtracting the base.
+	s := *src
+	s.Base, s.DiffBase, s.Normalize = nil, false, false
+	p, err := fetchProfiles(&s, o)
+	if err != nil {
+		return err
+	}
+	var base *profile.Profile
+	if len(src.Base) > 0 {
+		s.Sources = src.Base
+		if base, err = fetchProfiles(&s, o); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if output := currentConfig().Output; output != "" {
+		out, err := o.Writer.Open(output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+	return checkProfile(w, rules, p, base, o.UI)
+}
+
+// checkProfile evaluates the rules against the profile p and its base, which
+// may be nil, and writes a line per rule to w.
+func checkProfile(w io.Writer, rules *checkRules, p, base *profile.Profile, ui plugin.UI) error {
+	failed := 0
+	for _, r := range rules.Rules {
+		summary, violations, err := r.check(rules.SampleIndex, p, base, ui)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+		status := "PASS"
+		if len(violations) > 0 {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s  %s: %s\n", status, r.Name, summary)
+		for _, v := range violations {
+			fmt.Fprintf(w, "      %s\n", v)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rules failed", failed, len(rules.Rules))
+	}
+	fmt.Fprintf(w, "All %d rules passed\n", len(rules.Rules))
+	return nil
+}
+
+// checkValues are the values of a profile measured by a rule.
+type checkValues struct {
+	unit  string
+	total int64
+	value int64            // Value of the total, flat or cum metric.
+	flat  map[string]int64 // Flat values by function, for new_flat.
+}
+
+// measure returns the values of a copy of p, in the sample index and with
+// the filters of the rule.
+func (r *checkRule) measure(sampleIndex string, p *profile.Profile, ui plugin.UI) (*checkValues, error) {
+	p = p.Copy()
+	if r.SampleIndex != "" {
+		sampleIndex = r.SampleIndex
+	}
+	if sampleIndex == "" {
+		sampleIndex = currentConfig().SampleIndex
+	}
+	value, _, vt, err := sampleFormat(p, sampleIndex, false)
+	if err != nil {
+		return nil, err
+	}
+	cfg := currentConfig()
+	if r.Focus != "" {
+		cfg.Focus = r.Focus
+	}
+	if err := applyFocus(p, identifyNumLabelUnits(p, ui), cfg, ui); err != nil {
+		return nil, err
+	}
+
+	m := &checkValues{unit: vt.Unit, flat: make(map[string]int64)}
+	for _, s := range p.Sample {
+		v := value(s.Value)
+		m.total += v
+		var frames []string
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil {
+					frames = append(frames, line.Function.Name)
+				}
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		m.flat[frames[0]] += v
+		switch r.Metric {
+		case metricFlat:
+			if r.match.MatchString(frames[0]) {
+				m.value += v
+			}
+		case metricCum:
+			for _, fn := range frames {
+				if r.match.MatchString(fn) {
+					m.value += v
+					break
+				}
+			}
+		}
+	}
+	if r.Metric == metricTotal {
+		m.value = m.total
+	}
+	return m, nil
+}
+
+// check evaluates the rule, returning a summary of the values measured and
+// the violations of its limits.
+func (r *checkRule) check(sampleIndex string, p, base *profile.Profile, ui plugin.UI) (summary string, violations []string, err error) {
+	cur, err := r.measure(sampleIndex, p, ui)
+	if err != nil {
+		return "", nil, err
+	}
+	var old *checkValues
+	if base != nil {
+		if old, err = r.measure(sampleIndex, base, ui); err != nil {
+			return "", nil, fmt.Errorf("base profile: %v", err)
+		}
+		if !strings.EqualFold(old.unit, cur.unit) {
+			// Compare the values of the base in the unit of the profile.
+			scale, ok := unitScale(old.unit, cur.unit)
+			if !ok {
+				return "", nil, fmt.Errorf("unit %s of the base profile is not compatible with the unit %s of the profile", old.unit, cur.unit)
+			}
+			old.value = int64(float64(old.value) * scale)
+			for fn, v := range old.flat {
+				old.flat[fn] = int64(float64(v) * scale)
+			}
+		}
+	} else if r.MaxGrowth != "" || r.Metric == metricNewFlat {
+		return "", nil, errors.New("needs a base profile")
+	}
+	label := func(v int64) string {
+		return measurement.ScaledLabel(v, cur.unit, "auto")
+	}
+
+	if r.Metric == metricNewFlat {
+		limit, err := parseCheckLimit(r.Max, cur.total, cur.unit)
+		if err != nil {
+			return "", nil, fmt.Errorf("max: %v", err)
+		}
+		var fns []string
+		for fn, v := range cur.flat {
+			if float64(v) > limit && old.flat[fn] == 0 {
+				fns = append(fns, fn)
+			}
+		}
+		sort.Slice(fns, func(i, j int) bool {
+			if cur.flat[fns[i]] != cur.flat[fns[j]] {
+				return cur.flat[fns[i]] > cur.flat[fns[j]]
+			}
+			return fns[i] < fns[j]
+		})
+		for _, fn := range fns {
+			violations = append(violations, fmt.Sprintf("%s is new with a flat of %s (%s), above the max of %s",
+				fn, label(cur.flat[fn]), strings.TrimSpace(measurement.Percentage(cur.flat[fn], cur.total)), r.Max))
+		}
+		return fmt.Sprintf("%d new functions above %s flat", len(fns), r.Max), violations, nil
+	}
+
+	summary = label(cur.value)
+	if old != nil {
+		summary += fmt.Sprintf(", %s from the base %s", formatGrowth(cur.value, old.value), label(old.value))
+	}
+	if r.Max != "" {
+		limit, err := parseCheckLimit(r.Max, cur.total, cur.unit)
+		if err != nil {
+			return "", nil, fmt.Errorf("max: %v", err)
+		}
+		if float64(cur.value) > limit {
+			violations = append(violations, fmt.Sprintf("%s is above the max of %s", label(cur.value), r.Max))
+		}
+	}
+	if r.MaxGrowth != "" {
+		growth := cur.value - old.value
+		limit, err := parseCheckLimit(r.MaxGrowth, old.value, cur.unit)
+		if err != nil {
+			return "", nil, fmt.Errorf("max_growth: %v", err)
+		}
+		if strings.HasSuffix(r.MaxGrowth, "%") && old.value == 0 && growth > 0 {
+			// Any growth from nothing is an infinite percentage.
+			limit = math.Inf(-1)
+		}
+		if float64(growth) > limit {
+			violations = append(violations, fmt.Sprintf("growth of %s is above the max of %s", formatGrowth(cur.value, old.value), r.MaxGrowth))
+		}
+	}
+	return summary, violations, nil
+}
+
+// formatGrowth formats the growth from old to cur, as a percentage of old.
+func formatGrowth(cur, old int64) string {
+	switch {
+	case cur == old:
+		return "+0%"
+	case old == 0:
+		return "new"
+	}
+	return fmt.Sprintf("%+.2f%%", float64(cur-old)/math.Abs(float64(old))*100)
+}
+
+// checkQuantityRE matches the quantities of the limits of the check rules,
+// made of a number and an optional unit.
+var checkQuantityRE = regexp.MustCompile(`^([-+]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?)\s*(\S*)$`)
+
+// parseCheckLimit parses the limit of a rule, returning it in the sample
+// unit. A limit is either a percentage of total, such as "5%", or a
+// quantity, such as "2GiB" or "300ms", in a unit compatible with the sample
+// unit. Quantities without a unit are in the sample unit.
+func parseCheckLimit(limit string, total int64, sampleUnit string) (float64, error) {
+	if pct := strings.TrimSuffix(limit, "%"); pct != limit {
+		v, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid percentage %q", limit)
+		}
+		return v / 100 * math.Abs(float64(total)), nil
+	}
+	m := checkQuantityRE.FindStringSubmatch(limit)
+	if m == nil {
+		return 0, fmt.Errorf("invalid quantity %q", limit)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q", limit)
+	}
+	unit := m[2]
+	if unit == "" || strings.EqualFold(unit, sampleUnit) {
+		return v, nil
+	}
+	scale, ok := unitScale(unit, sampleUnit)
+	if !ok {
+		return 0, fmt.Errorf("unit %s of %q is not compatible with the sample unit %s", unit, limit, sampleUnit)
+	}
+	return v * scale, nil
+}
+
+// unitScale returns the factor converting values in unit from to unit to,
+// and false if the units do not measure the same quantity.
+func unitScale(from, to string) (float64, bool) {
+	if strings.EqualFold(from, to) {
+		return 1, true
+	}
+	scale, scaledUnit := measurement.Scale(1, from, to)
+	if _, u := measurement.Scale(1, to, to); scaledUnit == "" || scaledUnit != u {
+		return 0, false
+	}
+	return scale, true
+}
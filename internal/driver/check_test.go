@@ -0,0 +1,332 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/transport"
+	"github.com/google/pprof/profile"
+)
+
+func TestParseCheckRules(t *testing.T) {
+	rules, err := parseCheckRules(strings.NewReader(`
+# Checks of the nightly benchmarks.
+sample_index: alloc_space
+rules:
+  - name: json encoding   # A comment.
+    match: "^encoding/json\\."
+    max_growth: 5%
+
+  - metric: total
+    max: '2GiB'
+  -
+    metric: new_flat
+    max: 1%
+    focus: 'it''s'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.SampleIndex != "alloc_space" {
+		t.Errorf("got sample index %q, want alloc_space", rules.SampleIndex)
+	}
+	var got []checkRule
+	for _, r := range rules.Rules {
+		r.match = nil
+		got = append(got, *r)
+	}
+	want := []checkRule{
+		{Name: "json encoding", Metric: "cum", Match: `^encoding/json\.`, MaxGrowth: "5%", line: 5},
+		{Name: "total", Metric: "total", Max: "2GiB", line: 9},
+		{Name: "new_flat", Metric: "new_flat", Max: "1%", Focus: "it's", line: 11},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		rules, wantErr string
+	}{
+		{"", "no rules"},
+		{"rules:\n  - max: 1\n    limit: 2\n", "line 3: unknown rule key \"limit\""},
+		{"thresholds:\n", "line 1: unknown key \"thresholds\""},
+		{"rules: 1\n", "line 1: rules must be a list"},
+		{"  - max: 1\n", "line 1: unexpected indentation"},
+		{"rules:\n    max: 1\n", "line 2: rules must start with -"},
+		{"rules:\n  - match: '^a\n", "line 2: unterminated quoted value"},
+		{"rules:\n  - metric: flat\n    max: 1\n", "line 2: metric flat needs a match"},
+		{"rules:\n  - metric: total\n    match: a\n    max: 1\n", "line 2: metric total does not take a match"},
+		{"rules:\n  - metric: mean\n    max: 1\n", "line 2: unknown metric \"mean\""},
+		{"rules:\n  - match: a(\n    max: 1\n", "line 2: invalid match"},
+		{"rules:\n  - match: a\n", "line 2: rule needs a max or a max_growth"},
+		{"rules:\n  - metric: new_flat\n    max_growth: 1%\n", "line 2: metric new_flat takes a max only"},
+	} {
+		_, err := parseCheckRules(strings.NewReader(tc.rules))
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("parseCheckRules(%q): got error %v, want %q", tc.rules, err, tc.wantErr)
+		}
+	}
+}
+
+func TestParseCheckLimit(t *testing.T) {
+	for _, tc := range []struct {
+		limit   string
+		total   int64
+		unit    string
+		want    float64
+		wantErr bool
+	}{
+		{"5%", 200, "bytes", 10, false},
+		{"2GiB", 0, "bytes", 2 << 30, false},
+		{"1.5 kB", 0, "bytes", 1536, false},
+		{"300ms", 0, "nanoseconds", 3e8, false},
+		{"2s", 0, "ms", 2000, false},
+		{"100", 0, "count", 100, false},
+		{"100count", 0, "count", 100, false},
+		{"1MB", 0, "nanoseconds", 0, true},
+		{"1MB", 0, "count", 0, true},
+		{"1foo", 0, "count", 0, true},
+		{"five%", 100, "count", 0, true},
+		{"MB", 0, "bytes", 0, true},
+	} {
+		got, err := parseCheckLimit(tc.limit, tc.total, tc.unit)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseCheckLimit(%q, %d, %q): got error %v, want error %v", tc.limit, tc.total, tc.unit, err, tc.wantErr)
+			continue
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("parseCheckLimit(%q, %d, %q): got %v, want %v", tc.limit, tc.total, tc.unit, got, tc.want)
+		}
+	}
+}
+
+// checkTestProfile returns an allocation profile with a sample of value
+// bytes for each stack, listing the functions from the leaf.
+func checkTestProfile(stacks map[string]int64) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "alloc_objects", Unit: "count"}, {Type: "alloc_space", Unit: "bytes"}},
+	}
+	funcs := make(map[string]*profile.Location)
+	for stack, value := range stacks {
+		s := &profile.Sample{Value: []int64{1, value}}
+		for _, name := range strings.Split(stack, " ") {
+			loc := funcs[name]
+			if loc == nil {
+				fn := &profile.Function{ID: uint64(len(p.Function) + 1), Name: name}
+				loc = &profile.Location{ID: uint64(len(p.Location) + 1), Line: []profile.Line{{Function: fn}}}
+				p.Function = append(p.Function, fn)
+				p.Location = append(p.Location, loc)
+				funcs[name] = loc
+			}
+			s.Location = append(s.Location, loc)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p
+}
+
+func TestCheckProfile(t *testing.T) {
+	base := checkTestProfile(map[string]int64{
+		"encoding/json.Marshal main.encode main.main": 1000,
+		"main.parse main.main":                        1000,
+	})
+	p := checkTestProfile(map[string]int64{
+		"encoding/json.Marshal main.encode main.main": 1100,
+		"main.parse main.main":                        1000,
+		"main.cache main.main":                        400,
+		"main.log main.main":                          10,
+	})
+	timeBase := checkTestProfile(map[string]int64{"main.parse main.main": 1000})
+	timeBase.SampleType[1].Unit = "nanoseconds"
+	kbBase := checkTestProfile(map[string]int64{"main.parse main.main": 2})
+	kbBase.SampleType[1].Unit = "kilobytes"
+	ui := &proftest.TestUI{T: t, AllowRx: ".*"}
+	for _, tc := range []struct {
+		desc    string
+		rules   string
+		base    *profile.Profile
+		noBase  bool
+		want    []string
+		wantErr string
+	}{
+		{
+			desc: "pass",
+			rules: `
+sample_index: alloc_space
+rules:
+  - match: ^encoding/json\.
+    max_growth: 20%
+  - metric: total
+    max: 2.5kB
+  - metric: flat
+    match: ^main\.parse$
+    max: 50%
+    max_growth: 0
+`,
+			want: []string{
+				`PASS  cum ^encoding/json\.: 1.07kB, +10.00% from the base 1000B`,
+				"PASS  total: 2.45kB, +25.50% from the base 1.95kB",
+				`PASS  flat ^main\.parse$: 1000B, +0% from the base 1000B`,
+				"All 3 rules passed",
+			},
+		},
+		{
+			desc: "violations",
+			rules: `
+rules:
+  - name: json encoding
+    sample_index: alloc_space
+    match: ^encoding/json\.
+    max_growth: 5%
+  - metric: total
+    sample_index: alloc_space
+    max: 2KiB
+  - metric: new_flat
+    sample_index: alloc_space
+    max: 1%
+  - name: objects
+    sample_index: alloc_objects
+    metric: total
+    max: 10
+`,
+			want: []string{
+				"FAIL  json encoding: 1.07kB, +10.00% from the base 1000B\n      growth of +10.00% is above the max of 5%",
+				"FAIL  total: 2.45kB, +25.50% from the base 1.95kB\n      2.45kB is above the max of 2KiB",
+				"FAIL  new_flat: 1 new functions above 1% flat\n      main.cache is new with a flat of 400B (15.94%), above the max of 1%",
+				"PASS  objects: 4, +100.00% from the base 2",
+			},
+			wantErr: "3 of 4 rules failed",
+		},
+		{
+			desc: "focus",
+			rules: `
+sample_index: alloc_space
+rules:
+  - name: encode
+    focus: main.encode
+    metric: total
+    max_growth: 50B
+`,
+			want:    []string{"FAIL  encode: 1.07kB, +10.00% from the base 1000B\n      growth of +10.00% is above the max of 50B"},
+			wantErr: "1 of 1 rules failed",
+		},
+		{
+			desc:    "no base",
+			rules:   "rules:\n  - match: json\n    max_growth: 5%\n",
+			noBase:  true,
+			wantErr: "rule cum json: needs a base profile",
+		},
+		{
+			desc:  "base in other unit",
+			rules: "sample_index: alloc_space\nrules:\n  - metric: total\n    max_growth: 50%\n",
+			base:  kbBase,
+			want:  []string{"PASS  total: 2.45kB, +22.56% from the base 2kB"},
+		},
+		{
+			desc:    "base in incompatible unit",
+			rules:   "sample_index: alloc_space\nrules:\n  - metric: total\n    max_growth: 10%\n",
+			base:    timeBase,
+			wantErr: "unit nanoseconds of the base profile is not compatible with the unit bytes of the profile",
+		},
+		{
+			desc:    "invalid limit",
+			rules:   "rules:\n  - metric: total\n    max: 1ms\n",
+			wantErr: "rule total: max: unit ms",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			rules, err := parseCheckRules(strings.NewReader(tc.rules))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := base
+			if tc.base != nil {
+				b = tc.base
+			}
+			if tc.noBase {
+				b = nil
+			}
+			var buf bytes.Buffer
+			err = checkProfile(&buf, rules, p, b, ui)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("got error %v, want none", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("got:\n%s\nwant it to contain %q", buf.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	savedConfig := currentConfig()
+	defer setCurrentConfig(savedConfig)
+
+	dir := t.TempDir()
+	writeProfile := func(name string, p *profile.Profile) string {
+		var buf bytes.Buffer
+		if err := p.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		name = filepath.Join(dir, name)
+		writeFile(t, name, buf.Bytes())
+		return name
+	}
+	base := writeProfile("base.pb.gz", checkTestProfile(map[string]int64{"main.parse main.main": 1000}))
+	cur := writeProfile("new.pb.gz", checkTestProfile(map[string]int64{"main.parse main.main": 1500}))
+	rules := filepath.Join(dir, "rules.yaml")
+	writeFile(t, rules, []byte("sample_index: alloc_space\nrules:\n  - metric: total\n    max_growth: 25%\n"))
+	output := filepath.Join(dir, "report.txt")
+
+	cfg := currentConfig()
+	cfg.Output = output
+	setCurrentConfig(cfg)
+	o := setDefaults(&plugin.Options{
+		Flagset:       &testFlags{strings: map[string]string{"check": rules}, args: []string{base, cur}},
+		UI:            &proftest.TestUI{T: t, AllowRx: ".*"},
+		HTTPTransport: transport.New(nil),
+	})
+	src, cmd, err := parseFlags(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd) != 0 || !reflect.DeepEqual(src.Base, []string{base}) || !reflect.DeepEqual(src.Sources, []string{cur}) {
+		t.Fatalf("got command %v, base %v and sources %v, want the first source as the base", cmd, src.Base, src.Sources)
+	}
+	src.Symbolize = "none"
+	if err := runChecks(src, o); err == nil || err.Error() != "1 of 1 rules failed" {
+		t.Errorf("got error %v, want 1 of 1 rules failed", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FAIL  total: 1.46kB, +50.00% from the base 1000B\n      growth of +50.00% is above the max of 25%\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/google/pprof/internal/binutils"
 	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/symbolizer"
 )
 
 type source struct {
@@ -36,12 +37,21 @@ type source struct {
 	Symbolize          string
 	HTTPHostport       string
 	HTTPDisableBrowser bool
+	HTTPTLSCert        string
+	HTTPTLSKey         string
+	HTTPAuth           string
+	HTTPAllow          string
+	HTTPUpload         bool
+	ServeDir           string
+	Script             string
+	Check              string
 	Comment            string
 }
 
 // parseFlags parses the command lines through the specified flags package
 // and returns the source of the profile and optionally the command
-// for the kind of report to generate (nil for interactive use).
+// for the kind of report to generate (nil for interactive use). The
+// source is nil if there is nothing else to do.
 func parseFlags(o *plugin.Options) (*source, []string, error) {
 	flag := o.Flagset
 	// Comparisons.
@@ -64,9 +74,19 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	flagContentions := flag.Bool("contentions", false, "Display number of delays at each region")
 	flagMeanDelay := flag.Bool("mean_delay", false, "Display mean delay at each region")
 	flagTools := flag.String("tools", os.Getenv("PPROF_TOOLS"), "Path for object tool pathnames")
+	flagClearSymbolCache := flag.Bool("clear_symbol_cache", false, "Remove all entries from the symbol cache")
 
 	flagHTTP := flag.String("http", "", "Present interactive web UI at the specified http host:port")
 	flagNoBrowser := flag.Bool("no_browser", false, "Skip opening a browser for the interactive web UI")
+	flagHTTPUpload := flag.Bool("http_upload", false, "Allow uploading profiles to the web UI")
+	flagServeDir := flag.String("serve_dir", "", "Browse the profiles of a directory in the web UI")
+	flagHTTPTLSCert := flag.String("http_tls_cert", "", "Certificate file to serve the web UI over HTTPS")
+	flagHTTPTLSKey := flag.String("http_tls_key", "", "Private key file of -http_tls_cert")
+	flagHTTPAuth := flag.String("http_auth", os.Getenv("PPROF_HTTP_AUTH"), "Authentication for the web UI: basic:USER:PASSWORD or bearer:TOKEN")
+	flagHTTPAllow := flag.String("http_allow", "", "Comma separated IP addresses and CIDR blocks of the allowed web UI clients")
+	flagScript := flag.String("script", "", "Execute the interactive commands of a file, or of the standard input if -")
+	flagCheck := flag.String("check", "", "Check the profiles against the threshold rules of a file")
+	flagConfig := flag.String("config", "", "Start from the named config of the settings")
 
 	// Flags that set configuration properties.
 	cfg := currentConfig()
@@ -89,7 +109,27 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 			flag.ExtraUsage() +
 			usageMsgVars)
 	})
-	if len(args) == 0 {
+	if *flagClearSymbolCache {
+		if cache := symbolizer.DefaultCache(); cache != nil {
+			if err := cache.Clear(); err != nil {
+				return nil, nil, fmt.Errorf("clearing symbol cache: %v", err)
+			}
+			o.UI.PrintErr("Cleared symbol cache ", cache.Dir)
+		}
+		if len(args) == 0 {
+			// Nothing else to do.
+			return nil, nil, nil
+		}
+	}
+	if *flagServeDir != "" {
+		if len(args) != 0 {
+			return nil, nil, errors.New("-serve_dir does not take profile sources")
+		}
+		if *flagHTTP == "" {
+			// Serve on localhost, on any available port.
+			*flagHTTP = ":"
+		}
+	} else if len(args) == 0 {
 		return nil, nil, errors.New("no profile source specified")
 	}
 
@@ -108,6 +148,25 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	if err := configFlagSetter(); err != nil {
 		return nil, nil, err
 	}
+	if *flagConfig != "" {
+		fname, err := settingsFileName()
+		if err != nil {
+			return nil, nil, err
+		}
+		named, err := lookupConfig(fname, *flagConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The flags on the command line take precedence over the named
+		// config.
+		initial := currentConfig()
+		for _, f := range configFields {
+			if v := cfg.get(f); v != initial.get(f) {
+				named.set(f, v)
+			}
+		}
+		cfg = named
+	}
 
 	cmd, err := outputFormat(flagCommands, flagParamCommands)
 	if err != nil {
@@ -116,10 +175,30 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	if cmd != nil && *flagHTTP != "" {
 		return nil, nil, errors.New("-http is not compatible with an output format on the command line")
 	}
+	if *flagScript != "" && (cmd != nil || *flagHTTP != "") {
+		return nil, nil, errors.New("-script is not compatible with -http, -serve_dir or an output format on the command line")
+	}
+	if *flagCheck != "" && (cmd != nil || *flagHTTP != "" || *flagScript != "") {
+		return nil, nil, errors.New("-check is not compatible with -http, -serve_dir, -script or an output format on the command line")
+	}
+	if *flagServeDir != "" && (len(dropEmpty(*flagBase)) > 0 || len(dropEmpty(*flagDiffBase)) > 0) {
+		return nil, nil, errors.New("-serve_dir is not compatible with -base and -diff_base")
+	}
 
 	if *flagNoBrowser && *flagHTTP == "" {
 		return nil, nil, errors.New("-no_browser only makes sense with -http")
 	}
+	if (*flagHTTPTLSCert != "" || *flagHTTPTLSKey != "" || *flagHTTPAllow != "" || *flagHTTPUpload) && *flagHTTP == "" {
+		return nil, nil, errors.New("-http_tls_cert, -http_tls_key, -http_allow and -http_upload only make sense with -http")
+	}
+	if (*flagHTTPTLSCert == "") != (*flagHTTPTLSKey == "") {
+		return nil, nil, errors.New("-http_tls_cert and -http_tls_key must be specified together")
+	}
+	if *flagHTTP != "" {
+		if _, err := parseWebAccess(*flagHTTPAuth, *flagHTTPAllow); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	si := cfg.SampleIndex
 	si = sampleIndex(flagTotalDelay, si, "delay", "-total_delay", o.UI)
@@ -144,12 +223,24 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 		Symbolize:          *flagSymbolize,
 		HTTPHostport:       *flagHTTP,
 		HTTPDisableBrowser: *flagNoBrowser,
+		HTTPTLSCert:        *flagHTTPTLSCert,
+		HTTPTLSKey:         *flagHTTPTLSKey,
+		HTTPAuth:           *flagHTTPAuth,
+		HTTPAllow:          *flagHTTPAllow,
+		HTTPUpload:         *flagHTTPUpload,
+		ServeDir:           *flagServeDir,
+		Script:             *flagScript,
+		Check:              *flagCheck,
 		Comment:            *flagAddComment,
 	}
 
 	if err := source.addBaseProfiles(*flagBase, *flagDiffBase); err != nil {
 		return nil, nil, err
 	}
+	if source.Check != "" && len(source.Base) == 0 && len(source.Sources) == 2 {
+		// pprof -check rules.yaml base.pb.gz new.pb.gz
+		source.Base, source.Sources = source.Sources[:1], source.Sources[1:]
+	}
 
 	normalize := cfg.Normalize
 	if normalize && len(source.Base) == 0 {
@@ -302,6 +393,18 @@ to generate various views of a profile
 
    pprof [options] [binary] <source> ...
 
+Provide the "-script" flag to execute the commands of the interactive shell
+read from a file, or from the standard input with "-script -", stopping at
+the first error.
+
+   pprof -script <file> [options] [binary] <source> ...
+
+Provide the "-check" flag to check the profiles against the threshold rules
+of a file, compared to a base profile given with -base or as the first of two
+sources, and exit with an error if any rule is violated.
+
+   pprof -check <rules.yaml> [options] [binary] <base> <source>
+
 Omit the format and provide the "-http" flag to get an interactive web
 interface at the specified host:port that can be used to navigate through
 various views of a profile.
@@ -329,6 +432,7 @@ var usageMsgSrc = "\n\n" +
 	"      fastlocal             Only get function names from local binaries\n" +
 	"      remote                Do not examine local binaries\n" +
 	"      force                 Force re-symbolization\n" +
+	"      debuginfod            Download debug files from $DEBUGINFOD_URLS\n" +
 	"    Binary                  Local path or build id of binary for symbolization\n"
 
 var usageMsgVars = "\n\n" +
@@ -337,7 +441,23 @@ var usageMsgVars = "\n\n" +
 	"                      Host is optional and 'localhost' by default.\n" +
 	"                      Port is optional and a randomly available port by default.\n" +
 	"   -no_browser        Skip opening a browser for the interactive web UI.\n" +
+	"   -http_tls_cert     Certificate file to serve the web UI over HTTPS.\n" +
+	"   -http_tls_key      Private key file of the certificate.\n" +
+	"   -http_auth         Require authentication for the web UI, either\n" +
+	"                      basic:USER:PASSWORD or bearer:TOKEN.\n" +
+	"   -http_allow        Comma separated IP addresses and CIDR blocks of the\n" +
+	"                      clients allowed to use the web UI.\n" +
+	"   -http_upload       Allow uploading profiles to the web UI.\n" +
+	"   -serve_dir         Browse the profiles of a directory in the web interface,\n" +
+	"                      served as for -http.\n" +
+	"   -script            Execute the interactive commands of a file, or of the\n" +
+	"                      standard input if -, and exit at the first error.\n" +
+	"   -check             Check the profiles against the threshold rules of a\n" +
+	"                      file, and exit with an error on violations.\n" +
+	"   -config            Start from a named config saved in the settings, such\n" +
+	"                      as the ones saved in the web interface.\n" +
 	"   -tools             Search path for object tools\n" +
+	"   -clear_symbol_cache Remove all entries from the symbol cache\n" +
 	"\n" +
 	"  Legacy convenience options:\n" +
 	"   -inuse_space           Same as -sample_index=inuse_space\n" +
@@ -351,10 +471,30 @@ var usageMsgVars = "\n\n" +
 	"  Environment Variables:\n" +
 	"   PPROF_TMPDIR       Location for saved profiles (default $HOME/pprof)\n" +
 	"   PPROF_TOOLS        Search path for object-level tools\n" +
+	"   PPROF_GRAPH_LAYOUT Graph layout engine: dot or builtin\n" +
+	"                      default: dot if Graphviz is installed\n" +
 	"   PPROF_BINARY_PATH  Search path for local binary files\n" +
 	"                      default: $HOME/pprof/binaries\n" +
 	"                      searches $buildid/$name, $buildid/*, $path/$buildid,\n" +
 	"                      ${buildid:0:2}/${buildid:2}.debug, $name, $path,\n" +
 	"                      ${name}.debug, $dir/.debug/${name}.debug,\n" +
 	"                      usr/lib/debug/$dir/${name}.debug\n" +
+	"   PPROF_SYMBOL_CACHE Directory of the cache of local symbolization results\n" +
+	"                      default: pprof/symbols in the user cache directory\n" +
+	"                      off disables the cache\n" +
+	"   PPROF_SYMBOL_CACHE_SIZE Maximum size of the symbol cache in MB\n" +
+	"                      default: 512\n" +
+	"   PPROF_HTTP_AUTH    Default value of -http_auth\n" +
+	"   PPROF_SYMBOLIZE_WORKERS Maximum number of binaries symbolized concurrently\n" +
+	"                      default: the number of CPUs\n" +
+	"   PPROF_SHARE_DIR    Store of the views shared from the web UI\n" +
+	"                      default: $PPROF_TMPDIR/shared\n" +
+	"   PPROF_SHARE_URL    URL of the web UI serving $PPROF_SHARE_DIR, for links\n" +
+	"                      to shared views\n" +
+	"   PPROF_TEAM_CONFIG  Read-only settings file of named configs shared by a\n" +
+	"                      team, in addition to the user settings\n" +
+	"   DEBUGINFOD_URLS    Servers to fetch separate debug files from,\n" +
+	"                      with -symbolize=debuginfod\n" +
+	"   DEBUGINFOD_CACHE_PATH Cache of fetched debug files\n" +
+	"                      default: debuginfod_client in the user cache directory\n" +
 	"   * On Windows, %USERPROFILE% is used instead of $HOME"
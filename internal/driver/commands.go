@@ -85,6 +85,7 @@ var pprofCommands = commands{
 	"comments": {report.Comments, nil, nil, false, "Output all profile comments", ""},
 	"disasm":   {report.Dis, nil, nil, true, "Output assembly listings annotated with samples", listHelp("disasm", true)},
 	"dot":      {report.Dot, nil, nil, false, "Outputs a graph in DOT format", reportHelp("dot", false, true)},
+	"insights": {report.Insights, nil, nil, false, "Outputs findings about the hot spots of the profile", "insights [>f]\nFind the costs of memory allocation and garbage collection, the lock\ncontention hot spots, the deep recursions, the functions whose cost\ncomes from a single call site and the costly inlined functions, with\nthe peek and list commands showing them. Optionally save the output\non the file f."},
 	"list":     {report.List, nil, nil, true, "Output annotated source for functions matching regexp", listHelp("list", false)},
 	"peek":     {report.Tree, nil, nil, true, "Output callers/callees of functions matching regexp", "peek func_regex\nDisplay callers and callees of functions matching func_regex."},
 	"raw":      {report.Raw, nil, nil, false, "Outputs a text representation of the raw profile", ""},
@@ -95,9 +96,11 @@ var pprofCommands = commands{
 	"tree":     {report.Tree, nil, nil, false, "Outputs a text rendering of call graph", reportHelp("tree", true, true)},
 
 	// Save binary formats to a file
-	"callgrind": {report.Callgrind, nil, awayFromTTY("callgraph.out"), false, "Outputs a graph in callgrind format", reportHelp("callgrind", false, true)},
-	"proto":     {report.Proto, nil, awayFromTTY("pb.gz"), false, "Outputs the profile in compressed protobuf format", ""},
-	"topproto":  {report.TopProto, nil, awayFromTTY("pb.gz"), false, "Outputs top entries in compressed protobuf format", ""},
+	"callgrind":   {report.Callgrind, nil, awayFromTTY("callgraph.out"), false, "Outputs a graph in callgrind format", reportHelp("callgrind", false, true)},
+	"gecko":       {report.Gecko, nil, awayFromTTY("json"), false, "Outputs samples in Firefox Profiler format", "gecko [>f]\nSplit samples into threads by the thread_label label and order them by\nthe time_label numeric label. Optionally save the output on the file f."},
+	"html_report": {report.Dot, nil, awayFromTTY("html"), false, "Outputs a standalone HTML report", "html_report [>f]\nBundle the graph, top table, flame graph and source of the top functions\nin a single HTML file viewable offline. If f is a directory, write each\nview to its own page in it."},
+	"proto":       {report.Proto, nil, awayFromTTY("pb.gz"), false, "Outputs the profile in compressed protobuf format", ""},
+	"topproto":    {report.TopProto, nil, awayFromTTY("pb.gz"), false, "Outputs top entries in compressed protobuf format", ""},
 
 	// Generate report in DOT format and postprocess with dot
 	"gif": {report.Dot, invokeDot("gif"), awayFromTTY("gif"), false, "Outputs a graph image in GIF format", reportHelp("gif", false, true)},
@@ -150,6 +153,14 @@ var configHelp = map[string]string{
 	"compact_labels": "Show minimal headers",
 	"source_path":    "Search path for source files",
 	"trim_path":      "Path to trim from source paths before search",
+	"blame": helpText(
+		"Show the last commit touching hot source lines",
+		"Runs git blame on source files found in a local git repository.",
+		"Only applicable to commands `list` and `weblist`"),
+	"coverprofile": helpText(
+		"Show the coverage of hot source lines",
+		"Reads a Go coverage profile, as written by go test -coverprofile.",
+		"Only applicable to commands `list` and `weblist`"),
 	"intel_syntax": helpText(
 		"Show assembly in Intel syntax",
 		"Only applicable to commands `disasm` and `weblist`"),
@@ -249,6 +260,17 @@ var configHelp = map[string]string{
 		"Attributes inlined functions to their first out-of-line caller."),
 	"showcolumns": helpText(
 		"Show column numbers at the source code line level."),
+
+	// Gecko output options.
+	"thread_label": helpText(
+		"Label used to split samples into threads",
+		"Only applicable to the `gecko` command.",
+		"Samples without the label are placed in a separate thread."),
+	"time_label": helpText(
+		"Numeric label holding sample timestamps",
+		"Only applicable to the `gecko` command.",
+		"Timestamps are in nanoseconds. Samples without them are spaced out",
+		"by the sampling period."),
 }
 
 func helpText(s ...string) string {
@@ -278,6 +300,12 @@ func usage(commandLine bool) string {
 	} else {
 		help = "  Commands:\n"
 		commands = append(commands, fmtHelp("o/options", "List options and their current values"))
+		commands = append(commands, fmtHelp("push filter=val", "Set a filter option, pushing it on the filter stack"))
+		commands = append(commands, fmtHelp("pop", "Restore the filter option on top of the filter stack"))
+		commands = append(commands, fmtHelp("filters", "List the filter stack and the filters in effect"))
+		commands = append(commands, fmtHelp("undo", "Undo the last change of the options"))
+		commands = append(commands, fmtHelp("load <config>", "Set the options to the ones of a named config"))
+		commands = append(commands, fmtHelp("save <config>", "Save the options as a named config"))
 		commands = append(commands, fmtHelp("q/quit/exit/^D", "Exit pprof"))
 	}
 
@@ -382,8 +410,18 @@ func awayFromTTY(format string) PostProcessor {
 	}
 }
 
+// invokeDot converts a DOT graph to the format with the dot tool. With
+// the builtin layout, only svg is supported and the input has already
+// been laid out by generateReport.
 func invokeDot(format string) PostProcessor {
 	return func(input io.Reader, output io.Writer, ui plugin.UI) error {
+		if useBuiltinLayout() {
+			if format != "svg" {
+				return fmt.Errorf("%s output requires Graphviz; install it or use svg output instead", format)
+			}
+			_, err := io.Copy(output, input)
+			return err
+		}
 		cmd := exec.Command("dot", "-T"+format)
 		cmd.Stdin, cmd.Stdout, cmd.Stderr = input, output, os.Stderr
 		if err := cmd.Run(); err != nil {
@@ -23,6 +23,8 @@ type config struct {
 	CompactLabels       bool    `json:"compact_labels,omitempty"`
 	SourcePath          string  `json:"-"`
 	TrimPath            string  `json:"-"`
+	Blame               bool    `json:"blame,omitempty"`
+	CoverProfile        string  `json:"-"`
 	IntelSyntax         bool    `json:"intel_syntax,omitempty"`
 	Mean                bool    `json:"mean,omitempty"`
 	SampleIndex         string  `json:"-"`
@@ -55,6 +57,10 @@ type config struct {
 
 	// Output granularity
 	Granularity string `json:"granularity,omitempty"`
+
+	// Gecko output options
+	ThreadLabel string `json:"thread_label,omitempty"`
+	TimeLabel   string `json:"time_label,omitempty"`
 }
 
 // defaultConfig returns the default configuration values; it is unaffected by
@@ -69,6 +75,8 @@ func defaultConfig() config {
 		DivideBy:     1.0,
 		Sort:         "flat",
 		Granularity:  "functions",
+		ThreadLabel:  "thread",
+		TimeLabel:    "timestamp",
 	}
 }
 
@@ -115,10 +123,11 @@ func init() {
 		"SampleIndex": "sample_index",
 
 		// Following fields are also not placed in URLs.
-		"Output":     "output",
-		"SourcePath": "source_path",
-		"TrimPath":   "trim_path",
-		"DivideBy":   "divide_by",
+		"Output":       "output",
+		"SourcePath":   "source_path",
+		"TrimPath":     "trim_path",
+		"CoverProfile": "coverprofile",
+		"DivideBy":     "divide_by",
 	}
 
 	// choices holds the list of allowed values for config fields that can
@@ -138,6 +147,7 @@ func init() {
 		"unit":                 "unit",
 		"compact_labels":       "compact",
 		"intel_syntax":         "intel",
+		"blame":                "blame",
 		"nodecount":            "n",
 		"nodefraction":         "nf",
 		"edgefraction":         "ef",
@@ -159,6 +169,8 @@ func init() {
 		"granularity":          "g",
 		"noinlines":            "noinlines",
 		"showcolumns":          "showcolumns",
+		"thread_label":         "threadlabel",
+		"time_label":           "timelabel",
 	}
 
 	def := defaultConfig()
@@ -319,6 +331,7 @@ func (cfg *config) resetTransient() {
 	cfg.Output = current.Output
 	cfg.SourcePath = current.SourcePath
 	cfg.TrimPath = current.TrimPath
+	cfg.CoverProfile = current.CoverProfile
 	cfg.DivideBy = current.DivideBy
 	cfg.SampleIndex = current.SampleIndex
 }
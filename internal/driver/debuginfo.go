@@ -0,0 +1,248 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/elfexec"
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+var (
+	// debugFileDirectories are the global directories holding separate
+	// debug files, as for the GDB debug-file-directory setting.
+	debugFileDirectories = []string{"/usr/lib/debug"}
+
+	buildIDRE = regexp.MustCompile(`^[[:xdigit:]]{4,}$`)
+)
+
+// findDebugFile looks for a separate file with the debug information of
+// the binary of a mapping, if the binary is not available locally or has
+// no debug information. Following the GDB conventions described in
+// https://sourceware.org/gdb/onlinedocs/gdb/Separate-Debug-Files.html, it
+// looks for the build ID of the binary in the .build-id subdirectory of the
+// global debug directories, and for the file named in the .gnu_debuglink
+// section of the binary next to it and in the global debug directories.
+// Finally, if download is set, it downloads the debug information from the
+// debuginfod servers listed in $DEBUGINFOD_URLS. It returns "" if no debug
+// file is found.
+func findDebugFile(m *profile.Mapping, download bool, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) string {
+	buildID := m.BuildID
+	local := false
+	if m.File != "" {
+		if f, err := os.Open(m.File); err == nil {
+			id, _ := elfexec.GetBuildID(f)
+			f.Close()
+			fileBuildID := fmt.Sprintf("%x", id)
+			// Ignore a different version of the binary.
+			if id == nil || buildID == "" || fileBuildID == buildID {
+				if hasDebugInfo(m.File) {
+					return ""
+				}
+				local = true
+				if id != nil {
+					buildID = fileBuildID
+				}
+			}
+		}
+	}
+	if !buildIDRE.MatchString(buildID) {
+		buildID = ""
+	}
+
+	var fileNames []string
+	if buildID != "" {
+		for _, dir := range debugFileDirectories {
+			fileNames = append(fileNames, filepath.Join(dir, ".build-id", buildID[:2], buildID[2:]+".debug"))
+		}
+	}
+	if local {
+		if link := debugLink(m.File); link != "" {
+			dir := filepath.Dir(m.File)
+			fileNames = append(fileNames, filepath.Join(dir, link), filepath.Join(dir, ".debug", link))
+			for _, debugDir := range debugFileDirectories {
+				fileNames = append(fileNames, filepath.Join(debugDir, dir, link))
+			}
+		}
+	}
+	// matches reports whether a debug file can be opened and matches the
+	// build ID of the binary.
+	matches := func(name string) bool {
+		f, err := obj.Open(name, m.Start, m.Limit, m.Offset, m.KernelRelocationSymbol)
+		if err != nil {
+			return false
+		}
+		fileBuildID := f.BuildID()
+		f.Close()
+		if buildID != "" && buildID != fileBuildID {
+			ui.PrintErr("Ignoring debug file " + name + ": build-id mismatch (" + buildID + " != " + fileBuildID + ")")
+			return false
+		}
+		return true
+	}
+	for _, name := range fileNames {
+		if name != m.File && matches(name) {
+			return name
+		}
+	}
+
+	if buildID == "" || !download {
+		return ""
+	}
+	name, err := fetchDebugInfo(buildID, ui, tr)
+	if err != nil {
+		ui.PrintErr("Failed to fetch debug information for build-id ", buildID, ": ", err.Error())
+		return ""
+	}
+	if name == "" || !matches(name) {
+		return ""
+	}
+	return name
+}
+
+// hasDebugInfo reports whether the named ELF file has DWARF debug
+// information.
+func hasDebugInfo(name string) bool {
+	ef, err := elf.Open(name)
+	if err != nil {
+		// Not an ELF file, separate debug files do not apply.
+		return true
+	}
+	defer ef.Close()
+	return ef.Section(".debug_info") != nil || ef.Section(".zdebug_info") != nil
+}
+
+// debugLink returns the name of the separate debug file recorded in the
+// .gnu_debuglink section of the named ELF file, or "" if there is none.
+func debugLink(name string) string {
+	ef, err := elf.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer ef.Close()
+	s := ef.Section(".gnu_debuglink")
+	if s == nil {
+		return ""
+	}
+	data, err := s.Data()
+	if err != nil {
+		return ""
+	}
+	// The name is NUL terminated, and followed by a CRC of the debug file.
+	i := bytes.IndexByte(data, 0)
+	if i <= 0 {
+		return ""
+	}
+	link := string(data[:i])
+	if link != filepath.Base(link) {
+		return ""
+	}
+	return link
+}
+
+// fetchDebugInfo returns the name of a local copy of the debug information
+// for a build ID, downloaded from the debuginfod servers listed in
+// $DEBUGINFOD_URLS. Downloads are cached in $DEBUGINFOD_CACHE_PATH, or in the
+// debuginfod_client directory of the user cache directory, with the same
+// layout as other debuginfod clients. Servers lacking the debug information
+// are asked for the executable. It returns "" if no servers are configured.
+func fetchDebugInfo(buildID string, ui plugin.UI, tr http.RoundTripper) (string, error) {
+	servers := strings.Fields(os.Getenv("DEBUGINFOD_URLS"))
+	if len(servers) == 0 {
+		return "", nil
+	}
+	cacheDir := os.Getenv("DEBUGINFOD_CACHE_PATH")
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		cacheDir = filepath.Join(userCacheDir, "debuginfod_client")
+	}
+	dir := filepath.Join(cacheDir, buildID)
+	artifacts := []string{"debuginfo", "executable"}
+	for _, artifact := range artifacts {
+		if name := filepath.Join(dir, artifact); fileExists(name) {
+			return name, nil
+		}
+	}
+
+	timeout := 90 * time.Second
+	if t, err := strconv.Atoi(os.Getenv("DEBUGINFOD_TIMEOUT")); err == nil && t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+	var lastErr error
+	for _, artifact := range artifacts {
+		for _, server := range servers {
+			source := strings.TrimSuffix(server, "/") + "/buildid/" + url.PathEscape(buildID) + "/" + artifact
+			ui.Print("Fetching " + artifact + " for build-id " + buildID + " from " + server)
+			body, err := fetchURL(source, timeout, tr)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			name, err := saveCached(dir, artifact, body)
+			body.Close()
+			if err != nil {
+				return "", err
+			}
+			return name, nil
+		}
+	}
+	return "", lastErr
+}
+
+// saveCached saves the contents of r to the named file of dir, so that the
+// file is complete once it exists.
+func saveCached(dir, name string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	target := filepath.Join(dir, name)
+	if err := os.Rename(f.Name(), target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+func fileExists(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
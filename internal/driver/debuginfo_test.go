@@ -0,0 +1,238 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/internal/elfexec"
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+// elfObj opens ELF files, reading their build ID.
+type elfObj struct {
+	testObj
+}
+
+func (elfObj) Open(file string, start, limit, offset uint64, relocationSymbol string) (plugin.ObjFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	id, err := elfexec.GetBuildID(f)
+	if err != nil {
+		return nil, err
+	}
+	return testFile{file, fmt.Sprintf("%x", id)}, nil
+}
+
+type elfSection struct {
+	name string
+	typ  elf.SectionType
+	data []byte
+}
+
+// makeELF returns a minimal 64-bit ELF file with a build ID note and the
+// given sections.
+func makeELF(buildID string, sections ...elfSection) []byte {
+	id, err := hex.DecodeString(buildID)
+	if err != nil {
+		panic(err)
+	}
+	var note bytes.Buffer
+	for _, v := range []uint32{4, uint32(len(id)), 3} { // NT_GNU_BUILD_ID
+		binary.Write(&note, binary.LittleEndian, v)
+	}
+	note.WriteString("GNU\x00")
+	note.Write(id)
+	for note.Len()%4 != 0 {
+		note.WriteByte(0)
+	}
+	sections = append([]elfSection{{}, {".note.gnu.build-id", elf.SHT_NOTE, note.Bytes()}}, sections...)
+	var names bytes.Buffer
+	names.WriteByte(0)
+	sections = append(sections, elfSection{".shstrtab", elf.SHT_STRTAB, nil})
+
+	const headerSize, sectionHeaderSize = 64, 64
+	var data bytes.Buffer
+	var headers bytes.Buffer
+	offset := headerSize
+	for i, s := range sections {
+		nameOffset := 0
+		if s.name != "" {
+			nameOffset = names.Len()
+			names.WriteString(s.name + "\x00")
+		}
+		if i == len(sections)-1 {
+			s.data = names.Bytes()
+		}
+		align := uint64(1)
+		if s.typ == elf.SHT_NOTE {
+			align = 4
+		}
+		for _, v := range []interface{}{
+			uint32(nameOffset), uint32(s.typ), uint64(0), uint64(0),
+			uint64(offset + data.Len()), uint64(len(s.data)), uint32(0), uint32(0), align, uint64(0),
+		} {
+			binary.Write(&headers, binary.LittleEndian, v)
+		}
+		data.Write(s.data)
+		for data.Len()%8 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	var f bytes.Buffer
+	f.Write([]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)})
+	f.Write(make([]byte, 9))
+	for _, v := range []interface{}{
+		uint16(elf.ET_EXEC), uint16(elf.EM_X86_64), uint32(elf.EV_CURRENT),
+		uint64(0), uint64(0), uint64(offset + data.Len()), uint32(0),
+		uint16(headerSize), uint16(56), uint16(0), uint16(sectionHeaderSize),
+		uint16(len(sections)), uint16(len(sections) - 1),
+	} {
+		binary.Write(&f, binary.LittleEndian, v)
+	}
+	f.Write(data.Bytes())
+	f.Write(headers.Bytes())
+	return f.Bytes()
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindDebugFile(t *testing.T) {
+	dir := t.TempDir()
+	saveDirs := debugFileDirectories
+	defer func() { debugFileDirectories = saveDirs }()
+	debugFileDirectories = []string{filepath.Join(dir, "debug")}
+	t.Setenv("DEBUGINFOD_URLS", "")
+
+	debugInfo := elfSection{".debug_info", elf.SHT_PROGBITS, []byte("debug")}
+	debugLink := func(name string) elfSection {
+		return elfSection{".gnu_debuglink", elf.SHT_PROGBITS, []byte(name + "\x00\x00\x00\x00\x00\x00\x00\x00")}
+	}
+	bin := filepath.Join(dir, "bin")
+	writeFile(t, filepath.Join(bin, "full"), makeELF("aaaa0001", debugInfo))
+	writeFile(t, filepath.Join(bin, "stripped"), makeELF("aaaa0002"))
+	writeFile(t, filepath.Join(dir, "debug", ".build-id", "aa", "aa0002.debug"), makeELF("aaaa0002", debugInfo))
+	writeFile(t, filepath.Join(bin, "linked"), makeELF("aaaa0003", debugLink("linked.debug")))
+	writeFile(t, filepath.Join(bin, ".debug", "linked.debug"), makeELF("aaaa0003", debugInfo))
+	writeFile(t, filepath.Join(bin, "linked2"), makeELF("aaaa0004", debugLink("linked2.debug")))
+	writeFile(t, filepath.Join(dir, "debug", bin, "linked2.debug"), makeELF("aaaa0004", debugInfo))
+	writeFile(t, filepath.Join(bin, "mismatch"), makeELF("aaaa0005", debugLink("mismatch.debug")))
+	writeFile(t, filepath.Join(bin, "mismatch.debug"), makeELF("bbbb0005", debugInfo))
+
+	for _, tc := range []struct {
+		desc, file, buildID, want string
+		msgCount                  int
+	}{
+		{"binary with debug info", filepath.Join(bin, "full"), "", "", 0},
+		{"build-id directory", filepath.Join(bin, "stripped"), "", filepath.Join(dir, "debug", ".build-id", "aa", "aa0002.debug"), 0},
+		{"missing binary", "/missing/stripped", "aaaa0002", filepath.Join(dir, "debug", ".build-id", "aa", "aa0002.debug"), 0},
+		{"debug link in .debug", filepath.Join(bin, "linked"), "", filepath.Join(bin, ".debug", "linked.debug"), 0},
+		{"debug link in global directory", filepath.Join(bin, "linked2"), "", filepath.Join(dir, "debug", bin, "linked2.debug"), 0},
+		{"debug link build-id mismatch", filepath.Join(bin, "mismatch"), "", "", 1},
+		{"different binary", filepath.Join(bin, "stripped"), "cccc0002", "", 0},
+		{"no debug file", "/missing/binary", "dddd0001", "", 0},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := &profile.Mapping{File: tc.file, BuildID: tc.buildID}
+			if got := findDebugFile(m, true, elfObj{}, &proftest.TestUI{T: t, Ignore: tc.msgCount}, nil); got != tc.want {
+				t.Errorf("findDebugFile(%s, %q): got %q, want %q", tc.file, tc.buildID, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFetchDebugInfo(t *testing.T) {
+	saveDirs := debugFileDirectories
+	defer func() { debugFileDirectories = saveDirs }()
+	debugFileDirectories = nil
+
+	files := map[string][]byte{
+		"/buildid/aaaa0001/debuginfo":  makeELF("aaaa0001"),
+		"/buildid/aaaa0002/executable": makeELF("aaaa0002"),
+		"/buildid/aaaa0003/debuginfo":  makeELF("bbbb0003"),
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		data, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	t.Setenv("DEBUGINFOD_URLS", "http://127.0.0.1:1/missing "+server.URL)
+	t.Setenv("DEBUGINFOD_CACHE_PATH", cacheDir)
+
+	for _, tc := range []struct {
+		desc, buildID, want string
+		msgCount            int
+	}{
+		{"debug info", "aaaa0001", filepath.Join(cacheDir, "aaaa0001", "debuginfo"), 0},
+		{"executable", "aaaa0002", filepath.Join(cacheDir, "aaaa0002", "executable"), 0},
+		{"build-id mismatch", "aaaa0003", "", 1},
+		{"not found", "aaaa0004", "", 1},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := &profile.Mapping{File: "/missing/binary", BuildID: tc.buildID}
+			ui := &proftest.TestUI{T: t, Ignore: tc.msgCount, AllowRx: "Fetching"}
+			if got := findDebugFile(m, true, elfObj{}, ui, nil); got != tc.want {
+				t.Errorf("findDebugFile(%q): got %q, want %q", tc.buildID, got, tc.want)
+			}
+		})
+	}
+
+	// Downloads are cached.
+	requests = 0
+	m := &profile.Mapping{File: "/missing/binary", BuildID: "aaaa0001"}
+	if got, want := findDebugFile(m, true, elfObj{}, &proftest.TestUI{T: t}, nil), filepath.Join(cacheDir, "aaaa0001", "debuginfo"); got != want {
+		t.Errorf("findDebugFile from cache: got %q, want %q", got, want)
+	}
+	if requests != 0 {
+		t.Errorf("findDebugFile from cache: got %d requests, want none", requests)
+	}
+
+	// Nothing is downloaded unless requested.
+	m = &profile.Mapping{File: "/missing/binary", BuildID: "aaaa0004"}
+	if got := findDebugFile(m, false, elfObj{}, &proftest.TestUI{T: t}, nil); got != "" || requests != 0 {
+		t.Errorf("findDebugFile without download: got %q and %d requests, want none", got, requests)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/google/pprof/internal/graph"
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/report"
 	"github.com/google/pprof/profile"
@@ -40,10 +41,17 @@ func PProf(eo *plugin.Options) error {
 	o := setDefaults(eo)
 
 	src, cmd, err := parseFlags(o)
-	if err != nil {
+	if err != nil || src == nil {
 		return err
 	}
 
+	if src.ServeDir != "" {
+		return serveProfileDir(src, o)
+	}
+	if src.Check != "" {
+		return runChecks(src, o)
+	}
+
 	p, err := fetchProfiles(src, o)
 	if err != nil {
 		return err
@@ -54,7 +62,10 @@ func PProf(eo *plugin.Options) error {
 	}
 
 	if src.HTTPHostport != "" {
-		return serveWebInterface(src.HTTPHostport, p, o, src.HTTPDisableBrowser)
+		return serveWebInterface(src, p, o)
+	}
+	if src.Script != "" {
+		return runScript(p, src.Script, o)
 	}
 	return interactive(p, o)
 }
@@ -111,14 +122,23 @@ func generateRawReport(p *profile.Profile, cmd []string, cfg config, o *plugin.O
 
 // generateReport is allowed to modify p.
 func generateReport(p *profile.Profile, cmd []string, cfg config, o *plugin.Options) error {
+	if cmd[0] == "html_report" {
+		return generateHTMLReport(p, cfg, o)
+	}
 	c, rpt, err := generateRawReport(p, cmd, cfg, o)
 	if err != nil {
 		return err
 	}
 
-	// Generate the report.
+	// Generate the report. SVG graphs are laid out here when dot is not
+	// used.
 	dst := new(bytes.Buffer)
-	if err := report.Generate(dst, rpt, o.Obj); err != nil {
+	if c.format == report.Dot && builtinLayoutCommands[cmd[0]] && useBuiltinLayout() {
+		g, config := report.GetDOT(rpt)
+		if err := graph.ComposeSVG(dst, g, &graph.DotAttributes{}, config); err != nil {
+			return err
+		}
+	} else if err := report.Generate(dst, rpt, o.Obj); err != nil {
 		return err
 	}
 	src := dst
@@ -201,6 +221,8 @@ func applyCommandOverrides(cmd string, outputFormat int, cfg config) config {
 	case report.Proto, report.Raw, report.Callgrind:
 		trim = false
 		cfg.Granularity = "addresses"
+	case report.Gecko, report.Insights:
+		trim = false
 	}
 
 	if !trim {
@@ -316,7 +338,13 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, cfg conf
 		SourcePath: cfg.SourcePath,
 		TrimPath:   cfg.TrimPath,
 
+		Blame:        cfg.Blame,
+		CoverProfile: cfg.CoverProfile,
+
 		IntelSyntax: cfg.IntelSyntax,
+
+		ThreadLabel: cfg.ThreadLabel,
+		TimeLabel:   cfg.TimeLabel,
 	}
 
 	if len(p.Mapping) > 0 && p.Mapping[0].File != "" {
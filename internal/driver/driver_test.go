@@ -21,6 +21,7 @@ import (
 	"net"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"runtime"
@@ -1063,7 +1064,7 @@ var autoCompleteTests = []struct {
 }
 
 func TestAutoComplete(t *testing.T) {
-	complete := newCompleter(functionNames(heapProfile()))
+	complete := newCompleter(functionNames(heapProfile()), nil)
 
 	for _, test := range autoCompleteTests {
 		if out := complete(test.in); out != test.out {
@@ -1753,3 +1754,30 @@ func (m *mockFile) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error)
 func (*mockFile) Close() error {
 	return nil
 }
+
+func TestBuiltinLayout(t *testing.T) {
+	t.Setenv("PPROF_GRAPH_LAYOUT", "builtin")
+	o := &plugin.Options{
+		Obj:    fakeObjTool{},
+		UI:     &proftest.TestUI{T: t, AllowRx: "Generating report in"},
+		Writer: oswriter{},
+	}
+	cfg := currentConfig()
+	cfg.Output = filepath.Join(t.TempDir(), "graph.svg")
+	if err := generateReport(makeFakeProfile(), []string{"svg"}, cfg, o); err != nil {
+		t.Fatalf("svg: %v", err)
+	}
+	data, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<g id="viewport"`, `<g id="node1" class="node">`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("svg output does not contain %q", want)
+		}
+	}
+	err = generateReport(makeFakeProfile(), []string{"png"}, cfg, o)
+	if err == nil || !strings.Contains(err.Error(), "requires Graphviz") {
+		t.Errorf("png: got error %v, want it to require Graphviz", err)
+	}
+}
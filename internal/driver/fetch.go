@@ -494,11 +494,7 @@ func fetch(source string, duration, timeout time.Duration, ui plugin.UI, tr http
 
 	// First determine whether the source is a file, if not, it will be treated as a URL.
 	if _, openErr := os.Stat(source); openErr == nil {
-		if isPerfFile(source) {
-			f, err = convertPerfData(source, ui)
-		} else {
-			f, err = os.Open(source)
-		}
+		f, err = os.Open(source)
 	} else {
 		sourceURL, timeout := adjustURL(source, duration, timeout)
 		if sourceURL != "" {
@@ -513,11 +509,17 @@ func fetch(source string, duration, timeout time.Duration, ui plugin.UI, tr http
 	if err == nil {
 		defer f.Close()
 		p, err = profile.Parse(f)
+		if err != nil && src == "" && isPerfFile(source) {
+			// Fall back to perf_to_profile for the perf.data files the
+			// native reader rejects, such as the ones recorded in pipe mode.
+			p, err = convertPerfData(source, ui, err)
+		}
 	}
 	return
 }
 
-// fetchURL fetches a profile from a URL using HTTP.
+// fetchURL fetches a profile from a URL using HTTP. The retries of the
+// transport happen within the timeout.
 func fetchURL(source string, timeout time.Duration, tr http.RoundTripper) (io.ReadCloser, error) {
 	client := &http.Client{
 		Transport: tr,
@@ -565,24 +567,24 @@ func isPerfFile(path string) bool {
 }
 
 // convertPerfData converts the file at path which should be in perf.data format
-// using the perf_to_profile tool and returns the file containing the
-// profile.proto formatted data.
-func convertPerfData(perfPath string, ui plugin.UI) (*os.File, error) {
+// using the perf_to_profile tool and returns the profile. parseErr is the
+// error of the native perf.data reader, reported if the conversion fails.
+func convertPerfData(perfPath string, ui plugin.UI, parseErr error) (*profile.Profile, error) {
 	ui.Print(fmt.Sprintf(
 		"Converting %s to a profile.proto... (May take a few minutes)",
 		perfPath))
-	profile, err := newTempFile(os.TempDir(), "pprof_", ".pb.gz")
+	f, err := newTempFile(os.TempDir(), "pprof_", ".pb.gz")
 	if err != nil {
 		return nil, err
 	}
-	deferDeleteTempFile(profile.Name())
-	cmd := exec.Command("perf_to_profile", "-i", perfPath, "-o", profile.Name(), "-f")
+	deferDeleteTempFile(f.Name())
+	defer f.Close()
+	cmd := exec.Command("perf_to_profile", "-i", perfPath, "-o", f.Name(), "-f")
 	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
 	if err := cmd.Run(); err != nil {
-		profile.Close()
-		return nil, fmt.Errorf("failed to convert perf.data file. Try github.com/google/perf_data_converter: %v", err)
+		return nil, fmt.Errorf("%v; failed to convert perf.data file. Try github.com/google/perf_data_converter: %v", parseErr, err)
 	}
-	return profile, nil
+	return profile.Parse(f)
 }
 
 // adjustURL validates if a profile source is a URL and returns an
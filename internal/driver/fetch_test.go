@@ -26,6 +26,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"regexp"
@@ -232,6 +233,45 @@ func TestFetch(t *testing.T) {
 	}
 }
 
+func TestFetchPerfFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake perf_to_profile is a shell script")
+	}
+	dir := t.TempDir()
+	// A perf.data file recorded in pipe mode, with a 16-byte header, which
+	// the native reader rejects.
+	perfData := filepath.Join(dir, "perf.data")
+	if err := os.WriteFile(perfData, []byte("PERFILE2\x10\x00\x00\x00\x00\x00\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	converted, err := filepath.Abs("testdata/go.crc32.cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := &proftest.TestUI{T: t, AllowRx: "Converting"}
+	defer cleanupTempFiles()
+
+	if _, err := exec.LookPath("perf_to_profile"); err != nil {
+		if _, _, err := fetch(perfData, 0, 0, ui, &httpTransport{}); err == nil || !strings.Contains(err.Error(), "pipe mode") || !strings.Contains(err.Error(), "perf_data_converter") {
+			t.Errorf("without perf_to_profile: got error %v, want the ones of the reader and of the conversion", err)
+		}
+	}
+
+	// perf_to_profile -i perf.data -o profile -f
+	script := "#!/bin/sh\ncp " + converted + " \"$4\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "perf_to_profile"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	p, _, err := fetch(perfData, 0, 0, ui, &httpTransport{})
+	if err != nil {
+		t.Fatalf("with perf_to_profile: %v", err)
+	}
+	if len(p.Sample) == 0 {
+		t.Error("with perf_to_profile: want non-zero samples")
+	}
+}
+
 func TestFetchWithBase(t *testing.T) {
 	baseConfig := currentConfig()
 	defer setCurrentConfig(baseConfig)
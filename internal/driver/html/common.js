@@ -380,6 +380,44 @@ function initConfigManager() {
   bind('click', elem('delete-cancel'), cancelDialog);
   bind('click', elem('delete-confirm'), commitDelete);
 
+  // Snapshot the current view, and show the link to the snapshot.
+  function shareView(e) {
+    e.preventDefault();
+    const shareURL = e.currentTarget.href;
+    const path = location.pathname;
+    const body = new URLSearchParams();
+    body.set('view', path.substring(path.lastIndexOf('/') + 1));
+    body.set('query', location.search.substring(1));
+    shareURLInput.value = '';
+    shareError.innerText = '';
+    showDialog(shareDialog);
+    fetch(shareURL, {method: 'POST', body: body}).then((resp) => {
+      if (!resp.ok) throw new Error('Share failed');
+      return resp.json();
+    }).then((data) => {
+      shareURLInput.value = new URL(data.url, shareURL).href;
+      shareURLInput.select();
+    }).catch((err) => {
+      shareError.innerText = err.message;
+    });
+  }
+
+  function copyShareURL(e) {
+    shareURLInput.select();
+    navigator.clipboard.writeText(shareURLInput.value).then(() => {
+      showDialog(null);
+    }, () => {
+      shareError.innerText = 'Copy failed';
+    });
+  }
+
+  const shareDialog = elem('share-dialog');
+  const shareURLInput = elem('share-url');
+  const shareError = elem('share-error');
+  bind('click', document.getElementById('share-view'), shareView);
+  bind('click', elem('share-copy'), copyShareURL);
+  bind('click', elem('share-close'), cancelDialog);
+
   // Activate deletion button for all config entries in menu.
   for (const del of Array.from(document.getElementsByClassName('menu-delete-btn'))) {
     bind('click', del, (e) => {
@@ -388,6 +426,35 @@ function initConfigManager() {
   }
 }
 
+// Upload the profiles dropped on the page with the upload form, if any.
+function initUpload() {
+  'use strict';
+
+  const form = document.getElementById('upload-form');
+  if (!form) return;
+  const input = form.querySelector('input[type=file]');
+
+  function hasFiles(e) {
+    return e.dataTransfer && Array.from(e.dataTransfer.types).includes('Files');
+  }
+
+  document.addEventListener('dragover', function(e) {
+    if (!hasFiles(e)) return;
+    e.preventDefault();
+    form.classList.add('dragging');
+  });
+  document.addEventListener('dragleave', function(e) {
+    form.classList.remove('dragging');
+  });
+  document.addEventListener('drop', function(e) {
+    if (!hasFiles(e)) return;
+    e.preventDefault();
+    form.classList.remove('dragging');
+    input.files = e.dataTransfer.files;
+    form.submit();
+  });
+}
+
 // options if present can contain:
 //   hiliter: function(Number, Boolean): Boolean
 //     Overridable mechanism for highlighting/unhighlighting specified node.
@@ -678,7 +745,7 @@ function viewer(baseUrl, nodes, options) {
   }
 
   const ids = ['topbtn', 'graphbtn',
-               'flamegraph',
+               'flamegraph', 'icicle', 'sunburst', 'treemap',
                'peek', 'list',
                'disasm', 'focus', 'ignore', 'hide', 'show', 'show-from'];
   ids.forEach(makeSearchLinkDynamic);
@@ -697,6 +764,7 @@ function viewer(baseUrl, nodes, options) {
 
   addAction('details', handleDetails);
   initConfigManager();
+  initUpload();
 
   search.addEventListener('input', handleSearch);
   search.addEventListener('keydown', handleKey);
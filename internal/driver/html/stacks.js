@@ -1,7 +1,9 @@
-// stackViewer displays a flame-graph like view (extended to show callers).
+// stackViewer displays a flame-graph like view (extended to show callers),
+// or one of the alternative views of the same stacks.
 //   stacks - report.StackSet
 //   nodes  - List of names for each source in report.StackSet
-function stackViewer(stacks, nodes) {
+//   view   - One of "flamegraph" (the default), "icicle", "sunburst" or "treemap"
+function stackViewer(stacks, nodes, view) {
   'use strict';
 
   // Constants used in rendering.
@@ -12,6 +14,11 @@ function stackViewer(stacks, nodes) {
   const TEXT_MARGIN = 2;
   const FONT_SIZE = 12;
   const MIN_FONT_SIZE = 8;
+  const PX_PER_PT = 4/3;
+  const MIN_RING = 24;
+  const MAX_RING = 100;
+  const MIN_HEIGHT = 200;
+  const SVG_NS = 'http://www.w3.org/2000/svg';
 
   // Mapping from unit to a list of display scales/labels.
   // List should be ordered by increasing unit size.
@@ -38,13 +45,13 @@ function stackViewer(stacks, nodes) {
   let actionMenuOn = false; // Is action menu visible?
   let actionTarget = null;  // Box on which action menu is operating.
   let diff = false;         // Are we displaying a diff?
+  let hasBase = false;      // Are we comparing with a diff base?
 
   for (const stack of stacks.Stacks) {
-    if (stack.Value < 0) {
-      diff = true;
-      break;
-    }
+    if (stack.Value < 0) diff = true;
+    if (stack.Base != 0) hasBase = true;
   }
+  diff = diff && !hasBase;
 
   // Setup to allow measuring text width.
   const textSizer = document.createElement('canvas');
@@ -52,6 +59,7 @@ function stackViewer(stacks, nodes) {
   const textContext = textSizer.getContext('2d');
 
   // Get DOM elements.
+  const holder = find('stack-holder');
   const chart = find('stack-chart');
   const search = find('search');
   const actions = find('action-menu');
@@ -193,7 +201,7 @@ function stackViewer(stacks, nodes) {
     if (actionMenuOn) return;
     const src = stacks.Sources[box.src];
     div.title = details(box) + ' │ ' + src.FullName + (src.Inlined ? "\n(inlined)" : "");
-    detailBox.innerText = summary(box.sumpos, box.sumneg);
+    detailBox.innerText = summary(box);
     // Highlight all boxes that have the same source as box.
     toggleClass(box.src, 'hilite2', true);
   }
@@ -239,19 +247,216 @@ function stackViewer(stacks, nodes) {
       }
     }
 
-    const width = chart.clientWidth;
     elems.clear();
     actionTarget = null;
-    const [pos, neg] = totalValue(places);
-    const total = pos + neg;
-    const xscale = (width-2*PADDING) / total; // Converts from profile value to X pixels
+    displayList.length = 0;
+    if (view == 'sunburst') {
+      renderSunburst(places);
+    } else if (view == 'treemap') {
+      renderTreemap(places);
+    } else {
+      renderFlame(places);
+    }
+  }
+
+  // renderFlame draws the callees of the pivots below them and, except in
+  // the icicle view, their callers above them.
+  function renderFlame(places) {
+    const width = chart.clientWidth;
+    const total = totalValue(places);
+    const xscale = (width-2*PADDING) / total.size; // Converts from profile value to X pixels
     const x = PADDING;
     const y = 0;
 
-    displayList.length = 0;
     renderStacks(0, xscale, x, y, places, +1);  // Callees
-    renderStacks(0, xscale, x, y-ROW, places, -1);  // Callers (ROW left for separator)
-    display(xscale, pos, neg, displayList);
+    if (view != 'icicle') {
+      renderStacks(0, xscale, x, y-ROW, places, -1);  // Callers (ROW left for separator)
+    }
+    display(xscale, total, displayList);
+  }
+
+  // renderSunburst draws the pivots in the middle of the chart and their
+  // callees in rings around them. The angle covered by a frame is
+  // proportional to its value.
+  function renderSunburst(places) {
+    const top = ROW + 2*PADDING;  // Room for separator
+    const size = Math.max(MIN_HEIGHT,
+                          Math.min(chart.clientWidth, holder.clientHeight - top) - 2*PADDING);
+
+    // Make rings as wide as possible while keeping them readable. If that
+    // leaves too little room for the deepest stacks, they are cut off.
+    let levels = 1;
+    for (const place of places) {
+      levels = Math.max(levels, stacks.Stacks[place.Stack].Sources.length - place.Pos);
+    }
+    const ring = Math.min(MAX_RING, Math.max(MIN_RING, size / 2 / levels));
+    const radius = Math.min(size / 2, ring * levels);
+
+    // Lay out frames as in the flame graph, using the length of the outer
+    // circle as width. Each row of boxes then becomes a ring.
+    const total = totalValue(places);
+    const xscale = 2 * Math.PI * radius / total.size;
+    renderStacks(0, xscale, 0, 0, places, +1);
+
+    const svg = document.createElementNS(SVG_NS, 'svg');
+    svg.classList.add('sunburst');
+    svg.setAttribute('width', 2*radius);
+    svg.setAttribute('height', 2*radius);
+    svg.style.left = (chart.clientWidth - 2*radius) / 2 + 'px';
+    svg.style.top = top + 'px';
+    for (const box of displayList) {
+      const r0 = (box.y / ROW) * ring;
+      if (r0 + ring > radius + 1) continue;  // Cut off
+      const a0 = box.x / radius;
+      const a1 = (box.x + box.width) / radius;
+      svg.appendChild(drawArc(box, radius, r0, r0 + ring, a0, a1));
+    }
+
+    chart.style.height = (top + 2*radius + 2*PADDING) + 'px';
+    chart.replaceChildren(drawSep(ROW, total), svg);
+  }
+
+  // renderTreemap draws the self values of the stacks going through the
+  // pivots as nested rectangles grouped by package and file. The area of
+  // a rectangle is proportional to its value.
+  function renderTreemap(places) {
+    // Build the package/file/function hierarchy from the leaf of each stack.
+    const root = {children: new Map()};
+    const seen = new Set();
+    for (const place of places) {
+      if (seen.has(place.Stack)) continue; // Do not double-count stacks
+      seen.add(place.Stack);
+      const stack = stacks.Stacks[place.Stack];
+      const leaf = stack.Sources[stack.Sources.length-1];
+      const src = stacks.Sources[leaf];
+      const path = [src.Package || '(unknown package)',
+                    src.FileName || '(unknown file)',
+                    src.FullName];
+      let node = root;
+      for (let i = 0; i < path.length; i++) {
+        let child = node.children.get(path[i]);
+        if (!child) {
+          child = {name: path[i], src: leaf, sumpos: 0, sumneg: 0, base: 0, size: 0, self: 0};
+          if (i < path.length-1) child.children = new Map();
+          node.children.set(path[i], child);
+        }
+        if (stack.Value < 0) {
+          child.sumneg += -stack.Value;
+        } else {
+          child.sumpos += stack.Value;
+        }
+        child.base += stack.Base;
+        child.size += stackSize(stack);
+        child.self += stack.Value;
+        node = child;
+      }
+    }
+
+    const top = ROW + 2*PADDING;  // Room for separator
+    const width = chart.clientWidth - 2*PADDING;
+    const height = Math.max(MIN_HEIGHT, holder.clientHeight - top - 2*PADDING);
+    const divs = [];
+    divs.push(drawSep(ROW, totalValue(places)));
+    layoutTreemap(root, PADDING, top, width, height, divs);
+
+    chart.style.height = (top + height + 2*PADDING) + 'px';
+    chart.replaceChildren(...divs);
+  }
+
+  // layoutTreemap adds to divs the rectangles for the children of node,
+  // filling the rectangle at x,y of size w,h.
+  function layoutTreemap(node, x, y, w, h, divs) {
+    const items = [];
+    for (const child of node.children.values()) {
+      if (child.size > 0) items.push({node: child, size: child.size});
+    }
+    items.sort((a, b) => b.size - a.size);
+    for (const r of squarify(items, x, y, w, h)) {
+      if (r.w < MIN_WIDTH || r.h < MIN_WIDTH) continue;
+      const child = r.item.node;
+      if (!child.children) {
+        divs.push(drawTile(child, r.x, r.y, r.w, r.h));
+        continue;
+      }
+
+      // Group with a header showing its name, if there is room.
+      const g = makeRect('treemap-group', r.x, r.y, r.w - 1, r.h - 1);
+      g.title = child.name + ' │ ' + summary(child);
+      let header = 1;
+      if (r.w >= MIN_TEXT_WIDTH && r.h >= 2*ROW) {
+        const t = document.createElement('div');
+        t.classList.add('boxtext');
+        fitText(t, r.w - 2*TEXT_MARGIN, [child.name, child.name.split('/').pop()]);
+        g.appendChild(t);
+        header = ROW;
+      }
+      divs.push(g);
+      layoutTreemap(child, r.x + 1, r.y + header, r.w - 3, r.h - header - 2, divs);
+    }
+  }
+
+  // squarify lays out items, sorted by decreasing size, in the rectangle
+  // at x,y of size w,h. Items are placed in rows along the shorter side,
+  // and a row is closed when adding an item would make its rectangles
+  // less square. Returns a list of {item, x, y, w, h}.
+  function squarify(items, x, y, w, h) {
+    const result = [];
+    if (w <= 0 || h <= 0) return result;
+    let total = 0;
+    for (const item of items) total += item.size;
+    const scale = (w * h) / total;  // Converts from size to area
+
+    // worst returns the largest aspect ratio of the rectangles of row if
+    // it is placed along side.
+    function worst(row, side) {
+      let sum = 0, min = Infinity, max = 0;
+      for (const item of row) {
+        const area = item.size * scale;
+        sum += area;
+        min = Math.min(min, area);
+        max = Math.max(max, area);
+      }
+      return Math.max(side*side*max / (sum*sum), (sum*sum) / (side*side*min));
+    }
+
+    // place lays out row along the shorter side of the remaining space.
+    function place(row) {
+      let area = 0;
+      for (const item of row) area += item.size * scale;
+      if (w >= h) {
+        const rw = area / h;
+        let ry = y;
+        for (const item of row) {
+          const rh = item.size * scale / rw;
+          result.push({item: item, x: x, y: ry, w: rw, h: rh});
+          ry += rh;
+        }
+        x += rw;
+        w -= rw;
+      } else {
+        const rh = area / w;
+        let rx = x;
+        for (const item of row) {
+          const rw = item.size * scale / rh;
+          result.push({item: item, x: rx, y: y, w: rw, h: rh});
+          rx += rw;
+        }
+        y += rh;
+        h -= rh;
+      }
+    }
+
+    let row = [];
+    for (const item of items) {
+      const side = Math.min(w, h);
+      if (row.length > 0 && worst(row.concat([item]), side) > worst(row, side)) {
+        place(row);
+        row = [];
+      }
+      row.push(item);
+    }
+    if (row.length > 0) place(row);
+    return result;
   }
 
   // renderStacks creates boxes with top-left at x,y with children drawn as
@@ -283,6 +488,9 @@ function stackViewer(stacks, nodes) {
   //   self: number;     // Contribution as leaf (may be < 0 for diffs)
   //   sumpos: number;	 // Sum of |self| of positive nodes in tree (>= 0)
   //   sumneg: number;	 // Sum of |self| of negative nodes in tree (>= 0)
+  //   base: number;     // Sum of diff base values of nodes in tree (>= 0)
+  //   size: number;     // Width of tree in profile units (see stackSize)
+  //   selfsize: number; // Width of self contribution in profile units
   //   places: Place[];  // Stack slots that contributed to this group
   // }
   //
@@ -294,11 +502,12 @@ function stackViewer(stacks, nodes) {
   //   src: number;	   // Index in stacks.Sources
   //   sumpos: number;	   // From corresponding Group
   //   sumneg: number;	   // From corresponding Group
+  //   base: number;	   // From corresponding Group
   //   self: number;	   // From corresponding Group
   // };
 
   function groupWidth(xscale, g) {
-    return xscale * (g.sumpos + g.sumneg);
+    return xscale * g.size;
   }
 
   function renderGroup(depth, xscale, x, y, g, direction) {
@@ -316,12 +525,13 @@ function stackViewer(stacks, nodes) {
         src:    g.src,
 	sumpos: g.sumpos,
 	sumneg: g.sumneg,
+        base:   g.base,
         self:   g.self,
       };
       displayList.push(box);
       if (direction > 0) {
 	// Leave gap on left hand side to indicate self contribution.
-	x += xscale*Math.abs(g.self);
+	x += xscale*g.selfsize;
       }
     }
     y += direction * ROW;
@@ -366,7 +576,8 @@ function stackViewer(stacks, nodes) {
       let group = groupMap.get(src);
       if (!group) {
         const name = stacks.Sources[src].FullName;
-        group = {name: name, src: src, sumpos: 0, sumneg: 0, self: 0, places: []};
+        group = {name: name, src: src, sumpos: 0, sumneg: 0, base: 0, size: 0,
+                 self: 0, selfsize: 0, places: []};
         groupMap.set(src, group);
         groups.push(group);
       }
@@ -375,7 +586,13 @@ function stackViewer(stacks, nodes) {
       } else {
 	group.sumpos += stack.Value;
       }
-      group.self += (place.Pos == stack.Sources.length-1) ? stack.Value : 0;
+      const size = stackSize(stack);
+      group.base += stack.Base;
+      group.size += size;
+      if (place.Pos == stack.Sources.length-1) {
+        group.self += stack.Value;
+        group.selfsize += size;
+      }
       group.places.push(place);
     }
 
@@ -383,13 +600,13 @@ function stackViewer(stacks, nodes) {
     // Though alphabetical ordering is a potential alternative that will make
     // profile comparisons easier.
     groups.sort(function(a, b) {
-      return (b.sumpos + b.sumneg) - (a.sumpos + a.sumneg);
+      return b.size - a.size;
     });
 
     return groups;
   }
 
-  function display(xscale, posTotal, negTotal, list) {
+  function display(xscale, total, list) {
     // Sort boxes so that text selection follows a predictable order.
     list.sort(function(a, b) {
       if (a.y != b.y) return a.y - b.y;
@@ -405,7 +622,7 @@ function stackViewer(stacks, nodes) {
       box.y -= adjust;
       divs.push(drawBox(xscale, box));
     }
-    divs.push(drawSep(-adjust, posTotal, negTotal));
+    divs.push(drawSep(-adjust, total));
 
     const h = (list.length > 0 ?  list[list.length-1].y : 0) + 4*ROW;
     chart.style.height = h+'px';
@@ -416,20 +633,14 @@ function stackViewer(stacks, nodes) {
     const srcIndex = box.src;
     const src = stacks.Sources[srcIndex];
 
-    function makeRect(cl, x, y, w, h) {
-      const r = document.createElement('div');
-      r.style.left = x+'px';
-      r.style.top = y+'px';
-      r.style.width = w+'px';
-      r.style.height = h+'px';
-      r.classList.add(cl);
-      return r;
-    }
-
     // Background
     const w = box.width - 1; // Leave 1px gap
     const r = makeRect('boxbg', box.x, box.y, w, ROW);
-    if (!diff) r.style.background = makeColor(src.Color);
+    if (hasBase) {
+      r.style.background = diffColor(box.base, box.sumpos);
+    } else if (!diff) {
+      r.style.background = makeColor(src.Color);
+    }
     addElem(srcIndex, r);
     if (!src.Inlined) {
       r.classList.add('not-inlined');
@@ -460,9 +671,136 @@ function stackViewer(stacks, nodes) {
     return r;
   }
 
-  function drawSep(y, posTotal, negTotal) {
+  // drawTile returns the treemap rectangle for a function.
+  function drawTile(box, x, y, w, h) {
+    const src = stacks.Sources[box.src];
+    const r = makeRect('boxbg', x, y, w - 1, h - 1); // Leave 1px gap
+    if (hasBase) {
+      r.style.background = diffColor(box.base, box.sumpos);
+    } else if (!diff) {
+      r.style.background = makeColor(src.Color);
+    }
+    addElem(box.src, r);
+
+    // Positive/negative indicator for diff mode.
+    if (diff) {
+      const delta = box.sumpos - box.sumneg;
+      const partWidth = (w - 1) * Math.abs(delta) / (box.sumpos + box.sumneg);
+      if (partWidth >= MIN_WIDTH) {
+        r.appendChild(makeRect((delta < 0 ? 'negative' : 'positive'),
+                               0, 0, partWidth, h - 1));
+      }
+    }
+
+    // Label
+    if (w >= MIN_TEXT_WIDTH && h >= ROW) {
+      const t = document.createElement('div');
+      t.classList.add('boxtext');
+      fitText(t, w - 2*TEXT_MARGIN, src.Display);
+      r.appendChild(t);
+    }
+
+    r.addEventListener('click', () => { switchPivots(pprofQuoteMeta(src.UniqueName)); });
+    r.addEventListener('mouseenter', () => { handleEnter(box, r); });
+    r.addEventListener('mouseleave', () => { handleLeave(box); });
+    r.addEventListener('contextmenu', (e) => { showActionMenu(e, box); });
+    return r;
+  }
+
+  // drawArc returns the sunburst element for box: the part of the ring
+  // between radii r0 and r1 and angles a0 and a1 (clockwise from the top)
+  // around the point c,c.
+  function drawArc(box, c, r0, r1, a0, a1) {
+    const src = stacks.Sources[box.src];
+    const g = document.createElementNS(SVG_NS, 'g');
+
+    const path = document.createElementNS(SVG_NS, 'path');
+    path.setAttribute('d', arcPath(c, r0, r1, a0, a1));
+    if (diff) {
+      const delta = box.sumpos - box.sumneg;
+      if (delta != 0) path.classList.add(delta < 0 ? 'negative' : 'positive');
+    } else if (hasBase) {
+      path.style.fill = diffColor(box.base, box.sumpos);
+    } else {
+      path.style.fill = makeColor(src.Color);
+    }
+    addElem(box.src, path);
+    g.appendChild(path);
+
+    // SVG elements do not have tooltips unless they contain a title.
+    const title = document.createElementNS(SVG_NS, 'title');
+    title.textContent = details(box) + ' │ ' + src.FullName + (src.Inlined ? "\n(inlined)" : "");
+    g.appendChild(title);
+
+    // Label, written along the radius except in a full center circle.
+    const full = (a1 - a0 >= 2*Math.PI - 1e-6);
+    const center = (r0 == 0 && full);
+    const avail = (center ? 2*r1 : r1 - r0) - 2*TEXT_MARGIN;
+    const room = full ? Infinity : (a1 - a0) * (r0 + r1) / 2;  // Arc length
+    const fit = pickText(avail, src.Display);
+    if (fit.width <= avail && fit.size * PX_PER_PT <= room) {
+      const mid = (a0 + a1) / 2;
+      const r = center ? 0 : (r0 + r1) / 2;
+      const x = c + r * Math.sin(mid);
+      const y = c - r * Math.cos(mid);
+      // Keep text upright on the left half of the circle.
+      let angle = center ? 0 : mid * 180 / Math.PI - 90;
+      if (angle > 90) angle -= 180;
+      const t = document.createElementNS(SVG_NS, 'text');
+      t.classList.add('arctext');
+      t.setAttribute('x', x);
+      t.setAttribute('y', y);
+      t.setAttribute('transform', `rotate(${angle} ${x} ${y})`);
+      t.style.fontSize = fit.size + 'pt';
+      t.textContent = fit.text;
+      g.appendChild(t);
+    }
+
+    g.addEventListener('click', () => { switchPivots(pprofQuoteMeta(src.UniqueName)); });
+    g.addEventListener('mouseenter', () => { handleEnter(box, g); });
+    g.addEventListener('mouseleave', () => { handleLeave(box); });
+    g.addEventListener('contextmenu', (e) => { showActionMenu(e, box); });
+    return g;
+  }
+
+  // arcPath returns SVG path data for the part of the ring between radii
+  // r0 and r1 and angles a0 and a1 around the point c,c.
+  function arcPath(c, r0, r1, a0, a1) {
+    function point(r, a) {
+      return (c + r * Math.sin(a)).toFixed(2) + ' ' + (c - r * Math.cos(a)).toFixed(2);
+    }
+    if (a1 - a0 >= 2*Math.PI - 1e-6) {
+      // An arc cannot end where it starts, so full rings are drawn as two
+      // halves. The inner circle runs the other way to leave a hole.
+      const circle = (r, sweep) => `M ${point(r, a0)}` +
+            ` A ${r} ${r} 0 0 ${sweep} ${point(r, a0 + Math.PI)}` +
+            ` A ${r} ${r} 0 0 ${sweep} ${point(r, a0)} Z`;
+      return circle(r1, 1) + (r0 > 0 ? ' ' + circle(r0, 0) : '');
+    }
+    const large = (a1 - a0 > Math.PI) ? 1 : 0;
+    let d = `M ${point(r1, a0)} A ${r1} ${r1} 0 ${large} 1 ${point(r1, a1)}`;
+    if (r0 > 0) {
+      d += ` L ${point(r0, a1)} A ${r0} ${r0} 0 ${large} 0 ${point(r0, a0)}`;
+    } else {
+      d += ` L ${point(0, 0)}`;
+    }
+    return d + ' Z';
+  }
+
+  // makeRect returns a div with class cl covering the specified rectangle.
+  function makeRect(cl, x, y, w, h) {
+    const r = document.createElement('div');
+    r.style.left = x+'px';
+    r.style.top = y+'px';
+    r.style.width = w+'px';
+    r.style.height = h+'px';
+    r.classList.add(cl);
+    return r;
+  }
+
+  function drawSep(y, total) {
     const m = document.createElement('div');
-    m.innerText = summary(posTotal, negTotal);
+    m.innerText = summary(total);
     m.style.top = (y-ROW) + 'px';
     m.style.left = PADDING + 'px';
     m.style.width = (chart.clientWidth - PADDING*2) + 'px';
@@ -493,6 +831,17 @@ function stackViewer(stacks, nodes) {
 
   // fitText sets text and font-size clipped to the specified width w.
   function fitText(t, avail, textList) {
+    const fit = pickText(avail, textList);
+    if (fit.size != FONT_SIZE) {
+      t.style.fontSize = fit.size + 'pt';
+    }
+    t.innerText = fit.text;
+  }
+
+  // pickText returns the text and font size to use to fit one of the
+  // entries of textList in avail pixels, along with the resulting width.
+  // The width may exceed avail if no entry fits at the minimum font size.
+  function pickText(avail, textList) {
     // Find first entry in textList that fits.
     let width = avail;
     textContext.font = FONT_SIZE + 'pt Arial';
@@ -500,42 +849,54 @@ function stackViewer(stacks, nodes) {
       let text = textList[i];
       width = textContext.measureText(text).width;
       if (width <= avail) {
-        t.innerText = text;
-        return;
+        return {text: text, size: FONT_SIZE, width: width};
       }
     }
 
     // Try to fit by dropping font size.
     let text = textList[textList.length-1];
     const fs = Math.max(MIN_FONT_SIZE, FONT_SIZE * (avail / width));
-    t.style.fontSize = fs + 'pt';
-    t.innerText = text;
+    return {text: text, size: fs, width: width * fs / FONT_SIZE};
   }
 
-  // totalValue returns the positive and negative sums of the Values of stacks
-  // listed in places.
+  // totalValue returns the sums of the stacks listed in places, in the form
+  // of a Group without places.
   function totalValue(places) {
     const seen = new Set();
-    let pos = 0;
-    let neg = 0;
+    const total = {sumpos: 0, sumneg: 0, base: 0, size: 0};
     for (const place of places) {
       if (seen.has(place.Stack)) continue; // Do not double-count stacks
       seen.add(place.Stack);
       const stack = stacks.Stacks[place.Stack];
       if (stack.Value < 0) {
-	neg += -stack.Value;
+	total.sumneg += -stack.Value;
       } else {
-	pos += stack.Value;
+	total.sumpos += stack.Value;
       }
+      total.base += stack.Base;
+      total.size += stackSize(stack);
     }
-    return [pos, neg];
+    return total;
+  }
+
+  // stackSize returns the width taken by stack in profile units. When
+  // comparing with a diff base, this is the larger of the base and current
+  // values, so that stacks that shrank or went away remain visible.
+  function stackSize(stack) {
+    if (hasBase) return Math.max(stack.Value, stack.Base);
+    return Math.abs(stack.Value);
   }
 
-  function summary(pos, neg) {
+  function summary(g) {
     // Examples:
     //    6s (10%)
     //    12s (20%) 🠆 18s (30%)
-    return diff ? diffText(neg, pos) : percentText(pos);
+    //    12s (20%) 🠆 18s (30%) │ +6s (+50%)
+    if (hasBase) {
+      // All current values are positive.
+      return diffText(g.base, g.sumpos) + " │ " + deltaText(g.base, g.sumpos);
+    }
+    return diff ? diffText(g.sumneg, g.sumpos) : percentText(g.sumpos);
   }
 
   function details(box) {
@@ -543,7 +904,8 @@ function stackViewer(stacks, nodes) {
     //    6s (10%)
     //    6s (10%) │ self 3s (5%)
     //    6s (10%) │ 12s (20%) 🠆 18s (30%)
-    let result = percentText(box.sumpos - box.sumneg);
+    //    12s (20%) 🠆 18s (30%) │ +6s (+50%) │ self 3s (5%)
+    let result = hasBase ? summary(box) : percentText(box.sumpos - box.sumneg);
     if (box.self != 0) {
       result += " │ self " + unitText(box.self);
     }
@@ -559,6 +921,17 @@ function stackViewer(stacks, nodes) {
     return percentText(from) + " 🠆 " + percentText(to);
   }
 
+  // deltaText returns text that displays the change from from to to, in
+  // appropriate units and relative to from. E.g., +1s (+11.1%)
+  function deltaText(from, to) {
+    const sign = (to >= from) ? "+" : "";
+    let result = sign + unitText(to - from);
+    if (from != 0) {
+      result += " (" + sign + Number((100.0 * (to - from) / from).toFixed(1)) + "%)";
+    }
+    return result;
+  }
+
   // percentText returns text that displays v in appropriate units alongside its
   // percentange.
   function percentText(v) {
@@ -596,6 +969,17 @@ function stackViewer(stacks, nodes) {
     return elem;
   }
 
+  // diffColor returns the color of a frame whose value went from base to
+  // value: red for growth and blue for shrinkage, darker for larger changes
+  // relative to the size of the frame.
+  function diffColor(base, value) {
+    const size = Math.max(base, value);
+    const change = (size == 0) ? 0 : (value - base) / size; // In [-1, 1]
+    const hue = (change < 0) ? 220 : 0;
+    const lightness = 92 - 32 * Math.abs(change);
+    return `hsl(${hue}deg 80% ${lightness}%)`;
+  }
+
   function makeColor(index) {
     // Rotate hue around a circle. Multiple by phi to spread things
     // out better. Use 50% saturation to make subdued colors, and
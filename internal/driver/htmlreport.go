@@ -0,0 +1,230 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/profile"
+)
+
+// htmlReportFunctions is the number of functions, by flat value, whose
+// annotated source is included in HTML reports.
+const htmlReportFunctions = 10
+
+// htmlReportViews lists the views of an HTML report in display order,
+// with the names of their files when the report is written to a
+// directory.
+var htmlReportViews = []struct {
+	name, file string
+}{
+	{"graph", "index.html"},
+	{"top", "top.html"},
+	{"flamegraph", "flamegraph.html"},
+	{"source", "source.html"},
+}
+
+// generateHTMLReport writes an HTML report viewable without running
+// pprof. If the output is a directory, which is the case if it exists
+// as one or ends with a path separator, every view is written to its
+// own page. Otherwise all views are bundled in a single file.
+func generateHTMLReport(p *profile.Profile, cfg config, o *plugin.Options) error {
+	output := cfg.Output
+	if output != "" && (strings.HasSuffix(output, string(filepath.Separator)) || isDir(output)) {
+		links := make(map[string]string)
+		for _, v := range htmlReportViews {
+			links[v.name] = v.file
+		}
+		pages, _, err := renderHTMLReport(p, cfg, o, links)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return err
+		}
+		o.UI.PrintErr("Generating report in ", output)
+		for _, v := range htmlReportViews {
+			page, ok := pages[v.name]
+			if !ok {
+				continue
+			}
+			if err := writeOutput(o, filepath.Join(output, v.file), page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeHTMLReport(buf, p, cfg, o); err != nil {
+		return err
+	}
+	if output == "" {
+		return awayFromTTY("html")(buf, os.Stdout, o.UI)
+	}
+	o.UI.PrintErr("Generating report in ", output)
+	return writeOutput(o, output, buf.Bytes())
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+func writeOutput(o *plugin.Options, name string, data []byte) error {
+	out, err := o.Writer.Open(name)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeHTMLReport writes an HTML report as a single self-contained page.
+func writeHTMLReport(w io.Writer, p *profile.Profile, cfg config, o *plugin.Options) error {
+	links := make(map[string]string)
+	for _, v := range htmlReportViews {
+		links[v.name] = "#" + v.name
+	}
+	pages, title, err := renderHTMLReport(p, cfg, o, links)
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Title string
+		Views []string
+		Pages map[string]string
+	}{
+		Title: title,
+		Pages: make(map[string]string),
+	}
+	for _, v := range htmlReportViews {
+		if page, ok := pages[v.name]; ok {
+			data.Views = append(data.Views, v.name)
+			data.Pages[v.name] = string(page)
+		}
+	}
+	templates := template.New("templategroup")
+	addTemplates(templates)
+	return templates.ExecuteTemplate(w, "report", data)
+}
+
+// renderHTMLReport renders the views of an HTML report using the
+// handlers of the web interface. The pages link to each other with the
+// given links, and are returned by view name along with the title of
+// the report. The source view is left out if no source is available for
+// the top functions.
+func renderHTMLReport(p *profile.Profile, cfg config, o *plugin.Options, links map[string]string) (map[string][]byte, string, error) {
+	templates := template.New("templategroup")
+	addTemplates(templates)
+	report.AddSourceTemplates(templates)
+	ui := &webInterface{
+		prof:      p,
+		copier:    makeProfileCopier(p),
+		options:   o,
+		help:      webHelp(),
+		templates: templates,
+		static:    links,
+		config:    &cfg,
+	}
+
+	// Find the functions to include in the source view.
+	topCfg := cfg
+	topCfg.NodeCount = htmlReportFunctions
+	_, rpt, err := generateRawReport(ui.copier.newCopy(), []string{"top"}, topCfg, o)
+	if err != nil {
+		return nil, "", err
+	}
+	items, legend := report.TextItems(rpt)
+	var funcs []string
+	for _, item := range items {
+		if len(funcs) == htmlReportFunctions {
+			break
+		}
+		funcs = append(funcs, "^"+regexp.QuoteMeta(item.Name)+"$")
+	}
+
+	title := getFromLegend(legend, "File: ", "unknown") + " " + getFromLegend(legend, "Type: ", "unknown")
+	pages := make(map[string][]byte)
+	if len(funcs) > 0 {
+		page, err := renderPage(ui.source, url.Values{"f": {strings.Join(funcs, "|")}})
+		if err != nil {
+			o.UI.PrintErr("Leaving source out of the report: ", err)
+		} else {
+			pages["source"] = page
+		}
+	}
+	if _, ok := pages["source"]; !ok {
+		delete(links, "source")
+	}
+	for _, view := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"graph", ui.dot},
+		{"top", ui.top},
+		{"flamegraph", ui.stackView("flamegraph")},
+	} {
+		page, err := renderPage(view.handler, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("generating %s view: %v", view.name, err)
+		}
+		pages[view.name] = page
+	}
+	return pages, title, nil
+}
+
+// renderPage calls a handler of the web interface and returns the page
+// it generates.
+func renderPage(handler http.HandlerFunc, query url.Values) ([]byte, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: "/", RawQuery: query.Encode()},
+		Header: make(http.Header),
+	}
+	w := &pageWriter{header: make(http.Header)}
+	handler(w, req)
+	if w.status != 0 && w.status != http.StatusOK {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(w.body.String()))
+	}
+	return w.body.Bytes(), nil
+}
+
+// pageWriter is an http.ResponseWriter collecting a page in memory.
+type pageWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *pageWriter) Header() http.Header { return w.header }
+
+func (w *pageWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+
+func (w *pageWriter) WriteHeader(status int) { w.status = status }
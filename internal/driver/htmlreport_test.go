@@ -0,0 +1,80 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestHTMLReport(t *testing.T) {
+	o := &plugin.Options{
+		Obj:    fakeObjTool{},
+		UI:     &proftest.TestUI{T: t, AllowRx: "Generating report in"},
+		Writer: oswriter{},
+	}
+	dir := t.TempDir()
+
+	cfg := currentConfig()
+	cfg.Output = filepath.Join(dir, "report.html")
+	if err := generateReport(makeFakeProfile(), []string{"html_report"}, cfg, o); err != nil {
+		t.Fatalf("html_report: %v", err)
+	}
+	data, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+	for _, want := range []string{
+		`const views = \["graph","top","flamegraph","source"\];`,
+		`<iframe id="view-flamegraph"`,
+		// Pages are embedded as JavaScript strings.
+		`\\u003cg id=\\"node1\\" class=\\"node\\"\\u003e`,
+		`stackViewer\(`,
+		`makeTopTable\(`,
+		`f1:asm`,
+		`href=\\"#top\\" target=\\"_top\\"`,
+	} {
+		if match, _ := regexp.MatchString(want, report); !match {
+			t.Errorf("single file report does not match %q", want)
+		}
+	}
+	for _, unwanted := range []string{`href=\"./download\"`, `href=\"./peek\"`} {
+		if strings.Contains(report, unwanted) {
+			t.Errorf("single file report refers to the server: %q", unwanted)
+		}
+	}
+
+	cfg.Output = filepath.Join(dir, "report") + string(filepath.Separator)
+	if err := generateReport(makeFakeProfile(), []string{"html_report"}, cfg, o); err != nil {
+		t.Fatalf("html_report to directory: %v", err)
+	}
+	for _, v := range htmlReportViews {
+		data, err := os.ReadFile(filepath.Join(cfg.Output, v.file))
+		if err != nil {
+			t.Errorf("missing %s view: %v", v.name, err)
+			continue
+		}
+		if !strings.Contains(string(data), `href="top.html" target="_top"`) {
+			t.Errorf("%s does not link to the other views", v.file)
+		}
+	}
+}
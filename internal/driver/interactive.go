@@ -17,6 +17,7 @@ package driver
 import (
 	"fmt"
 	"io"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
@@ -33,16 +34,14 @@ var tailDigitsRE = regexp.MustCompile("[0-9]+$")
 // interactive starts a shell to read pprof commands.
 func interactive(p *profile.Profile, o *plugin.Options) error {
 	// Enter command processing loop.
-	o.UI.SetAutoComplete(newCompleter(functionNames(p)))
-	configure("compact_labels", "true")
-	configHelp["sample_index"] += fmt.Sprintf("Or use sample_index=name, with name in %v.\n", sampleTypes(p))
+	o.UI.SetAutoComplete(newCompleter(functionNames(p), labelNames(p)))
 
 	// Do not wait for the visualizer to complete, to allow multiple
 	// graphs to be visualized simultaneously.
 	interactiveMode = true
-	shortcuts := profileShortcuts(p)
+	sh := newShell(p, o)
+	sh.keepGoing = true
 
-	copier := makeProfileCopier(p)
 	greetings(p, o.UI)
 	for {
 		input, err := o.UI.ReadLine("(pprof) ")
@@ -55,74 +54,284 @@ func interactive(p *profile.Profile, o *plugin.Options) error {
 			}
 		}
 
-		for _, input := range shortcuts.expand(input) {
-			// Process assignments of the form variable=value
-			if s := strings.SplitN(input, "=", 2); len(s) > 0 {
-				name := strings.TrimSpace(s[0])
-				var value string
-				if len(s) == 2 {
-					value = s[1]
-					if comment := strings.LastIndex(value, commentStart); comment != -1 {
-						value = value[:comment]
-					}
-					value = strings.TrimSpace(value)
+		quit, err := sh.execute(input)
+		if err != nil {
+			o.UI.PrintErr(err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// shell executes the commands of the interactive shell on a profile.
+type shell struct {
+	p         *profile.Profile
+	copier    profileCopier
+	shortcuts shortcuts
+	o         *plugin.Options
+	// keepGoing reports the errors of the commands of a line of input
+	// and executes the rest of the line, as in interactive mode.
+	keepGoing bool
+
+	filters []pushedFilter // The filter stack, changed by push and pop.
+	undo    []shellState   // The states before the last changes.
+}
+
+// pushedFilter is an entry of the filter stack: a filter option set by push,
+// and the value it had before, which pop restores.
+type pushedFilter struct {
+	name, value, prev string
+}
+
+// shellState holds what the commands of the shell can change, and undo
+// restores.
+type shellState struct {
+	cfg     config
+	filters []pushedFilter
+}
+
+// maxUndo is the number of changes that can be undone.
+const maxUndo = 100
+
+// filterOptions are the options that can be pushed on the filter stack.
+var filterOptions = []string{
+	"focus", "ignore", "hide", "show", "show_from", "prune_from",
+	"tagfocus", "tagignore", "tagshow", "taghide",
+}
+
+// sampleIndexHelp is the help of sample_index, which newShell completes
+// with the sample types of the profile.
+var sampleIndexHelp = configHelp["sample_index"]
+
+func newShell(p *profile.Profile, o *plugin.Options) *shell {
+	configure("compact_labels", "true")
+	configHelp["sample_index"] = sampleIndexHelp + fmt.Sprintf("Or use sample_index=name, with name in %v.\n", sampleTypes(p))
+	return &shell{
+		p:         p,
+		copier:    makeProfileCopier(p),
+		shortcuts: profileShortcuts(p),
+		o:         o,
+	}
+}
+
+// execute executes a line of input: option assignments of the form
+// variable=value, and commands. It reports whether the input asks to quit.
+// It stops at the first error unless sh.keepGoing is set, in which case the
+// errors are reported and the rest of the line is executed.
+func (sh *shell) execute(input string) (bool, error) {
+	if strings.TrimSpace(input) == "undo" {
+		return false, sh.undoChange()
+	}
+	// Changes are undone a line of input at a time, as the shortcuts
+	// expand to several assignments.
+	defer sh.recordChange(sh.state())
+
+	for _, input := range sh.shortcuts.expand(input) {
+		quit, err := sh.executeCommand(input)
+		if err != nil {
+			if !sh.keepGoing {
+				return false, err
+			}
+			sh.o.UI.PrintErr(err)
+			continue
+		}
+		if quit {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// executeCommand executes a single option assignment or command, and
+// reports whether it asks to quit.
+func (sh *shell) executeCommand(input string) (bool, error) {
+	p, o := sh.p, sh.o
+	// Process assignments of the form variable=value
+	if s := strings.SplitN(input, "=", 2); len(s) > 0 {
+		name := strings.TrimSpace(s[0])
+		var value string
+		if len(s) == 2 {
+			value = s[1]
+			if comment := strings.LastIndex(value, commentStart); comment != -1 {
+				value = value[:comment]
+			}
+			value = strings.TrimSpace(value)
+		}
+		if isConfigurable(name) {
+			// All non-bool options require inputs
+			if len(s) == 1 && !isBoolConfig(name) {
+				return false, fmt.Errorf("please specify a value, e.g. %s=<val>", name)
+			}
+			if name == "sample_index" {
+				// Error check sample_index=xxx to ensure xxx is a valid sample type.
+				index, err := p.SampleIndexByName(value)
+				if err != nil {
+					return false, err
 				}
-				if isConfigurable(name) {
-					// All non-bool options require inputs
-					if len(s) == 1 && !isBoolConfig(name) {
-						o.UI.PrintErr(fmt.Errorf("please specify a value, e.g. %s=<val>", name))
-						continue
-					}
-					if name == "sample_index" {
-						// Error check sample_index=xxx to ensure xxx is a valid sample type.
-						index, err := p.SampleIndexByName(value)
-						if err != nil {
-							o.UI.PrintErr(err)
-							continue
-						}
-						if index < 0 || index >= len(p.SampleType) {
-							o.UI.PrintErr(fmt.Errorf("invalid sample_index %q", value))
-							continue
-						}
-						value = p.SampleType[index].Type
-					}
-					if err := configure(name, value); err != nil {
-						o.UI.PrintErr(err)
-					}
-					continue
+				if index < 0 || index >= len(p.SampleType) {
+					return false, fmt.Errorf("invalid sample_index %q", value)
 				}
+				value = p.SampleType[index].Type
 			}
+			return false, configure(name, value)
+		}
+	}
 
-			tokens := strings.Fields(input)
-			if len(tokens) == 0 {
-				continue
-			}
+	tokens := strings.Fields(input)
+	if len(tokens) == 0 {
+		return false, nil
+	}
 
-			switch tokens[0] {
-			case "o", "options":
-				printCurrentOptions(p, o.UI)
-				continue
-			case "exit", "quit", "q":
-				return nil
-			case "help":
-				commandHelp(strings.Join(tokens[1:], " "), o.UI)
-				continue
-			}
+	switch tokens[0] {
+	case "o", "options":
+		printCurrentOptions(p, o.UI)
+		return false, nil
+	case "exit", "quit", "q":
+		return true, nil
+	case "help":
+		commandHelp(strings.Join(tokens[1:], " "), o.UI)
+		return false, nil
+	case "push":
+		name, value, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(input), "push")), "=")
+		if !ok {
+			return false, fmt.Errorf("usage: push <filter>=<value>, with filter in %v", filterOptions)
+		}
+		return false, sh.push(strings.TrimSpace(name), strings.TrimSpace(value))
+	case "pop":
+		return false, sh.pop()
+	case "filters":
+		sh.printFilters()
+		return false, nil
+	case "load", "save":
+		if len(tokens) == 1 {
+			return false, fmt.Errorf("command %s requires the name of a config", tokens[0])
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(input), tokens[0]))
+		return false, namedConfigCommand(tokens[0], name)
+	}
 
-			args, cfg, err := parseCommandLine(tokens)
-			if err == nil {
-				err = generateReportWrapper(copier.newCopy(), args, cfg, o)
-			}
+	args, cfg, err := parseCommandLine(tokens)
+	if err == nil {
+		err = generateReportWrapper(sh.copier.newCopy(), args, cfg, o)
+	}
+	return false, err
+}
 
-			if err != nil {
-				o.UI.PrintErr(err)
-			}
+// state returns the current state of the shell.
+func (sh *shell) state() shellState {
+	return shellState{cfg: currentConfig(), filters: append([]pushedFilter(nil), sh.filters...)}
+}
+
+// recordChange records the state before a line of input, if the line
+// changed it, so that undo can restore it.
+func (sh *shell) recordChange(before shellState) {
+	if before.cfg == currentConfig() && reflect.DeepEqual(before.filters, sh.filters) {
+		return
+	}
+	if len(sh.undo) == maxUndo {
+		sh.undo = sh.undo[1:]
+	}
+	sh.undo = append(sh.undo, before)
+}
+
+// undoChange restores the state before the last change of the options or of
+// the filter stack.
+func (sh *shell) undoChange() error {
+	if len(sh.undo) == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	last := sh.undo[len(sh.undo)-1]
+	sh.undo = sh.undo[:len(sh.undo)-1]
+	setCurrentConfig(last.cfg)
+	sh.filters = last.filters
+	return nil
+}
+
+// push sets a filter option, pushing it on the filter stack.
+func (sh *shell) push(name, value string) error {
+	f, ok := configFieldMap[name]
+	if !ok || !isFilterOption(name) {
+		return fmt.Errorf("push takes a filter option, one of %v", filterOptions)
+	}
+	cfg := currentConfig()
+	prev := cfg.get(f)
+	if err := configure(name, value); err != nil {
+		return err
+	}
+	sh.filters = append(sh.filters, pushedFilter{name: name, value: value, prev: prev})
+	return nil
+}
+
+// pop restores the filter option on top of the filter stack to the value it
+// had before it was pushed, and removes it from the stack.
+func (sh *shell) pop() error {
+	if len(sh.filters) == 0 {
+		return fmt.Errorf("the filter stack is empty")
+	}
+	top := sh.filters[len(sh.filters)-1]
+	if err := configure(top.name, top.prev); err != nil {
+		return err
+	}
+	sh.filters = sh.filters[:len(sh.filters)-1]
+	return nil
+}
+
+// printFilters prints the filter stack and the filter options in effect.
+func (sh *shell) printFilters() {
+	var lines []string
+	if len(sh.filters) > 0 {
+		lines = append(lines, "Filter stack:")
+		for i, f := range sh.filters {
+			lines = append(lines, fmt.Sprintf("  %d: %s=%s", i+1, f.name, f.value))
+		}
+	}
+	cfg := currentConfig()
+	var active []string
+	for _, name := range filterOptions {
+		if v := cfg.get(configFieldMap[name]); v != "" {
+			active = append(active, fmt.Sprintf("   %s=%s", name, v))
 		}
 	}
+	if len(active) > 0 {
+		lines = append(lines, "Active filters:")
+		lines = append(lines, active...)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No filters")
+	}
+	sh.o.UI.Print(strings.Join(lines, "\n"))
+}
+
+func isFilterOption(name string) bool {
+	for _, f := range filterOptions {
+		if f == name {
+			return true
+		}
+	}
+	return false
 }
 
 var generateReportWrapper = generateReport // For testing purposes.
 
+// namedConfigCommand loads the named config into the current config, or saves
+// the current config under that name in the settings.
+func namedConfigCommand(cmd, name string) error {
+	fname, err := settingsFileName()
+	if err != nil {
+		return err
+	}
+	if cmd == "save" {
+		return saveConfig(fname, name, currentConfig())
+	}
+	cfg, err := lookupConfig(fname, name)
+	if err != nil {
+		return err
+	}
+	setCurrentConfig(cfg)
+	return nil
+}
+
 // greetings prints a brief welcome and some overall profile
 // information before accepting interactive commands.
 func greetings(p *profile.Profile, ui plugin.UI) {
@@ -338,34 +547,49 @@ func commandHelp(args string, ui plugin.UI) {
 	ui.PrintErr("Unknown command: " + args)
 }
 
-// newCompleter creates an autocompletion function for a set of commands.
-func newCompleter(fns []string) func(string) string {
+// newCompleter creates an autocompletion function for a set of commands,
+// completing their arguments with the function names fns, or with the label
+// keys and values of labels.
+func newCompleter(fns []string, labels map[string][]string) func(string) string {
 	return func(line string) string {
 		switch tokens := strings.Fields(line); len(tokens) {
 		case 0:
 			// Nothing to complete
 		case 1:
-			// Single token -- complete command name
+			// Single token -- complete command name, or option value
+			if name, value, ok := strings.Cut(tokens[0], "="); ok {
+				return completeAssignment(name, value, fns, labels)
+			}
 			if match := matchVariableOrCommand(tokens[0]); match != "" {
 				return match
 			}
 		case 2:
-			if tokens[0] == "help" {
+			switch tokens[0] {
+			case "help":
 				if match := matchVariableOrCommand(tokens[1]); match != "" {
 					return tokens[0] + " " + match
 				}
 				return line
+			case "push":
+				if name, value, ok := strings.Cut(tokens[1], "="); ok {
+					return tokens[0] + " " + completeAssignment(name, value, fns, labels)
+				}
+				return line
 			}
 			fallthrough
 		default:
-			// Multiple tokens -- complete using functions, except for tags
-			if cmd := pprofCommands[tokens[0]]; cmd != nil && tokens[0] != "tags" {
+			// Multiple tokens -- complete using functions, or labels for tags
+			if cmd := pprofCommands[tokens[0]]; cmd != nil {
+				complete := func(s string) string { return functionCompleter(s, fns) }
+				if tokens[0] == "tags" {
+					complete = func(s string) string { return labelCompleter(s, labels, false) }
+				}
 				lastTokenIdx := len(tokens) - 1
 				lastToken := tokens[lastTokenIdx]
 				if strings.HasPrefix(lastToken, "-") {
-					lastToken = "-" + functionCompleter(lastToken[1:], fns)
+					lastToken = "-" + complete(lastToken[1:])
 				} else {
-					lastToken = functionCompleter(lastToken, fns)
+					lastToken = complete(lastToken)
 				}
 				return strings.Join(append(tokens[:lastTokenIdx], lastToken), " ")
 			}
@@ -374,6 +598,21 @@ func newCompleter(fns []string) func(string) string {
 	}
 }
 
+// completeAssignment completes the value of an assignment of option name:
+// with a function name for the options filtering functions, or with a label
+// key or value for the ones filtering labels.
+func completeAssignment(name, value string, fns []string, labels map[string][]string) string {
+	switch name {
+	case "focus", "ignore", "hide", "show", "show_from", "prune_from":
+		value = functionCompleter(value, fns)
+	case "tagfocus", "tagignore":
+		value = labelCompleter(value, labels, false)
+	case "tagshow", "taghide", "tagroot", "tagleaf":
+		value = labelCompleter(value, labels, true)
+	}
+	return name + "=" + value
+}
+
 // matchVariableOrCommand attempts to match a string token to the prefix of a Command.
 func matchVariableOrCommand(token string) string {
 	token = strings.ToLower(token)
@@ -410,6 +649,89 @@ func functionCompleter(substring string, fns []string) string {
 	return substring
 }
 
+// labelCompleter completes the last element of a comma separated list of
+// label filters with a label key, or with a label value of the key if the
+// element is of the form key=value. Elements matching no key are completed
+// with a label value of any key, unless keysOnly is set.
+func labelCompleter(list string, labels map[string][]string, keysOnly bool) string {
+	i := strings.LastIndex(list, ",") + 1
+	head, elem := list[:i], list[i:]
+	if key, value, ok := strings.Cut(elem, "="); ok {
+		if keysOnly {
+			return list
+		}
+		return head + key + "=" + prefixCompleter(value, labels[key])
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	if key := prefixCompleter(elem, keys); key != elem || keysOnly {
+		if keysOnly {
+			return head + key
+		}
+		return head + key + "="
+	}
+	var values []string
+	for _, vs := range labels {
+		values = append(values, vs...)
+	}
+	return head + prefixCompleter(elem, values)
+}
+
+// prefixCompleter returns the only one of candidates starting with prefix,
+// or prefix if there is none or several.
+func prefixCompleter(prefix string, candidates []string) string {
+	found := ""
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) && c != found {
+			if found != "" {
+				return prefix
+			}
+			found = c
+		}
+	}
+	if found != "" {
+		return found
+	}
+	return prefix
+}
+
+// labelNames returns the keys of the labels of the samples of p, with the
+// values of the string labels. The keys of numeric labels have no values.
+func labelNames(p *profile.Profile) map[string][]string {
+	values := make(map[string]map[string]bool)
+	add := func(key string) map[string]bool {
+		if values[key] == nil {
+			values[key] = make(map[string]bool)
+		}
+		return values[key]
+	}
+	for _, s := range p.Sample {
+		for key, vs := range s.Label {
+			m := add(key)
+			for _, v := range vs {
+				m[v] = true
+			}
+		}
+	}
+	units, _ := p.NumLabelUnits()
+	for key := range units {
+		add(key)
+	}
+
+	labels := make(map[string][]string, len(values))
+	for key, m := range values {
+		vs := make([]string, 0, len(m))
+		for v := range m {
+			vs = append(vs, v)
+		}
+		sort.Strings(vs)
+		labels[key] = vs
+	}
+	return labels
+}
+
 func functionNames(p *profile.Profile) []string {
 	var fns []string
 	for _, fn := range p.Function {
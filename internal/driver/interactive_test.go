@@ -17,6 +17,7 @@ package driver
 import (
 	"fmt"
 	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -272,3 +273,141 @@ func TestInteractiveCommands(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterStack(t *testing.T) {
+	savedConfig := currentConfig()
+	defer setCurrentConfig(savedConfig)
+
+	type filters struct{ focus, hide string }
+	for _, tc := range []struct {
+		desc    string
+		input   []string
+		want    filters
+		stack   int
+		wantErr string
+	}{
+		{"push", []string{"push focus=a", "push hide=b", "push focus=c"}, filters{"c", "b"}, 3, ""},
+		{"pop", []string{"push focus=a", "push hide=b", "push focus=c", "pop"}, filters{"a", "b"}, 2, ""},
+		{"pop all", []string{"focus=z", "push focus=a", "push hide=b", "pop", "pop"}, filters{"z", ""}, 0, ""},
+		{"pop keeps assignments", []string{"push focus=a", "hide=b", "pop"}, filters{"", "b"}, 0, ""},
+		{"pop empty", []string{"push focus=a", "pop", "pop"}, filters{"", ""}, 0, "filter stack is empty"},
+		{"push non filter", []string{"push nodecount=3"}, filters{"", ""}, 0, "push takes a filter option"},
+		{"push without value", []string{"push focus"}, filters{"", ""}, 0, "usage: push"},
+		{"undo assignment", []string{"focus=a", "focus=b", "undo"}, filters{"a", ""}, 0, ""},
+		{"undo push", []string{"push focus=a", "push hide=b", "undo"}, filters{"a", ""}, 1, ""},
+		{"undo pop", []string{"push focus=a", "pop", "undo"}, filters{"a", ""}, 1, ""},
+		{"undo shortcut", []string{"focus=a", "hide=b", ":", "undo"}, filters{"a", "b"}, 0, ""},
+		{"undo twice", []string{"focus=a", "hide=b", "undo", "undo"}, filters{"", ""}, 0, ""},
+		{"undo report", []string{"focus=a", "filters", "undo"}, filters{"", ""}, 0, ""},
+		{"nothing to undo", []string{"undo"}, filters{"", ""}, 0, "nothing to undo"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			setCurrentConfig(savedConfig)
+			o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t}, HTTPTransport: transport.New(nil)})
+			sh := newShell(&profile.Profile{}, o)
+			var err error
+			for _, input := range tc.input {
+				if _, err = sh.execute(input); err != nil {
+					break
+				}
+			}
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("got error %v, want none", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+			cfg := currentConfig()
+			if got := (filters{cfg.Focus, cfg.Hide}); got != tc.want {
+				t.Errorf("got filters %+v, want %+v", got, tc.want)
+			}
+			if len(sh.filters) != tc.stack {
+				t.Errorf("got %d filters on the stack, want %d", len(sh.filters), tc.stack)
+			}
+		})
+	}
+}
+
+func TestShellKeepGoing(t *testing.T) {
+	savedConfig := currentConfig()
+	defer setCurrentConfig(savedConfig)
+
+	for _, keepGoing := range []bool{false, true} {
+		setCurrentConfig(savedConfig)
+		ui := &proftest.TestUI{T: t, AllowRx: "push takes a filter option"}
+		o := setDefaults(&plugin.Options{UI: ui, HTTPTransport: transport.New(nil)})
+		sh := newShell(&profile.Profile{}, o)
+		sh.keepGoing = keepGoing
+		sh.shortcuts = shortcuts{"both": {"push nodecount=3", "focus=a"}}
+		_, err := sh.execute("both")
+		wantFocus := ""
+		if keepGoing {
+			// The error is reported, and the rest of the line executed.
+			wantFocus = "a"
+			if err != nil || ui.NumAllowRxMatches != 1 {
+				t.Errorf("keepGoing: got error %v and %d reported errors, want none and 1", err, ui.NumAllowRxMatches)
+			}
+		} else if err == nil {
+			t.Error("got no error, want the one of push")
+		}
+		if got := currentConfig().Focus; got != wantFocus {
+			t.Errorf("keepGoing=%v: got focus %q, want %q", keepGoing, got, wantFocus)
+		}
+	}
+}
+
+func TestShellSampleIndexHelp(t *testing.T) {
+	savedConfig := currentConfig()
+	defer setCurrentConfig(savedConfig)
+
+	p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}}}
+	o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t}, HTTPTransport: transport.New(nil)})
+	newShell(p, o)
+	want := configHelp["sample_index"]
+	newShell(p, o)
+	if got := configHelp["sample_index"]; got != want {
+		t.Errorf("help after a second shell: got %q, want %q", got, want)
+	}
+}
+
+func TestLabelAutoComplete(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}},
+		Sample: []*profile.Sample{
+			{Value: []int64{1}, Label: map[string][]string{"mode": {"read"}, "thread": {"main"}}},
+			{Value: []int64{1}, Label: map[string][]string{"mode": {"write"}}, NumLabel: map[string][]int64{"bytes": {64}}},
+		},
+	}
+	labels := labelNames(p)
+	want := map[string][]string{"mode": {"read", "write"}, "thread": {"main"}, "bytes": {}}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("labelNames() = %v; want %v", labels, want)
+	}
+
+	complete := newCompleter([]string{"main.run", "main.main"}, labels)
+	for _, tc := range []struct{ in, out string }{
+		{"tagfocus=mo", "tagfocus=mode="},
+		{"tagfocus=mode=wr", "tagfocus=mode=write"},
+		{"tagfocus=mode=r", "tagfocus=mode=read"},
+		{"tagfocus=mode=read,th", "tagfocus=mode=read,thread="},
+		{"tagfocus=by", "tagfocus=bytes="},
+		{"tagfocus=wri", "tagfocus=write"},
+		{"tagfocus=x", "tagfocus=x"},         // no match
+		{"tagfocus=mode=", "tagfocus=mode="}, // read or write
+		{"tagignore=thread=m", "tagignore=thread=main"},
+		{"tagshow=th", "tagshow=thread"},
+		{"tagroot=mode,th", "tagroot=mode,thread"},
+		{"tagshow=wri", "tagshow=wri"}, // keys only
+		{"focus=run", "focus=main.run"},
+		{"push focus=.ma", "push focus=main.main"},
+		{"push tagfocus=mode=wr", "push tagfocus=mode=write"},
+		{"tags mo", "tags mode="},
+		{"tags -thread=", "tags -thread=main"},
+		{"top -run", "top -main.run"},
+		{"nodecount=1", "nodecount=1"},
+	} {
+		if got := complete(tc.in); got != tc.out {
+			t.Errorf("complete(%q) = %q; want %q", tc.in, got, tc.out)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/symbolizer"
 	"github.com/google/pprof/internal/transport"
+	"github.com/google/pprof/profile"
 )
 
 // setDefaults returns a new plugin.Options with zero fields sets to
@@ -40,17 +41,32 @@ func setDefaults(o *plugin.Options) *plugin.Options {
 	if d.Flagset == nil {
 		d.Flagset = &GoFlags{}
 	}
+	// Cache the results of symbolization with the default tools, which run
+	// on the actual binaries.
+	var symbolCache *symbolizer.Cache
 	if d.Obj == nil {
 		d.Obj = &binutils.Binutils{}
+		symbolCache = symbolizer.DefaultCache()
 	}
 	if d.UI == nil {
 		d.UI = &stdUI{r: bufio.NewReader(os.Stdin)}
 	}
 	if d.HTTPTransport == nil {
-		d.HTTPTransport = transport.New(d.Flagset)
+		d.HTTPTransport = transport.NewWithOptions(d.Flagset, d.HTTPFetch)
+	} else if d.HTTPFetch != nil {
+		d.HTTPTransport = transport.WithOptions(d.HTTPTransport, d.HTTPFetch)
 	}
 	if d.Sym == nil {
-		d.Sym = &symbolizer.Symbolizer{Obj: d.Obj, UI: d.UI, Transport: d.HTTPTransport}
+		obj, ui, tr := d.Obj, d.UI, d.HTTPTransport
+		d.Sym = &symbolizer.Symbolizer{
+			Obj:       obj,
+			UI:        ui,
+			Transport: tr,
+			Cache:     symbolCache,
+			DebugFile: func(m *profile.Mapping, download bool) string {
+				return findDebugFile(m, download, obj, ui, tr)
+			},
+		}
 	}
 	return d
 }
@@ -0,0 +1,72 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// scriptStdin is the -script name reading the script from the standard
+// input.
+const scriptStdin = "-"
+
+// runScript executes the commands of the interactive shell read from the
+// named script, or from the standard input if name is "-". Empty lines and
+// lines starting with # are ignored. It stops at the first error, which is
+// returned with the line of the script it comes from.
+func runScript(p *profile.Profile, name string, o *plugin.Options) error {
+	var r io.Reader = os.Stdin
+	if name != scriptStdin {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		name = "<stdin>"
+	}
+	return executeScript(p, name, r, o)
+}
+
+// executeScript executes the commands of the script read from r.
+func executeScript(p *profile.Profile, name string, r io.Reader, o *plugin.Options) error {
+	sh := newShell(p, o)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		input := strings.TrimSpace(s.Text())
+		if input == "" || strings.HasPrefix(input, "#") {
+			continue
+		}
+		quit, err := sh.execute(input)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		if quit {
+			return nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
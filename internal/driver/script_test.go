@@ -0,0 +1,202 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/transport"
+)
+
+func TestScript(t *testing.T) {
+	savedConfig := currentConfig()
+	defer setCurrentConfig(savedConfig)
+
+	dir := t.TempDir()
+	out := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	for _, tc := range []struct {
+		desc    string
+		script  string
+		wantErr string
+		want    map[string][]string // Expected contents of the outputs.
+		notRun  []string            // Outputs of commands not executed.
+	}{
+		{
+			desc: "reports",
+			script: `
+# Nightly reports.
+sample_index=cpu
+top >` + out("top.txt") + `
+focus=F3
+  top -cum >` + out("focused.txt") + `
+:
+traces > ` + out("traces.txt"),
+			want: map[string][]string{
+				"top.txt":     {"Showing nodes accounting for 300ms, 100% of 300ms total", "F2", "F3"},
+				"focused.txt": {"Active filters:\n   focus=F3", "F1", "F3"},
+				"traces.txt":  {"key:  bar", "100ms   F3", "200ms   F2"}, // ":" cleared the focus.
+			},
+		},
+		{
+			desc:    "invalid option",
+			script:  "top >" + out("first.txt") + "\nsort=nowhere\ntop >" + out("second.txt"),
+			wantErr: "test.pprof:2: invalid \"sort\" value",
+			want:    map[string][]string{"first.txt": {"F2"}},
+			notRun:  []string{"second.txt"},
+		},
+		{
+			desc:    "invalid sample index",
+			script:  "sample_index=alloc_space\ntop >" + out("second.txt"),
+			wantErr: "test.pprof:1:",
+			notRun:  []string{"second.txt"},
+		},
+		{
+			desc:    "unknown command",
+			script:  "\n\nfrobnicate\ntop >" + out("second.txt"),
+			wantErr: `test.pprof:3: unrecognized command: "frobnicate"`,
+			notRun:  []string{"second.txt"},
+		},
+		{
+			desc:   "quit",
+			script: "top >" + out("first.txt") + "\nquit\nfrobnicate",
+			want:   map[string][]string{"first.txt": {"F2"}},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			setCurrentConfig(savedConfig)
+			for _, name := range []string{"top.txt", "focused.txt", "traces.txt", "first.txt", "second.txt"} {
+				os.Remove(out(name))
+			}
+			p := makeFakeProfile()
+			p.Sample[0].Label = map[string][]string{"key": {"bar"}}
+			o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t, AllowRx: ".*"}, Obj: fakeObjTool{}, HTTPTransport: transport.New(nil)})
+
+			err := executeScript(p, "test.pprof", strings.NewReader(tc.script), o)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("got error %v, want none", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+			for name, wants := range tc.want {
+				data, err := os.ReadFile(out(name))
+				if err != nil {
+					t.Errorf("output %s: %v", name, err)
+					continue
+				}
+				for _, want := range wants {
+					if !strings.Contains(string(data), want) {
+						t.Errorf("output %s: got %s, want it to contain %q", name, data, want)
+					}
+				}
+			}
+			for _, name := range tc.notRun {
+				if _, err := os.Stat(out(name)); err == nil {
+					t.Errorf("output %s written after the error", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRunScriptFile(t *testing.T) {
+	savedConfig := currentConfig()
+	defer setCurrentConfig(savedConfig)
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "reports.pprof")
+	writeFile(t, script, []byte("nodecount=1\ntop >"+filepath.Join(dir, "top.txt")+"\n"))
+	o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t, AllowRx: ".*"}, Obj: fakeObjTool{}, HTTPTransport: transport.New(nil)})
+	if err := runScript(makeFakeProfile(), script, o); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "top.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Showing top 1 nodes out of 3") {
+		t.Errorf("got %s, want the top node only", data)
+	}
+	if err := runScript(makeFakeProfile(), filepath.Join(dir, "missing.pprof"), o); err == nil {
+		t.Error("got no error for a missing script")
+	}
+}
+
+func TestScriptNamedConfigs(t *testing.T) {
+	savedConfig := currentConfig()
+	defer setCurrentConfig(savedConfig)
+
+	// Keep the settings away from the ones of the user.
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("HOME", configDir)
+	t.Setenv("AppData", configDir)
+	fname, err := settingsFileName()
+	if err != nil {
+		t.Skip(err)
+	}
+	team := defaultConfig()
+	team.Hide = "F3"
+	teamFile := filepath.Join(configDir, "team.json")
+	if err := writeSettings(teamFile, &settings{Configs: []namedConfig{{Name: "no F3", config: team}}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PPROF_TEAM_CONFIG", teamFile)
+
+	dir := t.TempDir()
+	script := `
+focus=F2
+sort=cum
+save mine
+:
+load no F3
+top >` + filepath.Join(dir, "team.txt") + `
+load mine
+top >` + filepath.Join(dir, "mine.txt") + `
+load theirs
+`
+	o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t, AllowRx: ".*"}, Obj: fakeObjTool{}, HTTPTransport: transport.New(nil)})
+	err = executeScript(makeFakeProfile(), "test.pprof", strings.NewReader(script), o)
+	if want := "test.pprof:10: config theirs not found"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	saved, err := lookupConfig(fname, "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Focus != "F2" || saved.Sort != "cum" {
+		t.Errorf("saved config: got focus %q and sort %q, want F2 and cum", saved.Focus, saved.Sort)
+	}
+	for name, want := range map[string]struct{ has, hasNot string }{
+		"team.txt": {"hide=F3", "%  F3"},
+		"mine.txt": {"focus=F2", "hide="},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), want.has) || strings.Contains(string(data), want.hasNot) {
+			t.Errorf("output %s: got %s, want %q without %q", name, data, want.has, want.hasNot)
+		}
+	}
+}
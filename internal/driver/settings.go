@@ -18,6 +18,8 @@ type settings struct {
 type namedConfig struct {
 	Name string `json:"name"`
 	config
+
+	team bool // Read from the team settings?
 }
 
 // settingsFileName returns the name of the file where settings should be saved.
@@ -30,6 +32,13 @@ func settingsFileName() (string, error) {
 	return filepath.Join(dir, "pprof", "settings.json"), nil
 }
 
+// teamSettingsFileName returns the name of the read-only file of the configs
+// shared by a team, given by $PPROF_TEAM_CONFIG, or "" if there is none. The
+// file has the format of the settings file.
+func teamSettingsFileName() string {
+	return os.Getenv("PPROF_TEAM_CONFIG")
+}
+
 // readSettings reads settings from fname.
 func readSettings(fname string) (*settings, error) {
 	data, err := os.ReadFile(fname)
@@ -69,6 +78,62 @@ func writeSettings(fname string, settings *settings) error {
 	return nil
 }
 
+// readConfigs returns the named configs of the team settings followed by the
+// ones of the user settings in fname. A user config replaces the team config
+// of the same name.
+func readConfigs(fname string) ([]namedConfig, error) {
+	var configs []namedConfig
+	if team := teamSettingsFileName(); team != "" {
+		if _, err := os.Stat(team); err != nil {
+			return nil, fmt.Errorf("could not read team settings: %w", err)
+		}
+		settings, err := readSettings(team)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", team, err)
+		}
+		for _, c := range settings.Configs {
+			c.team = true
+			configs = append(configs, c)
+		}
+	}
+	settings, err := readSettings(fname)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range settings.Configs {
+		if i := findConfig(configs, c.Name); i >= 0 {
+			configs[i] = c
+			continue
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// findConfig returns the index of the config with the given name, or -1.
+func findConfig(configs []namedConfig, name string) int {
+	for i, c := range configs {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookupConfig returns the config with the given name, from the user settings
+// in fname or from the team settings.
+func lookupConfig(fname, name string) (config, error) {
+	configs, err := readConfigs(fname)
+	if err != nil {
+		return config{}, err
+	}
+	i := findConfig(configs, name)
+	if i < 0 {
+		return config{}, fmt.Errorf("config %s not found", name)
+	}
+	return configs[i].config, nil
+}
+
 // configMenuEntry holds information for a single config menu entry.
 type configMenuEntry struct {
 	Name       string
@@ -81,9 +146,9 @@ type configMenuEntry struct {
 func configMenu(fname string, u url.URL) []configMenuEntry {
 	// Start with system configs.
 	configs := []namedConfig{{Name: "Default", config: defaultConfig()}}
-	if settings, err := readSettings(fname); err == nil {
-		// Add user configs.
-		configs = append(configs, settings.Configs...)
+	if saved, err := readConfigs(fname); err == nil {
+		// Add team and user configs.
+		configs = append(configs, saved...)
 	}
 
 	// Convert to menu entries.
@@ -99,7 +164,7 @@ func configMenu(fname string, u url.URL) []configMenuEntry {
 		result[i] = configMenuEntry{
 			Name:       cfg.Name,
 			URL:        rel.String(),
-			UserConfig: (i != 0),
+			UserConfig: (i != 0 && !cfg.team),
 		}
 	}
 	// Mark the last matching config as currennt
@@ -132,26 +197,31 @@ func setConfig(fname string, request url.URL) error {
 	if err := cfg.applyURL(q); err != nil {
 		return err
 	}
+	return saveConfig(fname, name, cfg)
+}
+
+// saveConfig saves cfg under the given name to fname, replacing the config of
+// that name if any.
+func saveConfig(fname, name string, cfg config) error {
 	return editSettings(fname, func(s *settings) error {
-		for i, c := range s.Configs {
-			if c.Name == name {
-				s.Configs[i].config = cfg
-				return nil
-			}
+		if i := findConfig(s.Configs, name); i >= 0 {
+			s.Configs[i].config = cfg
+			return nil
 		}
 		s.Configs = append(s.Configs, namedConfig{Name: name, config: cfg})
 		return nil
 	})
 }
 
-// removeConfig removes config from fname.
+// removeConfig removes config from fname. The team configs are read-only.
 func removeConfig(fname, config string) error {
 	return editSettings(fname, func(s *settings) error {
-		for i, c := range s.Configs {
-			if c.Name == config {
-				s.Configs = append(s.Configs[:i], s.Configs[i+1:]...)
-				return nil
-			}
+		if i := findConfig(s.Configs, config); i >= 0 {
+			s.Configs = append(s.Configs[:i], s.Configs[i+1:]...)
+			return nil
+		}
+		if configs, err := readConfigs(fname); err == nil && findConfig(configs, config) >= 0 {
+			return fmt.Errorf("config %s is a read-only team config", config)
 		}
 		return fmt.Errorf("config %s not found", config)
 	})
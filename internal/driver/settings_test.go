@@ -5,7 +5,11 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/transport"
 )
 
 // settingsDirAndFile returns a directory in which settings should be stored
@@ -66,6 +70,8 @@ func TestParseConfig(t *testing.T) {
 		CompactLabels:       true,
 		SourcePath:          "",
 		TrimPath:            "",
+		Blame:               true,
+		CoverProfile:        "",
 		NodeCount:           10,
 		NodeFraction:        0.1,
 		EdgeFraction:        0.2,
@@ -87,6 +93,8 @@ func TestParseConfig(t *testing.T) {
 		Granularity:         "functions",
 		NoInlines:           true,
 		ShowColumns:         true,
+		ThreadLabel:         "goroutine",
+		TimeLabel:           "ts",
 	}
 	url, changed := cfg.makeURL(url.URL{})
 	if !changed {
@@ -245,3 +253,111 @@ func TestAssign(t *testing.T) {
 		t.Errorf("allowed assignment of invalid granularity")
 	}
 }
+
+func TestTeamConfigs(t *testing.T) {
+	tmpDir, fname := settingsDirAndFile(t)
+	defer os.RemoveAll(tmpDir)
+	teamFile := filepath.Join(tmpDir, "team.json")
+	t.Setenv("PPROF_TEAM_CONFIG", teamFile)
+
+	if _, err := readConfigs(fname); err == nil {
+		t.Error("got no error for a missing team settings file")
+	}
+
+	a, b, userB, c := defaultConfig(), defaultConfig(), defaultConfig(), defaultConfig()
+	a.Focus, b.Focus, userB.Hide, c.TagFocus = "foo", "bar", "baz", "qux"
+	team := &settings{Configs: []namedConfig{{Name: "A", config: a}, {Name: "B", config: b}}}
+	if err := writeSettings(teamFile, team); err != nil {
+		t.Fatal(err)
+	}
+	user := &settings{Configs: []namedConfig{{Name: "B", config: userB}, {Name: "C", config: c}}}
+	if err := writeSettings(fname, user); err != nil {
+		t.Fatal(err)
+	}
+
+	pageURL, _ := url.Parse("/top?h=baz")
+	menu := configMenu(fname, *pageURL)
+	want := []configMenuEntry{
+		{Name: "Default", URL: "?", Current: false, UserConfig: false},
+		{Name: "A", URL: "?f=foo", Current: false, UserConfig: false},
+		{Name: "B", URL: "?h=baz", Current: true, UserConfig: true},
+		{Name: "C", URL: "?tf=qux", Current: false, UserConfig: true},
+	}
+	if !reflect.DeepEqual(menu, want) {
+		t.Errorf("configMenu returned %v; want %v", menu, want)
+	}
+
+	for name, want := range map[string]config{"A": a, "B": userB, "C": c} {
+		got, err := lookupConfig(fname, name)
+		if err != nil {
+			t.Errorf("lookupConfig(%s): %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("lookupConfig(%s) = %+v; want %+v", name, got, want)
+		}
+	}
+	if _, err := lookupConfig(fname, "D"); err == nil {
+		t.Error("lookupConfig returned no error for an unknown config")
+	}
+
+	if err := removeConfig(fname, "A"); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("removing a team config: got error %v, want a read-only error", err)
+	}
+	// Removing the user config of a name shows the team config again.
+	if err := removeConfig(fname, "B"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lookupConfig(fname, "B"); err != nil || got.Focus != "bar" {
+		t.Errorf("after removing the user config: got %+v, %v; want the team config", got, err)
+	}
+	s, err := readSettings(teamFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Configs) != 2 {
+		t.Errorf("the team settings changed: got %d configs, want 2", len(s.Configs))
+	}
+}
+
+func TestConfigFlag(t *testing.T) {
+	baseConfig := currentConfig()
+	defer setCurrentConfig(baseConfig)
+
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("HOME", configDir)
+	t.Setenv("AppData", configDir)
+	t.Setenv("PPROF_TEAM_CONFIG", "")
+	fname, err := settingsFileName()
+	if err != nil {
+		t.Skip(err)
+	}
+	mine := defaultConfig()
+	mine.Focus, mine.Hide, mine.Sort = "foo", "bar", "cum"
+	if err := writeSettings(fname, &settings{Configs: []namedConfig{{Name: "mine", config: mine}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	o := setDefaults(&plugin.Options{HTTPTransport: transport.New(nil)})
+	o.Flagset = testFlags{
+		strings: map[string]string{"config": "mine", "hide": "baz"},
+		args:    []string{"profile.pb.gz"},
+	}
+	if _, _, err := parseFlags(o); err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	// The flags on the command line take precedence over the config.
+	got := currentConfig()
+	if got.Focus != "foo" || got.Hide != "baz" || got.Sort != "cum" {
+		t.Errorf("got focus %q, hide %q and sort %q; want foo, baz and cum", got.Focus, got.Hide, got.Sort)
+	}
+
+	o.Flagset = testFlags{
+		strings: map[string]string{"config": "theirs"},
+		args:    []string{"profile.pb.gz"},
+	}
+	if _, _, err := parseFlags(o); err == nil {
+		t.Error("parseFlags returned no error for an unknown config")
+	}
+}
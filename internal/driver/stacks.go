@@ -22,37 +22,41 @@ import (
 	"github.com/google/pprof/internal/report"
 )
 
-// stackView generates the flamegraph view.
-func (ui *webInterface) stackView(w http.ResponseWriter, req *http.Request) {
-	// Get all data in a report.
-	rpt, errList := ui.makeReport(w, req, []string{"svg"}, func(cfg *config) {
-		cfg.CallTree = true
-		cfg.Trim = false
-		cfg.Granularity = "filefunctions"
-	})
-	if rpt == nil {
-		return // error already reported
-	}
+// stackView returns a handler generating a view of the stacks of the
+// profile: "flamegraph", "icicle", "sunburst" or "treemap".
+func (ui *webInterface) stackView(view string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		// Get all data in a report.
+		rpt, errList := ui.makeReport(w, req, []string{"svg"}, func(cfg *config) {
+			cfg.CallTree = true
+			cfg.Trim = false
+			cfg.Granularity = "filefunctions"
+		})
+		if rpt == nil {
+			return // error already reported
+		}
 
-	// Make stack data and generate corresponding JSON.
-	stacks := rpt.Stacks()
-	b, err := json.Marshal(stacks)
-	if err != nil {
-		http.Error(w, "error serializing stacks for flame graph",
-			http.StatusInternalServerError)
-		ui.options.UI.PrintErr(err)
-		return
-	}
+		// Make stack data and generate corresponding JSON.
+		stacks := rpt.Stacks()
+		b, err := json.Marshal(stacks)
+		if err != nil {
+			http.Error(w, "error serializing stacks for flame graph",
+				http.StatusInternalServerError)
+			ui.options.UI.PrintErr(err)
+			return
+		}
 
-	nodes := make([]string, len(stacks.Sources))
-	for i, src := range stacks.Sources {
-		nodes[i] = src.FullName
-	}
-	nodes[0] = "" // root is not a real node
+		nodes := make([]string, len(stacks.Sources))
+		for i, src := range stacks.Sources {
+			nodes[i] = src.FullName
+		}
+		nodes[0] = "" // root is not a real node
 
-	_, legend := report.TextItems(rpt)
-	ui.render(w, req, "stacks", rpt, errList, legend, webArgs{
-		Stacks: template.JS(b),
-		Nodes:  nodes,
-	})
+		_, legend := report.TextItems(rpt)
+		ui.render(w, req, "stacks", rpt, errList, legend, webArgs{
+			Stacks: template.JS(b),
+			Nodes:  nodes,
+			View:   view,
+		})
+	}
 }
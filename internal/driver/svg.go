@@ -15,8 +15,11 @@
 package driver
 
 import (
+	"os"
+	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/google/pprof/third_party/svgpan"
 )
@@ -25,8 +28,34 @@ var (
 	viewBox  = regexp.MustCompile(`<svg\s*width="[^"]+"\s*height="[^"]+"\s*viewBox="[^"]+"`)
 	graphID  = regexp.MustCompile(`<g id="graph\d"`)
 	svgClose = regexp.MustCompile(`</svg>`)
+
+	// dotMissing records whether the dot tool is not installed, looked up
+	// once.
+	dotMissing     bool
+	dotMissingOnce sync.Once
 )
 
+// builtinLayoutCommands are the commands generating SVG graphs, which
+// pprof lays out itself when it does not use dot.
+var builtinLayoutCommands = map[string]bool{"svg": true, "web": true, "eog": true}
+
+// useBuiltinLayout reports whether graphs are laid out by pprof itself
+// rather than by the dot tool from Graphviz. PPROF_GRAPH_LAYOUT selects
+// "builtin" or "dot"; by default dot is used if it is installed.
+func useBuiltinLayout() bool {
+	switch os.Getenv("PPROF_GRAPH_LAYOUT") {
+	case "builtin":
+		return true
+	case "dot":
+		return false
+	}
+	dotMissingOnce.Do(func() {
+		_, err := exec.LookPath("dot")
+		dotMissing = err != nil
+	})
+	return dotMissing
+}
+
 // massageSVG enhances the SVG output from DOT to provide better
 // panning inside a web browser. It uses the svgpan library, which is
 // embedded into the svgpan.JSSource variable.
@@ -7,14 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/google/pprof/internal/binutils"
-	"github.com/google/pprof/internal/graph"
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/report"
 	"github.com/google/pprof/internal/transport"
 	"github.com/google/pprof/profile"
 	"html/template"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 )
 
 var singleTransport http.RoundTripper
@@ -24,9 +25,26 @@ type renderData map[string]string
 type RenderOption struct {
 	DiffType     string
 	BaseFilePath string
+
+	// Auth and Allow restrict the clients of the render function, as the
+	// -http_auth and -http_allow flags do for the web UI.
+	Auth  string
+	Allow string
 }
 
 func GetRenderFunc(filepath string, renderType string, renderData UdfRenderData, ro RenderOption) (func(w http.ResponseWriter, req *http.Request), error) {
+	access, err := parseWebAccess(ro.Auth, ro.Allow)
+	if err != nil {
+		return nil, err
+	}
+	render, err := getRenderFunc(filepath, renderType, renderData, ro)
+	if err != nil || access == nil {
+		return render, err
+	}
+	return access.handler(http.HandlerFunc(render)).ServeHTTP, nil
+}
+
+func getRenderFunc(filepath string, renderType string, renderData UdfRenderData, ro RenderOption) (func(w http.ResponseWriter, req *http.Request), error) {
 
 	if singleTransport == nil {
 		o := &plugin.Options{}
@@ -58,8 +76,13 @@ func GetRenderFunc(filepath string, renderType string, renderData UdfRenderData,
 	ui.help["details"] = "Show information about the profile and this view"
 	ui.help["graph"] = "Display profile as a directed graph"
 	ui.help["flamegraph"] = "Display profile as a flame graph"
+	ui.help["icicle"] = "Display the calls made by functions as a top-down icicle chart"
+	ui.help["sunburst"] = "Display profile as a sunburst chart"
+	ui.help["treemap"] = "Display self cost grouped by package, file and function"
 	ui.help["reset"] = "Show the entire profile"
 	ui.help["save_config"] = "Save current settings"
+	ui.help["upload"] = "Upload a profile to view, or to compare this profile with"
+	ui.help["share"] = "Get a lasting link to a snapshot of this view"
 
 	ui.renderData = renderData
 
@@ -76,9 +99,30 @@ func GetRenderFunc(filepath string, renderType string, renderData UdfRenderData,
 	case "peek":
 		return ui.peek, nil
 		break
-	case "flamegraph":
-		return ui.flamegraph, nil
+	case "flamegraph", "icicle", "sunburst", "treemap":
+		return ui.stackView(renderType), nil
 		break
+	case "upload":
+		return uploadRenderFunc(p, o, renderData)
+	case "share":
+		return shareRenderFunc(p, o, renderData)
+	case "html_report":
+		return func(w http.ResponseWriter, req *http.Request) {
+			cfg := currentConfig()
+			if err := cfg.applyURL(req.URL.Query()); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			report := &bytes.Buffer{}
+			if err := writeHTMLReport(report, copier.newCopy(), cfg, o); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				ui.options.UI.PrintErr(err)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Disposition", "attachment;filename=report.html")
+			w.Write(report.Bytes())
+		}, nil
 	//case "saveconfig":
 	//	return ui.dot, nil
 	//	break
@@ -109,7 +153,7 @@ func GetRenderFunc(filepath string, renderType string, renderData UdfRenderData,
 	//		"/disasm":       http.HandlerFunc(ui.disasm),
 	//		"/source":       http.HandlerFunc(ui.source),
 	//		"/peek":         http.HandlerFunc(ui.peek),
-	//		"/flamegraph":   http.HandlerFunc(ui.flamegraph),
+	//		"/flamegraph":   ui.stackView("flamegraph"),
 	//		"/saveconfig":   http.HandlerFunc(ui.saveConfig),
 	//		"/deleteconfig": http.HandlerFunc(ui.deleteConfig),
 	//		"/download": http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -131,6 +175,50 @@ func GetRenderFunc(filepath string, renderType string, renderData UdfRenderData,
 
 }
 
+// uploadRenderFunc returns the handler of the upload page, at
+// rd.Uploadurl, which also serves the views of the uploaded profiles below
+// it. p is the profile the uploads can be compared with.
+func uploadRenderFunc(p *profile.Profile, o *plugin.Options, rd UdfRenderData) (func(w http.ResponseWriter, req *http.Request), error) {
+	if rd.Uploadurl == "" {
+		return nil, errors.New("the upload page needs an Uploadurl")
+	}
+	ui, err := makeWebInterface(p, makeProfileCopier(p), o)
+	if err != nil {
+		return nil, err
+	}
+	ui.help = webHelp()
+	ui.upload = true
+	return subtreeRenderFunc(rd.Uploadurl, "/upload", newWebUploads(ui, rd.Graphurl).handlers())
+}
+
+// shareRenderFunc returns the handler sharing the views of p, at
+// rd.Shareurl, which also serves the shared views below it.
+func shareRenderFunc(p *profile.Profile, o *plugin.Options, rd UdfRenderData) (func(w http.ResponseWriter, req *http.Request), error) {
+	if rd.Shareurl == "" {
+		return nil, errors.New("sharing needs a Shareurl")
+	}
+	ui, err := makeWebInterface(p, makeProfileCopier(p), o)
+	if err != nil {
+		return nil, err
+	}
+	ui.help = webHelp()
+	return subtreeRenderFunc(rd.Shareurl, "/share", newWebShares(ui, o, ui.help, rd.Graphurl).handlers())
+}
+
+// subtreeRenderFunc returns a handler serving the handlers of the paths
+// below root, such as the handlers of webUploads below /upload, at the same
+// paths below the path of rawURL.
+func subtreeRenderFunc(rawURL, root string, handlers map[string]http.Handler) (func(w http.ResponseWriter, req *http.Request), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(u.Path, "/")
+	return func(w http.ResponseWriter, req *http.Request) {
+		serveSubtree(w, req, handlers, root+strings.TrimPrefix(req.URL.Path, prefix))
+	}, nil
+}
+
 func initRenderArgs(rd UdfRenderData) webArgs2 {
 	wd := webArgs2{}
 	wd.Topurl = rd.Topurl
@@ -140,6 +228,8 @@ func initRenderArgs(rd UdfRenderData) webArgs2 {
 	wd.Sourceurl = rd.Sourceurl
 	wd.Disasmurl = rd.Disasmurl
 	wd.Downloadurl = rd.Downloadurl
+	wd.Upload = rd.Uploadurl
+	wd.Share = rd.Shareurl
 
 	return wd
 
@@ -156,11 +246,9 @@ func (ui *webInterface2) dot(w http.ResponseWriter, req *http.Request) {
 	g, config := report.GetDOT(rpt)
 	legend := config.Labels
 	config.Labels = nil
-	dot := &bytes.Buffer{}
-	graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
 
-	// Convert to svg.
-	svg, err := dotToSvg(dot.Bytes())
+	// Lay out the graph as svg.
+	svg, err := graphToSvg(g, config)
 	if err != nil {
 		http.Error(w, "Could not execute dot; may need to install graphviz.",
 			http.StatusNotImplemented)
@@ -271,8 +359,16 @@ func (ui *webInterface2) peek(w http.ResponseWriter, req *http.Request) {
 
 }
 
-// flamegraph generates a web page containing a flamegraph.
-func (ui *webInterface2) flamegraph(w http.ResponseWriter, req *http.Request) {
+// stackView returns a handler generating a view of the stacks of the
+// profile: "flamegraph", "icicle", "sunburst" or "treemap".
+func (ui *webInterface2) stackView(view string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ui.stacks(w, req, view)
+	}
+}
+
+// stacks generates a web page containing the named view of the stacks.
+func (ui *webInterface2) stacks(w http.ResponseWriter, req *http.Request, view string) {
 	// Get all data in a report.
 	rpt, errList := ui.makeReport(w, req, []string{"svg"}, func(cfg *config) {
 		cfg.CallTree = true
@@ -303,6 +399,7 @@ func (ui *webInterface2) flamegraph(w http.ResponseWriter, req *http.Request) {
 	wd := initRenderArgs(ui.renderData)
 	wd.Stacks = template.JS(b)
 	wd.Nodes = nodes
+	wd.View = view
 	ui.render(w, req, "stacks", rpt, errList, legend, wd)
 
 }
@@ -317,6 +414,12 @@ type UdfRenderData struct {
 	Sampleurl     string
 	Cpuurl        string
 	Downloadurl   string
+	// Uploadurl is the path of the "upload" render function, without a
+	// trailing slash. The paths below it must be routed to it too.
+	Uploadurl string
+	// Shareurl is the path of the "share" render function, without a
+	// trailing slash. The paths below it must be routed to it too.
+	Shareurl string
 }
 
 // webArgs contains arguments passed to templates in webhtml.go.
@@ -333,7 +436,12 @@ type webArgs2 struct {
 	Top         []report.TextItem
 	FlameGraph  template.JS
 	Stacks      template.JS
+	View        string
 	Configs     []configMenuEntry
+	Static      map[string]string
+	Index       string
+	Upload      string
+	Share       string
 	UdfRenderData
 }
 
@@ -0,0 +1,179 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// tokenCookie is the cookie holding the bearer token of a browser that
+// authenticated with the token query parameter.
+const tokenCookie = "pprof_token"
+
+// webAccess restricts the web UI to allowed clients and authenticated
+// users.
+type webAccess struct {
+	allowed        []*net.IPNet // Networks of the allowed clients, any if empty.
+	user, password string       // Credentials for basic authentication.
+	token          string       // Token for bearer authentication.
+}
+
+// parseWebAccess parses the -http_auth and -http_allow settings. auth is
+// either basic:USER:PASSWORD or bearer:TOKEN, and allow a comma separated
+// list of IP addresses and CIDR blocks. It returns nil if neither is set.
+func parseWebAccess(auth, allow string) (*webAccess, error) {
+	if auth == "" && allow == "" {
+		return nil, nil
+	}
+	a := &webAccess{}
+	if auth != "" {
+		kind, creds, _ := strings.Cut(auth, ":")
+		switch kind {
+		case "basic":
+			user, password, ok := strings.Cut(creds, ":")
+			if !ok || user == "" || password == "" {
+				return nil, fmt.Errorf("invalid -http_auth: want basic:USER:PASSWORD")
+			}
+			a.user, a.password = user, password
+		case "bearer":
+			if creds == "" {
+				return nil, fmt.Errorf("invalid -http_auth: want bearer:TOKEN")
+			}
+			a.token = creds
+		default:
+			return nil, fmt.Errorf("invalid -http_auth: want basic:USER:PASSWORD or bearer:TOKEN")
+		}
+	}
+	for _, s := range strings.Split(allow, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid -http_allow address %q", s)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			a.allowed = append(a.allowed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -http_allow network %q: %v", s, err)
+		}
+		a.allowed = append(a.allowed, n)
+	}
+	return a, nil
+}
+
+// handler returns a handler that serves the requests of allowed and
+// authenticated clients with h, and rejects the others. A nil webAccess
+// allows all requests.
+func (a *webAccess) handler(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !a.allowedClient(req) {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		if !a.authenticate(w, req) {
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// allowedClient reports whether the client of a request is in one of the
+// allowed networks.
+func (a *webAccess) allowedClient(req *http.Request) bool {
+	if len(a.allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range a.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate reports whether a request carries the expected credentials,
+// responding with an authentication challenge if not. Browsers, which
+// cannot send bearer tokens themselves, may pass the token in the token
+// query parameter once: it is then kept in a cookie, and GET requests are
+// redirected to the URL without the token, so that it stays out of the
+// browser history and of the Referer header.
+func (a *webAccess) authenticate(w http.ResponseWriter, req *http.Request) bool {
+	switch {
+	case a.user != "":
+		if user, password, ok := req.BasicAuth(); ok && equal(user, a.user) && equal(password, a.password) {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="pprof", charset="UTF-8"`)
+	case a.token != "":
+		if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") && equal(strings.TrimPrefix(h, "Bearer "), a.token) {
+			return true
+		}
+		if c, err := req.Cookie(tokenCookie); err == nil && equal(c.Value, a.token) {
+			return true
+		}
+		if query := req.URL.Query(); query.Get("token") != "" && equal(query.Get("token"), a.token) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    a.token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   req.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				return true
+			}
+			query.Del("token")
+			u := *req.URL
+			u.RawQuery = query.Encode()
+			http.Redirect(w, req, u.RequestURI(), http.StatusSeeOther)
+			return false
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pprof"`)
+	default:
+		return true
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// equal compares secrets in constant time.
+func equal(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
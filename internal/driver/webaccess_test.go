@@ -0,0 +1,232 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+func TestParseWebAccess(t *testing.T) {
+	for _, tc := range []struct {
+		auth, allow string
+		wantErr     bool
+	}{
+		{"", "", false},
+		{"basic:user:pass:word", "", false},
+		{"bearer:token", "10.0.0.0/8, 192.168.1.1,::1", false},
+		{"basic:user", "", true},
+		{"basic::password", "", true},
+		{"bearer:", "", true},
+		{"digest:user:password", "", true},
+		{"", "10.0.0.0/33", true},
+		{"", "host.example.com", true},
+	} {
+		_, err := parseWebAccess(tc.auth, tc.allow)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("parseWebAccess(%q, %q): got error %v, want error %v", tc.auth, tc.allow, err, tc.wantErr)
+		}
+	}
+}
+
+func TestWebAccess(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	for _, tc := range []struct {
+		desc         string
+		auth, allow  string
+		remoteAddr   string
+		url          string
+		header       map[string]string
+		wantCode     int
+		wantCookie   bool
+		wantLocation string
+	}{
+		{desc: "open", remoteAddr: "203.0.113.1:1234", wantCode: http.StatusOK},
+		{desc: "allowed network", allow: "10.0.0.0/8", remoteAddr: "10.1.2.3:1234", wantCode: http.StatusOK},
+		{desc: "allowed address", allow: "10.0.0.0/8,192.168.1.1", remoteAddr: "192.168.1.1:1234", wantCode: http.StatusOK},
+		{desc: "allowed IPv6", allow: "::1", remoteAddr: "[::1]:1234", wantCode: http.StatusOK},
+		{desc: "disallowed", allow: "10.0.0.0/8,192.168.1.1", remoteAddr: "192.168.1.2:1234", wantCode: http.StatusForbidden},
+		{desc: "basic", auth: "basic:user:secret", header: map[string]string{"Authorization": "Basic dXNlcjpzZWNyZXQ="}, wantCode: http.StatusOK},
+		{desc: "basic wrong password", auth: "basic:user:secret", header: map[string]string{"Authorization": "Basic dXNlcjpzZWNyZXU="}, wantCode: http.StatusUnauthorized},
+		{desc: "basic missing", auth: "basic:user:secret", wantCode: http.StatusUnauthorized},
+		{desc: "bearer", auth: "bearer:t0k3n", header: map[string]string{"Authorization": "Bearer t0k3n"}, wantCode: http.StatusOK},
+		{desc: "bearer wrong token", auth: "bearer:t0k3n", header: map[string]string{"Authorization": "Bearer t0k3m"}, wantCode: http.StatusUnauthorized},
+		{desc: "bearer cookie", auth: "bearer:t0k3n", header: map[string]string{"Cookie": tokenCookie + "=t0k3n"}, wantCode: http.StatusOK},
+		{desc: "bearer query", auth: "bearer:t0k3n", url: "/top?token=t0k3n&si=cpu", wantCode: http.StatusSeeOther, wantCookie: true, wantLocation: "/top?si=cpu"},
+		{desc: "bearer wrong query", auth: "bearer:t0k3n", url: "/top?token=x", wantCode: http.StatusUnauthorized},
+		{desc: "authenticated but disallowed", auth: "bearer:t0k3n", allow: "10.0.0.0/8", remoteAddr: "192.168.1.2:1234", header: map[string]string{"Authorization": "Bearer t0k3n"}, wantCode: http.StatusForbidden},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			access, err := parseWebAccess(tc.auth, tc.allow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			url := tc.url
+			if url == "" {
+				url = "/top"
+			}
+			req := httptest.NewRequest("GET", url, nil)
+			if tc.remoteAddr != "" {
+				req.RemoteAddr = tc.remoteAddr
+			}
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			access.handler(ok).ServeHTTP(w, req)
+			if w.Code != tc.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, tc.wantCode)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no authentication challenge")
+			}
+			cookie := w.Header().Get("Set-Cookie")
+			if gotCookie := cookie != ""; gotCookie != tc.wantCookie {
+				t.Errorf("got cookie %v, want %v", gotCookie, tc.wantCookie)
+			}
+			if tc.wantCookie && !strings.Contains(cookie, "Path=/;") {
+				t.Errorf("got cookie %q, want it for all paths", cookie)
+			}
+			if got := w.Header().Get("Location"); got != tc.wantLocation {
+				t.Errorf("got location %q, want %q", got, tc.wantLocation)
+			}
+		})
+	}
+}
+
+func TestWebInterfaceAccess(t *testing.T) {
+	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
+		t.Skip("test assumes tcp available")
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	pool := writeTestCertificate(t, certFile, keyFile)
+
+	host, port, err := getHostAndPort("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostport := net.JoinHostPort(host, strconv.Itoa(port))
+	src := &source{
+		HTTPHostport:       hostport,
+		HTTPDisableBrowser: true,
+		HTTPTLSCert:        certFile,
+		HTTPTLSKey:         keyFile,
+		HTTPAuth:           "basic:user:secret",
+	}
+	// Handlers registered by the program, such as the ones of
+	// net/http/pprof, are restricted too.
+	http.HandleFunc("/pprof-test-default-mux", func(w http.ResponseWriter, req *http.Request) {})
+	go serveWebInterface(src, makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &stdUI{},
+	})
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	get := func(path, password string) (int, error) {
+		req, err := http.NewRequest("GET", "https://"+hostport+path, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.SetBasicAuth("user", password)
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	// Wait for the server to start.
+	var code int
+	for i := 0; i < 100; i++ {
+		if code, err = get("/ui/top", "secret"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("fetching the web UI over HTTPS: %v", err)
+	}
+	if code != http.StatusOK {
+		t.Errorf("authenticated request: got status %d, want %d", code, http.StatusOK)
+	}
+	if code, err := get("/ui/top", "wrong"); err != nil || code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request: got status %d, error %v, want status %d", code, err, http.StatusUnauthorized)
+	}
+	if code, err := get("/ui/pprof-test-default-mux", "wrong"); err != nil || code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request to http.DefaultServeMux: got status %d, error %v, want status %d", code, err, http.StatusUnauthorized)
+	}
+	if code, err := get("/ui/pprof-test-default-mux", "secret"); err != nil || code != http.StatusOK {
+		t.Errorf("authenticated request to http.DefaultServeMux: got status %d, error %v, want status %d", code, err, http.StatusOK)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key, and returns a certificate pool trusting it.
+func writeTestCertificate(t *testing.T, certFile, keyFile string) *x509.CertPool {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pprof test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
@@ -0,0 +1,416 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// maxDirViews is the maximum number of profiles, or combinations of
+// profiles, kept ready to be viewed when serving a directory.
+const maxDirViews = 8
+
+// selectionRE matches the selections of profiles in the paths of the views:
+// the IDs of the profiles to merge, joined by "+", optionally followed by
+// "-" and the ID of a base profile to subtract.
+var selectionRE = regexp.MustCompile(`^[[:xdigit:]]{12}(\+[[:xdigit:]]{12})*(-[[:xdigit:]]{12})?$`)
+
+// profileDir serves the web UI for the profiles of a directory. Its index
+// page lists the profiles, and the views of each profile, or of a merge or
+// a difference of profiles, are served below p/SELECTION/.
+type profileDir struct {
+	dir       string
+	src       *source // Options for fetching the profiles.
+	options   *plugin.Options
+	templates *template.Template
+
+	mu    sync.Mutex
+	files map[string]*dirProfile // By path relative to dir.
+	ids   map[string]*dirProfile // By ID.
+	views map[string]*dirView    // By selection.
+	loads map[string]*dirLoad    // Views being built, by selection.
+}
+
+// dirProfile describes a profile of the directory, for the index page.
+type dirProfile struct {
+	ID, Path string
+	Type     string
+	Time     string
+	Duration string
+	Comments []string
+
+	time    time.Time
+	modTime time.Time
+	size    int64
+	err     error // Error parsing the file, not listed if set.
+}
+
+// dirView holds the handlers of the views of a selection of profiles.
+type dirView struct {
+	stamp    string // Identifies the versions of the files of the selection.
+	handlers map[string]http.Handler
+	used     time.Time
+}
+
+// dirLoad tracks the building of the views of a selection, so that
+// concurrent requests for the selection share its result.
+type dirLoad struct {
+	stamp    string
+	done     chan struct{} // Closed once handlers and err are set.
+	handlers map[string]http.Handler
+	err      error
+}
+
+// serveProfileDir serves the web UI for the profiles of the directory
+// src.ServeDir, as set up by the HTTP options of src.
+func serveProfileDir(src *source, o *plugin.Options) error {
+	if fi, err := os.Stat(src.ServeDir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", src.ServeDir)
+	}
+	d, err := newProfileDir(src, o)
+	if err != nil {
+		return err
+	}
+	interactiveMode = true
+	return serveHandlers(src, d.handlers(), o)
+}
+
+func newProfileDir(src *source, o *plugin.Options) (*profileDir, error) {
+	// The profiles are fetched by absolute path, so that their names are
+	// not mistaken for URLs.
+	dir, err := filepath.Abs(src.ServeDir)
+	if err != nil {
+		return nil, err
+	}
+	templates := template.New("templategroup")
+	addTemplates(templates)
+	return &profileDir{
+		dir:       dir,
+		src:       src,
+		options:   o,
+		templates: templates,
+		files:     make(map[string]*dirProfile),
+		ids:       make(map[string]*dirProfile),
+		views:     make(map[string]*dirView),
+		loads:     make(map[string]*dirLoad),
+	}, nil
+}
+
+func (d *profileDir) handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/":       http.HandlerFunc(d.index),
+		"/open":   http.HandlerFunc(d.open),
+		"/p/":     http.HandlerFunc(d.view),
+		"/share/": newWebShares(nil, d.options, webHelp(), "").handlers()["/share/"],
+	}
+}
+
+// index generates the page listing the profiles of the directory.
+func (d *profileDir) index(w http.ResponseWriter, req *http.Request) {
+	profiles, err := d.scan()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		d.options.UI.PrintErr(err)
+		return
+	}
+	html := &bytes.Buffer{}
+	if err := d.templates.ExecuteTemplate(html, "profiles", struct {
+		Title    string
+		Profiles []*dirProfile
+	}{d.dir, profiles}); err != nil {
+		http.Error(w, "internal template error", http.StatusInternalServerError)
+		d.options.UI.PrintErr(err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(html.Bytes())
+}
+
+// open redirects to the views of the profiles selected in the index page:
+// the merge of the profiles given by the id parameters, minus the profile
+// given by the base parameter, if any.
+func (d *profileDir) open(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	ids := q["id"]
+	if len(ids) == 0 {
+		http.Error(w, "no profile selected", http.StatusBadRequest)
+		return
+	}
+	sel := strings.Join(ids, "+")
+	if base := q.Get("base"); base != "" {
+		sel += "-" + base
+	}
+	if !selectionRE.MatchString(sel) {
+		http.Error(w, "invalid profile selection", http.StatusBadRequest)
+		return
+	}
+	// Use a relative URL to work in presence of stripping/redirects in webui.go.
+	w.Header().Set("Location", "p/"+sel+"/")
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// view serves the views of a selection of profiles, at p/SELECTION/VIEW.
+func (d *profileDir) view(w http.ResponseWriter, req *http.Request) {
+	sel, view, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/p/"), "/")
+	if !ok || !selectionRE.MatchString(sel) {
+		http.NotFound(w, req)
+		return
+	}
+	handlers, err := d.selection(sel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		d.options.UI.PrintErr(err)
+		return
+	}
+	serveSubtree(w, req, handlers, "/"+view)
+}
+
+// selection returns the handlers of the views of a selection of profiles,
+// reusing them while the profiles are unchanged. The profiles are fetched
+// without holding d.mu, and concurrent requests for a selection wait for a
+// single fetch.
+func (d *profileDir) selection(sel string) (map[string]http.Handler, error) {
+	d.mu.Lock()
+	sources, base, stamp, err := d.resolve(sel)
+	if err != nil {
+		// The directory may have changed since the index was generated.
+		d.mu.Unlock()
+		if _, err := d.scan(); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		if sources, base, stamp, err = d.resolve(sel); err != nil {
+			d.mu.Unlock()
+			return nil, err
+		}
+	}
+	if v := d.views[sel]; v != nil && v.stamp == stamp {
+		v.used = time.Now()
+		d.mu.Unlock()
+		return v.handlers, nil
+	}
+	if l := d.loads[sel]; l != nil && l.stamp == stamp {
+		d.mu.Unlock()
+		<-l.done
+		return l.handlers, l.err
+	}
+	l := &dirLoad{stamp: stamp, done: make(chan struct{})}
+	d.loads[sel] = l
+	d.mu.Unlock()
+
+	l.handlers, l.err = d.load(sources, base)
+
+	d.mu.Lock()
+	// Keep the views unless a load of newer versions of the files started
+	// meanwhile.
+	if d.loads[sel] == l {
+		delete(d.loads, sel)
+		if l.err == nil {
+			if len(d.views) >= maxDirViews {
+				evictView(d.views)
+			}
+			d.views[sel] = &dirView{stamp: stamp, handlers: l.handlers, used: time.Now()}
+		}
+	}
+	d.mu.Unlock()
+	close(l.done)
+	return l.handlers, l.err
+}
+
+// load fetches the merge of the profiles in sources, minus the profile base
+// if set, and returns the handlers of its views.
+func (d *profileDir) load(sources []string, base string) (map[string]http.Handler, error) {
+	s := &source{
+		Sources:   sources,
+		Symbolize: d.src.Symbolize,
+		Timeout:   d.src.Timeout,
+	}
+	if base != "" {
+		s.Base, s.DiffBase = []string{base}, true
+	}
+	p, err := fetchProfiles(s, d.options)
+	if err != nil {
+		return nil, err
+	}
+	ui, err := makeWebInterface(p, makeProfileCopier(p), d.options)
+	if err != nil {
+		return nil, err
+	}
+	ui.help = webHelp()
+	ui.index = "../../"
+	ui.upload = d.src.HTTPUpload
+	return ui.handlers(), nil
+}
+
+// resolve returns the files of the profiles and of the base profile of a
+// selection, and a stamp identifying their current versions.
+func (d *profileDir) resolve(sel string) (sources []string, base, stamp string, err error) {
+	ids, baseID, _ := strings.Cut(sel, "-")
+	file := func(id string) (string, error) {
+		dp := d.ids[id]
+		if dp == nil {
+			return "", fmt.Errorf("unknown profile %s", id)
+		}
+		name := filepath.Join(d.dir, dp.Path)
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", dp.Path, fi.ModTime().UnixNano(), fi.Size())
+		return name, nil
+	}
+	for _, id := range strings.Split(ids, "+") {
+		name, err := file(id)
+		if err != nil {
+			return nil, "", "", err
+		}
+		sources = append(sources, name)
+	}
+	if baseID != "" {
+		if base, err = file(baseID); err != nil {
+			return nil, "", "", err
+		}
+	}
+	return sources, base, stamp, nil
+}
+
+// evictView drops the least recently used of views.
+func evictView(views map[string]*dirView) {
+	var oldest string
+	for sel, v := range views {
+		if oldest == "" || v.used.Before(views[oldest].used) {
+			oldest = sel
+		}
+	}
+	delete(views, oldest)
+}
+
+// scan updates the descriptions of the profiles of the directory, parsing
+// the new and modified files, and returns them, the most recent first. The
+// files are parsed without holding d.mu, which is only locked to read and
+// update the descriptions.
+func (d *profileDir) scan() ([]*dirProfile, error) {
+	d.mu.Lock()
+	known := d.files
+	d.mu.Unlock()
+
+	files := make(map[string]*dirProfile)
+	err := filepath.WalkDir(d.dir, func(name string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != d.dir && strings.HasPrefix(e.Name(), ".") {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !e.Type().IsRegular() {
+			return nil
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(d.dir, name)
+		if err != nil {
+			return err
+		}
+		dp := known[rel]
+		if dp == nil || !dp.modTime.Equal(fi.ModTime()) || dp.size != fi.Size() {
+			dp = describeProfile(name)
+			dp.Path = rel
+			dp.ID = profileID(rel)
+			dp.modTime, dp.size = fi.ModTime(), fi.Size()
+		}
+		files[rel] = dp
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]*dirProfile)
+	var profiles []*dirProfile
+	for _, dp := range files {
+		if dp.err != nil {
+			continue
+		}
+		ids[dp.ID] = dp
+		profiles = append(profiles, dp)
+	}
+	d.mu.Lock()
+	d.files, d.ids = files, ids
+	d.mu.Unlock()
+
+	sort.Slice(profiles, func(i, j int) bool {
+		if !profiles[i].time.Equal(profiles[j].time) {
+			return profiles[i].time.After(profiles[j].time)
+		}
+		return profiles[i].Path < profiles[j].Path
+	})
+	return profiles, nil
+}
+
+// profileID returns the ID of a profile in the URLs of its views.
+func profileID(path string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(path)))
+	return hex.EncodeToString(sum[:6])
+}
+
+// describeProfile parses the named profile to describe it in the index.
+func describeProfile(name string) *dirProfile {
+	f, err := os.Open(name)
+	if err != nil {
+		return &dirProfile{err: err}
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return &dirProfile{err: err}
+	}
+	dp := &dirProfile{Comments: p.Comments}
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type)
+	}
+	dp.Type = strings.Join(types, ", ")
+	if p.TimeNanos != 0 {
+		dp.time = time.Unix(0, p.TimeNanos)
+		dp.Time = dp.time.Format("2006-01-02 15:04:05")
+	}
+	if p.DurationNanos != 0 {
+		dp.Duration = time.Duration(p.DurationNanos).Round(time.Millisecond).String()
+	}
+	return dp
+}
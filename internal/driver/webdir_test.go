@@ -0,0 +1,243 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/transport"
+)
+
+// writeDirProfile writes a fake profile with its values scaled by scale.
+func writeDirProfile(t *testing.T, name string, scale float64, timestamp time.Time, comment string) {
+	t.Helper()
+	p := makeFakeProfile()
+	p.Scale(scale)
+	p.TimeNanos = timestamp.UnixNano()
+	p.Comments = []string{comment}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, name, buf.Bytes())
+}
+
+func TestServeProfileDir(t *testing.T) {
+	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
+		t.Skip("test assumes tcp available")
+	}
+	dir := t.TempDir()
+	start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.Local)
+	writeDirProfile(t, filepath.Join(dir, "a.pb.gz"), 1, start, "first profile")
+	writeDirProfile(t, filepath.Join(dir, "sub", "b.pb.gz"), 3, start.Add(time.Hour), "second profile")
+	writeDirProfile(t, filepath.Join(dir, ".hidden", "c.pb.gz"), 1, start, "hidden profile")
+	writeFile(t, filepath.Join(dir, "notes.txt"), []byte("not a profile"))
+
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t, AllowRx: "unknown profile"},
+		HTTPTransport: transport.New(nil),
+	})
+	d, err := newProfileDir(&source{ServeDir: dir}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := d.handlers()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if h := lookupHandler(handlers, req.URL.Path); h != nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		http.NotFound(w, req)
+	}))
+	defer server.Close()
+
+	get := func(path string, wantCode int) string {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if resp.StatusCode != wantCode {
+			t.Fatalf("GET %s: got status %d, want %d: %s", path, resp.StatusCode, wantCode, data)
+		}
+		return string(data)
+	}
+	checkFlat := func(path, want string) {
+		t.Helper()
+		body := get(path, http.StatusOK)
+		re := regexp.MustCompile(`"Name":"F2","InlineLabel":"","Flat":(\d+),`)
+		m := re.FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("GET %s: no F2 entry in %s", path, body)
+		}
+		if m[1] != want {
+			t.Errorf("GET %s: got flat %s for F2, want %s", path, m[1], want)
+		}
+		if !strings.Contains(body, `href="../../"`) {
+			t.Errorf("GET %s: no link to the index", path)
+		}
+	}
+
+	a, b := profileID("a.pb.gz"), profileID(filepath.Join("sub", "b.pb.gz"))
+	index := get("/", http.StatusOK)
+	for _, want := range []string{`href="p/` + b + `/">sub/b.pb.gz<`, "second profile", "2023-05-01 11:00:00", "10s", `href="p/` + a + `/">a.pb.gz<`, "first profile", "cpu"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index: missing %q", want)
+		}
+	}
+	if strings.Index(index, "sub/b.pb.gz") > strings.Index(index, "a.pb.gz") {
+		t.Error("index: profiles not listed the most recent first")
+	}
+	for _, unwanted := range []string{"hidden profile", "notes.txt"} {
+		if strings.Contains(index, unwanted) {
+			t.Errorf("index: unexpected %q", unwanted)
+		}
+	}
+
+	checkFlat("/p/"+a+"/top", "200")
+	checkFlat("/p/"+b+"/top", "600")
+	get("/p/"+b+"/", http.StatusOK)
+
+	// Merges and differences, opened from the index.
+	if body := get("/open?id="+a+"&id="+b+"&base=", http.StatusOK); !strings.Contains(body, `href="../../"`) {
+		t.Error("opening a selection: did not redirect to its views")
+	}
+	checkFlat("/p/"+a+"+"+b+"/top", "800")
+	checkFlat("/p/"+b+"-"+a+"/top", "400")
+
+	// Modified profiles are parsed again.
+	writeDirProfile(t, filepath.Join(dir, "a.pb.gz"), 2, start, "first profile")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "a.pb.gz"), later, later)
+	checkFlat("/p/"+a+"/top", "400")
+	checkFlat("/p/"+b+"-"+a+"/top", "200")
+
+	// New profiles can be opened before the index is reloaded.
+	writeDirProfile(t, filepath.Join(dir, "c.pb.gz"), 5, start, "new profile")
+	checkFlat("/p/"+profileID("c.pb.gz")+"/top", "1000")
+
+	get("/p/"+profileID("missing")+"/top", http.StatusBadRequest)
+	get("/p/"+a+"/nosuchview", http.StatusNotFound)
+	get("/p/invalid/top", http.StatusNotFound)
+	get("/open", http.StatusBadRequest)
+}
+
+func TestProfileDirConcurrentSelection(t *testing.T) {
+	dir := t.TempDir()
+	writeDirProfile(t, filepath.Join(dir, "a.pb.gz"), 1, time.Now(), "profile")
+
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: transport.New(nil),
+	})
+	d, err := newProfileDir(&source{ServeDir: dir}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	// All the requests get the views of a single load, while the index
+	// scans the directory.
+	const n = 8
+	results := make(chan map[string]http.Handler, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := d.scan(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			handlers, err := d.selection(profileID("a.pb.gz"))
+			if err != nil {
+				t.Error(err)
+			}
+			results <- handlers
+		}()
+	}
+	wg.Wait()
+	close(results)
+	want := reflect.ValueOf(d.views[profileID("a.pb.gz")].handlers).Pointer()
+	for handlers := range results {
+		if got := reflect.ValueOf(handlers).Pointer(); got != want {
+			t.Errorf("got handlers %x, want %x", got, want)
+		}
+	}
+	if len(d.loads) != 0 {
+		t.Errorf("got %d pending loads, want none", len(d.loads))
+	}
+}
+
+func TestLookupHandler(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			io.WriteString(w, name)
+		})
+	}
+	handlers := map[string]http.Handler{
+		"/":     named("root"),
+		"/open": named("open"),
+		"/p/":   named("p"),
+		"/p/x/": named("x"),
+	}
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/", "root"},
+		{"/open", "open"},
+		{"/open/", ""},
+		{"/p/", "p"},
+		{"/p/a/top", "p"},
+		{"/p/x/top", "x"},
+		{"/p/x/source/y", "x"},
+		{"/top", ""},
+	} {
+		got := ""
+		if h := lookupHandler(handlers, tc.path); h != nil {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+			got = w.Body.String()
+		}
+		if got != tc.want {
+			t.Errorf("lookupHandler(%q): got %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
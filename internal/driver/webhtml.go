@@ -58,6 +58,9 @@ func addTemplates(templates *template.Template) {
 	def("top", loadFile("html/top.html"))
 	def("sourcelisting", loadFile("html/source.html"))
 	def("plaintext", loadFile("html/plaintext.html"))
+	def("report", loadFile("html/report.html"))
+	def("profiles", loadFile("html/profiles.html"))
+	def("upload", loadFile("html/upload.html"))
 	// TODO: Rename "stacks" to "flamegraph" to seal moving off d3 flamegraph.
 	def("stacks", loadFile("html/stacks.html"))
 	def("stacks_css", loadCSS("html/stacks.css"))
@@ -0,0 +1,306 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// sharedIDRE matches the IDs of the shared views and of their profiles.
+var sharedIDRE = regexp.MustCompile(`^[[:xdigit:]]{24}$`)
+
+// sharedViewNames are the views that can be shared. The graph is the
+// empty name.
+var sharedViewNames = map[string]bool{
+	"": true, "top": true, "disasm": true, "source": true, "peek": true,
+	"flamegraph": true, "icicle": true, "sunburst": true, "treemap": true,
+}
+
+// sharedView is the record of a shared view. The view itself is part of
+// the link, so the record only holds what the view is of.
+type sharedView struct {
+	Profile string `json:"profile"` // ID of the profile.
+	Config  string `json:"config"`  // Configuration, as URL parameters.
+}
+
+// webShares creates links to snapshots of the views of a web UI, and serves
+// the snapshots below share/ID/. The snapshots are stored by content in the
+// directory returned by shareDir, so the links can be opened by any pprof
+// serving that directory, such as an instance of pprof -serve_dir.
+type webShares struct {
+	ui      *webInterface // The UI of the current profile, nil to only serve.
+	options *plugin.Options
+	help    map[string]string
+	home    string // Links back to the index, if not relative.
+
+	mu    sync.Mutex
+	views map[string]*dirView // By ID.
+}
+
+func newWebShares(ui *webInterface, o *plugin.Options, help map[string]string, home string) *webShares {
+	return &webShares{ui: ui, options: o, help: help, home: home, views: make(map[string]*dirView)}
+}
+
+func (s *webShares) handlers() map[string]http.Handler {
+	handlers := map[string]http.Handler{
+		"/share/": http.HandlerFunc(s.view),
+	}
+	if s.ui != nil {
+		handlers["/share"] = http.HandlerFunc(s.share)
+	}
+	return handlers
+}
+
+// share snapshots the current profile with the configuration given by the
+// URL parameters in the "query" parameter, and responds with a JSON object
+// holding the link to the snapshot of the view named by the "view"
+// parameter. The link is relative to the share page unless $PPROF_SHARE_URL
+// gives the URL of the server of the snapshots.
+func (s *webShares) share(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "share requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ui.uploaded {
+		// Keep the uploads out of the store, which they would outlive.
+		http.Error(w, "uploaded profiles cannot be shared", http.StatusForbidden)
+		return
+	}
+	view := req.FormValue("view")
+	if !sharedViewNames[view] {
+		view = ""
+	}
+	params, err := url.ParseQuery(req.FormValue("query"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := currentConfig()
+	if s.ui.config != nil {
+		cfg = *s.ui.config
+	}
+	if err := cfg.applyURL(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dir, err := shareDir(s.options.UI)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.options.UI.PrintErr(err)
+		return
+	}
+	id, err := storeSharedView(dir, s.ui.prof, cfg)
+	if err != nil {
+		http.Error(w, "Could not share view: "+err.Error(), http.StatusInternalServerError)
+		s.options.UI.PrintErr("Could not share view: ", err)
+		return
+	}
+	link := "share/" + id + "/" + view
+	if base := os.Getenv("PPROF_SHARE_URL"); base != "" {
+		link = strings.TrimSuffix(base, "/") + "/" + link
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		URL string `json:"url"`
+	}{link})
+}
+
+// view serves the snapshots of the shared views, at share/ID/VIEW.
+func (s *webShares) view(w http.ResponseWriter, req *http.Request) {
+	id, view, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/share/"), "/")
+	if !ok || !sharedIDRE.MatchString(id) {
+		http.NotFound(w, req)
+		return
+	}
+	handlers, err := s.snapshot(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		s.options.UI.PrintErr(err)
+		return
+	}
+	serveSubtree(w, req, handlers, "/"+view)
+}
+
+// snapshot returns the handlers of the views of a shared snapshot, loading
+// it on first use. The snapshots never change.
+func (s *webShares) snapshot(id string) (map[string]http.Handler, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v := s.views[id]; v != nil {
+		v.used = time.Now()
+		return v.handlers, nil
+	}
+
+	dir, err := shareDir(s.options.UI)
+	if err != nil {
+		return nil, err
+	}
+	p, cfg, err := loadSharedView(dir, id)
+	if err != nil {
+		return nil, err
+	}
+	ui, err := makeWebInterface(p, makeProfileCopier(p), s.options)
+	if err != nil {
+		return nil, err
+	}
+	ui.help = s.help
+	ui.config = &cfg
+	ui.shared = true
+	ui.index = s.home
+	if ui.index == "" {
+		ui.index = "../../"
+	}
+
+	if len(s.views) >= maxDirViews {
+		evictView(s.views)
+	}
+	v := &dirView{handlers: ui.handlers(), used: time.Now()}
+	s.views[id] = v
+	return v.handlers, nil
+}
+
+// shareDir returns the directory of the shared views: $PPROF_SHARE_DIR, or
+// the shared subdirectory of the directory of the saved profiles.
+func shareDir(ui plugin.UI) (string, error) {
+	dir := os.Getenv("PPROF_SHARE_DIR")
+	if dir == "" {
+		tmpDir, err := setTmpDir(ui)
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(tmpDir, "shared")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// storeSharedView stores profile p, and the record of a view of it with
+// configuration cfg, in dir. It returns the ID of the record. Both are
+// named after their contents, so sharing the same view twice gives the same
+// link, and the views of a profile share its file.
+func storeSharedView(dir string, p *profile.Profile, cfg config) (string, error) {
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		return "", err
+	}
+	pid := contentID(buf.Bytes())
+	if err := writeSharedFile(filepath.Join(dir, pid+".pb.gz"), buf.Bytes()); err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	for _, f := range configFields {
+		if v := cfg.get(f); f.urlparam != "" && v != f.defaultValue {
+			params.Set(f.urlparam, v)
+		}
+	}
+	rec, err := json.Marshal(sharedView{Profile: pid, Config: params.Encode()})
+	if err != nil {
+		return "", err
+	}
+	id := contentID(rec)
+	if err := writeSharedFile(filepath.Join(dir, id+".json"), rec); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// loadSharedView returns the profile and the configuration of the shared
+// view with the given ID.
+func loadSharedView(dir, id string) (*profile.Profile, config, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, cfg, fmt.Errorf("unknown shared view %s", id)
+		}
+		return nil, cfg, err
+	}
+	var rec sharedView
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, cfg, fmt.Errorf("shared view %s: %v", id, err)
+	}
+	if !sharedIDRE.MatchString(rec.Profile) {
+		return nil, cfg, fmt.Errorf("shared view %s: invalid profile %q", id, rec.Profile)
+	}
+	params, err := url.ParseQuery(rec.Config)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("shared view %s: %v", id, err)
+	}
+	if err := cfg.applyURL(params); err != nil {
+		return nil, cfg, fmt.Errorf("shared view %s: %v", id, err)
+	}
+	f, err := os.Open(filepath.Join(dir, rec.Profile+".pb.gz"))
+	if err != nil {
+		return nil, cfg, err
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("shared view %s: %v", id, err)
+	}
+	return p, cfg, nil
+}
+
+// contentID returns the ID of data in the store of shared views.
+func contentID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:12])
+}
+
+// writeSharedFile writes a file of the store of shared views, unless it
+// already exists. Being named after its contents, it is then up to date.
+func writeSharedFile(name string, data []byte) error {
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+	// Write to a temporary file first, so that readers never see a partial
+	// file.
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/transport"
+)
+
+// serveTestHandlers serves handlers the way defaultWebServer does.
+func serveTestHandlers(t *testing.T, handlers map[string]http.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if h := lookupHandler(handlers, req.URL.Path); h != nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		http.NotFound(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// topItems returns the items of the top table in a page of the top view.
+func topItems(t *testing.T, pageURL string) string {
+	t.Helper()
+	resp, err := http.Get(pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: got status %d: %s", pageURL, resp.StatusCode, data)
+	}
+	m := regexp.MustCompile(`makeTopTable\((.*)\);`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("GET %s: no top table in %s", pageURL, data)
+	}
+	return string(m[1])
+}
+
+func TestShareView(t *testing.T) {
+	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
+		t.Skip("test assumes tcp available")
+	}
+	shareDir := t.TempDir()
+	t.Setenv("PPROF_SHARE_DIR", shareDir)
+	t.Setenv("PPROF_SHARE_URL", "")
+
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t, AllowRx: "unknown shared view"},
+		HTTPTransport: transport.New(nil),
+	})
+	p := makeFakeProfile()
+	ui, err := makeWebInterface(p, makeProfileCopier(p), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui.help = webHelp()
+	server := serveTestHandlers(t, ui.handlers())
+
+	share := func(view, query string) string {
+		t.Helper()
+		resp, err := http.PostForm(server.URL+"/share", url.Values{"view": {view}, "query": {query}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("share %s?%s: got status %d", view, query, resp.StatusCode)
+		}
+		var result struct{ URL string }
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result.URL
+	}
+
+	const query = "f=F2&sort=cum&nodecount=2"
+	link := share("top", query)
+	if !regexp.MustCompile(`^share/[[:xdigit:]]{24}/top$`).MatchString(link) {
+		t.Fatalf("got link %q, want share/ID/top", link)
+	}
+	if again := share("top", query); again != link {
+		t.Errorf("sharing the same view twice: got %q and %q", link, again)
+	}
+	if other := share("top", ""); other == link {
+		t.Errorf("sharing different views: got the same link %q", link)
+	}
+	if profiles, _ := filepath.Glob(filepath.Join(shareDir, "*.pb.gz")); len(profiles) != 1 {
+		t.Errorf("got %d profiles in the store, want 1", len(profiles))
+	}
+
+	// The snapshot shows the view as configured when it was shared.
+	want := topItems(t, server.URL+"/top?"+query)
+	if got := topItems(t, server.URL+"/"+link); got != want {
+		t.Errorf("snapshot in the same server: got top %s, want %s", got, want)
+	}
+	if unfiltered := topItems(t, server.URL+"/top"); unfiltered == want {
+		t.Errorf("the sharing query does not change the view")
+	}
+
+	// The snapshots do not serve shares themselves.
+	client := &http.Client{Timeout: 10 * time.Second}
+	nested := server.URL + "/" + strings.TrimSuffix(link, "top") + "share/0123456789abcdef01234567/top"
+	resp, err := client.Get(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("share below a snapshot: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	// Another server, such as pprof -serve_dir, opens the snapshot.
+	d, err := newProfileDir(&source{ServeDir: t.TempDir()}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirServer := serveTestHandlers(t, d.handlers())
+	if got := topItems(t, dirServer.URL+"/"+link); got != want {
+		t.Errorf("snapshot in another server: got top %s, want %s", got, want)
+	}
+	resp, err = http.Get(dirServer.URL + "/share/0123456789abcdef01234567/top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown snapshot: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	t.Setenv("PPROF_SHARE_URL", "https://pprof.example.com/ui/")
+	if got, want := share("", query), "https://pprof.example.com/ui/"+strings.TrimSuffix(link, "top"); got != want {
+		t.Errorf("with $PPROF_SHARE_URL: got link %q, want %q", got, want)
+	}
+}
+
+func TestShareFromProfileDir(t *testing.T) {
+	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
+		t.Skip("test assumes tcp available")
+	}
+	t.Setenv("PPROF_SHARE_DIR", t.TempDir())
+	t.Setenv("PPROF_SHARE_URL", "")
+
+	dir := t.TempDir()
+	writeDirProfile(t, filepath.Join(dir, "a.pb.gz"), 1, time.Now(), "profile")
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: transport.New(nil),
+	})
+	d, err := newProfileDir(&source{ServeDir: dir}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := serveTestHandlers(t, d.handlers())
+
+	const query = "f=F2&sort=cum"
+	views := server.URL + "/p/" + profileID("a.pb.gz") + "/"
+	resp, err := http.PostForm(views+"share", url.Values{"view": {"top"}, "query": {query}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("share: got status %d", resp.StatusCode)
+	}
+	var result struct{ URL string }
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	// The link is relative to the share page, as resolved by the browser.
+	link, err := resp.Request.URL.Parse(result.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := topItems(t, views+"top?"+query)
+	if got := topItems(t, link.String()); got != want {
+		t.Errorf("snapshot shared from a directory view: got top %s, want %s", got, want)
+	}
+}
+
+func TestShareRenderFunc(t *testing.T) {
+	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
+		t.Skip("test assumes tcp available")
+	}
+	t.Setenv("PPROF_SHARE_DIR", t.TempDir())
+	t.Setenv("PPROF_SHARE_URL", "")
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: transport.New(nil),
+	})
+	render, err := shareRenderFunc(makeFakeProfile(), o, UdfRenderData{
+		Graphurl: "/pprof/graph",
+		Shareurl: "/pprof/share",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pprof/share", render)
+	mux.HandleFunc("/pprof/share/", render)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/pprof/share", url.Values{"view": {"top"}, "query": {"h=F1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct{ URL string }
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shareURL, _ := url.Parse(server.URL + "/pprof/share")
+	link, err := shareURL.Parse(result.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link.Path, "/pprof/share/") {
+		t.Fatalf("got link %s, want a link below /pprof/share/", link)
+	}
+	if got := topItems(t, link.String()); !strings.Contains(got, `"F3"`) || strings.Contains(got, `"F1"`) {
+		t.Errorf("got top %s, want F1 hidden", got)
+	}
+}
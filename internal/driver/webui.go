@@ -41,6 +41,25 @@ type webInterface struct {
 	help         map[string]string
 	templates    *template.Template
 	settingsFile string
+
+	// static maps view names to the links used to navigate between the
+	// pages of a static HTML report. It is nil when serving the web UI.
+	static map[string]string
+	// config, if set, is used instead of the current configuration as
+	// the base for the configuration of every report.
+	config *config
+	// index, if set, links the pages back to the index of the profiles
+	// served with -serve_dir.
+	index string
+	// upload enables the upload of profiles, as set by -http_upload.
+	upload bool
+	// shared is set for the views of a shared snapshot, which do not serve
+	// shares of their own.
+	shared bool
+	// uploaded is set for the views of an uploaded profile. The file names
+	// of the profile come from the client, so the views looking up sources
+	// or binaries on the server are disabled.
+	uploaded bool
 }
 
 func makeWebInterface(p *profile.Profile, copier profileCopier, opt *plugin.Options) (*webInterface, error) {
@@ -89,69 +108,140 @@ type webArgs struct {
 	Top         []report.TextItem
 	FlameGraph  template.JS
 	Stacks      template.JS
+	View        string
 	Configs     []configMenuEntry
+	Static      map[string]string
+	Index       string
+	Upload      string
+	Share       string
+	NoSource    bool
 }
 
-func serveWebInterface(hostport string, p *profile.Profile, o *plugin.Options, disableBrowser bool) error {
-	host, port, err := getHostAndPort(hostport)
-	if err != nil {
-		return err
-	}
+// serveWebInterface serves the web UI for a profile as set up by the HTTP
+// options of src.
+func serveWebInterface(src *source, p *profile.Profile, o *plugin.Options) error {
 	interactiveMode = true
 	copier := makeProfileCopier(p)
 	ui, err := makeWebInterface(p, copier, o)
 	if err != nil {
 		return err
 	}
-	for n, c := range pprofCommands {
-		ui.help[n] = c.description
+	ui.help = webHelp()
+	ui.upload = src.HTTPUpload
+	return serveHandlers(src, ui.handlers(), o)
+}
+
+// handlers returns the handlers of the views of the web UI, by path.
+func (ui *webInterface) handlers() map[string]http.Handler {
+	handlers := map[string]http.Handler{
+		"/":              http.HandlerFunc(ui.dot),
+		"/top":           http.HandlerFunc(ui.top),
+		"/disasm":        http.HandlerFunc(ui.disasm),
+		"/source":        http.HandlerFunc(ui.source),
+		"/peek":          http.HandlerFunc(ui.peek),
+		"/flamegraph":    ui.stackView("flamegraph"),
+		"/icicle":        ui.stackView("icicle"),
+		"/sunburst":      ui.stackView("sunburst"),
+		"/treemap":       ui.stackView("treemap"),
+		"/flamegraph2":   redirectWithQuery("flamegraph", http.StatusMovedPermanently), // Keep legacy URL working.
+		"/flamegraphold": redirectWithQuery("flamegraph", http.StatusMovedPermanently), // Keep legacy URL working.
+		"/saveconfig":    http.HandlerFunc(ui.saveConfig),
+		"/deleteconfig":  http.HandlerFunc(ui.deleteConfig),
+		"/download": http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.google.protobuf+gzip")
+			w.Header().Set("Content-Disposition", "attachment;filename=profile.pb.gz")
+			ui.prof.Write(w)
+		}),
+	}
+	if ui.upload {
+		for path, h := range newWebUploads(ui, "").handlers() {
+			handlers[path] = h
+		}
+	}
+	if ui.uploaded {
+		for _, path := range []string{"/disasm", "/source", "/peek"} {
+			handlers[path] = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				http.Error(w, "not available for uploaded profiles", http.StatusForbidden)
+			})
+		}
+	}
+	if !ui.shared {
+		for path, h := range newWebShares(ui, ui.options, ui.help, "").handlers() {
+			handlers[path] = h
+		}
 	}
-	for n, help := range configHelp {
-		ui.help[n] = help
+	return handlers
+}
+
+// serveHandlers serves the handlers of the web UI as set up by the HTTP
+// options of src. The authentication and client restrictions apply to all
+// the handlers, whatever the server.
+func serveHandlers(src *source, handlers map[string]http.Handler, o *plugin.Options) error {
+	host, port, err := getHostAndPort(src.HTTPHostport)
+	if err != nil {
+		return err
+	}
+	access, err := parseWebAccess(src.HTTPAuth, src.HTTPAllow)
+	if err != nil {
+		return err
 	}
-	ui.help["details"] = "Show information about the profile and this view"
-	ui.help["graph"] = "Display profile as a directed graph"
-	ui.help["flamegraph"] = "Display profile as a flame graph"
-	ui.help["reset"] = "Show the entire profile"
-	ui.help["save_config"] = "Save current settings"
 
 	server := o.HTTPServer
 	if server == nil {
-		server = defaultWebServer
+		// The paths the handlers do not serve fall back to
+		// http.DefaultServeMux, under the same restrictions.
+		fallback := access.handler(http.DefaultServeMux)
+		server = func(args *plugin.HTTPServerArgs) error {
+			return defaultWebServer(args, fallback)
+		}
 	}
 	args := &plugin.HTTPServerArgs{
-		Hostport: net.JoinHostPort(host, strconv.Itoa(port)),
-		Host:     host,
-		Port:     port,
-		Handlers: map[string]http.Handler{
-			"/":              http.HandlerFunc(ui.dot),
-			"/top":           http.HandlerFunc(ui.top),
-			"/disasm":        http.HandlerFunc(ui.disasm),
-			"/source":        http.HandlerFunc(ui.source),
-			"/peek":          http.HandlerFunc(ui.peek),
-			"/flamegraph":    http.HandlerFunc(ui.stackView),
-			"/flamegraph2":   redirectWithQuery("flamegraph", http.StatusMovedPermanently), // Keep legacy URL working.
-			"/flamegraphold": redirectWithQuery("flamegraph", http.StatusMovedPermanently), // Keep legacy URL working.
-			"/saveconfig":    http.HandlerFunc(ui.saveConfig),
-			"/deleteconfig":  http.HandlerFunc(ui.deleteConfig),
-			"/download": http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-				w.Header().Set("Content-Type", "application/vnd.google.protobuf+gzip")
-				w.Header().Set("Content-Disposition", "attachment;filename=profile.pb.gz")
-				p.Write(w)
-			}),
-		},
+		Hostport:    net.JoinHostPort(host, strconv.Itoa(port)),
+		Host:        host,
+		Port:        port,
+		Handlers:    make(map[string]http.Handler, len(handlers)),
+		TLSCertFile: src.HTTPTLSCert,
+		TLSKeyFile:  src.HTTPTLSKey,
+	}
+	for path, h := range handlers {
+		args.Handlers[path] = access.handler(h)
 	}
 
 	url := "http://" + args.Hostport
+	if args.TLSCertFile != "" {
+		url = "https://" + args.Hostport
+	}
 
 	o.UI.Print("Serving web UI on ", url)
 
-	if o.UI.WantBrowser() && !disableBrowser {
+	if o.UI.WantBrowser() && !src.HTTPDisableBrowser {
 		go openBrowser(url, o)
 	}
 	return server(args)
 }
 
+// webHelp returns the help text shown for the elements of the web UI.
+func webHelp() map[string]string {
+	help := make(map[string]string)
+	for n, c := range pprofCommands {
+		help[n] = c.description
+	}
+	for n, h := range configHelp {
+		help[n] = h
+	}
+	help["details"] = "Show information about the profile and this view"
+	help["graph"] = "Display profile as a directed graph"
+	help["flamegraph"] = "Display profile as a flame graph"
+	help["icicle"] = "Display the calls made by functions as a top-down icicle chart"
+	help["sunburst"] = "Display profile as a sunburst chart"
+	help["treemap"] = "Display self cost grouped by package, file and function"
+	help["reset"] = "Show the entire profile"
+	help["save_config"] = "Save current settings"
+	help["upload"] = "Upload a profile to view, or to compare this profile with"
+	help["share"] = "Get a lasting link to a snapshot of this view"
+	return help
+}
+
 func getHostAndPort(hostport string) (string, int, error) {
 	host, portStr, err := net.SplitHostPort(hostport)
 	if err != nil {
@@ -179,7 +269,10 @@ func getHostAndPort(hostport string) (string, int, error) {
 	}
 	return host, port, nil
 }
-func defaultWebServer(args *plugin.HTTPServerArgs) error {
+
+// defaultWebServer serves the handlers of args, and fallback at the other
+// paths.
+func defaultWebServer(args *plugin.HTTPServerArgs, fallback http.Handler) error {
 	ln, err := net.Listen("tcp", args.Hostport)
 	if err != nil {
 		return err
@@ -194,10 +287,9 @@ func defaultWebServer(args *plugin.HTTPServerArgs) error {
 				return
 			}
 		}
-		h := args.Handlers[req.URL.Path]
+		h := lookupHandler(args.Handlers, req.URL.Path)
 		if h == nil {
-			// Fall back to default behavior
-			h = http.DefaultServeMux
+			h = fallback
 		}
 		h.ServeHTTP(w, req)
 	})
@@ -210,9 +302,42 @@ func defaultWebServer(args *plugin.HTTPServerArgs) error {
 	mux.Handle("/ui/", http.StripPrefix("/ui", handler))
 	mux.Handle("/", redirectWithQuery("/ui", http.StatusTemporaryRedirect))
 	s := &http.Server{Handler: mux}
+	if args.TLSCertFile != "" {
+		return s.ServeTLS(ln, args.TLSCertFile, args.TLSKeyFile)
+	}
 	return s.Serve(ln)
 }
 
+// lookupHandler returns the handler for a path, nil if there is none. A
+// path ending in a slash, other than the root, also matches the paths below
+// it, the longest one first, as for http.ServeMux.
+func lookupHandler(handlers map[string]http.Handler, path string) http.Handler {
+	if h := handlers[path]; h != nil {
+		return h
+	}
+	for i := strings.LastIndex(path, "/"); i > 0; i = strings.LastIndex(path[:i], "/") {
+		if h := handlers[path[:i+1]]; h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
+// serveSubtree serves req with the handler of path among handlers, the
+// handlers of a UI nested below the path of req. The handler gets a copy of
+// req with path as its path, so that the UIs nested in it, and the links
+// relative to it, resolve as at the top level.
+func serveSubtree(w http.ResponseWriter, req *http.Request, handlers map[string]http.Handler, path string) {
+	h := lookupHandler(handlers, path)
+	if h == nil {
+		http.NotFound(w, req)
+		return
+	}
+	r := req.Clone(req.Context())
+	r.URL.Path, r.URL.RawPath = path, ""
+	h.ServeHTTP(w, r)
+}
+
 // redirectWithQuery responds with a given redirect code, preserving query
 // parameters in the redirect URL. It does not convert relative paths to
 // absolute paths like http.Redirect does, so that HTTPServerArgs.Handlers can
@@ -263,6 +388,9 @@ func openBrowser(url string, o *plugin.Options) {
 func (ui *webInterface) makeReport(w http.ResponseWriter, req *http.Request,
 	cmd []string, configEditor func(*config)) (*report.Report, []string) {
 	cfg := currentConfig()
+	if ui.config != nil {
+		cfg = *ui.config
+	}
 	if err := cfg.applyURL(req.URL.Query()); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		ui.options.UI.PrintErr(err)
@@ -295,6 +423,17 @@ func (ui *webInterface) render(w http.ResponseWriter, req *http.Request, tmpl st
 	data.Legend = legend
 	data.Help = ui.help
 	data.Configs = configMenu(ui.settingsFile, *req.URL)
+	data.Static = ui.static
+	data.Index = ui.index
+	if ui.static == nil {
+		if ui.upload {
+			data.Upload = "./upload"
+		}
+		if !ui.shared && !ui.uploaded {
+			data.Share = "./share"
+		}
+	}
+	data.NoSource = ui.uploaded
 
 	html := &bytes.Buffer{}
 	if err := ui.templates.ExecuteTemplate(html, tmpl, data); err != nil {
@@ -317,11 +456,9 @@ func (ui *webInterface) dot(w http.ResponseWriter, req *http.Request) {
 	g, config := report.GetDOT(rpt)
 	legend := config.Labels
 	config.Labels = nil
-	dot := &bytes.Buffer{}
-	graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
 
-	// Convert to svg.
-	svg, err := dotToSvg(dot.Bytes())
+	// Lay out the graph as svg.
+	svg, err := graphToSvg(g, config)
 	if err != nil {
 		http.Error(w, "Could not execute dot; may need to install graphviz.",
 			http.StatusNotImplemented)
@@ -341,12 +478,22 @@ func (ui *webInterface) dot(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
-func dotToSvg(dot []byte) ([]byte, error) {
-	cmd := exec.Command("dot", "-Tsvg")
+// graphToSvg lays out a graph with dot, or with the builtin layout when
+// dot is not used, and returns the svg to embed in a page.
+func graphToSvg(g *graph.Graph, config *graph.DotConfig) ([]byte, error) {
 	out := &bytes.Buffer{}
-	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewBuffer(dot), out, os.Stderr
-	if err := cmd.Run(); err != nil {
-		return nil, err
+	if useBuiltinLayout() {
+		if err := graph.ComposeSVG(out, g, &graph.DotAttributes{}, config); err != nil {
+			return nil, err
+		}
+	} else {
+		dot := &bytes.Buffer{}
+		graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
+		cmd := exec.Command("dot", "-Tsvg")
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = dot, out, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, err
+		}
 	}
 
 	// Fix dot bug related to unquoted ampersands.
@@ -21,7 +21,6 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"os/exec"
 	"regexp"
 	"runtime"
 	"sync"
@@ -52,11 +51,11 @@ func makeTestServer(t testing.TB, prof *profile.Profile) *httptest.Server {
 	}
 
 	// Start server and wait for it to be initialized
-	go serveWebInterface("unused:1234", prof, &plugin.Options{
+	go serveWebInterface(&source{HTTPHostport: "unused:1234"}, prof, &plugin.Options{
 		Obj:        fakeObjTool{},
 		UI:         &proftest.TestUI{T: t},
 		HTTPServer: creator,
-	}, false)
+	})
 	<-serverCreated
 
 	// Close the server when the test is done.
@@ -68,29 +67,23 @@ func makeTestServer(t testing.TB, prof *profile.Profile) *httptest.Server {
 func TestWebInterface(t *testing.T) {
 	prof := makeFakeProfile()
 	server := makeTestServer(t, prof)
-	haveDot := false
-	if _, err := exec.LookPath("dot"); err == nil {
-		haveDot = true
-	}
-
 	type testCase struct {
-		path    string
-		want    []string
-		needDot bool
+		path string
+		want []string
 	}
 	testcases := []testCase{
-		{"/", []string{"F1", "F2", "F3", "testbin", "cpu"}, true},
-		{"/top", []string{`"Name":"F2","InlineLabel":"","Flat":200,"Cum":300,"FlatFormat":"200ms","CumFormat":"300ms"}`}, false},
+		{"/", []string{"F1", "F2", "F3", "testbin", "cpu", `<g id="node1" class="node">`}},
+		{"/top", []string{`"Name":"F2","InlineLabel":"","Flat":200,"Cum":300,"FlatFormat":"200ms","CumFormat":"300ms"}`}},
 		{"/source?f=" + url.QueryEscape("F[12]"), []string{
 			"F1",
 			"F2",
 			`\. +300ms .*f1:asm`,    // Cumulative count for F1
 			"200ms +300ms .*f2:asm", // Flat + cumulative count for F2
-		}, false},
+		}},
 		{"/peek?f=" + url.QueryEscape("F[12]"),
-			[]string{"300ms.*F1", "200ms.*300ms.*F2"}, false},
+			[]string{"300ms.*F1", "200ms.*300ms.*F2"}},
 		{"/disasm?f=" + url.QueryEscape("F[12]"),
-			[]string{"f1:asm", "f2:asm"}, false},
+			[]string{"f1:asm", "f2:asm"}},
 		{"/flamegraph", []string{
 			"File: testbin",
 			// Check that interesting frames are included.
@@ -100,13 +93,12 @@ func TestWebInterface(t *testing.T) {
 			`function stackViewer`,
 			// Check new view CSS is included.
 			"#stack-chart {",
-		}, false},
+		}},
+		{"/icicle", []string{`\bF2\b`, `stackViewer\(.*, "icicle"\);`}},
+		{"/sunburst", []string{`\bF2\b`, `stackViewer\(.*, "sunburst"\);`}},
+		{"/treemap", []string{`"Package":""`, `stackViewer\(.*, "treemap"\);`}},
 	}
 	for _, c := range testcases {
-		if c.needDot && !haveDot {
-			t.Log("skipping", c.path, "since dot (graphviz) does not seem to be installed")
-			continue
-		}
 		res, err := http.Get(server.URL + c.path)
 		if err != nil {
 			t.Error("could not fetch", c.path, err)
@@ -131,9 +123,6 @@ func TestWebInterface(t *testing.T) {
 	// safety when run under the race detector.
 	var wg sync.WaitGroup
 	for _, c := range testcases {
-		if c.needDot && !haveDot {
-			continue
-		}
 		path := server.URL + c.path
 		for count := 0; count < 2; count++ {
 			wg.Add(1)
@@ -302,16 +291,11 @@ func TestIsLocalHost(t *testing.T) {
 	}
 }
 
-func BenchmarkTop(b *testing.B)   { benchmarkURL(b, "/top", false) }
-func BenchmarkFlame(b *testing.B) { benchmarkURL(b, "/flamegraph", false) }
-func BenchmarkDot(b *testing.B)   { benchmarkURL(b, "/", true) }
+func BenchmarkTop(b *testing.B)   { benchmarkURL(b, "/top") }
+func BenchmarkFlame(b *testing.B) { benchmarkURL(b, "/flamegraph") }
+func BenchmarkDot(b *testing.B)   { benchmarkURL(b, "/") }
 
-func benchmarkURL(b *testing.B, path string, needDot bool) {
-	if needDot {
-		if _, err := exec.LookPath("dot"); err != nil {
-			b.Skip("dot not available")
-		}
-	}
+func benchmarkURL(b *testing.B, path string) {
 	prof := largeProfile(b)
 	server := makeTestServer(b, prof)
 	url := server.URL + path
@@ -0,0 +1,290 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+const (
+	// maxUploadSize is the maximum size of an upload, and
+	// maxUploadProfileSize the maximum size of the uploaded profile once
+	// decompressed.
+	maxUploadSize        = 64 << 20
+	maxUploadProfileSize = 512 << 20
+
+	// maxUploads is the maximum number of uploaded profiles kept on disk.
+	// The oldest are deleted first.
+	maxUploads = 32
+)
+
+// uploadSelectionRE matches the selections of uploaded profiles in the paths
+// of their views: the ID of an upload, to view it, or "diff-" and the ID of
+// an upload, to view the current profile minus the upload.
+var uploadSelectionRE = regexp.MustCompile(`^(diff-)?[[:xdigit:]]{16}$`)
+
+// The uploaded profiles, stored in temporary files that are deleted on exit.
+var (
+	uploadFiles   = make(map[string]string) // By ID.
+	uploadOrder   []string                  // IDs, the oldest first.
+	uploadFilesMu sync.Mutex
+)
+
+// webUploads serves the page uploading profiles to a web UI, and the views
+// of the uploaded profiles below upload/SELECTION/.
+type webUploads struct {
+	ui   *webInterface // The UI of the current profile.
+	home string        // Links back to the views of ui, if not relative.
+
+	mu    sync.Mutex
+	views map[string]*dirView // By selection.
+}
+
+func newWebUploads(ui *webInterface, home string) *webUploads {
+	return &webUploads{ui: ui, home: home, views: make(map[string]*dirView)}
+}
+
+func (u *webUploads) handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/upload":  http.HandlerFunc(u.upload),
+		"/upload/": http.HandlerFunc(u.view),
+	}
+}
+
+// upload serves the upload page, and stores the profiles posted to it. The
+// profile is either the body of the request or the "profile" field of a
+// multipart form. Once stored, the client is redirected to the views of the
+// profile or, if the "base" parameter is set, to the views of the current
+// profile compared to it.
+func (u *webUploads) upload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		home := u.home
+		if home == "" {
+			home = "./"
+		}
+		html := &bytes.Buffer{}
+		if err := u.ui.templates.ExecuteTemplate(html, "upload", struct {
+			Index       string
+			MaxSizeMB   int
+			SampleTypes []string // Used by the script template.
+		}{home, maxUploadSize >> 20, nil}); err != nil {
+			http.Error(w, "internal template error", http.StatusInternalServerError)
+			u.ui.options.UI.PrintErr(err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(html.Bytes())
+		return
+	}
+
+	id, err := saveUpload(w, req)
+	if err != nil {
+		http.Error(w, "Could not upload profile: "+err.Error(), http.StatusBadRequest)
+		u.ui.options.UI.PrintErr("Could not upload profile: ", err)
+		return
+	}
+	sel := id
+	if req.FormValue("base") != "" {
+		sel = "diff-" + id
+	}
+	// Use a relative URL to work in presence of stripping/redirects in webui.go.
+	w.Header().Set("Location", "upload/"+sel+"/")
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// view serves the views of an uploaded profile, at upload/SELECTION/VIEW.
+func (u *webUploads) view(w http.ResponseWriter, req *http.Request) {
+	sel, view, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/upload/"), "/")
+	if !ok || !uploadSelectionRE.MatchString(sel) {
+		http.NotFound(w, req)
+		return
+	}
+	handlers, err := u.selection(sel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		u.ui.options.UI.PrintErr(err)
+		return
+	}
+	serveSubtree(w, req, handlers, "/"+view)
+}
+
+// selection returns the handlers of the views of an uploaded profile,
+// building them on first use. They are built without holding u.mu, so that
+// a slow upload does not hold up the views of the others.
+func (u *webUploads) selection(sel string) (map[string]http.Handler, error) {
+	u.mu.Lock()
+	if v := u.views[sel]; v != nil {
+		v.used = time.Now()
+		u.mu.Unlock()
+		return v.handlers, nil
+	}
+	u.mu.Unlock()
+
+	handlers, err := u.load(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if v := u.views[sel]; v != nil {
+		// Built by a concurrent request meanwhile.
+		v.used = time.Now()
+		return v.handlers, nil
+	}
+	if len(u.views) >= maxDirViews {
+		evictView(u.views)
+	}
+	u.views[sel] = &dirView{handlers: handlers, used: time.Now()}
+	return handlers, nil
+}
+
+// load fetches the uploaded profile of a selection and returns the handlers
+// of its views.
+func (u *webUploads) load(sel string) (map[string]http.Handler, error) {
+	id := strings.TrimPrefix(sel, "diff-")
+	name, ok := uploadedProfile(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown uploaded profile %s", id)
+	}
+	// The mappings and file names of the profile come from the client:
+	// do not look them up on the server.
+	p, err := fetchProfiles(&source{Sources: []string{name}, Symbolize: "none"}, u.ui.options)
+	if err != nil {
+		return nil, err
+	}
+	if id != sel {
+		base := p
+		if p, err = diffProfiles(u.ui.copier.newCopy(), base); err != nil {
+			return nil, err
+		}
+	}
+	ui, err := makeWebInterface(p, makeProfileCopier(p), u.ui.options)
+	if err != nil {
+		return nil, err
+	}
+	ui.help = u.ui.help
+	ui.upload = u.ui.upload
+	ui.uploaded = true
+	ui.index = u.home
+	if ui.index == "" {
+		ui.index = "../../"
+	}
+	return ui.handlers(), nil
+}
+
+// diffProfiles returns profile p minus profile base, with the samples of
+// base labeled as -diff_base does.
+func diffProfiles(p, base *profile.Profile) (*profile.Profile, error) {
+	base.SetLabel("pprof::base", []string{"true"})
+	base.Scale(-1)
+	return profile.Merge([]*profile.Profile{p, base})
+}
+
+// saveUpload stores the profile uploaded by req in a temporary file and
+// returns its ID.
+func saveUpload(w http.ResponseWriter, req *http.Request) (string, error) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+	var body io.Reader = req.Body
+	if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		f, _, err := req.FormFile("profile")
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		body = f
+	}
+	p, err := readUpload(body)
+	if err != nil {
+		return "", err
+	}
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+	f, err := newTempFile(os.TempDir(), "pprof.upload.", ".pb.gz")
+	if err != nil {
+		return "", err
+	}
+	deferDeleteTempFile(f.Name())
+	if err := p.Write(f); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	uploadFilesMu.Lock()
+	defer uploadFilesMu.Unlock()
+	uploadFiles[id] = f.Name()
+	uploadOrder = append(uploadOrder, id)
+	for len(uploadOrder) > maxUploads {
+		os.Remove(uploadFiles[uploadOrder[0]])
+		delete(uploadFiles, uploadOrder[0])
+		uploadOrder = uploadOrder[1:]
+	}
+	return id, nil
+}
+
+// readUpload parses an uploaded profile, in any of the formats understood
+// by profile.ParseData, limiting its decompressed size.
+func readUpload(r io.Reader) (*profile.Profile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty profile")
+	}
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewBuffer(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(io.LimitReader(gz, maxUploadProfileSize+1)); err != nil {
+			return nil, err
+		}
+		if len(data) > maxUploadProfileSize {
+			return nil, fmt.Errorf("profile larger than %d MB once decompressed", maxUploadProfileSize>>20)
+		}
+	}
+	return profile.ParseData(data)
+}
+
+// uploadedProfile returns the file of the uploaded profile with the given ID.
+func uploadedProfile(id string) (string, bool) {
+	uploadFilesMu.Lock()
+	defer uploadFilesMu.Unlock()
+	name, ok := uploadFiles[id]
+	return name, ok
+}
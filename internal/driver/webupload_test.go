@@ -0,0 +1,356 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/transport"
+)
+
+// uploadTestServer serves handlers, with the options of the driver.
+func uploadTestServer(t *testing.T, handlers func(o *plugin.Options) http.Handler) *httptest.Server {
+	t.Helper()
+	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
+		t.Skip("test assumes tcp available")
+	}
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t, AllowRx: "Could not upload profile|unknown uploaded profile"},
+		HTTPTransport: transport.New(nil),
+	})
+	server := httptest.NewServer(handlers(o))
+	t.Cleanup(func() {
+		server.Close()
+		cleanupTempFiles()
+	})
+	return server
+}
+
+// uploadProfile posts a profile to the upload page at pageURL, as a form if
+// form is set, and returns the URL it redirects to.
+func uploadProfile(t *testing.T, pageURL string, data []byte, form, base bool) (string, int) {
+	t.Helper()
+	var body bytes.Buffer
+	contentType := "application/octet-stream"
+	target := pageURL
+	if form {
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile("profile", "profile.pb.gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+		if base {
+			mw.WriteField("base", "1")
+		}
+		mw.Close()
+		contentType = mw.FormDataContentType()
+	} else {
+		body.Write(data)
+		if base {
+			target += "?base=1"
+		}
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Post(target, contentType, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		return "", resp.StatusCode
+	}
+	loc, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc.String(), resp.StatusCode
+}
+
+// uploadedFlat returns the flat value of F2 in the top view below url.
+func uploadedFlat(t *testing.T, viewsURL string) string {
+	t.Helper()
+	resp, err := http.Get(viewsURL + "top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`"Name":"F2","InlineLabel":"","Flat":(-?\d+),`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("%stop: status %d, no F2 entry in %s", viewsURL, resp.StatusCode, data)
+	}
+	return string(m[1])
+}
+
+func fakeProfileData(t *testing.T, scale float64) []byte {
+	t.Helper()
+	p := makeFakeProfile()
+	p.Scale(scale)
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWebUpload(t *testing.T) {
+	server := uploadTestServer(t, func(o *plugin.Options) http.Handler {
+		p := makeFakeProfile()
+		ui, err := makeWebInterface(p, makeProfileCopier(p), o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ui.help = webHelp()
+		ui.upload = true
+		handlers := ui.handlers()
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if h := lookupHandler(handlers, req.URL.Path); h != nil {
+				h.ServeHTTP(w, req)
+				return
+			}
+			http.NotFound(w, req)
+		})
+	})
+
+	resp, err := http.Get(server.URL + "/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Contains(page, []byte(`name="profile"`)) || !bytes.Contains(page, []byte(`name="base"`)) {
+		t.Errorf("upload page: no upload form in %s", page)
+	}
+
+	data := fakeProfileData(t, 3)
+	for _, tc := range []struct {
+		desc       string
+		form, base bool
+		wantFlat   string
+	}{
+		{"form", true, false, "600"},
+		{"body", false, false, "600"},
+		{"form diff", true, true, "-400"},
+		{"body diff", false, true, "-400"},
+	} {
+		loc, code := uploadProfile(t, server.URL+"/upload", data, tc.form, tc.base)
+		if code != http.StatusSeeOther {
+			t.Errorf("%s: got status %d, want %d", tc.desc, code, http.StatusSeeOther)
+			continue
+		}
+		if wantPrefix := server.URL + "/upload/"; !strings.HasPrefix(loc, wantPrefix) {
+			t.Errorf("%s: redirected to %s, want a URL below %s", tc.desc, loc, wantPrefix)
+			continue
+		}
+		if got := uploadedFlat(t, loc); got != tc.wantFlat {
+			t.Errorf("%s: got flat %s for F2, want %s", tc.desc, got, tc.wantFlat)
+		}
+	}
+
+	// The views resolving the files of an uploaded profile on the server are
+	// disabled.
+	loc, _ := uploadProfile(t, server.URL+"/upload", data, true, false)
+	for _, view := range []string{"source?f=F2", "disasm?f=F2", "peek?f=F2"} {
+		resp, err := http.Get(loc + view)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("uploaded %s: got status %d, want %d", view, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+	resp, err = http.Get(loc + "top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, link := range []string{`href="./source"`, `href="./share"`} {
+		if bytes.Contains(page, []byte(link)) {
+			t.Errorf("uploaded top: unexpected %s", link)
+		}
+	}
+	// Nor are they shared, which would store them for good.
+	resp, err = http.PostForm(loc+"share", url.Values{"view": {"source"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("sharing an upload: got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a profile", []byte("\x00\x01\x02 not a profile")},
+	} {
+		if _, code := uploadProfile(t, server.URL+"/upload", tc.data, true, false); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", tc.desc, code, http.StatusBadRequest)
+		}
+	}
+
+	resp, err = http.Get(server.URL + "/upload/0123456789abcdef/top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown upload: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestWebUploadFromProfileDir(t *testing.T) {
+	dir := t.TempDir()
+	writeDirProfile(t, filepath.Join(dir, "a.pb.gz"), 1, time.Now(), "profile")
+	server := uploadTestServer(t, func(o *plugin.Options) http.Handler {
+		d, err := newProfileDir(&source{ServeDir: dir, HTTPUpload: true}, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handlers := d.handlers()
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if h := lookupHandler(handlers, req.URL.Path); h != nil {
+				h.ServeHTTP(w, req)
+				return
+			}
+			http.NotFound(w, req)
+		})
+	})
+
+	views := server.URL + "/p/" + profileID("a.pb.gz") + "/"
+	loc, code := uploadProfile(t, views+"upload", fakeProfileData(t, 3), true, true)
+	if code != http.StatusSeeOther {
+		t.Fatalf("got status %d, want %d", code, http.StatusSeeOther)
+	}
+	if !strings.HasPrefix(loc, views+"upload/") {
+		t.Errorf("redirected to %s, want a URL below %supload/", loc, views)
+	}
+	if got, want := uploadedFlat(t, loc), "-400"; got != want {
+		t.Errorf("got flat %s for F2, want %s", got, want)
+	}
+}
+
+func TestWebUploadDisabled(t *testing.T) {
+	server := uploadTestServer(t, func(o *plugin.Options) http.Handler {
+		p := makeFakeProfile()
+		ui, err := makeWebInterface(p, makeProfileCopier(p), o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ui.help = webHelp()
+		handlers := ui.handlers()
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if h := lookupHandler(handlers, req.URL.Path); h != nil {
+				h.ServeHTTP(w, req)
+				return
+			}
+			http.NotFound(w, req)
+		})
+	})
+
+	if _, code := uploadProfile(t, server.URL+"/upload", fakeProfileData(t, 1), true, false); code != http.StatusNotFound {
+		t.Errorf("upload: got status %d, want %d", code, http.StatusNotFound)
+	}
+	resp, err := http.Get(server.URL + "/top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if bytes.Contains(page, []byte(`href="./upload"`)) {
+		t.Error("top: unexpected link to the upload page")
+	}
+}
+
+func TestUploadRenderFunc(t *testing.T) {
+	server := uploadTestServer(t, func(o *plugin.Options) http.Handler {
+		render, err := uploadRenderFunc(makeFakeProfile(), o, UdfRenderData{
+			Graphurl:  "/pprof/graph",
+			Uploadurl: "/pprof/upload",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/pprof/upload", render)
+		mux.HandleFunc("/pprof/upload/", render)
+		return mux
+	})
+
+	loc, code := uploadProfile(t, server.URL+"/pprof/upload", fakeProfileData(t, 2), true, true)
+	if code != http.StatusSeeOther {
+		t.Fatalf("got status %d, want %d", code, http.StatusSeeOther)
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !uploadSelectionRE.MatchString(strings.Trim(strings.TrimPrefix(u.Path, "/pprof/upload/"), "/")) {
+		t.Fatalf("redirected to %s, want the views of an upload below /pprof/upload/", loc)
+	}
+	if got, want := uploadedFlat(t, loc), "-200"; got != want {
+		t.Errorf("got flat %s for F2, want %s", got, want)
+	}
+	resp, err := http.Get(loc + "top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Contains(page, []byte(`href="/pprof/graph"`)) {
+		t.Error("views of the upload do not link back to the current profile")
+	}
+}
+
+func TestReadUploadLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping decompression of a large profile in short mode")
+	}
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	zeros := make([]byte, 1<<20)
+	for i := 0; i <= maxUploadProfileSize>>20; i++ {
+		gz.Write(zeros)
+	}
+	gz.Close()
+	if _, err := readUpload(&buf); err == nil || !strings.Contains(err.Error(), "decompressed") {
+		t.Errorf("got error %v, want the decompressed size to be limited", err)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"io"
 	"math"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/google/pprof/internal/measurement"
@@ -55,12 +56,52 @@ const maxNodelets = 4 // Number of nodelets for labels (both numeric and non)
 // ComposeDot creates and writes a in the DOT format to the writer, using
 // the configurations given.
 func ComposeDot(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) {
-	builder := &builder{w, a, c}
+	builder := &builder{w, a, c, nil}
+	builder.compose(g)
+}
 
+// builder wraps an io.Writer and understands how to compose DOT formatted elements.
+type builder struct {
+	io.Writer
+	attributes *DotAttributes
+	config     *DotConfig
+	dot        *dotGraph // If not nil, the elements are also added to it.
+}
+
+// dotAttr is an attribute of a DOT element. The value keeps its DOT
+// escape sequences.
+type dotAttr struct {
+	key, value string
+	quoted     bool // Whether the value is written in double quotes.
+}
+
+func quotedAttr(key, value string) dotAttr {
+	return dotAttr{key, value, true}
+}
+
+func intAttr(key string, value int) dotAttr {
+	return dotAttr{key, strconv.Itoa(value), false}
+}
+
+// formatAttrs returns the attributes in the DOT syntax.
+func formatAttrs(attrs []dotAttr) string {
+	s := make([]string, len(attrs))
+	for i, a := range attrs {
+		if a.quoted {
+			s[i] = fmt.Sprintf(`%s="%s"`, a.key, a.value)
+		} else {
+			s[i] = a.key + "=" + a.value
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+// compose generates the DOT elements of a graph.
+func (b *builder) compose(g *Graph) {
 	// Begin constructing DOT by adding a title and legend.
-	builder.start()
-	defer builder.finish()
-	builder.addLegend()
+	b.start()
+	defer b.finish()
+	b.addLegend()
 
 	if len(g.Nodes) == 0 {
 		return
@@ -82,8 +123,8 @@ func ComposeDot(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) {
 
 	// Add nodes and nodelets to DOT builder.
 	for _, n := range g.Nodes {
-		builder.addNode(n, nodeIDMap[n], maxFlat)
-		hasNodelets[n] = builder.addNodelets(n, nodeIDMap[n])
+		b.addNode(n, nodeIDMap[n], maxFlat)
+		hasNodelets[n] = b.addNodelets(n, nodeIDMap[n])
 
 		// Collect all edges. Use a fake node to support multiple incoming edges.
 		for _, e := range n.Out {
@@ -93,25 +134,25 @@ func ComposeDot(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) {
 
 	// Add edges to DOT builder. Sort edges by frequency as a hint to the graph layout engine.
 	for _, e := range edges.Sort() {
-		builder.addEdge(e, nodeIDMap[e.Src], nodeIDMap[e.Dest], hasNodelets[e.Src])
+		b.addEdge(e, nodeIDMap[e.Src], nodeIDMap[e.Dest], hasNodelets[e.Src])
 	}
 }
 
-// builder wraps an io.Writer and understands how to compose DOT formatted elements.
-type builder struct {
-	io.Writer
-	attributes *DotAttributes
-	config     *DotConfig
-}
-
 // start generates a title and initial node in DOT format.
 func (b *builder) start() {
 	graphname := "unnamed"
 	if b.config.Title != "" {
 		graphname = b.config.Title
 	}
+	defaults := []dotAttr{{"style", "filled", false}, quotedAttr("fillcolor", "#f8f8f8")}
 	fmt.Fprintln(b, `digraph "`+graphname+`" {`)
-	fmt.Fprintln(b, `node [style=filled fillcolor="#f8f8f8"]`)
+	fmt.Fprintln(b, "node ["+formatAttrs(defaults)+"]")
+	if b.dot != nil {
+		b.dot.name = graphname
+		for _, a := range defaults {
+			b.dot.nodeDefaults[a.key] = a.value
+		}
+	}
 }
 
 // finish closes the opening curly bracket in the constructed DOT buffer.
@@ -119,22 +160,44 @@ func (b *builder) finish() {
 	fmt.Fprintln(b, "}")
 }
 
+// node generates a node statement in DOT format.
+func (b *builder) node(name string, attrs []dotAttr) {
+	fmt.Fprintf(b, "%s [%s]\n", name, formatAttrs(attrs))
+	if b.dot != nil {
+		b.dot.addNode(name, attrs)
+	}
+}
+
+// edge generates an edge statement in DOT format.
+func (b *builder) edge(from, to string, attrs []dotAttr) {
+	fmt.Fprintf(b, "%s -> %s [%s]\n", from, to, formatAttrs(attrs))
+	if b.dot != nil {
+		b.dot.addEdge(from, to, attrs)
+	}
+}
+
 // addLegend generates a legend in DOT format.
 func (b *builder) addLegend() {
 	labels := b.config.Labels
 	if len(labels) == 0 {
 		return
 	}
-	title := labels[0]
-	fmt.Fprintf(b, `subgraph cluster_L { "%s" [shape=box fontsize=16`, escapeForDot(title))
-	fmt.Fprintf(b, ` label="%s\l"`, strings.Join(escapeAllForDot(labels), `\l`))
+	title := escapeForDot(labels[0])
+	attrs := []dotAttr{
+		{"shape", "box", false},
+		intAttr("fontsize", 16),
+		quotedAttr("label", strings.Join(escapeAllForDot(labels), `\l`)+`\l`),
+	}
 	if b.config.LegendURL != "" {
-		fmt.Fprintf(b, ` URL="%s" target="_blank"`, b.config.LegendURL)
+		attrs = append(attrs, quotedAttr("URL", b.config.LegendURL), quotedAttr("target", "_blank"))
 	}
 	if b.config.Title != "" {
-		fmt.Fprintf(b, ` tooltip="%s"`, b.config.Title)
+		attrs = append(attrs, quotedAttr("tooltip", b.config.Title))
+	}
+	fmt.Fprintf(b, "subgraph cluster_L { \"%s\" [%s] }\n", title, formatAttrs(attrs))
+	if b.dot != nil {
+		b.dot.addNode(unescapeDot(title), attrs)
 	}
-	fmt.Fprintf(b, "] }\n")
 }
 
 // addNode generates a graph node in DOT format.
@@ -186,35 +249,40 @@ func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 	}
 
 	// Create DOT attribute for node.
-	attr := fmt.Sprintf(`label="%s" id="node%d" fontsize=%d shape=%s tooltip="%s (%s)" color="%s" fillcolor="%s"`,
-		label, nodeID, fontSize, shape, escapeForDot(node.Info.PrintableName()), cumValue,
-		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), false),
-		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), true))
+	attr := []dotAttr{
+		quotedAttr("label", label),
+		quotedAttr("id", fmt.Sprintf("node%d", nodeID)),
+		intAttr("fontsize", fontSize),
+		{"shape", shape, false},
+		quotedAttr("tooltip", fmt.Sprintf("%s (%s)", escapeForDot(node.Info.PrintableName()), cumValue)),
+		quotedAttr("color", dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), false)),
+		quotedAttr("fillcolor", dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), true)),
+	}
 
 	// Add on extra attributes if provided.
 	if attrs != nil {
 		// Make bold if specified.
 		if attrs.Bold {
-			attr += ` style="bold,filled"`
+			attr = append(attr, quotedAttr("style", "bold,filled"))
 		}
 
 		// Add peripheries if specified.
 		if attrs.Peripheries != 0 {
-			attr += fmt.Sprintf(` peripheries=%d`, attrs.Peripheries)
+			attr = append(attr, intAttr("peripheries", attrs.Peripheries))
 		}
 
 		// Add URL if specified. target="_blank" forces the link to open in a new tab.
 		if attrs.URL != "" {
-			attr += fmt.Sprintf(` URL="%s" target="_blank"`, attrs.URL)
+			attr = append(attr, quotedAttr("URL", attrs.URL), quotedAttr("target", "_blank"))
 		}
 	}
 
-	fmt.Fprintf(b, "N%d [%s]\n", nodeID, attr)
+	b.node(fmt.Sprintf("N%d", nodeID), attr)
 }
 
 // addNodelets generates the DOT boxes for the node tags if they exist.
 func (b *builder) addNodelets(node *Node, nodeID int) bool {
-	var nodelets string
+	var nodelets bool
 
 	// Populate two Tag slices, one for LabelTags and one for NumericTags.
 	var ts []*Tag
@@ -247,40 +315,65 @@ func (b *builder) addNodelets(node *Node, nodeID int) bool {
 			continue
 		}
 		weight := b.config.FormatValue(w)
-		nodelets += fmt.Sprintf(`N%d_%d [label = "%s" id="N%d_%d" fontsize=8 shape=box3d tooltip="%s"]`+"\n", nodeID, i, t.Name, nodeID, i, weight)
-		nodelets += fmt.Sprintf(`N%d -> N%d_%d [label=" %s" weight=100 tooltip="%s" labeltooltip="%s"]`+"\n", nodeID, nodeID, i, weight, weight, weight)
+		b.nodelet(fmt.Sprintf("N%d", nodeID), fmt.Sprintf("N%d_%d", nodeID, i), t.Name, weight, nil)
+		nodelets = true
 		if nts := lnts[t.Name]; nts != nil {
-			nodelets += b.numericNodelets(nts, maxNodelets, flatTags, fmt.Sprintf(`N%d_%d`, nodeID, i))
+			b.numericNodelets(nts, maxNodelets, flatTags, fmt.Sprintf(`N%d_%d`, nodeID, i))
 		}
 	}
 
 	if nts := lnts[""]; nts != nil {
-		nodelets += b.numericNodelets(nts, maxNodelets, flatTags, fmt.Sprintf(`N%d`, nodeID))
+		if b.numericNodelets(nts, maxNodelets, flatTags, fmt.Sprintf(`N%d`, nodeID)) {
+			nodelets = true
+		}
 	}
 
-	fmt.Fprint(b, nodelets)
-	return nodelets != ""
+	return nodelets
 }
 
-func (b *builder) numericNodelets(nts []*Tag, maxNumNodelets int, flatTags bool, source string) string {
-	nodelets := ""
+// numericNodelets generates the nodelets of numeric tags and reports
+// whether there were any.
+func (b *builder) numericNodelets(nts []*Tag, maxNumNodelets int, flatTags bool, source string) bool {
+	nodelets := false
 
 	// Collapse numeric labels into maxNumNodelets buckets, of the form:
 	// 1MB..2MB, 3MB..5MB, ...
 	for j, t := range b.collapsedTags(nts, maxNumNodelets, flatTags) {
-		w, attr := t.CumValue(), ` style="dotted"`
+		w, attr := t.CumValue(), []dotAttr{quotedAttr("style", "dotted")}
 		if flatTags || t.FlatValue() == t.CumValue() {
-			w, attr = t.FlatValue(), ""
+			w, attr = t.FlatValue(), nil
 		}
 		if w != 0 {
 			weight := b.config.FormatValue(w)
-			nodelets += fmt.Sprintf(`N%s_%d [label = "%s" id="N%s_%d" fontsize=8 shape=box3d tooltip="%s"]`+"\n", source, j, t.Name, source, j, weight)
-			nodelets += fmt.Sprintf(`%s -> N%s_%d [label=" %s" weight=100 tooltip="%s" labeltooltip="%s"%s]`+"\n", source, source, j, weight, weight, weight, attr)
+			b.nodelet(source, fmt.Sprintf("N%s_%d", source, j), t.Name, weight, attr)
+			nodelets = true
 		}
 	}
 	return nodelets
 }
 
+// nodelet generates a nodelet box for a tag and its edge from the
+// source node. The label of nodelets is written with spaces around the
+// equal sign.
+func (b *builder) nodelet(source, name, label, weight string, edgeAttrs []dotAttr) {
+	attrs := []dotAttr{
+		quotedAttr("id", name),
+		intAttr("fontsize", 8),
+		{"shape", "box3d", false},
+		quotedAttr("tooltip", weight),
+	}
+	fmt.Fprintf(b, "%s [label = \"%s\" %s]\n", name, label, formatAttrs(attrs))
+	if b.dot != nil {
+		b.dot.addNode(name, append([]dotAttr{quotedAttr("label", label)}, attrs...))
+	}
+	b.edge(source, name, append([]dotAttr{
+		quotedAttr("label", " "+weight),
+		intAttr("weight", 100),
+		quotedAttr("tooltip", weight),
+		quotedAttr("labeltooltip", weight),
+	}, edgeAttrs...))
+}
+
 // addEdge generates a graph edge in DOT format.
 func (b *builder) addEdge(edge *Edge, from, to int, hasNodelets bool) {
 	var inline string
@@ -288,37 +381,37 @@ func (b *builder) addEdge(edge *Edge, from, to int, hasNodelets bool) {
 		inline = `\n (inline)`
 	}
 	w := b.config.FormatValue(edge.WeightValue())
-	attr := fmt.Sprintf(`label=" %s%s"`, w, inline)
+	attr := []dotAttr{quotedAttr("label", fmt.Sprintf(" %s%s", w, inline))}
 	if b.config.Total != 0 {
 		// Note: edge.weight > b.config.Total is possible for profile diffs.
 		if weight := 1 + int(min64(abs64(edge.WeightValue()*100/b.config.Total), 100)); weight > 1 {
-			attr = fmt.Sprintf(`%s weight=%d`, attr, weight)
+			attr = append(attr, intAttr("weight", weight))
 		}
 		if width := 1 + int(min64(abs64(edge.WeightValue()*5/b.config.Total), 5)); width > 1 {
-			attr = fmt.Sprintf(`%s penwidth=%d`, attr, width)
+			attr = append(attr, intAttr("penwidth", width))
 		}
-		attr = fmt.Sprintf(`%s color="%s"`, attr,
-			dotColor(float64(edge.WeightValue())/float64(abs64(b.config.Total)), false))
+		attr = append(attr, quotedAttr("color",
+			dotColor(float64(edge.WeightValue())/float64(abs64(b.config.Total)), false)))
 	}
 	arrow := "->"
 	if edge.Residual {
 		arrow = "..."
 	}
-	tooltip := fmt.Sprintf(`"%s %s %s (%s)"`,
+	tooltip := fmt.Sprintf(`%s %s %s (%s)`,
 		escapeForDot(edge.Src.Info.PrintableName()), arrow,
 		escapeForDot(edge.Dest.Info.PrintableName()), w)
-	attr = fmt.Sprintf(`%s tooltip=%s labeltooltip=%s`, attr, tooltip, tooltip)
+	attr = append(attr, quotedAttr("tooltip", tooltip), quotedAttr("labeltooltip", tooltip))
 
 	if edge.Residual {
-		attr = attr + ` style="dotted"`
+		attr = append(attr, quotedAttr("style", "dotted"))
 	}
 
 	if hasNodelets {
 		// Separate children further if source has tags.
-		attr = attr + " minlen=2"
+		attr = append(attr, intAttr("minlen", 2))
 	}
 
-	fmt.Fprintf(b, "N%d -> N%d [%s]\n", from, to, attr)
+	b.edge(fmt.Sprintf("N%d", from), fmt.Sprintf("N%d", to), attr)
 }
 
 // dotColor returns a color for the given score (between -1.0 and
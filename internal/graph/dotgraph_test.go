@@ -341,7 +341,7 @@ func TestTagCollapse(t *testing.T) {
 
 	for _, tc := range tagWant {
 		var got, want []*Tag
-		b := builder{nil, &DotAttributes{}, &DotConfig{}}
+		b := builder{nil, &DotAttributes{}, &DotConfig{}, nil}
 		got = b.collapsedTags(tagSource, len(tc), true)
 		want = SortTags(tc, true)
 
@@ -0,0 +1,720 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Layout parameters, in points, following the Graphviz defaults.
+const (
+	layoutNodeSep    = 18.0 // Horizontal space between nodes.
+	layoutVirtualSep = 8.0  // Horizontal space next to edge bends.
+	layoutRankSep    = 18.0 // Vertical space between ranks and edge labels.
+	layoutMinWidth   = 54.0
+	layoutMinHeight  = 36.0
+	layoutMarginX    = 8.0
+	layoutMarginY    = 4.0
+	layoutLoopWidth  = 40.0 // Horizontal extent of self loops.
+
+	defaultFontSize   = 14.0
+	layoutLineSpacing = 1.2
+	crossingPasses    = 24
+	positionPasses    = 32
+)
+
+// dotGraph holds the elements of a graph composed by ComposeDot, with
+// their DOT attributes, for the layout.
+type dotGraph struct {
+	name   string
+	nodes  []*dotNode
+	edges  []*dotEdge
+	byName map[string]*dotNode
+
+	nodeDefaults map[string]string
+}
+
+type dotNode struct {
+	name  string
+	attrs map[string]string
+}
+
+type dotEdge struct {
+	from, to *dotNode
+	attrs    map[string]string
+}
+
+func newDotGraph() *dotGraph {
+	return &dotGraph{
+		byName:       make(map[string]*dotNode),
+		nodeDefaults: make(map[string]string),
+	}
+}
+
+// addNode adds the attributes to the node with the given name, creating
+// it if needed.
+func (g *dotGraph) addNode(name string, attrs []dotAttr) *dotNode {
+	n := g.byName[name]
+	if n == nil {
+		n = &dotNode{name: name, attrs: make(map[string]string)}
+		g.byName[name] = n
+		g.nodes = append(g.nodes, n)
+	}
+	for _, a := range attrs {
+		n.attrs[a.key] = a.value
+	}
+	return n
+}
+
+// addEdge adds an edge between two nodes, creating them if needed.
+func (g *dotGraph) addEdge(from, to string, attrs []dotAttr) {
+	e := &dotEdge{g.addNode(from, nil), g.addNode(to, nil), make(map[string]string)}
+	for _, a := range attrs {
+		e.attrs[a.key] = a.value
+	}
+	g.edges = append(g.edges, e)
+}
+
+// attr returns the value of an attribute of a node, falling back to the
+// node defaults of the graph.
+func (g *dotGraph) attr(n *dotNode, key string) string {
+	if v, ok := n.attrs[key]; ok {
+		return v
+	}
+	return g.nodeDefaults[key]
+}
+
+// edgeAttr returns the value of an attribute of an edge.
+func (g *dotGraph) edgeAttr(e *dotEdge, key string) string {
+	return e.attrs[key]
+}
+
+type point struct{ x, y float64 }
+
+// layoutNode is a node placed by the layout. Virtual nodes route edges
+// spanning several ranks and hold edge labels.
+type layoutNode struct {
+	dot   *dotNode // nil for virtual nodes.
+	label bool     // Virtual node holding the label of its edge.
+
+	lines    []dotLine
+	fontSize float64
+
+	w, h     float64
+	loopPad  float64 // Extra room on the right for self loops.
+	rank     int
+	order    int
+	x, y     float64 // Center of the node.
+	up, down []layoutSeg
+}
+
+// layoutSeg connects nodes on adjacent ranks.
+type layoutSeg struct {
+	n      *layoutNode
+	weight float64
+}
+
+type layoutEdge struct {
+	dot        *dotEdge
+	tail, head *layoutNode // Endpoints after breaking cycles.
+	reversed   bool
+	chain      []*layoutNode // Virtual nodes from tail to head.
+	minLen     int
+	weight     float64
+
+	lines    []dotLine
+	fontSize float64
+
+	points []point     // Route from the source to the destination.
+	label  *layoutNode // Virtual node holding the label, if any.
+	loop   bool        // Whether this edge is a self loop.
+	loopAt *layoutNode // Node the loop is attached to.
+}
+
+// layout is a layered drawing of a dotGraph, computed with the method
+// of Sugiyama et al.: cycles are broken, nodes are assigned to ranks,
+// long edges are split by virtual nodes, crossings are reduced by
+// reordering ranks and finally nodes get coordinates.
+type layout struct {
+	g             *dotGraph
+	nodes         []*layoutNode // Real nodes, in the order of g.nodes.
+	edges         []*layoutEdge
+	ranks         [][]*layoutNode
+	width, height float64
+}
+
+// newLayout computes the layout of a graph.
+func newLayout(g *dotGraph) *layout {
+	l := &layout{g: g}
+	byDot := make(map[*dotNode]*layoutNode)
+	for _, dn := range g.nodes {
+		n := &layoutNode{dot: dn}
+		n.fontSize = parseFloat(g.attr(dn, "fontsize"), defaultFontSize)
+		label, ok := dn.attrs["label"]
+		if !ok {
+			label = g.nodeDefaults["label"]
+		}
+		if label == "" || label == `\N` {
+			label = dn.name
+		}
+		n.lines = parseDotLabel(label)
+		n.w, n.h = nodeSize(n.lines, n.fontSize, g.attr(dn, "shape"))
+		if p := parseFloat(g.attr(dn, "peripheries"), 1); p > 1 {
+			n.w += 8 * (p - 1)
+			n.h += 8 * (p - 1)
+		}
+		byDot[dn] = n
+		l.nodes = append(l.nodes, n)
+	}
+	for _, de := range g.edges {
+		e := &layoutEdge{
+			dot:    de,
+			tail:   byDot[de.from],
+			head:   byDot[de.to],
+			minLen: int(parseFloat(g.edgeAttr(de, "minlen"), 1)),
+			weight: parseFloat(g.edgeAttr(de, "weight"), 1),
+		}
+		if e.minLen < 1 {
+			e.minLen = 1
+		}
+		if label := g.edgeAttr(de, "label"); label != "" {
+			e.fontSize = parseFloat(g.edgeAttr(de, "fontsize"), defaultFontSize)
+			e.lines = parseDotLabel(label)
+		}
+		if e.tail == e.head {
+			e.loop, e.loopAt = true, e.tail
+			w, _ := labelSize(e.lines, e.fontSize)
+			e.tail.loopPad = math.Max(e.tail.loopPad, layoutLoopWidth+w)
+		}
+		l.edges = append(l.edges, e)
+	}
+
+	l.breakCycles()
+	l.assignRanks()
+	l.buildRanks()
+	l.reduceCrossings()
+	l.assignCoordinates()
+	l.routeEdges()
+	return l
+}
+
+// nodeSize returns the size of a node with the given label.
+func nodeSize(lines []dotLine, fontSize float64, shape string) (float64, float64) {
+	w, h := labelSize(lines, fontSize)
+	w = math.Max(w+2*layoutMarginX, layoutMinWidth)
+	h = math.Max(h+2*layoutMarginY, layoutMinHeight)
+	switch shape {
+	case "box3d":
+		w, h = w+4, h+4
+	case "ellipse", "oval", "circle":
+		w, h = w*math.Sqrt2, h*math.Sqrt2
+		if shape == "circle" {
+			w = math.Max(w, h)
+			h = w
+		}
+	}
+	return w, h
+}
+
+// labelSize estimates the size of a label.
+func labelSize(lines []dotLine, fontSize float64) (float64, float64) {
+	if len(lines) == 0 {
+		return 0, 0
+	}
+	var w float64
+	for _, l := range lines {
+		w = math.Max(w, textWidth(l.text, fontSize))
+	}
+	return w, float64(len(lines)) * fontSize * layoutLineSpacing
+}
+
+// textWidth estimates the width of text in the Times font, which is
+// what Graphviz uses by default.
+func textWidth(s string, fontSize float64) float64 {
+	var w float64
+	for _, r := range s {
+		switch {
+		case r == 'i' || r == 'j' || r == 'l' || r == '.' || r == ',' || r == ':' ||
+			r == ';' || r == '\'' || r == '|' || r == '!' || r == ' ':
+			w += 0.28
+		case r == 'f' || r == 'r' || r == 't' || r == '(' || r == ')' || r == '[' || r == ']' || r == '/':
+			w += 0.34
+		case r == 'm' || r == 'w' || r == 'M' || r == 'W' || r == '%':
+			w += 0.82
+		case 'A' <= r && r <= 'Z':
+			w += 0.68
+		case r >= 0x80:
+			w += 0.8
+		default:
+			w += 0.5
+		}
+	}
+	return w * fontSize
+}
+
+func parseFloat(s string, def float64) float64 {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v
+	}
+	return def
+}
+
+// breakCycles reverses the edges closing cycles, found by a depth
+// first search in node order, so that the graph becomes acyclic.
+func (l *layout) breakCycles() {
+	out := make(map[*layoutNode][]*layoutEdge)
+	for _, e := range l.edges {
+		if !e.loop {
+			out[e.tail] = append(out[e.tail], e)
+		}
+	}
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make(map[*layoutNode]int)
+	var visit func(n *layoutNode)
+	visit = func(n *layoutNode) {
+		state[n] = active
+		for _, e := range out[n] {
+			switch state[e.head] {
+			case active:
+				e.reversed = true
+			case unvisited:
+				visit(e.head)
+			}
+		}
+		state[n] = done
+	}
+	for _, n := range l.nodes {
+		if state[n] == unvisited {
+			visit(n)
+		}
+	}
+	for _, e := range l.edges {
+		if e.reversed {
+			e.tail, e.head = e.head, e.tail
+		}
+	}
+}
+
+// assignRanks places every node on the lowest rank allowed by its
+// predecessors, then moves sources down next to their successors to
+// keep edges short.
+func (l *layout) assignRanks() {
+	in := make(map[*layoutNode][]*layoutEdge)
+	out := make(map[*layoutNode][]*layoutEdge)
+	for _, e := range l.edges {
+		if !e.loop {
+			out[e.tail] = append(out[e.tail], e)
+			in[e.head] = append(in[e.head], e)
+		}
+	}
+
+	// Topological sort, preferring the original node order.
+	indegree := make(map[*layoutNode]int)
+	for n, es := range in {
+		indegree[n] = len(es)
+	}
+	var topo, queue []*layoutNode
+	for _, n := range l.nodes {
+		if indegree[n] == 0 {
+			queue = append(queue, n)
+		}
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		topo = append(topo, n)
+		for _, e := range out[n] {
+			if indegree[e.head]--; indegree[e.head] == 0 {
+				queue = append(queue, e.head)
+			}
+		}
+	}
+
+	for _, n := range topo {
+		for _, e := range out[n] {
+			if r := n.rank + e.minLen; r > e.head.rank {
+				e.head.rank = r
+			}
+		}
+	}
+	for i := len(topo) - 1; i >= 0; i-- {
+		n := topo[i]
+		if len(in[n]) > 0 || len(out[n]) == 0 {
+			continue
+		}
+		r := math.MaxInt32
+		for _, e := range out[n] {
+			if hr := e.head.rank - e.minLen; hr < r {
+				r = hr
+			}
+		}
+		if r > n.rank {
+			n.rank = r
+		}
+	}
+}
+
+// buildRanks splits edges into segments between adjacent ranks. Ranks
+// are doubled so that every edge crosses at least one intermediate
+// rank, where its label is placed as done by Graphviz.
+func (l *layout) buildRanks() {
+	maxRank := 0
+	for _, n := range l.nodes {
+		n.rank *= 2
+		if n.rank > maxRank {
+			maxRank = n.rank
+		}
+	}
+	l.ranks = make([][]*layoutNode, maxRank+1)
+	for _, n := range l.nodes {
+		l.ranks[n.rank] = append(l.ranks[n.rank], n)
+	}
+	for _, e := range l.edges {
+		if e.loop {
+			continue
+		}
+		prev := e.tail
+		for r := e.tail.rank + 1; r < e.head.rank; r++ {
+			v := &layoutNode{rank: r, w: 2}
+			l.ranks[r] = append(l.ranks[r], v)
+			e.chain = append(e.chain, v)
+			l.connect(prev, v, e.weight)
+			prev = v
+		}
+		if len(e.chain) > 0 && len(e.lines) > 0 {
+			e.label = e.chain[len(e.chain)/2]
+			e.label.label = true
+			e.label.w, e.label.h = labelSize(e.lines, e.fontSize)
+			e.label.w += 2 * layoutVirtualSep
+		}
+		l.connect(prev, e.head, e.weight)
+	}
+	for _, rank := range l.ranks {
+		for i, n := range rank {
+			n.order = i
+		}
+	}
+}
+
+// connect adds a segment between nodes on adjacent ranks. As in
+// Graphviz, segments between virtual nodes are weighted more heavily to
+// keep long edges straight.
+func (l *layout) connect(from, to *layoutNode, weight float64) {
+	if from.dot == nil && to.dot == nil {
+		weight *= 8
+	} else if from.dot == nil || to.dot == nil {
+		weight *= 2
+	}
+	from.down = append(from.down, layoutSeg{to, weight})
+	to.up = append(to.up, layoutSeg{from, weight})
+}
+
+// reduceCrossings reorders the nodes of each rank using the barycenter
+// heuristic, sweeping alternately down and up, and keeps the ordering
+// with the fewest crossings.
+func (l *layout) reduceCrossings() {
+	best := l.saveOrder()
+	bestCrossings := l.crossings()
+	for pass := 0; pass < crossingPasses && bestCrossings > 0; pass++ {
+		if pass%2 == 0 {
+			for r := 1; r < len(l.ranks); r++ {
+				sortByBarycenter(l.ranks[r], func(n *layoutNode) []layoutSeg { return n.up })
+			}
+		} else {
+			for r := len(l.ranks) - 2; r >= 0; r-- {
+				sortByBarycenter(l.ranks[r], func(n *layoutNode) []layoutSeg { return n.down })
+			}
+		}
+		if c := l.crossings(); c < bestCrossings {
+			best, bestCrossings = l.saveOrder(), c
+		}
+	}
+	for r, rank := range best {
+		l.ranks[r] = rank
+		for i, n := range rank {
+			n.order = i
+		}
+	}
+}
+
+func (l *layout) saveOrder() [][]*layoutNode {
+	order := make([][]*layoutNode, len(l.ranks))
+	for r, rank := range l.ranks {
+		order[r] = append([]*layoutNode(nil), rank...)
+	}
+	return order
+}
+
+func sortByBarycenter(rank []*layoutNode, adj func(*layoutNode) []layoutSeg) {
+	bary := make(map[*layoutNode]float64, len(rank))
+	for _, n := range rank {
+		segs := adj(n)
+		if len(segs) == 0 {
+			bary[n] = float64(n.order)
+			continue
+		}
+		var sum float64
+		for _, s := range segs {
+			sum += float64(s.n.order)
+		}
+		bary[n] = sum / float64(len(segs))
+	}
+	sort.SliceStable(rank, func(i, j int) bool { return bary[rank[i]] < bary[rank[j]] })
+	for i, n := range rank {
+		n.order = i
+	}
+}
+
+// crossings counts the pairs of crossing segments between all pairs of
+// adjacent ranks.
+func (l *layout) crossings() int {
+	var total int
+	type seg struct{ a, b int }
+	for _, rank := range l.ranks {
+		var segs []seg
+		for _, n := range rank {
+			for _, s := range n.down {
+				segs = append(segs, seg{n.order, s.n.order})
+			}
+		}
+		for i := range segs {
+			for j := i + 1; j < len(segs); j++ {
+				s, t := segs[i], segs[j]
+				if (s.a-t.a)*(s.b-t.b) < 0 {
+					total++
+				}
+			}
+		}
+	}
+	return total
+}
+
+// separation returns the minimum distance between the centers of two
+// nodes adjacent on a rank.
+func separation(a, b *layoutNode) float64 {
+	sep := layoutNodeSep
+	if a.dot == nil || b.dot == nil {
+		sep = layoutVirtualSep
+	}
+	return a.w/2 + a.loopPad + sep + b.w/2
+}
+
+// assignCoordinates places ranks from top to bottom and moves nodes
+// horizontally towards the weighted average position of their
+// neighbors, keeping the order and separation of each rank.
+func (l *layout) assignCoordinates() {
+	for _, rank := range l.ranks {
+		x := 0.0
+		for i, n := range rank {
+			if i > 0 {
+				x += separation(rank[i-1], n)
+			}
+			n.x = x
+		}
+	}
+	for pass := 0; pass < positionPasses; pass++ {
+		if pass%2 == 0 {
+			for _, rank := range l.ranks {
+				placeRank(rank)
+			}
+		} else {
+			for r := len(l.ranks) - 1; r >= 0; r-- {
+				placeRank(l.ranks[r])
+			}
+		}
+	}
+
+	minX := math.Inf(1)
+	for _, rank := range l.ranks {
+		for _, n := range rank {
+			minX = math.Min(minX, n.x-n.w/2)
+		}
+	}
+	y := 0.0
+	for r, rank := range l.ranks {
+		var h float64
+		for _, n := range rank {
+			n.x -= minX
+			h = math.Max(h, n.h)
+			l.width = math.Max(l.width, n.x+n.w/2+n.loopPad)
+		}
+		if r > 0 {
+			y += layoutRankSep
+		}
+		for _, n := range rank {
+			n.y = y + h/2
+		}
+		y += h
+	}
+	l.height = y
+}
+
+// placeRank moves the nodes of a rank as close as possible to the
+// weighted average position of their neighbors. Minimizing the squared
+// displacement subject to the separation constraints is an isotonic
+// regression, solved with the pool adjacent violators algorithm.
+func placeRank(rank []*layoutNode) {
+	if len(rank) == 0 {
+		return
+	}
+	type block struct {
+		weight, sum float64
+		count       int
+	}
+	offsets := make([]float64, len(rank))
+	blocks := make([]block, 0, len(rank))
+	for i, n := range rank {
+		if i > 0 {
+			offsets[i] = offsets[i-1] + separation(rank[i-1], n)
+		}
+		want, weight := n.x, 0.0
+		var sum float64
+		for _, segs := range [][]layoutSeg{n.up, n.down} {
+			for _, s := range segs {
+				sum += s.weight * s.n.x
+				weight += s.weight
+			}
+		}
+		if weight > 0 {
+			want = sum / weight
+		} else {
+			weight = 0.01
+		}
+		blocks = append(blocks, block{weight, weight * (want - offsets[i]), 1})
+		for len(blocks) > 1 {
+			a, b := blocks[len(blocks)-2], blocks[len(blocks)-1]
+			if a.sum/a.weight <= b.sum/b.weight {
+				break
+			}
+			blocks = blocks[:len(blocks)-2]
+			blocks = append(blocks, block{a.weight + b.weight, a.sum + b.sum, a.count + b.count})
+		}
+	}
+	i := 0
+	for _, b := range blocks {
+		v := b.sum / b.weight
+		for j := 0; j < b.count; j++ {
+			rank[i].x = v + offsets[i]
+			i++
+		}
+	}
+}
+
+// routeEdges computes the route of every edge through its virtual
+// nodes, clipped at the boundary of its endpoints.
+func (l *layout) routeEdges() {
+	for _, e := range l.edges {
+		if e.loop {
+			continue
+		}
+		pts := []point{{e.tail.x, e.tail.y}}
+		for _, v := range e.chain {
+			if v == e.label {
+				pts = append(pts, point{v.x - v.w/2 + 1, v.y})
+				continue
+			}
+			pts = append(pts, point{v.x, v.y})
+		}
+		pts = append(pts, point{e.head.x, e.head.y})
+		pts[0] = clipToBox(e.tail, pts[1])
+		pts[len(pts)-1] = clipToBox(e.head, pts[len(pts)-2])
+		if e.reversed {
+			for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+				pts[i], pts[j] = pts[j], pts[i]
+			}
+		}
+		e.points = pts
+	}
+}
+
+// clipToBox returns the point where the segment from the center of n
+// to p leaves the bounding box of n.
+func clipToBox(n *layoutNode, p point) point {
+	dx, dy := p.x-n.x, p.y-n.y
+	if dx == 0 && dy == 0 {
+		return p
+	}
+	t := math.Inf(1)
+	if dx != 0 {
+		t = math.Min(t, n.w/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		t = math.Min(t, n.h/2/math.Abs(dy))
+	}
+	t = math.Min(t, 1)
+	return point{n.x + t*dx, n.y + t*dy}
+}
+
+// unescapeDot interprets the escape sequences of a DOT string that is
+// not a label, turning line breaks into newlines.
+func unescapeDot(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'l', 'r':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// dotLine is a line of a DOT label, with its justification: 'n' for
+// centered, 'l' for left and 'r' for right justified.
+type dotLine struct {
+	text string
+	just byte
+}
+
+// parseDotLabel splits a DOT label into lines. Each line is terminated
+// by an escape sequence that determines its justification.
+func parseDotLabel(s string) []dotLine {
+	var lines []dotLine
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch c := s[i]; c {
+			case 'n', 'l', 'r':
+				lines = append(lines, dotLine{b.String(), c})
+				b.Reset()
+			default:
+				b.WriteByte(c)
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	if b.Len() > 0 || len(lines) == 0 {
+		lines = append(lines, dotLine{b.String(), 'n'})
+	}
+	return lines
+}
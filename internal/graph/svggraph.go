@@ -0,0 +1,280 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	svgMargin      = 4.0
+	svgArrowLength = 10.0
+	svgArrowWidth  = 3.5
+	svgFontFamily  = "Times,serif"
+)
+
+// ComposeSVG lays out the graph without invoking Graphviz and writes it
+// as SVG. The graph is styled exactly as by ComposeDot, and the output
+// is structured like that of "dot -Tsvg" so it can be post-processed
+// the same way.
+func ComposeSVG(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) error {
+	b := &builder{io.Discard, a, c, newDotGraph()}
+	b.compose(g)
+	bw := bufio.NewWriter(w)
+	writeSVG(bw, newLayout(b.dot))
+	return bw.Flush()
+}
+
+// svgWriter writes the elements of a layout, translated by the margin.
+type svgWriter struct {
+	w *bufio.Writer
+	l *layout
+}
+
+func (s *svgWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(s.w, format, args...)
+}
+
+func writeSVG(w *bufio.Writer, l *layout) {
+	s := &svgWriter{w, l}
+	width, height := l.width+2*svgMargin, l.height+2*svgMargin
+	s.printf(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n")
+	s.printf(`<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">` + "\n")
+	s.printf(`<svg width="%.0fpt" height="%.0fpt"`+"\n", width, height)
+	s.printf(` viewBox="0.00 0.00 %.2f %.2f" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`+"\n", width, height)
+	s.printf(`<g id="graph0" class="graph" transform="translate(%g %g)">`+"\n", svgMargin, svgMargin)
+	s.printf("<title>%s</title>\n", html.EscapeString(l.g.name))
+	s.printf(`<polygon fill="white" stroke="transparent" points="%s"/>`+"\n",
+		polygonPoints(-svgMargin, -svgMargin, width, height))
+
+	// Edges are drawn first so that nodes are never covered by them.
+	for i, e := range l.edges {
+		s.edge(i+1, e)
+	}
+	for _, n := range l.nodes {
+		s.node(n)
+	}
+	s.printf("</g>\n</svg>\n")
+}
+
+func polygonPoints(x, y, w, h float64) string {
+	return fmt.Sprintf("%.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f",
+		x+w, y, x, y, x, y+h, x+w, y+h, x+w, y)
+}
+
+// link opens an anchor for the URL and tooltip attributes, if present,
+// and returns the string closing it.
+func (s *svgWriter) link(url, tooltip string) string {
+	if url == "" && tooltip == "" {
+		return ""
+	}
+	s.printf("<a")
+	if url != "" {
+		s.printf(` xlink:href="%s" target="_blank"`, html.EscapeString(unescapeDot(url)))
+	}
+	if tooltip != "" {
+		s.printf(` xlink:title="%s"`, html.EscapeString(unescapeDot(tooltip)))
+	}
+	s.printf(">\n")
+	return "</a>\n"
+}
+
+func (s *svgWriter) node(n *layoutNode) {
+	g, dn := s.l.g, n.dot
+	s.printf(`<g`)
+	if id := g.attr(dn, "id"); id != "" {
+		s.printf(` id="%s"`, html.EscapeString(unescapeDot(id)))
+	}
+	s.printf(` class="node">`+"\n<title>%s</title>\n", html.EscapeString(dn.name))
+	end := s.link(g.attr(dn, "URL"), g.attr(dn, "tooltip"))
+
+	stroke := colorAttr(g.attr(dn, "color"), "black")
+	fill := "none"
+	style := g.attr(dn, "style")
+	if strings.Contains(style, "filled") {
+		fill = colorAttr(g.attr(dn, "fillcolor"), "lightgrey")
+	}
+	strokeWidth := ""
+	if strings.Contains(style, "bold") {
+		strokeWidth = ` stroke-width="2"`
+	}
+
+	x, y, w, h := n.x-n.w/2, n.y-n.h/2, n.w, n.h
+	shape := g.attr(dn, "shape")
+	if shape == "box3d" {
+		w, h = w-4, h-4
+		y += 4
+	}
+	for p := int(parseFloat(g.attr(dn, "peripheries"), 1)); p > 1; p-- {
+		inset := 4 * float64(p-1)
+		s.shape(shape, x+inset, y+inset, w-2*inset, h-2*inset, "none", stroke, strokeWidth)
+	}
+	s.shape(shape, x, y, w, h, fill, stroke, strokeWidth)
+	if shape == "box3d" {
+		s.printf(`<polyline fill="none" stroke="%s"%s points="%.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f"/>`+"\n",
+			stroke, strokeWidth, x, y, x+4, y-4, x+w+4, y-4, x+w+4, y+h-4, x+w, y+h)
+		s.printf(`<polyline fill="none" stroke="%s"%s points="%.2f,%.2f %.2f,%.2f"/>`+"\n",
+			stroke, strokeWidth, x+w, y, x+w+4, y-4)
+	}
+	s.text(n.lines, n.fontSize, n.x, n.y, w-2*layoutMarginX)
+	s.printf("%s</g>\n", end)
+}
+
+// shape draws the outline of a node within the given bounding box.
+func (s *svgWriter) shape(shape string, x, y, w, h float64, fill, stroke, strokeWidth string) {
+	switch shape {
+	case "ellipse", "oval", "circle":
+		s.printf(`<ellipse fill="%s" stroke="%s"%s cx="%.2f" cy="%.2f" rx="%.2f" ry="%.2f"/>`+"\n",
+			fill, stroke, strokeWidth, x+w/2, y+h/2, w/2, h/2)
+	default:
+		s.printf(`<polygon fill="%s" stroke="%s"%s points="%s"/>`+"\n",
+			fill, stroke, strokeWidth, polygonPoints(x, y, w, h))
+	}
+}
+
+// text draws the lines of a label centered vertically at y. Centered
+// lines are centered at x, and justified lines are aligned within the
+// given width.
+func (s *svgWriter) text(lines []dotLine, fontSize, x, y, width float64) {
+	lineHeight := fontSize * layoutLineSpacing
+	top := y - float64(len(lines))*lineHeight/2
+	for i, l := range lines {
+		anchor, lx := "middle", x
+		switch l.just {
+		case 'l':
+			anchor, lx = "start", x-width/2
+		case 'r':
+			anchor, lx = "end", x+width/2
+		}
+		s.printf(`<text text-anchor="%s" x="%.2f" y="%.2f" font-family="%s" font-size="%.2f">%s</text>`+"\n",
+			anchor, lx, top+float64(i)*lineHeight+fontSize, svgFontFamily, fontSize, html.EscapeString(l.text))
+	}
+}
+
+func (s *svgWriter) edge(id int, e *layoutEdge) {
+	g, de := s.l.g, e.dot
+	s.printf(`<g id="edge%d" class="edge">`+"\n<title>%s</title>\n", id,
+		html.EscapeString(de.from.name+"->"+de.to.name))
+	end := s.link(g.edgeAttr(de, "URL"), g.edgeAttr(de, "tooltip"))
+
+	color := colorAttr(g.edgeAttr(de, "color"), "black")
+	width := parseFloat(g.edgeAttr(de, "penwidth"), 1)
+	dash := ""
+	switch style := g.edgeAttr(de, "style"); {
+	case strings.Contains(style, "dotted"):
+		dash = ` stroke-dasharray="1,5"`
+	case strings.Contains(style, "dashed"):
+		dash = ` stroke-dasharray="5,2"`
+	}
+
+	var path []point
+	var labelAt point
+	if e.loop {
+		n := e.loopAt
+		right := n.x + n.w/2
+		path = []point{
+			{right, n.y - n.h/5},
+			{right + layoutLoopWidth, n.y - n.h/2},
+			{right + layoutLoopWidth, n.y + n.h/2},
+			{right, n.y + n.h/5},
+		}
+		labelAt = point{right + layoutLoopWidth*0.75 + 2, n.y}
+	} else {
+		path = bezierPath(e.points)
+		if e.label != nil {
+			labelAt = point{e.label.x - e.label.w/2 + layoutVirtualSep, e.label.y}
+		} else {
+			a, b := e.points[len(e.points)/2-1], e.points[len(e.points)/2]
+			labelAt = point{(a.x+b.x)/2 + 2, (a.y + b.y) / 2}
+		}
+	}
+
+	// Shorten the path so that the arrowhead ends at the node.
+	tip := path[len(path)-1]
+	from := path[len(path)-2]
+	dx, dy := tip.x-from.x, tip.y-from.y
+	if d := math.Hypot(dx, dy); d > 0 {
+		dx, dy = dx/d, dy/d
+	} else {
+		dx, dy = 0, 1
+	}
+	base := point{tip.x - dx*svgArrowLength, tip.y - dy*svgArrowLength}
+	path[len(path)-1] = base
+	path[len(path)-2] = point{from.x - dx*svgArrowLength, from.y - dy*svgArrowLength}
+
+	var d strings.Builder
+	fmt.Fprintf(&d, "M%.2f,%.2f C", path[0].x, path[0].y)
+	for i, p := range path[1:] {
+		if i > 0 {
+			d.WriteByte(' ')
+		}
+		fmt.Fprintf(&d, "%.2f,%.2f", p.x, p.y)
+	}
+	s.printf(`<path fill="none" stroke="%s" stroke-width="%g"%s d="%s"/>`+"\n", color, width, dash, d.String())
+	s.printf(`<polygon fill="%s" stroke="%s" stroke-width="%g" points="%.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f"/>`+"\n",
+		color, color, width,
+		base.x-dy*svgArrowWidth, base.y+dx*svgArrowWidth,
+		tip.x, tip.y,
+		base.x+dy*svgArrowWidth, base.y-dx*svgArrowWidth,
+		base.x-dy*svgArrowWidth, base.y+dx*svgArrowWidth)
+	s.printf("%s", end)
+
+	if len(e.lines) > 0 {
+		s.printf("<g>\n")
+		end := s.link("", g.edgeAttr(de, "labeltooltip"))
+		w, _ := labelSize(e.lines, e.fontSize)
+		s.text(e.lines, e.fontSize, labelAt.x+w/2, labelAt.y, w)
+		s.printf("%s</g>\n", end)
+	}
+	s.printf("</g>\n")
+}
+
+// bezierPath returns the control points of a cubic Bézier spline
+// through the points of a route: the start point followed by three
+// points per segment.
+func bezierPath(pts []point) []point {
+	at := func(i int) point {
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(pts) {
+			i = len(pts) - 1
+		}
+		return pts[i]
+	}
+	path := []point{pts[0]}
+	for i := 0; i+1 < len(pts); i++ {
+		p0, p1, p2, p3 := at(i-1), at(i), at(i+1), at(i+2)
+		path = append(path,
+			point{p1.x + (p2.x-p0.x)/6, p1.y + (p2.y-p0.y)/6},
+			point{p2.x - (p3.x-p1.x)/6, p2.y - (p3.y-p1.y)/6},
+			p2)
+	}
+	return path
+}
+
+// colorAttr returns an SVG color for a DOT color attribute.
+func colorAttr(c, def string) string {
+	c = unescapeDot(c)
+	if c == "" {
+		return def
+	}
+	return html.EscapeString(c)
+}
@@ -0,0 +1,248 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseDotLabel(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []dotLine
+	}{
+		{"", []dotLine{{"", 'n'}}},
+		{`main`, []dotLine{{"main", 'n'}}},
+		{`a\nb\n`, []dotLine{{"a", 'n'}, {"b", 'n'}}},
+		{`Type: cpu\lTime: x\l`, []dotLine{{"Type: cpu", 'l'}, {"Time: x", 'l'}}},
+		{`say \"hi\"\rend`, []dotLine{{`say "hi"`, 'r'}, {"end", 'n'}}},
+	} {
+		if got := parseDotLabel(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseDotLabel(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	g := newDotGraph()
+	for _, e := range []struct {
+		from, to, label string
+	}{
+		{"a", "b", "1"},
+		{"b", "c", ""},
+		{"a", "c", "long edge"},
+		{"c", "a", ""},
+		{"c", "d", ""},
+		{"b", "b", ""},
+	} {
+		var attrs []dotAttr
+		if e.label != "" {
+			attrs = append(attrs, quotedAttr("label", e.label))
+		}
+		g.addEdge(e.from, e.to, attrs)
+	}
+	g.addNode("e", nil)
+	l := newLayout(g)
+
+	var reversed int
+	for _, e := range l.edges {
+		if e.loop {
+			continue
+		}
+		if e.reversed {
+			reversed++
+		}
+		if e.head.rank <= e.tail.rank {
+			t.Errorf("edge %s->%s goes from rank %d to %d", e.dot.from.name, e.dot.to.name, e.tail.rank, e.head.rank)
+		}
+		src, dst := e.points[0], e.points[len(e.points)-1]
+		if from, to := l.nodes[indexOf(g, e.dot.from)], l.nodes[indexOf(g, e.dot.to)]; !onBoundary(from, src) || !onBoundary(to, dst) {
+			t.Errorf("edge %s->%s route %v does not join its nodes", e.dot.from.name, e.dot.to.name, e.points)
+		}
+	}
+	if reversed != 1 {
+		t.Errorf("got %d reversed edges, want 1", reversed)
+	}
+	for r, rank := range l.ranks {
+		for i := 1; i < len(rank); i++ {
+			a, b := rank[i-1], rank[i]
+			if b.x-b.w/2 < a.x+a.w/2 {
+				t.Errorf("rank %d: nodes %d and %d overlap", r, i-1, i)
+			}
+		}
+		for _, n := range rank {
+			if n.x-n.w/2 < -1e-6 || n.x+n.w/2 > l.width+1e-6 || n.y+n.h/2 > l.height+1e-6 {
+				t.Errorf("rank %d: node outside of the %gx%g drawing", r, l.width, l.height)
+			}
+		}
+	}
+}
+
+func indexOf(g *dotGraph, n *dotNode) int {
+	for i, m := range g.nodes {
+		if m == n {
+			return i
+		}
+	}
+	return -1
+}
+
+func onBoundary(n *layoutNode, p point) bool {
+	const eps = 1e-6
+	inX := p.x >= n.x-n.w/2-eps && p.x <= n.x+n.w/2+eps
+	inY := p.y >= n.y-n.h/2-eps && p.y <= n.y+n.h/2+eps
+	onX := abs(p.x-(n.x-n.w/2)) < eps || abs(p.x-(n.x+n.w/2)) < eps
+	onY := abs(p.y-(n.y-n.h/2)) < eps || abs(p.y-(n.y+n.h/2)) < eps
+	return inX && inY && (onX || onY)
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// TestComposeRecordsDot checks that the graph laid out by ComposeSVG
+// has the elements and attributes written by ComposeDot.
+func TestComposeRecordsDot(t *testing.T) {
+	g := baseGraph()
+	a, c := baseAttrsAndConfig()
+	a.Nodes[g.Nodes[0]] = &DotNodeAttributes{Bold: true, Peripheries: 2, URL: "www.google.com"}
+	g.Nodes[0].LabelTags["tag1"] = &Tag{Name: "tag1", Cum: 10, Flat: 10}
+	g.Nodes[0].NumericTags["tag1"] = TagMap{"tag2": &Tag{Name: "tag2", Cum: 20, Flat: 20, Unit: "ms"}}
+	g.Nodes[0].Out[g.Nodes[1]].Residual = true
+
+	var dot bytes.Buffer
+	b := &builder{&dot, a, c, newDotGraph()}
+	b.compose(g)
+
+	var got []string
+	for _, n := range b.dot.nodes {
+		got = append(got, n.name+" "+n.attrs["label"])
+	}
+	for _, e := range b.dot.edges {
+		got = append(got, e.from.name+" -> "+e.to.name+" "+e.attrs["label"])
+	}
+	want := []string{
+		`label1 label1\llabel2\llabel3: \"foo\"\l`,
+		`N1 src\n10 (10.00%)\nof 25 (25.00%)`,
+		`N1_0 tag1`,
+		`NN1_0_0 tag2`,
+		`N2 dest\n15 (15.00%)\nof 25 (25.00%)`,
+		`N1 -> N1_0  10`,
+		`N1_0 -> NN1_0_0  20`,
+		`N1 -> N2  10`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, n := range b.dot.nodes {
+		for key, value := range n.attrs {
+			if key != "label" && !strings.Contains(dot.String(), key+"="+value) && !strings.Contains(dot.String(), key+`="`+value+`"`) {
+				t.Errorf("node %s: attribute %s=%q is not in the DOT output", n.name, key, value)
+			}
+		}
+	}
+	if got := b.dot.attr(b.dot.byName["N1"], "peripheries"); got != "2" {
+		t.Errorf("got peripheries %q, want 2", got)
+	}
+	if got := b.dot.attr(b.dot.byName["N2"], "fillcolor"); got == "#f8f8f8" || got == "" {
+		t.Errorf("got fillcolor %q, want the one of the node", got)
+	}
+	if got := b.dot.edgeAttr(b.dot.edges[2], "style"); got != "dotted" {
+		t.Errorf("got residual edge style %q, want dotted", got)
+	}
+}
+
+func TestComposeSVG(t *testing.T) {
+	g := baseGraph()
+	a, c := baseAttrsAndConfig()
+	var buf bytes.Buffer
+	if err := ComposeSVG(&buf, g, a, c); err != nil {
+		t.Fatalf("ComposeSVG: %v", err)
+	}
+	checkSVG(t, "base graph", buf.Bytes(), len(g.Nodes))
+	for _, want := range []string{
+		`fill="#edddd5" stroke="#b23c00"`,
+		`font-size="22.00">src</text>`,
+		`text-anchor="start"`,
+		`>label3: &#34;foo&#34;</text>`,
+		`xlink:title="src -&gt; dest (10)"`,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+}
+
+func TestComposeSVGVariants(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		modify func(g *Graph, a *DotAttributes, c *DotConfig)
+	}{
+		{"empty graph", func(g *Graph, a *DotAttributes, c *DotConfig) { g.Nodes = nil }},
+		{"names that need escaping", func(g *Graph, a *DotAttributes, c *DotConfig) {
+			g.Nodes[0].Info = NodeInfo{Name: `var"src"`}
+			g.Nodes[1].Info = NodeInfo{Name: `var"#dest#"`}
+		}},
+		{"comments with newlines", func(g *Graph, a *DotAttributes, c *DotConfig) {
+			c.Labels = []string{"comment line 1\ncomment line 2 \"unterminated double quote", `second comment "double quote"`}
+		}},
+		{"legend URL", func(g *Graph, a *DotAttributes, c *DotConfig) { c.LegendURL = "http://example.com" }},
+		{"node attributes", func(g *Graph, a *DotAttributes, c *DotConfig) {
+			a.Nodes[g.Nodes[0]] = &DotNodeAttributes{Shape: "folder", Bold: true, Peripheries: 2, URL: "www.google.com"}
+			g.Nodes[1].Flat = 0
+		}},
+	} {
+		g := baseGraph()
+		a, c := baseAttrsAndConfig()
+		tc.modify(g, a, c)
+		var buf bytes.Buffer
+		if err := ComposeSVG(&buf, g, a, c); err != nil {
+			t.Errorf("%s: ComposeSVG: %v", tc.desc, err)
+			continue
+		}
+		checkSVG(t, tc.desc, buf.Bytes(), len(g.Nodes))
+	}
+}
+
+var svgHeader = regexp.MustCompile(`<svg\s*width="[^"]+"\s*height="[^"]+"\s*viewBox="[^"]+"`)
+
+func checkSVG(t *testing.T, name string, svg []byte, nodes int) {
+	t.Helper()
+	d := xml.NewDecoder(bytes.NewReader(svg))
+	d.Strict = true
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("%s: invalid XML: %v", name, err)
+			return
+		}
+	}
+	if !svgHeader.Match(svg) || !bytes.Contains(svg, []byte(`<g id="graph0"`)) {
+		t.Errorf("%s: output does not look like Graphviz SVG:\n%s", name, svg)
+	}
+	if got := bytes.Count(svg, []byte(`<g id="node`)); got != nodes {
+		t.Errorf("%s: got %d node groups, want %d", name, got, nodes)
+	}
+}
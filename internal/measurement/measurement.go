@@ -261,11 +261,11 @@ func (ut unitType) convertUnit(value int64, fromUnitStr, toUnitStr string) (floa
 var unitTypes = []unitType{{
 	units: []unit{
 		{"B", []string{"b", "byte"}, 1},
-		{"kB", []string{"kb", "kbyte", "kilobyte"}, float64(1 << 10)},
-		{"MB", []string{"mb", "mbyte", "megabyte"}, float64(1 << 20)},
-		{"GB", []string{"gb", "gbyte", "gigabyte"}, float64(1 << 30)},
-		{"TB", []string{"tb", "tbyte", "terabyte"}, float64(1 << 40)},
-		{"PB", []string{"pb", "pbyte", "petabyte"}, float64(1 << 50)},
+		{"kB", []string{"kb", "kbyte", "kilobyte", "kib", "kibibyte"}, float64(1 << 10)},
+		{"MB", []string{"mb", "mbyte", "megabyte", "mib", "mebibyte"}, float64(1 << 20)},
+		{"GB", []string{"gb", "gbyte", "gigabyte", "gib", "gibibyte"}, float64(1 << 30)},
+		{"TB", []string{"tb", "tbyte", "terabyte", "tib", "tebibyte"}, float64(1 << 40)},
+		{"PB", []string{"pb", "pbyte", "petabyte", "pib", "pebibyte"}, float64(1 << 50)},
 	},
 	defaultUnit: unit{"B", []string{"b", "byte"}, 1},
 }, {
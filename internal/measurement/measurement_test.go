@@ -32,6 +32,7 @@ func TestScale(t *testing.T) {
 		{1, "kilobyte", "b", 1024, "B"},
 		{1, "mb", "kb", 1024, "kB"},
 		{1, "gb", "mb", 1024, "MB"},
+		{2, "GiB", "MiB", 2048, "MB"},
 		{1024, "gb", "tb", 1, "TB"},
 		{1024, "tb", "pb", 1, "PB"},
 		{2048, "mb", "auto", 2, "GB"},
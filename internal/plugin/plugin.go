@@ -43,6 +43,11 @@ type Options struct {
 	// authentication checks.
 	HTTPServer    func(args *HTTPServerArgs) error
 	HTTPTransport http.RoundTripper
+
+	// HTTPFetch configures the HTTP requests fetching profiles and
+	// symbols through HTTPTransport. Its proxy only applies to the
+	// default HTTPTransport.
+	HTTPFetch *HTTPFetchOptions
 }
 
 // Writer provides a mechanism to write data under a certain name,
@@ -212,6 +217,31 @@ type HTTPServerArgs struct {
 	Port int    // Port portion of Hostport
 
 	// Handlers maps from URL paths to the handler to invoke to
-	// serve that path.
+	// serve that path. A path ending in a slash, other than "/", also
+	// serves the paths below it, as for http.ServeMux patterns.
 	Handlers map[string]http.Handler
+
+	// TLSCertFile and TLSKeyFile name the certificate and private key to
+	// serve HTTPS with, if set (derived from flags).
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// HTTPFetchOptions configures the HTTP requests fetching profiles and
+// symbols. The flags of the command line take precedence over them.
+type HTTPFetchOptions struct {
+	// Header holds headers added to the requests, such as Authorization.
+	// They are not sent to other hosts the requests are redirected to.
+	Header http.Header
+
+	// Proxy is the URL of the proxy of the requests. If empty, the
+	// proxy is taken from the environment, as by http.ProxyFromEnvironment.
+	Proxy string
+
+	// Retries is the number of times a request failing with a timeout or
+	// a 5xx status is retried, waiting RetryDelay before the first retry
+	// and twice as long before each next one. RetryDelay defaults to one
+	// second.
+	Retries    int
+	RetryDelay time.Duration
 }
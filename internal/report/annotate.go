@@ -0,0 +1,336 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lineAnnotator annotates lines of source listings with the last commit
+// touching them, as reported by git blame, and with their coverage in a
+// Go coverage profile. A nil *lineAnnotator provides no annotations.
+type lineAnnotator struct {
+	reader *sourceReader // Locates source files.
+	blame  bool
+
+	// blames holds the commits touching each line per file, nil if git
+	// blame failed for the file.
+	blames map[string]map[int]*blameCommit
+
+	// coverage holds the blocks of the coverage profile per file name
+	// in the coverage profile, and covered the coverage of each line per
+	// file, computed from it on demand.
+	coverage map[string][]coverBlock
+	covered  map[string]map[int]bool
+}
+
+// lineAnnotation holds the annotations of a source line. Empty fields are
+// not known.
+type lineAnnotation struct {
+	commit   string // Last commit touching the line, e.g. "1a2b3c4d alice 2023-05-01".
+	coverage string // "covered" or "not covered".
+}
+
+// blameCommit is a commit reported by git blame.
+type blameCommit struct {
+	hash   string
+	author string
+	time   time.Time
+}
+
+// coverBlock is a block of statements in a Go coverage profile.
+type coverBlock struct {
+	startLine, endLine int
+	count              int64
+}
+
+// newLineAnnotator returns an annotator providing the annotations selected
+// by o, or nil if none are.
+func newLineAnnotator(reader *sourceReader, o *Options) (*lineAnnotator, error) {
+	if !o.Blame && o.CoverProfile == "" {
+		return nil, nil
+	}
+	a := &lineAnnotator{
+		reader:  reader,
+		blame:   o.Blame,
+		blames:  map[string]map[int]*blameCommit{},
+		covered: map[string]map[int]bool{},
+	}
+	if o.CoverProfile != "" {
+		f, err := os.Open(o.CoverProfile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if a.coverage, err = parseCoverProfile(f); err != nil {
+			return nil, fmt.Errorf("%s: %v", o.CoverProfile, err)
+		}
+	}
+	return a, nil
+}
+
+// annotate returns the annotations of line lineno of the named source file.
+func (a *lineAnnotator) annotate(file string, lineno int) lineAnnotation {
+	var l lineAnnotation
+	if a == nil {
+		return l
+	}
+	if a.blame {
+		blames, ok := a.blames[file]
+		if !ok {
+			blames = a.gitBlame(file)
+			a.blames[file] = blames
+		}
+		if c := blames[lineno]; c != nil {
+			l.commit = c.String()
+		}
+	}
+	if a.coverage != nil {
+		covered, ok := a.covered[file]
+		if !ok {
+			covered = a.lineCoverage(file)
+			a.covered[file] = covered
+		}
+		if c, ok := covered[lineno]; ok {
+			l.coverage = "not covered"
+			if c {
+				l.coverage = "covered"
+			}
+		}
+	}
+	return l
+}
+
+// gitBlame returns the commits touching each line of the named source
+// file, or nil if they cannot be determined.
+func (a *lineAnnotator) gitBlame(file string) map[int]*blameCommit {
+	path, err := a.reader.resolve(file)
+	if err != nil {
+		return nil
+	}
+	cmd := exec.Command("git", "blame", "--porcelain", "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	blames, err := parseBlame(bytes.NewReader(out))
+	if err != nil {
+		return nil
+	}
+	return blames
+}
+
+// lineCoverage returns the coverage of the lines of the named source file
+// in the coverage profile. Lines missing from the result are not part of
+// any block.
+func (a *lineAnnotator) lineCoverage(file string) map[int]bool {
+	covered := map[int]bool{}
+	for _, b := range a.coverage[coverFile(file, a.coverage)] {
+		for l := b.startLine; l <= b.endLine; l++ {
+			covered[l] = covered[l] || b.count > 0
+		}
+	}
+	return covered
+}
+
+// coverFile returns the name in the coverage profile of the named source
+// file. File names in coverage profiles start with an import path rather
+// than a directory, so the name sharing the most trailing path elements
+// with file is picked. At least the directory and file name must match.
+func coverFile(file string, coverage map[string][]coverBlock) string {
+	elems := strings.Split(filepath.ToSlash(file), "/")
+	best, bestCount := "", 1
+	if len(elems) == 1 {
+		bestCount = 0
+	}
+	for name := range coverage {
+		names := strings.Split(name, "/")
+		count := 0
+		for count < len(elems) && count < len(names) &&
+			elems[len(elems)-1-count] == names[len(names)-1-count] {
+			count++
+		}
+		if count > bestCount || (count == bestCount && count > 0 && name < best) {
+			best, bestCount = name, count
+		}
+	}
+	return best
+}
+
+// String returns the annotations of a line for text reports, e.g.
+// "[1a2b3c4d alice 2023-05-01, covered]", or "" if there are none.
+func (l lineAnnotation) String() string {
+	var parts []string
+	for _, p := range []string{l.commit, l.coverage} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// HTML returns the annotations of a line for HTML reports.
+func (l lineAnnotation) HTML() string {
+	var parts []string
+	if l.commit != "" {
+		parts = append(parts, `<span class=blame>`+template.HTMLEscapeString(l.commit)+`</span>`)
+	}
+	switch l.coverage {
+	case "covered":
+		parts = append(parts, `<span class=covered>covered</span>`)
+	case "not covered":
+		parts = append(parts, `<span class=uncovered>not covered</span>`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return `<span class=annotation>[` + strings.Join(parts, ", ") + `]</span>`
+}
+
+// String returns a short description of the commit, made of its abbreviated
+// hash, its author and its date.
+func (c *blameCommit) String() string {
+	if strings.Trim(c.hash, "0") == "" {
+		return "not committed"
+	}
+	hash := c.hash
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	return fmt.Sprintf("%s %s %s", hash, c.author, c.time.UTC().Format("2006-01-02"))
+}
+
+// parseBlame parses the output of git blame --porcelain and returns the
+// commit touching each line.
+func parseBlame(r io.Reader) (map[int]*blameCommit, error) {
+	commits := map[string]*blameCommit{}
+	lines := map[int]*blameCommit{}
+	var current *blameCommit
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "\t") {
+			// Contents of the line, which end the entry.
+			current = nil
+			continue
+		}
+		if current == nil {
+			// Entry header: <hash> <original line> <final line> [<lines in group>].
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("unexpected blame entry %q", line)
+			}
+			lineno, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("unexpected blame entry %q", line)
+			}
+			hash := fields[0]
+			if current = commits[hash]; current == nil {
+				current = &blameCommit{hash: hash}
+				commits[hash] = current
+			}
+			lines[lineno] = current
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "author":
+			current.author = value
+		case "author-time":
+			secs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected blame author time %q", value)
+			}
+			current.time = time.Unix(secs, 0)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// parseCoverProfile parses a coverage profile written by go test
+// -coverprofile and returns its blocks per file.
+func parseCoverProfile(r io.Reader) (map[string][]coverBlock, error) {
+	blocks := map[string][]coverBlock{}
+	s := bufio.NewScanner(r)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || (lineno == 1 && strings.HasPrefix(line, "mode:")) {
+			continue
+		}
+		// Entry format: <file>:<line>.<column>,<line>.<column> <statements> <count>
+		b, file, err := parseCoverBlock(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		blocks[file] = append(blocks[file], b)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no coverage blocks found")
+	}
+	return blocks, nil
+}
+
+func parseCoverBlock(line string) (coverBlock, string, error) {
+	bad := fmt.Errorf("unexpected coverage entry %q", line)
+	colon := strings.LastIndex(line, ":")
+	if colon < 0 {
+		return coverBlock{}, "", bad
+	}
+	file := line[:colon]
+	fields := strings.Fields(line[colon+1:])
+	if len(fields) != 3 {
+		return coverBlock{}, "", bad
+	}
+	start, end, ok := strings.Cut(fields[0], ",")
+	if !ok {
+		return coverBlock{}, "", bad
+	}
+	// Only the lines of the positions matter.
+	startLine, _, _ := strings.Cut(start, ".")
+	endLine, _, _ := strings.Cut(end, ".")
+	var b coverBlock
+	var err error
+	if b.startLine, err = strconv.Atoi(startLine); err != nil {
+		return coverBlock{}, "", bad
+	}
+	if b.endLine, err = strconv.Atoi(endLine); err != nil {
+		return coverBlock{}, "", bad
+	}
+	if b.count, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return coverBlock{}, "", bad
+	}
+	return b, file, nil
+}
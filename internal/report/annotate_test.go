@@ -0,0 +1,174 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestParseBlame(t *testing.T) {
+	const blame = "0123456789abcdef0123456789abcdef01234567 1 1 2\n" +
+		"author Alice\n" +
+		"author-mail <alice@example.com>\n" +
+		"author-time 1683000000\n" +
+		"author-tz +0000\n" +
+		"summary Add foo\n" +
+		"filename foo.go\n" +
+		"\tpackage foo\n" +
+		"0123456789abcdef0123456789abcdef01234567 2 2\n" +
+		"\t\n" +
+		"0000000000000000000000000000000000000000 3 3 1\n" +
+		"author Not Committed Yet\n" +
+		"author-time 1700000000\n" +
+		"filename foo.go\n" +
+		"\tfunc foo() {}\n"
+	lines, err := parseBlame(strings.NewReader(blame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for lineno, want := range map[int]string{
+		1: "01234567 Alice 2023-05-02",
+		2: "01234567 Alice 2023-05-02",
+		3: "not committed",
+	} {
+		if c := lines[lineno]; c == nil || c.String() != want {
+			t.Errorf("line %d: got %v, want %s", lineno, c, want)
+		}
+	}
+	if len(lines) != 3 {
+		t.Errorf("got %d lines, want 3", len(lines))
+	}
+}
+
+func TestParseCoverProfile(t *testing.T) {
+	const cover = `mode: set
+example.com/mod/foo/foo.go:3.13,5.2 1 1
+example.com/mod/foo/foo.go:7.13,9.16 1 0
+example.com/mod/foo/foo.go:9.16,11.3 1 1
+example.com/mod/bar/foo.go:3.13,5.2 1 0
+`
+	coverage, err := parseCoverProfile(strings.NewReader(cover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &lineAnnotator{coverage: coverage, covered: map[string]map[int]bool{}}
+	for _, tc := range []struct {
+		file   string
+		lineno int
+		want   string
+	}{
+		{"/src/mod/foo/foo.go", 4, "covered"},
+		{"/src/mod/foo/foo.go", 6, ""},
+		{"/src/mod/foo/foo.go", 8, "not covered"},
+		{"/src/mod/foo/foo.go", 9, "covered"}, // Shared by two blocks.
+		{"/src/mod/bar/foo.go", 4, "not covered"},
+		{"/src/other/foo.go", 4, ""}, // Only the file name matches.
+	} {
+		if got := a.annotate(tc.file, tc.lineno).coverage; got != tc.want {
+			t.Errorf("%s:%d: got coverage %q, want %q", tc.file, tc.lineno, got, tc.want)
+		}
+	}
+
+	if _, err := parseCoverProfile(strings.NewReader("mode: set\nfoo.go:1.1 1 1\n")); err == nil {
+		t.Error("parsing bad coverage entry: got no error")
+	}
+}
+
+func TestAnnotatedSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	const source = "package foo\n\nfunc foo() {\n\tbar()\n}\n\nfunc bar() {\n\tfor {\n\t}\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "foo.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "foo.go"},
+		{"commit", "-q", "-m", "Add foo"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_AUTHOR_DATE=2023-05-02T12:00:00Z",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	coverProfile := filepath.Join(dir, "cover.out")
+	const cover = "mode: set\nexample.com/foo/foo.go:3.12,5.2 1 1\nexample.com/foo/foo.go:7.12,8.6 1 0\n"
+	if err := os.WriteFile(coverProfile, []byte(cover), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	foo := &profile.Function{ID: 1, Name: "foo", Filename: "/build/foo/foo.go", StartLine: 3}
+	bar := &profile.Function{ID: 2, Name: "bar", Filename: "/build/foo/foo.go", StartLine: 7}
+	fooLoc := &profile.Location{ID: 1, Address: 1, Line: []profile.Line{{Function: foo, Line: 4}}}
+	barLoc := &profile.Location{ID: 2, Address: 2, Line: []profile.Line{{Function: bar, Line: 8}}}
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Value: []int64{10}, Location: []*profile.Location{barLoc, fooLoc}},
+		},
+		Location: []*profile.Location{fooLoc, barLoc},
+		Function: []*profile.Function{foo, bar},
+	}
+
+	for _, format := range []int{List, WebList} {
+		rpt := &Report{
+			prof: prof,
+			options: &Options{
+				OutputFormat: format,
+				Symbol:       regexp.MustCompile("foo|bar"),
+				SampleValue:  func(s []int64) int64 { return s[0] },
+				SourcePath:   dir,
+				TrimPath:     "/build/foo",
+				Blame:        true,
+				CoverProfile: coverProfile,
+			},
+			formatValue: func(v int64) string { return fmt.Sprint(v) },
+		}
+		var out bytes.Buffer
+		if err := Generate(&out, rpt, nil); err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		got := out.String()
+		for _, want := range []string{
+			`\bbar\(\) +(<span class=annotation>)?\[(<span class=blame>)?[0-9a-f]{8} Alice 2023-05-02(</span>)?, (<span class=covered>)?covered\b`,
+			`\bfor \{ +(<span class=annotation>)?\[(<span class=blame>)?[0-9a-f]{8} Alice 2023-05-02(</span>)?, (<span class=uncovered>)?not covered\b`,
+		} {
+			if match, _ := regexp.MatchString(want, got); !match {
+				t.Errorf("format %d: output does not match %q:\n%s", format, want, got)
+			}
+		}
+		// Lines without samples are not annotated.
+		if strings.Contains(got, "func foo() {  ") {
+			t.Errorf("format %d: line without samples was annotated:\n%s", format, got)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements the output of profiles in the processed profile
+// format of the Firefox Profiler (https://profiler.firefox.com), version
+// 47. The format is documented in src/types/profile.js of
+// https://github.com/firefox-devtools/profiler.
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+const geckoProfileVersion = 47
+
+type geckoProfile struct {
+	Meta     geckoMeta      `json:"meta"`
+	Libs     []interface{}  `json:"libs"`
+	Pages    []interface{}  `json:"pages"`
+	Counters []interface{}  `json:"counters"`
+	Threads  []*geckoThread `json:"threads"`
+}
+
+type geckoMeta struct {
+	Interval                   float64         `json:"interval"`
+	StartTime                  float64         `json:"startTime"`
+	ProcessType                int             `json:"processType"`
+	Product                    string          `json:"product"`
+	Stackwalk                  int             `json:"stackwalk"`
+	Version                    int             `json:"version"`
+	PreprocessedProfileVersion int             `json:"preprocessedProfileVersion"`
+	Symbolicated               bool            `json:"symbolicated"`
+	Categories                 []geckoCategory `json:"categories"`
+	MarkerSchema               []interface{}   `json:"markerSchema"`
+}
+
+type geckoCategory struct {
+	Name          string   `json:"name"`
+	Color         string   `json:"color"`
+	Subcategories []string `json:"subcategories"`
+}
+
+type geckoThread struct {
+	ProcessType         string        `json:"processType"`
+	ProcessStartupTime  float64       `json:"processStartupTime"`
+	ProcessShutdownTime *float64      `json:"processShutdownTime"`
+	RegisterTime        float64       `json:"registerTime"`
+	UnregisterTime      *float64      `json:"unregisterTime"`
+	PausedRanges        []interface{} `json:"pausedRanges"`
+	Name                string        `json:"name"`
+	IsMainThread        bool          `json:"isMainThread"`
+	Pid                 string        `json:"pid"`
+	Tid                 int           `json:"tid"`
+
+	Samples       geckoSamples       `json:"samples"`
+	Markers       geckoMarkers       `json:"markers"`
+	StackTable    geckoStackTable    `json:"stackTable"`
+	FrameTable    geckoFrameTable    `json:"frameTable"`
+	FuncTable     geckoFuncTable     `json:"funcTable"`
+	ResourceTable geckoResourceTable `json:"resourceTable"`
+	NativeSymbols geckoNativeSymbols `json:"nativeSymbols"`
+	StringArray   []string           `json:"stringArray"`
+
+	total   int64
+	strings map[string]int
+	funcs   map[geckoFuncKey]int
+	frames  map[geckoFrameKey]int
+	stacks  map[[2]int]int
+}
+
+type geckoSamples struct {
+	Length     int       `json:"length"`
+	Stack      []int     `json:"stack"`
+	Time       []float64 `json:"time"`
+	Weight     []float64 `json:"weight"`
+	WeightType string    `json:"weightType"`
+}
+
+type geckoMarkers struct {
+	Length    int           `json:"length"`
+	Category  []int         `json:"category"`
+	Data      []interface{} `json:"data"`
+	EndTime   []float64     `json:"endTime"`
+	Name      []int         `json:"name"`
+	Phase     []int         `json:"phase"`
+	StartTime []float64     `json:"startTime"`
+}
+
+type geckoStackTable struct {
+	Length      int    `json:"length"`
+	Frame       []int  `json:"frame"`
+	Prefix      []*int `json:"prefix"`
+	Category    []int  `json:"category"`
+	Subcategory []int  `json:"subcategory"`
+}
+
+type geckoFrameTable struct {
+	Length         int      `json:"length"`
+	Address        []int64  `json:"address"`
+	InlineDepth    []int    `json:"inlineDepth"`
+	Category       []int    `json:"category"`
+	Subcategory    []int    `json:"subcategory"`
+	Func           []int    `json:"func"`
+	NativeSymbol   []*int   `json:"nativeSymbol"`
+	InnerWindowID  []*int   `json:"innerWindowID"`
+	Implementation []*int   `json:"implementation"`
+	Line           []*int64 `json:"line"`
+	Column         []*int64 `json:"column"`
+}
+
+type geckoFuncTable struct {
+	Length        int      `json:"length"`
+	Name          []int    `json:"name"`
+	IsJS          []bool   `json:"isJS"`
+	RelevantForJS []bool   `json:"relevantForJS"`
+	Resource      []int    `json:"resource"`
+	FileName      []*int   `json:"fileName"`
+	LineNumber    []*int64 `json:"lineNumber"`
+	ColumnNumber  []*int64 `json:"columnNumber"`
+}
+
+type geckoResourceTable struct {
+	Length int   `json:"length"`
+	Lib    []int `json:"lib"`
+	Name   []int `json:"name"`
+	Host   []int `json:"host"`
+	Type   []int `json:"type"`
+}
+
+type geckoNativeSymbols struct {
+	Length       int     `json:"length"`
+	LibIndex     []int   `json:"libIndex"`
+	Address      []int64 `json:"address"`
+	Name         []int   `json:"name"`
+	FunctionSize []*int  `json:"functionSize"`
+}
+
+type geckoFuncKey struct {
+	name, file string
+	startLine  int64
+}
+
+type geckoFrameKey struct {
+	fn          int
+	address     uint64
+	line        int64
+	inlineDepth int
+}
+
+// geckoSample is a sample pending to be added to a thread.
+type geckoSample struct {
+	time   float64
+	stack  int
+	weight float64
+}
+
+func newGeckoThread(name string) *geckoThread {
+	return &geckoThread{
+		ProcessType:  "default",
+		PausedRanges: []interface{}{},
+		Name:         name,
+		Pid:          "0",
+		Samples: geckoSamples{
+			Stack:  []int{},
+			Time:   []float64{},
+			Weight: []float64{},
+		},
+		Markers: geckoMarkers{
+			Category:  []int{},
+			Data:      []interface{}{},
+			EndTime:   []float64{},
+			Name:      []int{},
+			Phase:     []int{},
+			StartTime: []float64{},
+		},
+		StackTable: geckoStackTable{
+			Frame:       []int{},
+			Prefix:      []*int{},
+			Category:    []int{},
+			Subcategory: []int{},
+		},
+		FrameTable: geckoFrameTable{
+			Address:        []int64{},
+			InlineDepth:    []int{},
+			Category:       []int{},
+			Subcategory:    []int{},
+			Func:           []int{},
+			NativeSymbol:   []*int{},
+			InnerWindowID:  []*int{},
+			Implementation: []*int{},
+			Line:           []*int64{},
+			Column:         []*int64{},
+		},
+		FuncTable: geckoFuncTable{
+			Name:          []int{},
+			IsJS:          []bool{},
+			RelevantForJS: []bool{},
+			Resource:      []int{},
+			FileName:      []*int{},
+			LineNumber:    []*int64{},
+			ColumnNumber:  []*int64{},
+		},
+		ResourceTable: geckoResourceTable{
+			Lib:  []int{},
+			Name: []int{},
+			Host: []int{},
+			Type: []int{},
+		},
+		NativeSymbols: geckoNativeSymbols{
+			LibIndex:     []int{},
+			Address:      []int64{},
+			Name:         []int{},
+			FunctionSize: []*int{},
+		},
+		StringArray: []string{},
+		strings:     make(map[string]int),
+		funcs:       make(map[geckoFuncKey]int),
+		frames:      make(map[geckoFrameKey]int),
+		stacks:      make(map[[2]int]int),
+	}
+}
+
+func (t *geckoThread) str(s string) int {
+	if i, ok := t.strings[s]; ok {
+		return i
+	}
+	i := len(t.StringArray)
+	t.StringArray = append(t.StringArray, s)
+	t.strings[s] = i
+	return i
+}
+
+// nonZero returns a pointer to v, or nil if v is zero, which the
+// Firefox Profiler uses for unknown values.
+func nonZero(v int64) *int64 {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+func (t *geckoThread) function(fn *profile.Function) int {
+	k := geckoFuncKey{name: "??"}
+	if fn != nil {
+		k = geckoFuncKey{fn.Name, fn.Filename, fn.StartLine}
+	}
+	if i, ok := t.funcs[k]; ok {
+		return i
+	}
+	ft := &t.FuncTable
+	i := ft.Length
+	ft.Name = append(ft.Name, t.str(k.name))
+	ft.IsJS = append(ft.IsJS, false)
+	ft.RelevantForJS = append(ft.RelevantForJS, false)
+	ft.Resource = append(ft.Resource, -1)
+	var file *int
+	if k.file != "" {
+		f := t.str(k.file)
+		file = &f
+	}
+	ft.FileName = append(ft.FileName, file)
+	ft.LineNumber = append(ft.LineNumber, nonZero(k.startLine))
+	ft.ColumnNumber = append(ft.ColumnNumber, nil)
+	ft.Length++
+	t.funcs[k] = i
+	return i
+}
+
+func (t *geckoThread) frame(loc *profile.Location, line profile.Line, inlineDepth int) int {
+	fn := t.function(line.Function)
+	k := geckoFrameKey{fn, loc.Address, line.Line, inlineDepth}
+	if i, ok := t.frames[k]; ok {
+		return i
+	}
+	ft := &t.FrameTable
+	i := ft.Length
+	addr := int64(-1)
+	if loc.Address != 0 {
+		addr = int64(loc.Address)
+	}
+	ft.Address = append(ft.Address, addr)
+	ft.InlineDepth = append(ft.InlineDepth, inlineDepth)
+	ft.Category = append(ft.Category, 0)
+	ft.Subcategory = append(ft.Subcategory, 0)
+	ft.Func = append(ft.Func, fn)
+	ft.NativeSymbol = append(ft.NativeSymbol, nil)
+	ft.InnerWindowID = append(ft.InnerWindowID, nil)
+	ft.Implementation = append(ft.Implementation, nil)
+	ft.Line = append(ft.Line, nonZero(line.Line))
+	ft.Column = append(ft.Column, nonZero(line.Column))
+	ft.Length++
+	t.frames[k] = i
+	return i
+}
+
+// stack returns the index of the stack for a sample, adding the
+// stacks for all its prefixes as needed. It returns -1 for samples
+// without locations.
+func (t *geckoThread) stack(s *profile.Sample) int {
+	prefix := -1
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		loc := s.Location[i]
+		for j := len(loc.Line) - 1; j >= 0; j-- {
+			frame := t.frame(loc, loc.Line[j], len(loc.Line)-1-j)
+			k := [2]int{prefix, frame}
+			if st, ok := t.stacks[k]; ok {
+				prefix = st
+				continue
+			}
+			st := &t.StackTable
+			var p *int
+			if prefix >= 0 {
+				p = new(int)
+				*p = prefix
+			}
+			st.Frame = append(st.Frame, frame)
+			st.Prefix = append(st.Prefix, p)
+			st.Category = append(st.Category, 0)
+			st.Subcategory = append(st.Subcategory, 0)
+			t.stacks[k] = st.Length
+			prefix = st.Length
+			st.Length++
+		}
+	}
+	return prefix
+}
+
+// printGecko writes the profile in the processed profile format of the
+// Firefox Profiler. Samples are split into threads according to the
+// value of the ThreadLabel label, and ordered by the value of the
+// TimeLabel numeric label, taken to be in nanoseconds, if present.
+func printGecko(w io.Writer, rpt *Report) error {
+	prof := rpt.prof
+	o := rpt.options
+
+	// Time-based sample values are reported as durations in
+	// milliseconds, other values as counts.
+	weightType, weightUnit := "samples", ""
+	switch _, unit := measurement.Scale(1, o.SampleUnit, "ms"); {
+	case unit == "ms":
+		weightType, weightUnit = "tracing-ms", "ms"
+	case o.SampleUnit == "bytes":
+		weightType = "bytes"
+	}
+	weight := func(v int64) float64 {
+		f := float64(v)
+		if o.Ratio > 0 {
+			f *= o.Ratio
+		}
+		if weightUnit != "" {
+			f, _ = measurement.Scale(int64(f), o.SampleUnit, weightUnit)
+		}
+		return f
+	}
+
+	// Without timestamps, space samples out by the sampling period.
+	interval := 1.0
+	if prof.PeriodType != nil && prof.Period > 0 {
+		if v, unit := measurement.Scale(prof.Period, prof.PeriodType.Unit, "ms"); unit == "ms" {
+			interval = v
+		}
+	}
+
+	threads := make(map[string]*geckoThread)
+	pending := make(map[*geckoThread][]geckoSample)
+	var order []*geckoThread
+	for i, s := range prof.Sample {
+		v := o.SampleValue(s.Value)
+		if v == 0 {
+			continue
+		}
+		name := geckoThreadName(s, o.ThreadLabel)
+		t := threads[name]
+		if t == nil {
+			t = newGeckoThread(name)
+			threads[name] = t
+			order = append(order, t)
+		}
+		stack := t.stack(s)
+		if stack < 0 {
+			continue
+		}
+		t.total += abs64(v)
+
+		time := float64(i) * interval
+		if ts := s.NumLabel[o.TimeLabel]; o.TimeLabel != "" && len(ts) > 0 {
+			time = float64(ts[0]-prof.TimeNanos) / 1e6
+		}
+		pending[t] = append(pending[t], geckoSample{time, stack, weight(v)})
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].total > order[j].total
+	})
+	for i, t := range order {
+		t.Tid = i
+		t.IsMainThread = i == 0
+		samples := pending[t]
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].time < samples[j].time
+		})
+		for _, s := range samples {
+			t.Samples.Stack = append(t.Samples.Stack, s.stack)
+			t.Samples.Time = append(t.Samples.Time, s.time)
+			t.Samples.Weight = append(t.Samples.Weight, s.weight)
+		}
+		t.Samples.Length = len(samples)
+		t.Samples.WeightType = weightType
+	}
+
+	gp := &geckoProfile{
+		Meta: geckoMeta{
+			Interval:                   interval,
+			StartTime:                  float64(prof.TimeNanos) / 1e6,
+			Product:                    "pprof",
+			Stackwalk:                  1,
+			Version:                    27,
+			PreprocessedProfileVersion: geckoProfileVersion,
+			Symbolicated:               true,
+			Categories: []geckoCategory{
+				{Name: "Other", Color: "grey", Subcategories: []string{"Other"}},
+			},
+			MarkerSchema: []interface{}{},
+		},
+		Libs:     []interface{}{},
+		Pages:    []interface{}{},
+		Counters: []interface{}{},
+		Threads:  order,
+	}
+	if o.Title != "" {
+		gp.Meta.Product = o.Title
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(gp)
+}
+
+// geckoThreadName returns the name of the thread for a sample, taken
+// from the value of the given label.
+func geckoThreadName(s *profile.Sample, label string) string {
+	if label == "" {
+		return "main"
+	}
+	if v := s.Label[label]; len(v) > 0 {
+		return v[0]
+	}
+	if v := s.NumLabel[label]; len(v) > 0 {
+		return label + " " + strconv.FormatInt(v[0], 10)
+	}
+	return fmt.Sprintf("(no %s)", label)
+}
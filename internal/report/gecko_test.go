@@ -0,0 +1,120 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestGecko(t *testing.T) {
+	// See report_test.go for the functions available to use in tests.
+	main, foo, bar, tee := testL[0], testL[1], testL[2], testL[3]
+	sample := func(value int64, thread string, ts int64, locs ...*profile.Location) *profile.Sample {
+		s := testSample(value, locs...)
+		s.Label = map[string][]string{"thread": {thread}}
+		s.NumLabel = map[string][]int64{"timestamp": {ts}}
+		return s
+	}
+	prof := makeTestProfile(
+		sample(10, "worker", 3e6, bar, foo, main),
+		sample(20, "main", 2e6, tee, main),
+		sample(30, "worker", 1e6, foo, main),
+		sample(40, "worker", 5e6, bar, foo, main),
+	)
+	rpt := NewDefault(prof, Options{
+		OutputFormat: Gecko,
+		ThreadLabel:  "thread",
+		TimeLabel:    "timestamp",
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	var got struct {
+		Meta struct {
+			PreprocessedProfileVersion int
+		}
+		Threads []struct {
+			Name         string
+			IsMainThread bool
+			Samples      struct {
+				Length int
+				Stack  []int
+				Time   []float64
+				Weight []float64
+			}
+			StackTable struct {
+				Frame  []int
+				Prefix []*int
+			}
+			FrameTable struct {
+				Func []int
+			}
+			FuncTable struct {
+				Name []int
+			}
+			StringArray []string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if got.Meta.PreprocessedProfileVersion != geckoProfileVersion {
+		t.Errorf("got version %d, want %d", got.Meta.PreprocessedProfileVersion, geckoProfileVersion)
+	}
+	if len(got.Threads) != 2 {
+		t.Fatalf("got %d threads, want 2", len(got.Threads))
+	}
+	worker := got.Threads[0]
+	if worker.Name != "worker" || !worker.IsMainThread || got.Threads[1].Name != "main" {
+		t.Errorf("got threads %q, %q; want worker first", worker.Name, got.Threads[1].Name)
+	}
+	if want := []float64{1, 3, 5}; !reflect.DeepEqual(worker.Samples.Time, want) {
+		t.Errorf("got times %v, want %v", worker.Samples.Time, want)
+	}
+	if want := []float64{30, 10, 40}; !reflect.DeepEqual(worker.Samples.Weight, want) {
+		t.Errorf("got weights %v, want %v", worker.Samples.Weight, want)
+	}
+
+	// Walk the stacks back to the root to check the function names.
+	stackNames := func(stack int) []string {
+		var names []string
+		for stack >= 0 {
+			fn := worker.FrameTable.Func[worker.StackTable.Frame[stack]]
+			names = append([]string{worker.StringArray[worker.FuncTable.Name[fn]]}, names...)
+			p := worker.StackTable.Prefix[stack]
+			if p == nil {
+				break
+			}
+			stack = *p
+		}
+		return names
+	}
+	wantStacks := [][]string{{"main", "foo"}, {"main", "foo", "bar"}, {"main", "foo", "bar"}}
+	for i, stack := range worker.Samples.Stack {
+		if got := stackNames(stack); !reflect.DeepEqual(got, wantStacks[i]) {
+			t.Errorf("sample %d: got stack %v, want %v", i, got, wantStacks[i])
+		}
+	}
+	if worker.Samples.Stack[1] != worker.Samples.Stack[2] {
+		t.Errorf("identical stacks were not shared: %v", worker.Samples.Stack)
+	}
+}
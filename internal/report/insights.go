@@ -0,0 +1,383 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// An InsightRule finds insights about the cost of a report: patterns worth
+// a look, such as a function spending much of its time waiting for locks.
+type InsightRule interface {
+	// Name identifies the rule in the report.
+	Name() string
+	// Insights returns the findings of the rule.
+	Insights(d *InsightData) []Insight
+}
+
+// InsightData is the data analyzed by the insight rules.
+type InsightData struct {
+	Profile *profile.Profile
+	Graph   *graph.Graph // Graph of the report, with all its nodes.
+	Items   []TextItem   // Text items of the nodes of Graph, in the same order.
+	Total   int64        // Total value of the report.
+
+	SampleType  string
+	SampleValue func(s []int64) int64
+}
+
+// An Insight is a finding of an insight rule.
+type Insight struct {
+	Message string
+	Value   int64    // Cost the finding is about.
+	Funcs   []string // Functions to look at, first the main one.
+}
+
+// minInsightFraction is the fraction of the total a cost needs to be reported
+// by the default insight rules.
+const minInsightFraction = 0.05
+
+// DefaultInsightRules returns the insight rules used by the reports unless
+// Options.InsightRules gives others.
+func DefaultInsightRules() []InsightRule {
+	return []InsightRule{
+		gcRule{},
+		lockRule{},
+		recursionRule{},
+		callSiteRule{},
+		inlineRule{},
+	}
+}
+
+// printInsights prints the findings of the insight rules of the report, with
+// the commands showing the functions they are about.
+func printInsights(w io.Writer, rpt *Report) error {
+	o := rpt.options
+	g, origCount, droppedNodes, _ := rpt.newTrimmedGraph()
+	rpt.selectOutputUnit(g)
+	labels := reportLabels(rpt, g, origCount, droppedNodes, 0, false)
+	fmt.Fprintln(w, strings.Join(labels, "\n"))
+
+	d := &InsightData{
+		Profile:     rpt.prof,
+		Graph:       g,
+		Items:       textItems(rpt, g),
+		Total:       rpt.total,
+		SampleType:  o.SampleType,
+		SampleValue: o.SampleValue,
+	}
+	rules := o.InsightRules
+	if rules == nil {
+		rules = DefaultInsightRules()
+	}
+	type finding struct {
+		rule string
+		Insight
+	}
+	var findings []finding
+	for _, r := range rules {
+		for _, in := range r.Insights(d) {
+			findings = append(findings, finding{r.Name(), in})
+		}
+	}
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No insights")
+		return nil
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return abs64(findings[i].Value) > abs64(findings[j].Value)
+	})
+
+	fmt.Fprintln(w, "Insights:")
+	for _, f := range findings {
+		fmt.Fprintf(w, "%10s %s  %s: %s\n", rpt.formatValue(f.Value), measurement.Percentage(f.Value, rpt.total), f.rule, f.Message)
+		for _, fn := range f.Funcs {
+			re := "^" + regexp.QuoteMeta(fn) + "$"
+			fmt.Fprintf(w, "%20s peek %s   list %s\n", "", re, re)
+		}
+	}
+	return nil
+}
+
+// isSignificant returns whether value is worth an insight.
+func (d *InsightData) isSignificant(value int64) bool {
+	return d.Total != 0 && float64(abs64(value)) >= minInsightFraction*float64(abs64(d.Total))
+}
+
+// percentage formats value as a percentage of the total.
+func (d *InsightData) percentage(value int64) string {
+	return strings.TrimSpace(measurement.Percentage(value, d.Total))
+}
+
+// sampleFrames returns the names of the functions of the stack of s, from
+// the leaf, including the inlined ones.
+func sampleFrames(s *profile.Sample) []string {
+	var frames []string
+	for _, loc := range s.Location {
+		for _, line := range loc.Line {
+			if line.Function != nil {
+				frames = append(frames, line.Function.Name)
+			}
+		}
+	}
+	return frames
+}
+
+// siteCosts accumulates the costs of samples by site.
+type siteCosts map[string]int64
+
+// top returns the sites with a significant cost, most costly first, at most
+// n of them.
+func (c siteCosts) top(d *InsightData, n int) []string {
+	var sites []string
+	for site, v := range c {
+		if d.isSignificant(v) {
+			sites = append(sites, site)
+		}
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if vi, vj := abs64(c[sites[i]]), abs64(c[sites[j]]); vi != vj {
+			return vi > vj
+		}
+		return sites[i] < sites[j]
+	})
+	if len(sites) > n {
+		sites = sites[:n]
+	}
+	return sites
+}
+
+// gcFunctions are the functions of the Go runtime in which memory
+// allocation and garbage collection spend their time, with a description.
+var gcFunctions = map[string]string{
+	"runtime.mallocgc":       "Memory allocation",
+	"runtime.gcBgMarkWorker": "Background garbage collection",
+	"runtime.bgsweep":        "Background sweeping",
+}
+
+// gcRule finds significant costs of memory allocation and garbage
+// collection, with the sites allocating the most.
+type gcRule struct{}
+
+func (gcRule) Name() string { return "gc" }
+
+func (gcRule) Insights(d *InsightData) []Insight {
+	costs := make(siteCosts)
+	allocSites := make(siteCosts)
+	for _, s := range d.Profile.Sample {
+		v := d.SampleValue(s.Value)
+		frames := sampleFrames(s)
+		seen := make(map[string]bool)
+		for i, fn := range frames {
+			if gcFunctions[fn] == "" || seen[fn] {
+				continue
+			}
+			seen[fn] = true
+			costs[fn] += v
+			if fn != "runtime.mallocgc" {
+				continue
+			}
+			// The allocation site is the first caller outside of the runtime.
+			for _, caller := range frames[i+1:] {
+				if !strings.HasPrefix(caller, "runtime.") {
+					allocSites[caller] += v
+					break
+				}
+			}
+		}
+	}
+
+	var insights []Insight
+	for _, fn := range costs.top(d, len(costs)) {
+		in := Insight{
+			Message: fmt.Sprintf("%s (%s) takes %s of the total", gcFunctions[fn], fn, d.percentage(costs[fn])),
+			Value:   costs[fn],
+			Funcs:   []string{fn},
+		}
+		if fn == "runtime.mallocgc" {
+			var sites []string
+			for _, site := range allocSites.top(d, 3) {
+				sites = append(sites, fmt.Sprintf("%s (%s)", site, d.percentage(allocSites[site])))
+				in.Funcs = append(in.Funcs, site)
+			}
+			if len(sites) > 0 {
+				in.Message += ", mostly allocating in " + strings.Join(sites, ", ")
+			}
+		}
+		insights = append(insights, in)
+	}
+	return insights
+}
+
+// lockFunctionRE matches the functions acquiring locks.
+var lockFunctionRE = regexp.MustCompile(`^(sync\.\(\*(RW)?Mutex\)\.R?Lock|sync\.runtime_Semacquire.*|runtime\.semacquire.*|runtime\.lock2?|pthread_mutex_(timed)?lock|pthread_rwlock_(rd|wr)lock|__lll_lock_wait)$`)
+
+// lockRule finds the sites spending a significant time acquiring locks. In
+// contention profiles, all the samples are about locks, and the sites are
+// the callers of the synchronization functions.
+type lockRule struct{}
+
+func (lockRule) Name() string { return "locks" }
+
+func (lockRule) Insights(d *InsightData) []Insight {
+	contention := d.SampleType == "contentions" || d.SampleType == "delay"
+	sites := make(siteCosts)
+	lockFuncs := make(map[string]string)
+	for _, s := range d.Profile.Sample {
+		frames := sampleFrames(s)
+		lock := -1
+		for i, fn := range frames {
+			if lockFunctionRE.MatchString(fn) {
+				lock = i
+			}
+		}
+		if lock < 0 && !contention {
+			continue
+		}
+		// The site is the first caller outside of the synchronization
+		// functions.
+		for _, caller := range frames[lock+1:] {
+			if !strings.HasPrefix(caller, "sync.") && !strings.HasPrefix(caller, "runtime.") {
+				sites[caller] += d.SampleValue(s.Value)
+				if lock >= 0 {
+					lockFuncs[caller] = frames[lock]
+				}
+				break
+			}
+		}
+	}
+
+	var insights []Insight
+	for _, site := range sites.top(d, 5) {
+		msg := fmt.Sprintf("%s is a lock contention hot spot, with %s of the total", site, d.percentage(sites[site]))
+		if lock := lockFuncs[site]; lock != "" {
+			msg = fmt.Sprintf("%s waits for locks for %s of the total, in %s", site, d.percentage(sites[site]), lock)
+		}
+		insights = append(insights, Insight{Message: msg, Value: sites[site], Funcs: []string{site}})
+	}
+	return insights
+}
+
+// minRecursionDepth is the number of times a function must be on a stack
+// for its recursion to be deep.
+const minRecursionDepth = 8
+
+// recursionRule finds the functions recursing deeply in a significant part
+// of the samples.
+type recursionRule struct{}
+
+func (recursionRule) Name() string { return "recursion" }
+
+func (recursionRule) Insights(d *InsightData) []Insight {
+	costs := make(siteCosts)
+	depths := make(map[string]int)
+	for _, s := range d.Profile.Sample {
+		counts := make(map[string]int)
+		for _, fn := range sampleFrames(s) {
+			counts[fn]++
+		}
+		for fn, n := range counts {
+			if n < minRecursionDepth {
+				continue
+			}
+			costs[fn] += d.SampleValue(s.Value)
+			if n > depths[fn] {
+				depths[fn] = n
+			}
+		}
+	}
+
+	var insights []Insight
+	for _, fn := range costs.top(d, 5) {
+		insights = append(insights, Insight{
+			Message: fmt.Sprintf("%s recurses up to %d calls deep, in %s of the total", fn, depths[fn], d.percentage(costs[fn])),
+			Value:   costs[fn],
+			Funcs:   []string{fn},
+		})
+	}
+	return insights
+}
+
+// minCallSiteFraction is the fraction of the cost of a function a call site
+// must account for to dominate it.
+const minCallSiteFraction = 0.8
+
+// callSiteRule finds the costly functions called from several sites, whose
+// cost mostly comes from one of them.
+type callSiteRule struct{}
+
+func (callSiteRule) Name() string { return "callsite" }
+
+func (callSiteRule) Insights(d *InsightData) []Insight {
+	var insights []Insight
+	for _, n := range d.Graph.Nodes {
+		if !d.isSignificant(n.CumValue()) {
+			continue
+		}
+		var top *graph.Edge
+		var sum int64
+		callers := 0
+		for src, e := range n.In {
+			if src == n {
+				continue
+			}
+			callers++
+			sum += abs64(e.WeightValue())
+			if top == nil || abs64(e.WeightValue()) > abs64(top.WeightValue()) {
+				top = e
+			}
+		}
+		if callers < 2 || float64(abs64(top.WeightValue())) < minCallSiteFraction*float64(sum) {
+			continue
+		}
+		insights = append(insights, Insight{
+			Message: fmt.Sprintf("%s of the cost of %s comes from its calls by %s",
+				strings.TrimSpace(measurement.Percentage(top.WeightValue(), sum)), n.Info.Name, top.Src.Info.Name),
+			Value: top.WeightValue(),
+			Funcs: []string{n.Info.Name, top.Src.Info.Name},
+		})
+	}
+	return insights
+}
+
+// inlineRule finds the functions inlined in all their callers with a
+// significant flat cost, which appears in the code of the callers.
+type inlineRule struct{}
+
+func (inlineRule) Name() string { return "inline" }
+
+func (inlineRule) Insights(d *InsightData) []Insight {
+	var insights []Insight
+	for i, item := range d.Items {
+		if item.InlineLabel != "(inline)" || !d.isSignificant(item.Flat) {
+			continue
+		}
+		fn := d.Graph.Nodes[i].Info.Name
+		insights = append(insights, Insight{
+			Message: fmt.Sprintf("%s is inlined in all its callers, with a flat cost of %s of the total", fn, d.percentage(item.Flat)),
+			Value:   item.Flat,
+			Funcs:   []string{fn},
+		})
+	}
+	return insights
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// insightSample is a sample of the profiles of the insight tests. Its stack
+// lists the functions from the leaf; "a+b" is a location of function a
+// inlined in b.
+type insightSample struct {
+	value int64
+	stack []string
+}
+
+func insightTestProfile(sampleType string, samples ...insightSample) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: sampleType, Unit: "count"}},
+		Mapping:    []*profile.Mapping{{ID: 1, Start: 0x1000, Limit: 0x10000, HasFunctions: true}},
+	}
+	funcs := make(map[string]*profile.Function)
+	locs := make(map[string]*profile.Location)
+	for _, s := range samples {
+		ps := &profile.Sample{Value: []int64{s.value}}
+		for _, frame := range s.stack {
+			loc := locs[frame]
+			if loc == nil {
+				loc = &profile.Location{ID: uint64(len(p.Location) + 1), Mapping: p.Mapping[0]}
+				for _, name := range strings.Split(frame, "+") {
+					fn := funcs[name]
+					if fn == nil {
+						fn = &profile.Function{ID: uint64(len(p.Function) + 1), Name: name}
+						funcs[name] = fn
+						p.Function = append(p.Function, fn)
+					}
+					loc.Line = append(loc.Line, profile.Line{Function: fn})
+				}
+				locs[frame] = loc
+				p.Location = append(p.Location, loc)
+			}
+			ps.Location = append(ps.Location, loc)
+		}
+		p.Sample = append(p.Sample, ps)
+	}
+	return p
+}
+
+func TestInsights(t *testing.T) {
+	recursion := []string{"main.leaf"}
+	for i := 0; i < 10; i++ {
+		recursion = append(recursion, "main.walk")
+	}
+	recursion = append(recursion, "main.main")
+
+	for _, tc := range []struct {
+		desc       string
+		sampleType string
+		samples    []insightSample
+		want       []string
+	}{
+		{
+			desc:       "allocation",
+			sampleType: "cpu",
+			samples: []insightSample{
+				{40, []string{"runtime.mallocgc", "runtime.newobject", "main.parse", "main.main"}},
+				{10, []string{"runtime.mallocgc", "runtime.makeslice", "main.load", "main.main"}},
+				{10, []string{"runtime.scanobject", "runtime.gcDrain", "runtime.gcBgMarkWorker"}},
+				{40, []string{"main.compute", "main.main"}},
+			},
+			want: []string{
+				"gc: Memory allocation (runtime.mallocgc) takes 50.00% of the total, mostly allocating in main.parse (40.00%), main.load (10.00%)",
+				`peek ^runtime\.mallocgc$   list ^runtime\.mallocgc$`,
+				`peek ^main\.parse$   list ^main\.parse$`,
+				"gc: Background garbage collection (runtime.gcBgMarkWorker) takes 10.00% of the total",
+			},
+		},
+		{
+			desc:       "locks",
+			sampleType: "cpu",
+			samples: []insightSample{
+				{30, []string{"runtime.lock2", "runtime.lock", "sync.(*Mutex).lockSlow", "sync.(*Mutex).Lock", "main.get", "main.main"}},
+				{2, []string{"sync.(*Mutex).Lock", "main.put", "main.main"}},
+				{68, []string{"main.compute", "main.main"}},
+			},
+			want: []string{
+				"locks: main.get waits for locks for 30.00% of the total, in sync.(*Mutex).Lock",
+				`peek ^main\.get$   list ^main\.get$`,
+			},
+		},
+		{
+			desc:       "contention",
+			sampleType: "delay",
+			samples: []insightSample{
+				{80, []string{"sync.(*Mutex).Unlock", "main.put", "main.main"}},
+				{20, []string{"runtime.chansend", "main.send", "main.main"}},
+			},
+			want: []string{
+				"locks: main.put is a lock contention hot spot, with 80.00% of the total",
+				"locks: main.send is a lock contention hot spot, with 20.00% of the total",
+			},
+		},
+		{
+			desc:       "recursion",
+			sampleType: "cpu",
+			samples: []insightSample{
+				{60, recursion},
+				{40, []string{"main.walk", "main.main"}},
+			},
+			want: []string{
+				"recursion: main.walk recurses up to 10 calls deep, in 60.00% of the total",
+			},
+		},
+		{
+			desc:       "call site",
+			sampleType: "cpu",
+			samples: []insightSample{
+				{90, []string{"main.hash", "main.lookup", "main.main"}},
+				{10, []string{"main.hash", "main.insert", "main.main"}},
+			},
+			want: []string{
+				"callsite: 90.00% of the cost of main.hash comes from its calls by main.lookup",
+				`peek ^main\.hash$   list ^main\.hash$`,
+				`peek ^main\.lookup$   list ^main\.lookup$`,
+			},
+		},
+		{
+			desc:       "inlined",
+			sampleType: "cpu",
+			samples: []insightSample{
+				{30, []string{"main.small+main.caller", "main.main"}},
+				{70, []string{"main.caller", "main.main"}},
+			},
+			want: []string{
+				"inline: main.small is inlined in all its callers, with a flat cost of 30.00% of the total",
+			},
+		},
+		{
+			desc:       "none",
+			sampleType: "cpu",
+			samples: []insightSample{
+				{50, []string{"main.a", "main.main"}},
+				{50, []string{"main.b", "main.main"}},
+			},
+			want: []string{"No insights"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			rpt := NewDefault(insightTestProfile(tc.sampleType, tc.samples...), Options{OutputFormat: Insights})
+			var buf bytes.Buffer
+			if err := Generate(&buf, rpt, nil); err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("got:\n%s\nwant it to contain %q", buf.String(), want)
+				}
+			}
+		})
+	}
+}
+
+// largeFlatRule is an insight rule finding the functions with a flat cost
+// above a fraction of the total.
+type largeFlatRule struct{ fraction float64 }
+
+func (largeFlatRule) Name() string { return "flat" }
+
+func (r largeFlatRule) Insights(d *InsightData) []Insight {
+	var insights []Insight
+	for _, item := range d.Items {
+		if float64(item.Flat) > r.fraction*float64(d.Total) {
+			insights = append(insights, Insight{Message: item.Name + " is large", Value: item.Flat})
+		}
+	}
+	return insights
+}
+
+func TestInsightRules(t *testing.T) {
+	p := insightTestProfile("cpu",
+		insightSample{90, []string{"main.hash", "main.lookup", "main.main"}},
+		insightSample{10, []string{"main.hash", "main.insert", "main.main"}},
+		insightSample{20, []string{"main.sort", "main.main"}},
+	)
+	rpt := NewDefault(p, Options{OutputFormat: Insights, InsightRules: []InsightRule{largeFlatRule{0.1}}})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{"100 83.33%  flat: main.hash is large\n", "20 16.67%  flat: main.sort is large\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got:\n%s\nwant it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "callsite:") {
+		t.Errorf("got:\n%s\nwant only the findings of the given rules", got)
+	}
+	if i, j := strings.Index(got, "main.hash"), strings.Index(got, "main.sort"); i > j {
+		t.Errorf("got:\n%s\nwant the most costly findings first", got)
+	}
+}
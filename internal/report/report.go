@@ -39,6 +39,8 @@ const (
 	Comments
 	Dis
 	Dot
+	Gecko
+	Insights
 	List
 	Proto
 	Raw
@@ -80,7 +82,15 @@ type Options struct {
 	SourcePath string         // Search path for source files.
 	TrimPath   string         // Paths to trim from source file paths.
 
+	Blame        bool   // Whether to annotate source lines with the last commit touching them.
+	CoverProfile string // Go coverage profile used to annotate source lines.
+
 	IntelSyntax bool // Whether or not to print assembly in Intel syntax.
+
+	ThreadLabel string // Label used to split samples into threads in Gecko output.
+	TimeLabel   string // Numeric label holding sample timestamps in Gecko output.
+
+	InsightRules []InsightRule // Rules of the insights report, DefaultInsightRules if nil.
 }
 
 // Generate generates a report as directed by the Report.
@@ -115,6 +125,10 @@ func Generate(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
 		return printWebSource(w, rpt, obj)
 	case Callgrind:
 		return printCallgrind(w, rpt)
+	case Gecko:
+		return printGecko(w, rpt)
+	case Insights:
+		return printInsights(w, rpt)
 	}
 	return fmt.Errorf("unexpected output format")
 }
@@ -783,7 +797,11 @@ func TextItems(rpt *Report) ([]TextItem, []string) {
 	g, origCount, droppedNodes, _ := rpt.newTrimmedGraph()
 	rpt.selectOutputUnit(g)
 	labels := reportLabels(rpt, g, origCount, droppedNodes, 0, false)
+	return textItems(rpt, g), labels
+}
 
+// textItems returns the text items of the nodes of graph g of the report.
+func textItems(rpt *Report, g *graph.Graph) []TextItem {
 	var items []TextItem
 	var flatSum int64
 	for _, n := range g.Nodes {
@@ -817,7 +835,7 @@ func TextItems(rpt *Report) ([]TextItem, []string) {
 			CumFormat:   rpt.formatValue(cum),
 		})
 	}
-	return items, labels
+	return items
 }
 
 // printText prints a flat text report for a profile.
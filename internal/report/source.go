@@ -71,6 +71,10 @@ func printSource(w io.Writer, rpt *Report) error {
 		sourcePath = wd
 	}
 	reader := newSourceReader(sourcePath, o.TrimPath)
+	annotator, err := newLineAnnotator(reader, o)
+	if err != nil {
+		return err
+	}
 
 	fmt.Fprintf(w, "Total: %s\n", rpt.formatValue(rpt.total))
 	for _, fn := range functions {
@@ -115,7 +119,13 @@ func printSource(w io.Writer, rpt *Report) error {
 			}
 
 			for _, fn := range fnodes {
-				fmt.Fprintf(w, "%10s %10s %6d:%s\n", valueOrDot(fn.Flat, rpt), valueOrDot(fn.Cum, rpt), fn.Info.Lineno, fn.Info.Name)
+				line := fn.Info.Name
+				if fn.Flat != 0 || fn.Cum != 0 {
+					if ann := annotator.annotate(filename, fn.Info.Lineno).String(); ann != "" {
+						line = rightPad(line, 80) + " " + ann
+					}
+				}
+				fmt.Fprintf(w, "%10s %10s %6d:%s\n", valueOrDot(fn.Flat, rpt), valueOrDot(fn.Cum, rpt), fn.Info.Lineno, line)
 			}
 		}
 	}
@@ -136,6 +146,7 @@ func printWebSource(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
 // sourcePrinter holds state needed for generating source+asm HTML listing.
 type sourcePrinter struct {
 	reader     *sourceReader
+	annotator  *lineAnnotator // May be nil
 	synth      *synthCode
 	objectTool plugin.ObjTool
 	objects    map[string]plugin.ObjFile  // Opened object files
@@ -213,6 +224,12 @@ func PrintWebList(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFiles int) er
 	if len(sp.interest) == 0 {
 		return fmt.Errorf("no matches found for regexp: %s", rpt.options.Symbol)
 	}
+	annotator, err := newLineAnnotator(sp.reader, rpt.options)
+	if err != nil {
+		sp.close()
+		return err
+	}
+	sp.annotator = annotator
 	sp.print(w, maxFiles, rpt)
 	sp.close()
 	return nil
@@ -654,7 +671,11 @@ func (sp *sourcePrinter) printFile(w io.Writer, f *sourceFile, rpt *Report) {
 				})
 			}
 
-			printFunctionSourceLine(w, l, flatSum, cumSum, lineContents, asm, sp.reader, rpt)
+			var ann lineAnnotation
+			if flatSum != 0 || cumSum != 0 {
+				ann = sp.annotator.annotate(f.fname, l)
+			}
+			printFunctionSourceLine(w, l, flatSum, cumSum, lineContents, ann, asm, sp.reader, rpt)
 		}
 		printFunctionClosing(w)
 	}
@@ -788,13 +809,17 @@ func printFunctionHeader(w io.Writer, name, path string, flatSum, cumSum int64,
 
 // printFunctionSourceLine prints a source line and the corresponding assembly.
 func printFunctionSourceLine(w io.Writer, lineNo int, flat, cum int64, lineContents string,
-	assembly []assemblyInstruction, reader *sourceReader, rpt *Report) {
+	ann lineAnnotation, assembly []assemblyInstruction, reader *sourceReader, rpt *Report) {
+	contents := template.HTMLEscapeString(lineContents)
+	if a := ann.HTML(); a != "" {
+		contents = template.HTMLEscapeString(rightPad(lineContents, 80)) + a
+	}
 	if len(assembly) == 0 {
 		fmt.Fprintf(w,
 			"<span class=line> %6d</span> <span class=nop>  %10s %10s %8s  %s </span>\n",
 			lineNo,
 			valueOrDot(flat, rpt), valueOrDot(cum, rpt),
-			"", template.HTMLEscapeString(lineContents))
+			"", contents)
 		return
 	}
 
@@ -811,7 +836,7 @@ func printFunctionSourceLine(w io.Writer, lineNo int, flat, cum int64, lineConte
 		"<span class=line> %6d</span> <span class=%s>  %10s %10s %8s  %s </span>",
 		lineNo, cl,
 		valueOrDot(flat, rpt), valueOrDot(cum, rpt),
-		"", template.HTMLEscapeString(lineContents))
+		"", contents)
 	if nestedInfo {
 		srcIndent := indentation(lineContents)
 		printNested(w, srcIndent, assembly, reader, rpt)
@@ -969,6 +994,16 @@ func (reader *sourceReader) fileError(path string) error {
 	return reader.errors[path]
 }
 
+// resolve returns the name of the local file holding the source for path.
+func (reader *sourceReader) resolve(path string) (string, error) {
+	f, err := openSourceFile(path, reader.searchPath, reader.trimPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return f.Name(), nil
+}
+
 // line returns the line numbered "lineno" in path, or _,false if lineno is out of range.
 func (reader *sourceReader) line(path string, lineno int) (string, bool) {
 	lines, ok := reader.files[path]
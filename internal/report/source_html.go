@@ -50,6 +50,15 @@ background-color: #eeeeee;
 color: #008800;
 display: none;
 }
+.annotation, .blame {
+color: #888888;
+}
+.covered {
+color: #008800;
+}
+.uncovered {
+color: #cc0000;
+}
 </style>`
 
 const weblistPageScript = `<script type="text/javascript">
@@ -59,6 +68,11 @@ function pprof_toggle_asm(e) {
   if (e.target) target = e.target;
   else if (e.srcElement) target = e.srcElement;
 
+  // Annotations are nested in the source line.
+  while (target && target.parentNode && /annotation|blame|covered/.test(target.className)) {
+    target = target.parentNode;
+  }
+
   if (target) {
     var asm = target.nextSibling;
     if (asm && asm.className == "asm") {
@@ -27,6 +27,10 @@ import (
 //
 // Slices in StackSet and the types it contains are always non-nil,
 // which makes Javascript code that uses the JSON encoding less error-prone.
+//
+// With a diff base, the values of the base are kept apart in Stack.Base
+// rather than netted into Stack.Value, as they used to be: users summing
+// Stack.Value to get the change from the base need Stack.Value - Stack.Base.
 type StackSet struct {
 	Total   int64         // Total value of the profile.
 	Scale   float64       // Multiplier to generate displayed value
@@ -36,9 +40,19 @@ type StackSet struct {
 	Sources []StackSource // Mapping from source index to info
 }
 
-// Stack holds a single stack instance.
+// Stack holds the samples of a single stack. The samples with the same
+// sources are merged into a single Stack, so a profile with a diff base
+// gets one Stack for both sides of the comparison.
 type Stack struct {
-	Value   int64 // Total value for all samples of this stack.
+	// Value is the total value of the samples of this stack outside the
+	// diff base. Unlike the values of the profile, it does not include the
+	// negated values of the diff base, which are in Base: the change of the
+	// stack from the base is Value - Base. Without a diff base, Value is
+	// the total value of the stack and Base is 0.
+	Value int64
+	// Base is the total value of the samples of this stack in the diff
+	// base, as a positive value.
+	Base    int64
 	Sources []int // Indices in StackSet.Sources (callers before callees).
 }
 
@@ -47,6 +61,7 @@ type StackSource struct {
 	FullName   string
 	FileName   string
 	UniqueName string // Disambiguates functions with same names
+	Package    string // Package of the function, or "" if not known
 	Inlined    bool   // If true this source was inlined into its caller
 
 	// Alternative names to display (with decreasing lengths) to make text fit.
@@ -63,7 +78,8 @@ type StackSource struct {
 	// 4,6,9,10, the Places entry for X will contain [S,4].
 	Places []StackSlot
 
-	// Combined count of stacks where this source is the leaf.
+	// Combined count of stacks where this source is the leaf. The values
+	// of the diff base, if any, are subtracted.
 	Self int64
 
 	// Color number to use for this source.
@@ -130,6 +146,7 @@ func (s *StackSet) makeInitialStacks(rpt *Report) {
 			x.UniqueName = x.FullName
 			unknownIndex++
 		}
+		x.Package = packageName(x.FullName)
 		x.Inlined = inlined
 		x.Display = shortNameList(x.FullName)
 		s.Sources = append(s.Sources, x)
@@ -144,9 +161,12 @@ func (s *StackSet) makeInitialStacks(rpt *Report) {
 		Places:   []StackSlot{},
 	}}
 
+	// Samples with the same stack share a Stack, which puts the values of
+	// the diff base, if any, next to the values they are compared with.
+	stacks := map[string]int{} // Index in s.Stacks by stackKey.
 	for _, sample := range rpt.prof.Sample {
 		value := rpt.options.SampleValue(sample.Value)
-		stack := Stack{Value: value, Sources: []int{0}} // Start with the root
+		sources := []int{0} // Start with the root
 
 		// Note: we need to reverse the order in the produced stack.
 		for i := len(sample.Location) - 1; i >= 0; i-- {
@@ -154,14 +174,36 @@ func (s *StackSet) makeInitialStacks(rpt *Report) {
 			for j := len(loc.Line) - 1; j >= 0; j-- {
 				line := loc.Line[j]
 				inlined := (j != len(loc.Line)-1)
-				stack.Sources = append(stack.Sources, getSrc(line, inlined))
+				sources = append(sources, getSrc(line, inlined))
 			}
 		}
 
-		leaf := stack.Sources[len(stack.Sources)-1]
+		leaf := sources[len(sources)-1]
 		s.Sources[leaf].Self += value
-		s.Stacks = append(s.Stacks, stack)
+
+		key := stackKey(sources)
+		index, ok := stacks[key]
+		if !ok {
+			index = len(s.Stacks)
+			stacks[key] = index
+			s.Stacks = append(s.Stacks, Stack{Sources: sources})
+		}
+		if sample.DiffBaseSample() {
+			// The diff base has been subtracted from the profile.
+			s.Stacks[index].Base -= value
+		} else {
+			s.Stacks[index].Value += value
+		}
+	}
+}
+
+// stackKey returns a string identifying the list of sources of a stack.
+func stackKey(sources []int) string {
+	key := make([]byte, 0, 2*len(sources))
+	for _, src := range sources {
+		key = binary.AppendUvarint(key, uint64(src))
 	}
+	return string(key)
 }
 
 func (s *StackSet) fillPlaces() {
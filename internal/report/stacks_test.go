@@ -56,6 +56,18 @@ func TestStacks(t *testing.T) {
 				makeStack(200, "0:root", "1:main", "2:foo", "2:foo", "3:bar"),
 			},
 		},
+		{
+			"duplicates",
+			makeTestStacks(
+				testSample(100, bar, foo, main),
+				testSample(200, tee, foo, main),
+				testSample(300, bar, foo, main),
+			),
+			[]stack{
+				makeStack(400, "0:root", "1:main", "2:foo", "3:bar"),
+				makeStack(200, "0:root", "1:main", "2:foo", "4:tee"),
+			},
+		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			var got []stack
@@ -76,6 +88,45 @@ func TestStacks(t *testing.T) {
 	}
 }
 
+func TestDiffStacks(t *testing.T) {
+	// See report_test.go for the functions available to use in tests.
+	main, foo, bar, tee := testL[0], testL[1], testL[2], testL[3]
+
+	// Samples of the diff base are negated and labeled, as done by -diff_base.
+	baseSample := func(value int64, locs ...*profile.Location) *profile.Sample {
+		s := testSample(-value, locs...)
+		s.Label = map[string][]string{"pprof::base": {"true"}}
+		return s
+	}
+	stacks := makeTestStacks(
+		testSample(300, bar, foo, main),
+		baseSample(100, bar, foo, main),
+		baseSample(200, tee, foo, main),
+		testSample(50, tee, main),
+	)
+
+	type stack struct {
+		value, base int64
+		leaf        string
+	}
+	var got []stack
+	for _, s := range stacks.Stacks {
+		leaf := stacks.Sources[s.Sources[len(s.Sources)-1]].FullName
+		got = append(got, stack{s.Value, s.Base, leaf})
+	}
+	want := []stack{
+		{300, 100, "bar"},
+		{0, 200, "tee"},
+		{50, 0, "tee"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expecting stacks %+v, got %+v", want, got)
+	}
+	if self := findSource(stacks, "tee").Self; self != -150 {
+		t.Errorf("expecting self -150 for tee, got %d", self)
+	}
+}
+
 func TestStackSources(t *testing.T) {
 	// See report_test.go for the functions available to use in tests.
 	main, foo, bar, tee, inl := testL[0], testL[1], testL[2], testL[3], testL[5]
@@ -172,3 +223,30 @@ func findSource(stacks StackSet, name string) StackSource {
 	}
 	return StackSource{}
 }
+
+func TestStackPackages(t *testing.T) {
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+	}
+	for i, name := range []string{"main.main", "example.com/web.(*Server).Serve", "std::vector<int>::push_back", "malloc"} {
+		fn := &profile.Function{ID: uint64(i + 1), Name: name}
+		loc := &profile.Location{ID: uint64(i + 1), Line: []profile.Line{{Function: fn}}}
+		prof.Function = append(prof.Function, fn)
+		prof.Location = append(prof.Location, loc)
+	}
+	prof.Sample = []*profile.Sample{{Value: []int64{100}, Location: []*profile.Location{prof.Location[3], prof.Location[2], prof.Location[1], prof.Location[0]}}}
+	rpt := NewDefault(prof, Options{OutputFormat: Tree, CallTree: true})
+	stacks := rpt.Stacks()
+
+	for name, want := range map[string]string{
+		"root":                            "",
+		"main.main":                       "main",
+		"example.com/web.(*Server).Serve": "example.com/web",
+		"std::vector<int>::push_back":     "std",
+		"malloc":                          "",
+	} {
+		if got := findSource(stacks, name).Package; got != want {
+			t.Errorf("package of %s: got %q, want %q", name, got, want)
+		}
+	}
+}
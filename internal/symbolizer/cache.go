@@ -0,0 +1,252 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// Cache is a persistent cache of the results of local symbolization,
+// shared across pprof runs. It holds the frames of each address
+// symbolized in a binary, keyed by the build ID of the binary, the tool
+// symbolizing it and the address in the object file, so binaries without
+// a build ID are not cached. Each binary has its own file in the cache
+// directory for each tool. The frames are cached before demangling,
+// which is applied to the profile afterwards.
+type Cache struct {
+	Dir     string // Directory holding the cache files.
+	MaxSize int64  // Maximum total size of the cache files in bytes, 0 for no limit.
+}
+
+// defaultCacheSize is the default maximum size of the symbol cache.
+const defaultCacheSize = 512 << 20
+
+var cacheBuildIDRE = regexp.MustCompile(`^[[:xdigit:]]+$`)
+
+// DefaultCache returns the symbol cache configured by the environment, or
+// nil if caching is disabled. $PPROF_SYMBOL_CACHE sets the cache directory,
+// by default pprof/symbols in the user cache directory, or disables the
+// cache if set to "off". $PPROF_SYMBOL_CACHE_SIZE sets the maximum size of
+// the cache in megabytes.
+func DefaultCache() *Cache {
+	dir := os.Getenv("PPROF_SYMBOL_CACHE")
+	switch dir {
+	case "off":
+		return nil
+	case "":
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(userCacheDir, "pprof", "symbols")
+	}
+	c := &Cache{Dir: dir, MaxSize: defaultCacheSize}
+	if size, err := strconv.ParseInt(os.Getenv("PPROF_SYMBOL_CACHE_SIZE"), 10, 64); err == nil && size >= 0 {
+		c.MaxSize = size << 20
+	}
+	return c
+}
+
+// Clear removes all entries from the cache.
+func (c *Cache) Clear() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f.name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedFrames holds the frames of the symbolized addresses of a binary.
+// It is shared by the mappings of the binary, which may be symbolized
+// concurrently.
+type cachedFrames struct {
+	mu      sync.Mutex
+	key     string // Build ID of the binary, and hash of the symbolizer.
+	frames  map[uint64][]plugin.Frame
+	updated bool // Whether frames has entries missing from the cache.
+}
+
+// lookup returns the frames of an address of the binary, and whether they
+// are known.
+func (cf *cachedFrames) lookup(addr uint64) ([]plugin.Frame, bool) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	stack, ok := cf.frames[addr]
+	return stack, ok
+}
+
+// add records the frames of an address of the binary. Addresses without
+// frames are not recorded, since the failure may be temporary.
+func (cf *cachedFrames) add(addr uint64, stack []plugin.Frame) {
+	if len(stack) == 0 {
+		return
+	}
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	cf.frames[addr] = stack
+	cf.updated = true
+}
+
+// cacheKey returns the key of the frames of the binary with the build ID,
+// as symbolized by the described symbolizer.
+func cacheKey(buildID, symbolizer string) string {
+	if symbolizer == "" {
+		return buildID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(symbolizer))
+	return fmt.Sprintf("%s-%08x", buildID, h.Sum32())
+}
+
+func (c *Cache) fileName(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// load returns the cached frames for the binary with the build ID, as
+// symbolized by the described symbolizer, or nil if the build ID cannot be
+// cached.
+func (c *Cache) load(buildID, symbolizer string) *cachedFrames {
+	if !cacheBuildIDRE.MatchString(buildID) {
+		return nil
+	}
+	cf := &cachedFrames{key: cacheKey(buildID, symbolizer), frames: map[uint64][]plugin.Frame{}}
+	name := c.fileName(cf.key)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return cf
+	}
+	if err := json.Unmarshal(data, &cf.frames); err != nil {
+		// Ignore corrupted entries, they will be overwritten.
+		cf.frames = map[uint64][]plugin.Frame{}
+		return cf
+	}
+	// Record the use for the eviction of least recently used entries.
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return cf
+}
+
+// store saves the frames of a binary, merged with any frames cached by
+// concurrent runs, and evicts the least recently used entries if the cache
+// exceeds its maximum size.
+func (c *Cache) store(cf *cachedFrames) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	frames := map[uint64][]plugin.Frame{}
+	name := c.fileName(cf.key)
+	if data, err := os.ReadFile(name); err == nil {
+		json.Unmarshal(data, &frames)
+	}
+	for addr, stack := range cf.frames {
+		frames[addr] = stack
+	}
+	data, err := json.Marshal(frames)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	return c.trim(name)
+}
+
+type cacheFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// files returns the files of the cache.
+func (c *Cache) files() ([]cacheFile, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []cacheFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{filepath.Join(c.Dir, e.Name()), info.Size(), info.ModTime()})
+	}
+	return files, nil
+}
+
+// trim removes the least recently used cache files until the cache fits
+// in its maximum size. The named file, just stored, is kept.
+func (c *Cache) trim(keep string) error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if size <= c.MaxSize {
+			break
+		}
+		if f.name == keep {
+			continue
+		}
+		if err := os.Remove(f.name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("evicting %s: %v", f.name, err)
+		}
+		size -= f.size
+	}
+	return nil
+}
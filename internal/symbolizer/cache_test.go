@@ -0,0 +1,201 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+// countingObjTool opens files with a build ID, counting the addresses
+// they symbolize.
+type countingObjTool struct {
+	mockObjTool
+	buildID    string
+	symbolizer string
+	lookups    *int
+}
+
+func (o countingObjTool) Open(file string, start, limit, offset uint64, relocationSymbol string) (plugin.ObjFile, error) {
+	return countingObjFile{mockObjFile{frames: mockAddresses}, o.buildID, o.symbolizer, o.lookups}, nil
+}
+
+type countingObjFile struct {
+	mockObjFile
+	buildID    string
+	symbolizer string
+	lookups    *int
+}
+
+func (f countingObjFile) BuildID() string {
+	return f.buildID
+}
+
+func (f countingObjFile) Symbolizer() string {
+	return f.symbolizer
+}
+
+func (f countingObjFile) SourceLine(addr uint64) ([]plugin.Frame, error) {
+	*f.lookups++
+	return f.mockObjFile.SourceLine(addr)
+}
+
+func TestCachedSymbolization(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	for _, tc := range []struct {
+		desc        string
+		buildID     string
+		fast, force bool
+		wantLookups int
+		wantCached  bool
+	}{
+		{desc: "first run", buildID: "abcd01", wantLookups: len(testL), wantCached: true},
+		{desc: "cached", buildID: "abcd01", wantLookups: 0, wantCached: true},
+		{desc: "forced", buildID: "abcd01", force: true, wantLookups: len(testL), wantCached: true},
+		{desc: "fast", buildID: "abcd01", fast: true, wantLookups: len(testL), wantCached: true},
+		{desc: "other binary", buildID: "abcd02", wantLookups: len(testL), wantCached: true},
+		{desc: "no build ID", wantLookups: len(testL)},
+		{desc: "no build ID again", wantLookups: len(testL)},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var lookups int
+			obj := countingObjTool{buildID: tc.buildID, lookups: &lookups}
+			prof := testProfile.Copy()
+			if err := doLocalSymbolize(prof, tc.fast, tc.force, obj, &proftest.TestUI{T: t}, cache, 1, nil); err != nil {
+				t.Fatalf("doLocalSymbolize: %v", err)
+			}
+			if lookups != tc.wantLookups {
+				t.Errorf("got %d lookups, want %d", lookups, tc.wantLookups)
+			}
+			for _, loc := range prof.Location {
+				if err := checkSymbolizedLocation(loc.Address, loc.Line); err != nil {
+					t.Errorf("location %d: %v", loc.Address, err)
+				}
+			}
+			_, err := os.Stat(cache.fileName(tc.buildID))
+			if cached := err == nil; cached != tc.wantCached {
+				t.Errorf("got cached %v, want %v", cached, tc.wantCached)
+			}
+		})
+	}
+
+	if err := cache.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if files, _ := cache.files(); len(files) != 0 {
+		t.Errorf("Clear left %d files", len(files))
+	}
+	var lookups int
+	obj := countingObjTool{buildID: "abcd01", lookups: &lookups}
+	if err := doLocalSymbolize(testProfile.Copy(), false, false, obj, &proftest.TestUI{T: t}, cache, 1, nil); err != nil {
+		t.Fatalf("doLocalSymbolize: %v", err)
+	}
+	if lookups != len(testL) {
+		t.Errorf("after Clear: got %d lookups, want %d", lookups, len(testL))
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	base := testProfile.Copy()
+	// An address without frames.
+	loc := &profile.Location{ID: uint64(len(base.Location) + 1), Mapping: base.Mapping[0], Address: 9000}
+	base.Location = append(base.Location, loc)
+	base.Sample = append(base.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{1}})
+
+	for _, tc := range []struct {
+		desc, symbolizer string
+		wantLookups      int
+	}{
+		{"addr2line", "addr2line -aif", len(base.Location)},
+		{"llvm-symbolizer", "llvm-symbolizer --inlining", len(base.Location)},
+		// Only the address without frames is looked up again.
+		{"addr2line again", "addr2line -aif", 1},
+	} {
+		var lookups int
+		obj := countingObjTool{buildID: "abcd01", symbolizer: tc.symbolizer, lookups: &lookups}
+		if err := doLocalSymbolize(base.Copy(), false, false, obj, &proftest.TestUI{T: t}, cache, 1, nil); err != nil {
+			t.Fatalf("%s: doLocalSymbolize: %v", tc.desc, err)
+		}
+		if lookups != tc.wantLookups {
+			t.Errorf("%s: got %d lookups, want %d", tc.desc, lookups, tc.wantLookups)
+		}
+	}
+	if files, _ := cache.files(); len(files) != 2 {
+		t.Errorf("got %d cache files, want one per symbolizer", len(files))
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	frames := map[uint64][]plugin.Frame{0x1000: {{Func: "main", File: "main.c", Line: 3}}}
+	var size int64
+	now := time.Now()
+	for i, id := range []string{"01", "02", "03"} {
+		if err := cache.store(&cachedFrames{key: id, frames: frames}); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+		// Make the use times distinct, the oldest first.
+		used := now.Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(cache.fileName(id), used, used)
+		info, err := os.Stat(cache.fileName(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size = info.Size()
+	}
+
+	// Loading an entry makes it the most recently used.
+	if got := cache.load("01", ""); got == nil || !reflect.DeepEqual(got.frames, frames) {
+		t.Fatalf("load: got %v, want %v", got, frames)
+	}
+
+	// Only room for two entries.
+	cache.MaxSize = 2 * size
+	if err := cache.store(&cachedFrames{key: "04", frames: frames}); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	var got []string
+	files, _ := cache.files()
+	for _, f := range files {
+		got = append(got, filepath.Base(f.name))
+	}
+	if want := []string{"01.json", "04.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after eviction: got %v, want %v", got, want)
+	}
+
+	if cache.load("../01", "") != nil {
+		t.Error("load accepted an invalid build ID")
+	}
+}
+
+func TestDefaultCache(t *testing.T) {
+	t.Setenv("PPROF_SYMBOL_CACHE", "/tmp/symbols")
+	t.Setenv("PPROF_SYMBOL_CACHE_SIZE", "10")
+	if got, want := DefaultCache(), (&Cache{Dir: "/tmp/symbols", MaxSize: 10 << 20}); !reflect.DeepEqual(got, want) {
+		t.Errorf("DefaultCache: got %v, want %v", got, want)
+	}
+	t.Setenv("PPROF_SYMBOL_CACHE", "off")
+	if got := DefaultCache(); got != nil {
+		t.Errorf("DefaultCache when disabled: got %v, want nil", got)
+	}
+}
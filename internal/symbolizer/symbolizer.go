@@ -22,8 +22,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/google/pprof/internal/binutils"
 	"github.com/google/pprof/internal/plugin"
@@ -37,6 +41,19 @@ type Symbolizer struct {
 	Obj       plugin.ObjTool
 	UI        plugin.UI
 	Transport http.RoundTripper
+	Cache     *Cache // Cache of local symbolization results, nil to disable.
+
+	// Workers is the maximum number of mappings symbolized concurrently.
+	// If zero, it is set by $PPROF_SYMBOLIZE_WORKERS, or defaults to the
+	// number of CPUs.
+	Workers int
+
+	// DebugFile, if not nil, returns the name of a separate file with the
+	// debug information of the binary of a mapping, or "" if there is
+	// none. Local symbolization reads it instead of the binary. Remote
+	// debug information is downloaded only if download is set, by the
+	// debuginfod symbolization option.
+	DebugFile func(m *profile.Mapping, download bool) string
 }
 
 // test taps for dependency injection
@@ -48,7 +65,7 @@ var demangleFunction = Demangle
 // local binaries; if the source is a URL it attempts to get any
 // missed entries using symbolz.
 func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *profile.Profile) error {
-	remote, local, fast, force, demanglerMode := true, true, false, false, ""
+	remote, local, fast, force, download, demanglerMode := true, true, false, false, false, ""
 	for _, o := range strings.Split(strings.ToLower(mode), ":") {
 		switch o {
 		case "":
@@ -63,6 +80,8 @@ func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *pr
 			remote, local = true, false
 		case "force":
 			force = true
+		case "debuginfod":
+			download = true
 		default:
 			switch d := strings.TrimPrefix(o, "demangle="); d {
 			case "full", "none", "templates":
@@ -73,14 +92,18 @@ func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *pr
 				continue
 			}
 			s.UI.PrintErr("ignoring unrecognized symbolization option: " + mode)
-			s.UI.PrintErr("expecting -symbolize=[local|fastlocal|remote|none][:force][:demangle=[none|full|templates|default]")
+			s.UI.PrintErr("expecting -symbolize=[local|fastlocal|remote|none][:force][:debuginfod][:demangle=[none|full|templates|default]")
 		}
 	}
 
 	var err error
 	if local {
 		// Symbolize locally using binutils.
-		if err = localSymbolize(p, fast, force, s.Obj, s.UI); err != nil {
+		var debugFile func(*profile.Mapping) string
+		if s.DebugFile != nil {
+			debugFile = func(m *profile.Mapping) string { return s.DebugFile(m, download) }
+		}
+		if err = localSymbolize(p, fast, force, s.Obj, s.UI, s.Cache, s.workers(), debugFile); err != nil {
 			s.UI.PrintErr("local symbolization: " + err.Error())
 		}
 	}
@@ -97,6 +120,17 @@ func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *pr
 	return nil
 }
 
+// workers returns the maximum number of mappings to symbolize concurrently.
+func (s *Symbolizer) workers() int {
+	if s.Workers > 0 {
+		return s.Workers
+	}
+	if n, err := strconv.Atoi(os.Getenv("PPROF_SYMBOLIZE_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
 // postURL issues a POST to a URL over HTTP.
 func postURL(source, post string, tr http.RoundTripper) ([]byte, error) {
 	client := &http.Client{
@@ -125,35 +159,44 @@ func statusCodeError(resp *http.Response) error {
 
 // doLocalSymbolize adds symbol and line number information to all locations
 // in a profile. mode enables some options to control
-// symbolization.
-func doLocalSymbolize(prof *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI) error {
+// symbolization. Unless fast is set, the results are looked up in and added
+// to the cache, if not nil. If force is set, cached results are ignored and
+// replaced. Up to workers mappings are symbolized concurrently.
+func doLocalSymbolize(prof *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI, cache *Cache, workers int, debugFile func(*profile.Mapping) string) error {
 	if fast {
 		if bu, ok := obj.(*binutils.Binutils); ok {
 			bu.SetFastSymbolization(true)
 		}
+		cache = nil
 	}
 
-	mt, err := newMapping(prof, obj, ui, force)
+	mt, err := newMapping(prof, obj, ui, force, debugFile)
 	if err != nil {
 		return err
 	}
 	defer mt.close()
+	if cache != nil {
+		mt.useCache(cache, force)
+		defer func() {
+			if err := mt.storeCache(); err != nil {
+				ui.PrintErr("Saving symbol cache: ", err)
+			}
+		}()
+	}
 
+	// The stacks are added to the profile in the order of the locations,
+	// so that the functions are numbered the same way however the
+	// symbolization of the mappings is scheduled.
+	stacks := mt.sourceLines(workers)
 	functions := make(map[profile.Function]*profile.Function)
-	for _, l := range mt.prof.Location {
-		m := l.Mapping
-		segment := mt.segments[m]
-		if segment == nil {
-			// Nothing to do.
-			continue
-		}
-
-		stack, err := segment.SourceLine(l.Address)
-		if err != nil || len(stack) == 0 {
+	for li, l := range mt.prof.Location {
+		stack := stacks[li]
+		if len(stack) == 0 {
 			// No answers from addr2line.
 			continue
 		}
 
+		m := l.Mapping
 		l.Line = make([]profile.Line, len(stack))
 		l.IsFolded = false
 		for i, frame := range stack {
@@ -295,10 +338,12 @@ func removeMatching(name string, start, end byte) string {
 	return name
 }
 
-// newMapping creates a mappingTable for a profile.
-func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force bool) (*mappingTable, error) {
+// newMapping creates a mappingTable for a profile. The binaries are read
+// from the separate debug files returned by debugFile, if not nil.
+func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force bool, debugFile func(*profile.Mapping) string) (*mappingTable, error) {
 	mt := &mappingTable{
 		prof:     prof,
+		ui:       ui,
 		segments: make(map[*profile.Mapping]plugin.ObjFile),
 	}
 
@@ -319,15 +364,6 @@ func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force b
 			continue
 		}
 
-		if m.File == "" {
-			if midx == 0 {
-				ui.PrintErr("Main binary filename not available.")
-				continue
-			}
-			missingBinaries = true
-			continue
-		}
-
 		// Skip well-known system mappings
 		if m.Unsymbolizable() {
 			continue
@@ -340,11 +376,27 @@ func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force b
 			}
 		}
 
+		file := m.File
+		if debugFile != nil {
+			if name := debugFile(m); name != "" {
+				file = name
+			}
+		}
+
+		if file == "" {
+			if midx == 0 {
+				ui.PrintErr("Main binary filename not available.")
+				continue
+			}
+			missingBinaries = true
+			continue
+		}
+
 		name := filepath.Base(m.File)
 		if m.BuildID != "" {
 			name += fmt.Sprintf(" (build ID %s)", m.BuildID)
 		}
-		f, err := obj.Open(m.File, m.Start, m.Limit, m.Offset, m.KernelRelocationSymbol)
+		f, err := obj.Open(file, m.Start, m.Limit, m.Offset, m.KernelRelocationSymbol)
 		if err != nil {
 			ui.PrintErr("Local symbolization failed for ", name, ": ", err)
 			missingBinaries = true
@@ -369,7 +421,173 @@ func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force b
 // profile.
 type mappingTable struct {
 	prof     *profile.Profile
+	ui       plugin.UI
 	segments map[*profile.Mapping]plugin.ObjFile
+
+	// Cached symbolization results, by mapping and by cache key.
+	cache  *Cache
+	cached map[*profile.Mapping]*cachedFrames
+	keys   map[string]*cachedFrames
+}
+
+// describedSymbolizer is implemented by object files describing the tool
+// and options they look up source lines with, which the cached results
+// depend on.
+type describedSymbolizer interface {
+	Symbolizer() string
+}
+
+// useCache sets up the lookup of symbolization results in the cache. If
+// force is set, the cached results are ignored, and replaced once stored.
+func (mt *mappingTable) useCache(cache *Cache, force bool) {
+	mt.cache = cache
+	mt.cached = make(map[*profile.Mapping]*cachedFrames)
+	mt.keys = make(map[string]*cachedFrames)
+	for m, segment := range mt.segments {
+		id, symbolizer := segment.BuildID(), ""
+		if d, ok := segment.(describedSymbolizer); ok {
+			symbolizer = d.Symbolizer()
+		}
+		key := cacheKey(id, symbolizer)
+		cf := mt.keys[key]
+		if cf == nil {
+			if cf = cache.load(id, symbolizer); cf == nil {
+				continue
+			}
+			if force {
+				cf.frames = map[uint64][]plugin.Frame{}
+			}
+			mt.keys[key] = cf
+		}
+		mt.cached[m] = cf
+	}
+}
+
+// batchSourceLiner is implemented by object files that look up the source
+// lines of several addresses faster than one at a time.
+type batchSourceLiner interface {
+	SourceLines(addrs []uint64) ([][]plugin.Frame, error)
+}
+
+// sourceLines returns the frames of the addresses of the locations of the
+// profile, in the order of the locations, nil for the locations that could
+// not be symbolized. Up to workers mappings are symbolized concurrently.
+func (mt *mappingTable) sourceLines(workers int) [][]plugin.Frame {
+	var mappings []*profile.Mapping
+	locations := make(map[*profile.Mapping][]int)
+	for i, l := range mt.prof.Location {
+		m := l.Mapping
+		if mt.segments[m] == nil {
+			// Nothing to do.
+			continue
+		}
+		if locations[m] == nil {
+			mappings = append(mappings, m)
+		}
+		locations[m] = append(locations[m], i)
+	}
+
+	// Each mapping fills in the stacks of its own locations.
+	stacks := make([][]plugin.Frame, len(mt.prof.Location))
+	if workers < 1 {
+		workers = 1
+	}
+	work := make(chan *profile.Mapping)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(mappings); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range work {
+				addrs := make([]uint64, len(locations[m]))
+				for j, li := range locations[m] {
+					addrs[j] = mt.prof.Location[li].Address
+				}
+				for j, stack := range mt.mappingSourceLines(m, addrs) {
+					stacks[locations[m][j]] = stack
+				}
+			}
+		}()
+	}
+	for _, m := range mappings {
+		work <- m
+	}
+	close(work)
+	wg.Wait()
+	return stacks
+}
+
+// mappingSourceLines returns the frames of addresses of a mapping, looking
+// them up in the cache first, and then in the object file, in a batch if
+// supported. If the batch fails, the addresses are looked up one at a time.
+func (mt *mappingTable) mappingSourceLines(m *profile.Mapping, addrs []uint64) [][]plugin.Frame {
+	segment := mt.segments[m]
+	stacks := make([][]plugin.Frame, len(addrs))
+
+	cf := mt.cached[m]
+	var objAddrs []uint64
+	if cf != nil {
+		objAddrs = make([]uint64, len(addrs))
+		for i, addr := range addrs {
+			objAddr, err := segment.ObjAddr(addr)
+			if err != nil {
+				// Cached results cannot be matched, skip the cache.
+				cf = nil
+				break
+			}
+			objAddrs[i] = objAddr
+		}
+	}
+	var missing []int
+	for i := range addrs {
+		if cf != nil {
+			if stack, ok := cf.lookup(objAddrs[i]); ok {
+				stacks[i] = stack
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+
+	found := func(i int, stack []plugin.Frame) {
+		stacks[i] = stack
+		if cf != nil {
+			cf.add(objAddrs[i], stack)
+		}
+	}
+	if b, ok := segment.(batchSourceLiner); ok && len(missing) > 1 {
+		lookup := make([]uint64, len(missing))
+		for j, i := range missing {
+			lookup[j] = addrs[i]
+		}
+		results, err := b.SourceLines(lookup)
+		if err == nil {
+			for j, i := range missing {
+				found(i, results[j])
+			}
+			return stacks
+		}
+		mt.ui.PrintErr("Local symbolization of ", filepath.Base(m.File), ": ", err, "; looking up addresses one at a time")
+	}
+	for _, i := range missing {
+		if stack, err := segment.SourceLine(addrs[i]); err == nil {
+			found(i, stack)
+		}
+	}
+	return stacks
+}
+
+// storeCache saves the new symbolization results in the cache.
+func (mt *mappingTable) storeCache() error {
+	for _, cf := range mt.keys {
+		if !cf.updated {
+			continue
+		}
+		if err := mt.cache.store(cf); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // close releases any external processes being used for the mapping.
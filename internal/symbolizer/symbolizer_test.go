@@ -15,10 +15,13 @@
 package symbolizer
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/google/pprof/internal/plugin"
@@ -116,6 +119,12 @@ func TestSymbolization(t *testing.T) {
 	s := Symbolizer{
 		Obj: mockObjTool{},
 		UI:  &proftest.TestUI{T: t},
+		DebugFile: func(m *profile.Mapping, download bool) string {
+			if download {
+				return "/downloaded/debug/file"
+			}
+			return ""
+		},
 	}
 	for i, tc := range []testcase{
 		{
@@ -150,6 +159,10 @@ func TestSymbolization(t *testing.T) {
 			"force:remote",
 			"force:symbolz=[force]",
 		},
+		{
+			"local:debuginfod",
+			"local=[debuginfod]",
+		},
 	} {
 		prof := testProfile.Copy()
 		if err := s.Symbolize(tc.mode, nil, prof); err != nil {
@@ -173,7 +186,7 @@ func symbolzMock(p *profile.Profile, force bool, sources plugin.MappingSources,
 	return nil
 }
 
-func localMock(p *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI) error {
+func localMock(p *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI, cache *Cache, workers int, debugFile func(*profile.Mapping) string) error {
 	var args []string
 	if fast {
 		args = append(args, "fast")
@@ -181,6 +194,9 @@ func localMock(p *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plug
 	if force {
 		args = append(args, "force")
 	}
+	if debugFile != nil && debugFile(p.Mapping[0]) != "" {
+		args = append(args, "debuginfod")
+	}
 	p.Comments = append(p.Comments, "local=["+strings.Join(args, ",")+"]")
 	return nil
 }
@@ -205,7 +221,7 @@ func TestLocalSymbolization(t *testing.T) {
 	}
 
 	b := mockObjTool{}
-	if err := localSymbolize(prof, false, false, b, &proftest.TestUI{T: t}); err != nil {
+	if err := localSymbolize(prof, false, false, b, &proftest.TestUI{T: t}, nil, 1, nil); err != nil {
 		t.Fatalf("localSymbolize(): %v", err)
 	}
 
@@ -222,6 +238,150 @@ func TestLocalSymbolization(t *testing.T) {
 	}
 }
 
+// batchObjTool opens files that look up addresses in batches, recording
+// the lookups of each file.
+type batchObjTool struct {
+	mockObjTool
+	mu      sync.Mutex
+	lookups map[string][][]uint64
+	fail    bool // Whether the batch lookups fail.
+}
+
+func (o *batchObjTool) Open(file string, start, limit, offset uint64, relocationSymbol string) (plugin.ObjFile, error) {
+	return batchObjFile{mockObjFile{}, file, o}, nil
+}
+
+type batchObjFile struct {
+	mockObjFile
+	name string
+	obj  *batchObjTool
+}
+
+// SourceLine returns the frames of mockAddresses, below a frame of a
+// function of the file.
+func (f batchObjFile) SourceLine(addr uint64) ([]plugin.Frame, error) {
+	if mockAddresses[addr] == nil {
+		return nil, nil
+	}
+	return append([]plugin.Frame{frame(f.name+"_leaf", f.name+".src", int(addr), 0)}, mockAddresses[addr]...), nil
+}
+
+func (f batchObjFile) SourceLines(addrs []uint64) ([][]plugin.Frame, error) {
+	f.obj.mu.Lock()
+	f.obj.lookups[f.name] = append(f.obj.lookups[f.name], addrs)
+	f.obj.mu.Unlock()
+	if f.obj.fail {
+		return nil, errors.New("symbolizer crashed")
+	}
+	stacks := make([][]plugin.Frame, len(addrs))
+	for i, addr := range addrs {
+		stacks[i], _ = f.SourceLine(addr)
+	}
+	return stacks, nil
+}
+
+func TestDebugFileSymbolization(t *testing.T) {
+	prof := testProfile.Copy()
+	obj := &batchObjTool{lookups: map[string][][]uint64{}}
+	debugFile := func(m *profile.Mapping) string { return "/usr/lib/debug/" + m.File + ".debug" }
+	if err := doLocalSymbolize(prof, false, false, obj, &proftest.TestUI{T: t}, nil, 1, debugFile); err != nil {
+		t.Fatalf("doLocalSymbolize: %v", err)
+	}
+	if got, want := prof.Location[0].Line[0].Function.Name, "/usr/lib/debug/mapping.debug_leaf"; got != want {
+		t.Errorf("got leaf %s, want %s from the debug file", got, want)
+	}
+	if got := prof.Mapping[0].File; got != "mapping" {
+		t.Errorf("got mapping file %q, want the binary", got)
+	}
+}
+
+func TestFailedBatchSymbolization(t *testing.T) {
+	prof := testProfile.Copy()
+	obj := &batchObjTool{lookups: map[string][][]uint64{}, fail: true}
+	ui := &proftest.TestUI{T: t, AllowRx: "symbolizer crashed; looking up addresses one at a time"}
+	if err := doLocalSymbolize(prof, false, false, obj, ui, nil, 1, nil); err != nil {
+		t.Fatalf("doLocalSymbolize: %v", err)
+	}
+	if len(obj.lookups["mapping"]) != 1 {
+		t.Errorf("got batches %v, want one", obj.lookups["mapping"])
+	}
+	if ui.NumAllowRxMatches != 1 {
+		t.Errorf("got %d reports of the failed batch, want 1", ui.NumAllowRxMatches)
+	}
+	for _, loc := range prof.Location {
+		if len(loc.Line) == 0 {
+			t.Fatalf("location %d not symbolized", loc.ID)
+		}
+		if err := checkSymbolizedLocation(loc.Address, loc.Line[1:]); err != nil {
+			t.Errorf("location %d: %v", loc.ID, err)
+		}
+	}
+}
+
+func TestParallelSymbolization(t *testing.T) {
+	// A profile with locations interleaved across several mappings, which
+	// share some functions.
+	const mappings = 8
+	base := &profile.Profile{}
+	for i := 0; i < mappings; i++ {
+		base.Mapping = append(base.Mapping, &profile.Mapping{
+			ID:    uint64(i + 1),
+			Start: 0x1000,
+			Limit: 0x5000,
+			File:  fmt.Sprintf("lib%d", i),
+		})
+	}
+	for _, addr := range []uint64{1000, 2000, 3000, 4000, 5000} {
+		for _, m := range base.Mapping {
+			loc := &profile.Location{ID: uint64(len(base.Location) + 1), Mapping: m, Address: addr}
+			base.Location = append(base.Location, loc)
+			base.Sample = append(base.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{1}})
+		}
+	}
+
+	var want []string
+	for _, workers := range []int{1, 2, mappings, 2 * mappings} {
+		obj := &batchObjTool{lookups: map[string][][]uint64{}}
+		prof := base.Copy()
+		if err := doLocalSymbolize(prof, false, false, obj, &proftest.TestUI{T: t}, nil, workers, nil); err != nil {
+			t.Fatalf("doLocalSymbolize with %d workers: %v", workers, err)
+		}
+
+		// The function table does not depend on the scheduling.
+		var got []string
+		for i, f := range prof.Function {
+			if f.ID != uint64(i+1) {
+				t.Errorf("%d workers: function %s has ID %d, want %d", workers, f.Name, f.ID, i+1)
+			}
+			got = append(got, f.Name)
+		}
+		if want == nil {
+			want = got
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers: got functions %v, want %v", workers, got, want)
+		}
+
+		for _, loc := range prof.Location {
+			if len(loc.Line) == 0 {
+				t.Fatalf("%d workers: location %d not symbolized", workers, loc.ID)
+			}
+			if got, want := loc.Line[0].Function.Name, loc.Mapping.File+"_leaf"; got != want {
+				t.Errorf("%d workers: location %d: got leaf %s, want %s", workers, loc.ID, got, want)
+			}
+			if err := checkSymbolizedLocation(loc.Address, loc.Line[1:]); err != nil {
+				t.Errorf("%d workers: location %d: %v", workers, loc.ID, err)
+			}
+		}
+
+		// The addresses of each mapping are looked up in a single batch.
+		for _, m := range base.Mapping {
+			if got, want := obj.lookups[m.File], [][]uint64{{1000, 2000, 3000, 4000, 5000}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("%d workers: %s lookups: got %v, want %v", workers, m.File, got, want)
+			}
+		}
+	}
+}
+
 func checkSymbolizedLocation(a uint64, got []profile.Line) error {
 	want, ok := mockAddresses[a]
 	if !ok {
@@ -13,16 +13,22 @@
 // limitations under the License.
 
 // Package transport provides a mechanism to send requests with https cert,
-// key, and CA.
+// key, and CA, custom headers, a proxy and retries.
 package transport
 
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/pprof/internal/plugin"
 )
@@ -31,15 +37,33 @@ type transport struct {
 	cert       *string
 	key        *string
 	ca         *string
+	header     *[]*string
+	proxy      *string
+	retries    *int
+	options    *plugin.HTTPFetchOptions
 	caCertPool *x509.CertPool
 	certs      []tls.Certificate
+	proxyURL   *url.URL
+	fetch      http.RoundTripper
 	initOnce   sync.Once
 	initErr    error
 }
 
 const extraUsage = `    -tls_cert             TLS client certificate file for fetching profile and symbols
     -tls_key              TLS private key file for fetching profile and symbols
-    -tls_ca               TLS CA certs file for fetching profile and symbols`
+    -tls_ca               TLS CA certs file for fetching profile and symbols
+    -fetch_header         Header of the requests fetching profile and symbols, as
+                          "Name: value"; may be repeated. A value starting with @
+                          is read from the named file, and $VAR in a value is
+                          replaced by the environment variable VAR
+    -fetch_proxy          Proxy URL for fetching profile and symbols, instead of
+                          the one of the HTTP_PROXY or HTTPS_PROXY variables
+    -fetch_retries        Number of retries of the requests failing with a
+                          timeout or a 5xx status, with exponential backoff`
+
+// defaultRetryDelay is the delay before the first retry of a request if the
+// options give none.
+const defaultRetryDelay = time.Second
 
 // New returns a round tripper for making requests with the
 // specified cert, key, and ca. The flags tls_cert, tls_key, and tls_ca are
@@ -47,17 +71,36 @@ const extraUsage = `    -tls_cert             TLS client certificate file for fe
 // the flagset is nil, no flags will be added, and users will not be able to
 // use these flags.
 func New(flagset plugin.FlagSet) http.RoundTripper {
+	return NewWithOptions(flagset, nil)
+}
+
+// NewWithOptions is like New, and also applies the headers, proxy and
+// retries of the options to the requests. The flags fetch_header,
+// fetch_proxy and fetch_retries are added to the flagset to let a user
+// override them. The options may be nil.
+func NewWithOptions(flagset plugin.FlagSet, o *plugin.HTTPFetchOptions) http.RoundTripper {
 	if flagset == nil {
-		return &transport{}
+		return &transport{options: o}
 	}
 	flagset.AddExtraUsage(extraUsage)
 	return &transport{
-		cert: flagset.String("tls_cert", "", "TLS client certificate file for fetching profile and symbols"),
-		key:  flagset.String("tls_key", "", "TLS private key file for fetching profile and symbols"),
-		ca:   flagset.String("tls_ca", "", "TLS CA certs file for fetching profile and symbols"),
+		cert:    flagset.String("tls_cert", "", "TLS client certificate file for fetching profile and symbols"),
+		key:     flagset.String("tls_key", "", "TLS private key file for fetching profile and symbols"),
+		ca:      flagset.String("tls_ca", "", "TLS CA certs file for fetching profile and symbols"),
+		header:  flagset.StringList("fetch_header", "", "Header of the requests fetching profile and symbols, as Name: value"),
+		proxy:   flagset.String("fetch_proxy", "", "Proxy URL for fetching profile and symbols"),
+		retries: flagset.Int("fetch_retries", -1, "Number of retries of the requests failing with a timeout or a 5xx status"),
+		options: o,
 	}
 }
 
+// WithOptions returns a round tripper adding the headers and retries of the
+// options to the requests of tr. The proxy of the options is ignored, since
+// it is up to tr.
+func WithOptions(tr http.RoundTripper, o *plugin.HTTPFetchOptions) http.RoundTripper {
+	return newFetchTransport(tr, o)
+}
+
 // initialize uses the cert, key, and ca to initialize the certs
 // to use these when making requests.
 func (tr *transport) initialize() error {
@@ -94,11 +137,66 @@ func (tr *transport) initialize() error {
 		tr.caCertPool = caCertPool
 	}
 
+	// The flags take precedence over the options.
+	var o plugin.HTTPFetchOptions
+	if tr.options != nil {
+		o = *tr.options
+		o.Header = tr.options.Header.Clone()
+	}
+	if tr.header != nil {
+		for _, h := range *tr.header {
+			if *h == "" {
+				continue
+			}
+			name, value, err := parseHeader(*h)
+			if err != nil {
+				return err
+			}
+			if o.Header == nil {
+				o.Header = make(http.Header)
+			}
+			o.Header.Set(name, value)
+		}
+	}
+	if tr.proxy != nil && *tr.proxy != "" {
+		o.Proxy = *tr.proxy
+	}
+	if tr.retries != nil && *tr.retries >= 0 {
+		o.Retries = *tr.retries
+	}
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", o.Proxy)
+		}
+		tr.proxyURL = u
+	}
+	tr.fetch = newFetchTransport(roundTripperFunc(tr.roundTrip), &o)
 	return nil
 }
 
-// RoundTrip executes a single HTTP transaction, returning
-// a Response for the provided Request.
+// parseHeader parses a -fetch_header flag of the form "Name: value". The
+// value is read from a file if it starts with @, and environment variables
+// are expanded in it otherwise, to keep secrets out of the command line.
+func parseHeader(h string) (name, value string, err error) {
+	name, value, ok := strings.Cut(h, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		// Keep the flag out of the error, as it may hold a secret.
+		return "", "", errors.New("-fetch_header must be of the form \"Name: value\"")
+	}
+	if file := strings.TrimPrefix(value, "@"); file != value {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", fmt.Errorf("could not read the value of the %s header specified by -fetch_header: %v", name, err)
+		}
+		return name, strings.TrimSpace(string(data)), nil
+	}
+	return name, os.ExpandEnv(value), nil
+}
+
+// RoundTrip executes an HTTP transaction, retried according to the options,
+// returning a Response for the provided Request.
 func (tr *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	tr.initOnce.Do(func() {
 		tr.initErr = tr.initialize()
@@ -106,7 +204,11 @@ func (tr *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if tr.initErr != nil {
 		return nil, tr.initErr
 	}
+	return tr.fetch.RoundTrip(req)
+}
 
+// roundTrip executes a single HTTP transaction.
+func (tr *transport) roundTrip(req *http.Request) (*http.Response, error) {
 	tlsConfig := &tls.Config{
 		RootCAs:      tr.caCertPool,
 		Certificates: tr.certs,
@@ -122,10 +224,106 @@ func (tr *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		req.URL.Scheme = "https"
 	}
 
+	proxy := http.ProxyFromEnvironment
+	if tr.proxyURL != nil {
+		proxy = http.ProxyURL(tr.proxyURL)
+	}
 	transport := http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
+		Proxy:           proxy,
 		TLSClientConfig: tlsConfig,
 	}
 
 	return transport.RoundTrip(req)
 }
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fetchTransport adds headers to the requests of a round tripper, and
+// retries them with exponential backoff on timeouts and 5xx statuses. The
+// headers are only added for the host of the original request: like
+// http.Client does for Authorization, they are not sent to the other hosts
+// a request is redirected to.
+type fetchTransport struct {
+	base    http.RoundTripper
+	header  http.Header
+	retries int
+	delay   time.Duration
+}
+
+func newFetchTransport(base http.RoundTripper, o *plugin.HTTPFetchOptions) *fetchTransport {
+	t := &fetchTransport{base: base, delay: defaultRetryDelay}
+	if o != nil {
+		t.header, t.retries = o.Header, o.Retries
+		if o.RetryDelay > 0 {
+			t.delay = o.RetryDelay
+		}
+	}
+	return t
+}
+
+func (t *fetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.header) > 0 && originalRequest(req).URL.Host == req.URL.Host {
+		req = req.Clone(req.Context())
+		for name, values := range t.header {
+			req.Header.Del(name)
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+	}
+	retries := t.retries
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The body cannot be sent again.
+		retries = 0
+	}
+	delay := t.delay
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= retries || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// originalRequest returns the request a chain of redirects leading to req
+// started from, or req if it is not a redirect.
+func originalRequest(req *http.Request) *http.Request {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req
+}
+
+// retryable returns whether a request is worth retrying after getting resp
+// or err.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	return resp.StatusCode >= 500
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// flakyServer returns a server failing the first failures requests with
+// status, and recording the Authorization headers and bodies of all the
+// requests.
+func flakyServer(t *testing.T, failures, status int) (srv *httptest.Server, auths, bodies *[]string) {
+	auths, bodies = new([]string), new([]string)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*auths = append(*auths, r.Header.Get("Authorization"))
+		*bodies = append(*bodies, string(body))
+		if len(*auths) <= failures {
+			http.Error(w, "try again", status)
+			return
+		}
+		io.WriteString(w, "profile")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, auths, bodies
+}
+
+func TestFetchOptions(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		failures   int
+		status     int
+		retries    int
+		post       bool
+		wantStatus int
+		wantTries  int
+	}{
+		{"success", 0, 0, 2, false, http.StatusOK, 1},
+		{"retried", 2, http.StatusServiceUnavailable, 2, false, http.StatusOK, 3},
+		{"retried post", 1, http.StatusBadGateway, 2, true, http.StatusOK, 2},
+		{"too many failures", 3, http.StatusInternalServerError, 2, false, http.StatusInternalServerError, 3},
+		{"client error", 1, http.StatusNotFound, 2, false, http.StatusNotFound, 1},
+		{"no retries", 1, http.StatusServiceUnavailable, 0, false, http.StatusServiceUnavailable, 1},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv, auths, bodies := flakyServer(t, tc.failures, tc.status)
+			tr := NewWithOptions(nil, &plugin.HTTPFetchOptions{
+				Header:     http.Header{"Authorization": {"Bearer token"}},
+				Retries:    tc.retries,
+				RetryDelay: time.Millisecond,
+			})
+			client := &http.Client{Transport: tr}
+			var resp *http.Response
+			var err error
+			if tc.post {
+				resp, err = client.Post(srv.URL, "application/octet-stream", strings.NewReader("0x1000"))
+			} else {
+				resp, err = client.Get(srv.URL)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if len(*auths) != tc.wantTries {
+				t.Errorf("got %d requests, want %d", len(*auths), tc.wantTries)
+			}
+			for i, auth := range *auths {
+				if auth != "Bearer token" {
+					t.Errorf("request %d: got Authorization %q, want the one of the options", i, auth)
+				}
+				if body := (*bodies)[i]; tc.post && body != "0x1000" {
+					t.Errorf("request %d: got body %q, want 0x1000", i, body)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchOptionsRedirect(t *testing.T) {
+	other, otherAuths, _ := flakyServer(t, 0, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/final":
+			io.WriteString(w, r.Header.Get("Authorization"))
+		default:
+			http.Redirect(w, r, other.URL, http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewWithOptions(nil, &plugin.HTTPFetchOptions{
+		Header: http.Header{"Authorization": {"Bearer token"}},
+	})}
+	resp, err := client.Get(srv.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := []string{""}; !reflect.DeepEqual(*otherAuths, want) {
+		t.Errorf("redirected to another host: got Authorization %q, want %q", *otherAuths, want)
+	}
+
+	resp, err = client.Get(srv.URL + "/same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got, want := string(body), "Bearer token"; got != want {
+		t.Errorf("redirected on the same host: got Authorization %q, want %q", got, want)
+	}
+}
+
+// timeoutError is a network error reporting a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWithOptionsRetriesTimeouts(t *testing.T) {
+	for _, tc := range []struct {
+		desc      string
+		err       error
+		wantTries int
+	}{
+		{"timeout", timeoutError{}, 3},
+		{"other error", errors.New("connection refused"), 1},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			tries := 0
+			base := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				tries++
+				return nil, tc.err
+			})
+			client := &http.Client{Transport: WithOptions(base, &plugin.HTTPFetchOptions{Retries: 2, RetryDelay: time.Millisecond})}
+			if _, err := client.Get("http://profiles.example/debug/pprof/heap"); err == nil {
+				t.Error("got no error, want the one of the last request")
+			}
+			if tries != tc.wantTries {
+				t.Errorf("got %d requests, want %d", tries, tc.wantTries)
+			}
+		})
+	}
+}
+
+func TestFetchFlags(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("Bearer from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PPROF_TEST_TENANT", "team-a")
+
+	var gotURL string
+	var gotHeader http.Header
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL, gotHeader = r.URL.String(), r.Header
+		io.WriteString(w, "profile")
+	}))
+	defer proxy.Close()
+
+	headers := []string{"", "Authorization: @" + tokenFile, "X-Tenant: $PPROF_TEST_TENANT"}
+	var headerFlags []*string
+	for i := range headers {
+		headerFlags = append(headerFlags, &headers[i])
+	}
+	proxyFlag, retries := proxy.URL, 1
+	tr := &transport{
+		header:  &headerFlags,
+		proxy:   &proxyFlag,
+		retries: &retries,
+		options: &plugin.HTTPFetchOptions{
+			Header:  http.Header{"Authorization": {"Bearer from-options"}, "X-Client": {"tests"}},
+			Proxy:   "http://unused.example:3128",
+			Retries: 5,
+		},
+	}
+	resp, err := (&http.Client{Transport: tr}).Get("http://profiles.example/debug/pprof/heap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := "http://profiles.example/debug/pprof/heap"; gotURL != want {
+		t.Errorf("got proxied URL %q, want %q", gotURL, want)
+	}
+	for name, want := range map[string]string{
+		"Authorization": "Bearer from-file",
+		"X-Tenant":      "team-a",
+		"X-Client":      "tests",
+	} {
+		if got := gotHeader.Get(name); got != want {
+			t.Errorf("got %s header %q, want %q", name, got, want)
+		}
+	}
+	if got := tr.fetch.(*fetchTransport).retries; got != 1 {
+		t.Errorf("got %d retries, want the 1 of the flag", got)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	t.Setenv("PPROF_TEST_TOKEN", "secret")
+	for _, tc := range []struct {
+		header  string
+		want    []string
+		wantErr bool
+	}{
+		{"Authorization: Bearer ${PPROF_TEST_TOKEN}", []string{"Authorization", "Bearer secret"}, false},
+		{"X-Empty:", []string{"X-Empty", ""}, false},
+		{"X-Time: 10:30", []string{"X-Time", "10:30"}, false},
+		{"Bearer secret", nil, true},
+		{": value", nil, true},
+		{"Bad Name: value", nil, true},
+		{"Authorization: @" + filepath.Join(t.TempDir(), "missing"), nil, true},
+	} {
+		name, value, err := parseHeader(tc.header)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseHeader(%q): got error %v, want error %v", tc.header, err, tc.wantErr)
+			continue
+		}
+		if got := []string{name, value}; err == nil && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseHeader(%q): got %v, want %v", tc.header, got, tc.want)
+		}
+		if err != nil && strings.Contains(err.Error(), "secret") {
+			t.Errorf("parseHeader(%q): got error %v, want it without the value", tc.header, err)
+		}
+	}
+}
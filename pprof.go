@@ -19,6 +19,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 
@@ -43,7 +44,10 @@ type readlineUI struct {
 }
 
 func newUI() driver.UI {
-	rl, err := readline.New("")
+	rl, err := readline.NewEx(&readline.Config{
+		HistoryFile:       historyFile(),
+		HistorySearchFold: true,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "readline: %v", err)
 		return nil
@@ -53,6 +57,21 @@ func newUI() driver.UI {
 	}
 }
 
+// historyFile returns the file keeping the commands of the interactive
+// shell across sessions, next to the settings of pprof, or "" if there is no
+// place for it.
+func historyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	dir = filepath.Join(dir, "pprof")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "history")
+}
+
 // ReadLine returns a line of text (a command) read from the user.
 // prompt is printed before reading the command.
 func (r *readlineUI) ReadLine(prompt string) (string, error) {
@@ -105,5 +124,21 @@ func (r *readlineUI) WantBrowser() bool {
 // SetAutoComplete instructs the UI to call complete(cmd) to obtain
 // the auto-completion of cmd, if the UI supports auto-completion at all.
 func (r *readlineUI) SetAutoComplete(complete func(string) string) {
-	// TODO: Implement auto-completion support.
+	cfg := r.rl.Config.Clone()
+	cfg.AutoComplete = completer(complete)
+	r.rl.SetConfig(cfg)
+}
+
+// completer implements readline.AutoCompleter with a function completing
+// the line up to the cursor. Readline can only insert the completion at the
+// cursor, so completions changing what is already typed are ignored.
+type completer func(string) string
+
+func (c completer) Do(line []rune, pos int) ([][]rune, int) {
+	typed := string(line[:pos])
+	completed := c(typed)
+	if len(completed) <= len(typed) || !strings.HasPrefix(completed, typed) {
+		return nil, 0
+	}
+	return [][]rune{[]rune(completed[len(typed):])}, pos
 }
@@ -0,0 +1,555 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser for the callgrind profile format
+// written by Valgrind's callgrind and cachegrind tools (and by pprof
+// itself), converting it into the profile.proto format.
+//
+// The format is described at
+// https://valgrind.org/docs/manual/cl-format.html
+
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	callgrindHeaderRE = regexp.MustCompile(`^(version|creator|pid|cmd|part|desc|positions|events|event|thread|summary|totals):\s*(.*)$`)
+	callgrindSpecRE   = regexp.MustCompile(`^(ob|fl|fi|fe|fn|cob|cfi|cfl|cfn|calls|jump|jcnd)=\s*(.*)$`)
+	callgrindEventRE  = regexp.MustCompile(`^(.*)\((.*)\)$`)
+)
+
+// callgrindMaxDepth limits the depth of the stacks reconstructed from
+// the caller/callee relationships in a callgrind profile.
+const callgrindMaxDepth = 128
+
+// callgrindMinFraction is the smallest fraction of a cost that is
+// attributed to a caller when reconstructing stacks. Smaller fractions
+// are left with the callee, to bound the number of stacks generated.
+const callgrindMinFraction = 1e-4
+
+// cgFunc identifies a function in a callgrind profile.
+type cgFunc struct {
+	obj, file, name string
+}
+
+// cgSite identifies a position within a function.
+type cgSite struct {
+	fn   cgFunc
+	file string // Source file of the position, which may be an inlined file.
+	addr uint64
+	line int64
+}
+
+// cgCall identifies a call from a site to a function.
+type cgCall struct {
+	site   cgSite
+	callee cgFunc
+}
+
+// callgrindParser holds the state needed while parsing a callgrind
+// profile.
+type callgrindParser struct {
+	p *Profile
+
+	positions []string
+	instrPos  int // Index of the "instr" position, or -1.
+	linePos   int // Index of the "line" position, or -1.
+
+	// Name compression tables.
+	objs, files, fns map[string]string
+
+	// Current context.
+	ob, fl, fi, fn string
+	cob, cfi, cfn  string
+	inCall         bool
+	skipNext       bool
+	lastPos        []int64
+
+	self     map[cgSite][]int64
+	calls    map[cgCall][]int64
+	siteList []cgSite
+	callList []cgCall
+}
+
+// isCallgrind reports whether b looks like a callgrind or cachegrind
+// profile, based on its first non-blank line.
+func isCallgrind(b []byte) bool {
+	s := bufio.NewScanner(bytes.NewBuffer(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if strings.Contains(line, "callgrind format") {
+				return true
+			}
+			continue
+		}
+		return callgrindHeaderRE.MatchString(line)
+	}
+	return false
+}
+
+// parseCallgrind parses a callgrind or cachegrind profile and returns
+// a newly populated Profile. Callgrind only records the cost of each
+// position and the inclusive cost of each call, so full stacks are
+// reconstructed by attributing the cost of each function to its
+// callers in proportion to the inclusive cost of the calls.
+func parseCallgrind(b []byte) (*Profile, error) {
+	if !isCallgrind(b) {
+		return nil, errUnrecognized
+	}
+	cp := &callgrindParser{
+		p:         &Profile{},
+		positions: []string{"line"},
+		instrPos:  -1,
+		linePos:   0,
+		objs:      make(map[string]string),
+		files:     make(map[string]string),
+		fns:       make(map[string]string),
+		lastPos:   make([]int64, 1),
+		self:      make(map[cgSite][]int64),
+		calls:     make(map[cgCall][]int64),
+	}
+	s := bufio.NewScanner(bytes.NewBuffer(b))
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if err := cp.parseLine(strings.TrimSpace(s.Text())); err != nil {
+			return nil, fmt.Errorf("callgrind: line %d: %v", n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(cp.p.SampleType) == 0 {
+		return nil, fmt.Errorf("callgrind: missing events line")
+	}
+	cp.buildSamples()
+	return cp.p, nil
+}
+
+func (cp *callgrindParser) parseLine(line string) error {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	if m := callgrindSpecRE.FindStringSubmatch(line); m != nil {
+		return cp.parseSpec(m[1], m[2])
+	}
+	if m := callgrindHeaderRE.FindStringSubmatch(line); m != nil {
+		return cp.parseHeader(m[1], m[2])
+	}
+	return cp.parseCost(line)
+}
+
+// parseHeader handles a "key: value" header line.
+func (cp *callgrindParser) parseHeader(key, value string) error {
+	switch key {
+	case "positions":
+		cp.positions = strings.Fields(value)
+		cp.instrPos, cp.linePos = -1, -1
+		for i, pos := range cp.positions {
+			switch pos {
+			case "instr":
+				cp.instrPos = i
+			case "line":
+				cp.linePos = i
+			}
+		}
+		cp.lastPos = make([]int64, len(cp.positions))
+	case "events":
+		if len(cp.p.SampleType) != 0 {
+			return fmt.Errorf("multiple events lines")
+		}
+		for _, ev := range strings.Fields(value) {
+			vt := &ValueType{Type: ev, Unit: "count"}
+			if m := callgrindEventRE.FindStringSubmatch(ev); m != nil {
+				// pprof writes events as "type(unit)".
+				vt.Type, vt.Unit = m[1], m[2]
+			}
+			cp.p.SampleType = append(cp.p.SampleType, vt)
+		}
+		if len(cp.p.SampleType) == 0 {
+			return fmt.Errorf("no events")
+		}
+		cp.p.PeriodType = &ValueType{Type: cp.p.SampleType[0].Type, Unit: cp.p.SampleType[0].Unit}
+		cp.p.Period = 1
+	case "cmd", "desc", "creator":
+		if value != "" {
+			cp.p.Comments = append(cp.p.Comments, key+": "+value)
+		}
+	}
+	return nil
+}
+
+// parseSpec handles a "key=value" position or call specification.
+func (cp *callgrindParser) parseSpec(key, value string) error {
+	var err error
+	switch key {
+	case "ob":
+		cp.ob, err = callgrindName(cp.objs, value)
+	case "fl":
+		cp.fl, err = callgrindName(cp.files, value)
+		cp.fi = cp.fl
+	case "fi", "fe":
+		cp.fi, err = callgrindName(cp.files, value)
+	case "fn":
+		cp.fn, err = callgrindName(cp.fns, value)
+		cp.fi = cp.fl
+	case "cob":
+		cp.cob, err = callgrindName(cp.objs, value)
+	case "cfi", "cfl":
+		cp.cfi, err = callgrindName(cp.files, value)
+	case "cfn":
+		cp.cfn, err = callgrindName(cp.fns, value)
+	case "calls":
+		if cp.cfn == "" {
+			return fmt.Errorf("calls without cfn")
+		}
+		cp.inCall = true
+	case "jump", "jcnd":
+		cp.skipNext = true
+	}
+	return err
+}
+
+// callgrindName resolves a possibly compressed name. "(N) name"
+// defines name N, and "(N)" refers to a name previously defined.
+func callgrindName(names map[string]string, s string) (string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return s, nil
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return s, nil
+	}
+	id, name := s[1:end], strings.TrimSpace(s[end+1:])
+	if name == "" {
+		name, ok := names[id]
+		if !ok {
+			return "", fmt.Errorf("undefined name reference %s", s)
+		}
+		return name, nil
+	}
+	names[id] = name
+	return name, nil
+}
+
+// parseCost handles a cost line, made of positions followed by costs.
+func (cp *callgrindParser) parseCost(line string) error {
+	if len(cp.p.SampleType) == 0 {
+		return fmt.Errorf("cost line %q before the events line", line)
+	}
+	fields := strings.Fields(line)
+	if len(fields) < len(cp.positions) {
+		return fmt.Errorf("malformed cost line %q", line)
+	}
+	for i := range cp.positions {
+		v, err := cp.parsePosition(fields[i], cp.lastPos[i])
+		if err != nil {
+			return err
+		}
+		cp.lastPos[i] = v
+	}
+	if cp.skipNext {
+		// The line following a jump specification holds the source
+		// position of the jump, which carries no cost.
+		cp.skipNext = false
+		return nil
+	}
+	costs := fields[len(cp.positions):]
+	if len(costs) > len(cp.p.SampleType) {
+		return fmt.Errorf("too many costs in %q", line)
+	}
+	values := make([]int64, len(cp.p.SampleType))
+	for i, c := range costs {
+		v, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			f, ferr := strconv.ParseFloat(c, 64)
+			if ferr != nil {
+				return fmt.Errorf("malformed cost %q", c)
+			}
+			v = int64(math.Round(f))
+		}
+		values[i] = v
+	}
+
+	site := cgSite{
+		fn:   cgFunc{cp.ob, cp.fl, cp.fn},
+		file: cp.fi,
+	}
+	if cp.instrPos >= 0 {
+		site.addr = uint64(cp.lastPos[cp.instrPos])
+	}
+	if cp.linePos >= 0 {
+		site.line = cp.lastPos[cp.linePos]
+	}
+
+	if cp.inCall {
+		callee := cgFunc{cp.cob, cp.cfi, cp.cfn}
+		if callee.obj == "" {
+			callee.obj = cp.ob
+		}
+		if callee.file == "" {
+			callee.file = cp.fl
+		}
+		call := cgCall{site, callee}
+		if _, ok := cp.calls[call]; !ok {
+			cp.callList = append(cp.callList, call)
+		}
+		cp.calls[call] = addValues(cp.calls[call], values)
+		cp.inCall = false
+		cp.cob, cp.cfi, cp.cfn = "", "", ""
+		return nil
+	}
+	if _, ok := cp.self[site]; !ok {
+		cp.siteList = append(cp.siteList, site)
+	}
+	cp.self[site] = addValues(cp.self[site], values)
+	return nil
+}
+
+// parsePosition parses a position, which may be absolute (decimal or
+// hexadecimal), relative to the previous one ("+N", "-N") or the same
+// as the previous one ("*").
+func (cp *callgrindParser) parsePosition(s string, last int64) (int64, error) {
+	switch {
+	case s == "*":
+		return last, nil
+	case strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-"):
+		v, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed position %q", s)
+		}
+		return last + v, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed position %q", s)
+	}
+	return int64(v), nil
+}
+
+func addValues(dst, src []int64) []int64 {
+	if dst == nil {
+		dst = make([]int64, len(src))
+	}
+	for i, v := range src {
+		dst[i] += v
+	}
+	return dst
+}
+
+// buildSamples reconstructs samples from the self costs and the
+// inclusive call costs collected while parsing.
+func (cp *callgrindParser) buildSamples() {
+	// Compute the inclusive cost of each function, and collect the
+	// calls into each function.
+	inclusive := make(map[cgFunc][]int64)
+	callers := make(map[cgFunc][]cgCall)
+	for _, site := range cp.siteList {
+		inclusive[site.fn] = addValues(inclusive[site.fn], cp.self[site])
+	}
+	for _, call := range cp.callList {
+		inclusive[call.site.fn] = addValues(inclusive[call.site.fn], cp.calls[call])
+	}
+	// Callees may be referenced without their file or object, so
+	// resolve them by name if there is a single matching function.
+	byName := make(map[string][]cgFunc)
+	for fn := range inclusive {
+		byName[fn.name] = append(byName[fn.name], fn)
+	}
+	for _, call := range cp.callList {
+		callee := call.callee
+		if _, ok := inclusive[callee]; !ok && len(byName[callee.name]) == 1 {
+			callee = byName[callee.name][0]
+		}
+		callers[callee] = append(callers[callee], call)
+	}
+
+	b := &callgrindBuilder{
+		cp:        cp,
+		inclusive: inclusive,
+		callers:   callers,
+		stacks:    make(map[string]*callgrindStack),
+		locations: make(map[cgSite]*Location),
+		functions: make(map[cgFunc]*Function),
+		mappings:  make(map[string]*Mapping),
+	}
+	for _, site := range cp.siteList {
+		b.values = cp.self[site]
+		b.expand([]cgSite{site}, 1)
+	}
+
+	p := cp.p
+	for _, st := range b.stackList {
+		s := &Sample{
+			Location: st.locs,
+			Value:    make([]int64, len(st.values)),
+		}
+		nonzero := false
+		for i, v := range st.values {
+			s.Value[i] = int64(math.Round(v))
+			nonzero = nonzero || s.Value[i] != 0
+		}
+		if nonzero {
+			p.Sample = append(p.Sample, s)
+		}
+	}
+	p.remapLocationIDs()
+	p.remapFunctionIDs()
+	for i, m := range p.Mapping {
+		m.ID = uint64(i + 1)
+	}
+}
+
+// callgrindStack accumulates the cost of a reconstructed stack.
+type callgrindStack struct {
+	locs   []*Location
+	values []float64
+}
+
+// callgrindBuilder holds the state used to reconstruct stacks.
+type callgrindBuilder struct {
+	cp        *callgrindParser
+	inclusive map[cgFunc][]int64
+	callers   map[cgFunc][]cgCall
+
+	// values is the self cost of the site being expanded.
+	values []int64
+
+	stacks    map[string]*callgrindStack
+	stackList []*callgrindStack
+	locations map[cgSite]*Location
+	functions map[cgFunc]*Function
+	mappings  map[string]*Mapping
+}
+
+// expand attributes the given fraction of the current self cost to the
+// stack formed by frames (leaf first), recursing into the callers of
+// the outermost frame.
+func (b *callgrindBuilder) expand(frames []cgSite, fraction float64) {
+	fn := frames[len(frames)-1].fn
+	calls := b.callers[fn]
+	remaining := fraction
+	if incl := b.inclusive[fn]; len(calls) > 0 && len(incl) > 0 && len(frames) < callgrindMaxDepth {
+		k := 0
+		for k < len(incl)-1 && incl[k] == 0 {
+			k++
+		}
+		total := incl[k]
+		var in int64
+		for _, c := range calls {
+			in += b.cp.calls[c][k]
+		}
+		if in > total {
+			total = in
+		}
+		for _, c := range calls {
+			if total <= 0 || onStack(frames, c.site.fn) {
+				continue
+			}
+			f := fraction * float64(b.cp.calls[c][k]) / float64(total)
+			if f < callgrindMinFraction {
+				continue
+			}
+			b.expand(append(frames[:len(frames):len(frames)], c.site), f)
+			remaining -= f
+		}
+	}
+	if remaining > 0 {
+		b.add(frames, remaining)
+	}
+}
+
+func onStack(frames []cgSite, fn cgFunc) bool {
+	for _, f := range frames {
+		if f.fn == fn {
+			return true
+		}
+	}
+	return false
+}
+
+// add accumulates the given fraction of the current self cost into
+// the sample for the stack formed by frames.
+func (b *callgrindBuilder) add(frames []cgSite, fraction float64) {
+	locs := make([]*Location, len(frames))
+	var key strings.Builder
+	for i, f := range frames {
+		locs[i] = b.location(f)
+		fmt.Fprintf(&key, "%d;", locs[i].ID)
+	}
+	st := b.stacks[key.String()]
+	if st == nil {
+		st = &callgrindStack{locs: locs, values: make([]float64, len(b.values))}
+		b.stacks[key.String()] = st
+		b.stackList = append(b.stackList, st)
+	}
+	for i, v := range b.values {
+		st.values[i] += float64(v) * fraction
+	}
+}
+
+func (b *callgrindBuilder) location(site cgSite) *Location {
+	if l := b.locations[site]; l != nil {
+		return l
+	}
+	fn := cgFunc{site.fn.obj, site.file, site.fn.name}
+	f := b.functions[fn]
+	if f == nil {
+		f = &Function{
+			Name:       fn.name,
+			SystemName: fn.name,
+			Filename:   fn.file,
+		}
+		b.functions[fn] = f
+		b.cp.p.Function = append(b.cp.p.Function, f)
+	}
+	l := &Location{
+		ID:      uint64(len(b.cp.p.Location) + 1),
+		Address: site.addr,
+		Line:    []Line{{Function: f, Line: site.line}},
+		Mapping: b.mapping(site.fn.obj),
+	}
+	b.locations[site] = l
+	b.cp.p.Location = append(b.cp.p.Location, l)
+	return l
+}
+
+func (b *callgrindBuilder) mapping(obj string) *Mapping {
+	if obj == "" {
+		return nil
+	}
+	if m := b.mappings[obj]; m != nil {
+		return m
+	}
+	m := &Mapping{
+		File:           obj,
+		HasFunctions:   true,
+		HasFilenames:   true,
+		HasLineNumbers: true,
+	}
+	b.mappings[obj] = m
+	b.cp.p.Mapping = append(b.cp.p.Mapping, m)
+	return m
+}
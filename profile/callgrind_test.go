@@ -0,0 +1,174 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testCallgrind = `# callgrind format
+version: 1
+creator: callgrind-3.19.0
+cmd: ./bench
+positions: line
+events: Ir Dr
+
+ob=(1) /bin/bench
+fl=(1) main.cc
+fn=(1) main
+10 5 1
+cfn=(2) foo
+calls=2 20
+11 300 30
+cfn=(3) bar
+calls=1 30
++1 100 10
+
+fn=(2) foo
+20 100 10
+cfn=(3)
+calls=4 30
+21 200 20
+
+fl=(2) bar.cc
+fn=(3)
+30 300 30
+`
+
+// stackString returns the function names of a sample, root first.
+func stackString(s *Sample) string {
+	var names []string
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		for _, ln := range s.Location[i].Line {
+			names = append(names, ln.Function.Name)
+		}
+	}
+	return strings.Join(names, ";")
+}
+
+func TestParseCallgrind(t *testing.T) {
+	p, err := ParseData([]byte(testCallgrind))
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+	if got, want := len(p.SampleType), 2; got != want {
+		t.Fatalf("got %d sample types, want %d", got, want)
+	}
+	if p.SampleType[0].Type != "Ir" || p.SampleType[1].Type != "Dr" {
+		t.Errorf("got sample types %v, want Ir, Dr", p.SampleType)
+	}
+
+	got := make(map[string]int64)
+	var total int64
+	for _, s := range p.Sample {
+		got[stackString(s)] += s.Value[0]
+		total += s.Value[0]
+	}
+	// Self costs: main 5, foo 100, bar 300. bar is called from main
+	// (100) and from foo (200); foo is only called from main.
+	want := map[string]int64{
+		"main":         5,
+		"main;foo":     100,
+		"main;bar":     100,
+		"main;foo;bar": 200,
+	}
+	if len(got) != len(want) {
+		var stacks []string
+		for s := range got {
+			stacks = append(stacks, s)
+		}
+		sort.Strings(stacks)
+		t.Fatalf("got stacks %v, want %v", stacks, want)
+	}
+	for stack, v := range want {
+		if got[stack] != v {
+			t.Errorf("stack %s: got %d, want %d", stack, got[stack], v)
+		}
+	}
+	if total != 405 {
+		t.Errorf("got total %d, want 405", total)
+	}
+
+	for _, s := range p.Sample {
+		if stackString(s) != "main;foo;bar" {
+			continue
+		}
+		if l := s.Location[0].Line[0]; l.Function.Filename != "bar.cc" || l.Line != 30 {
+			t.Errorf("got leaf %s:%d, want bar.cc:30", l.Function.Filename, l.Line)
+		}
+		if l := s.Location[1].Line[0]; l.Function.Name != "foo" || l.Line != 21 {
+			t.Errorf("got call site %s:%d, want foo:21", l.Function.Name, l.Line)
+		}
+		if m := s.Location[0].Mapping; m == nil || m.File != "/bin/bench" || !m.HasFunctions {
+			t.Errorf("got mapping %v, want /bin/bench with functions", m)
+		}
+	}
+}
+
+func TestParseCallgrindPprofOutput(t *testing.T) {
+	// Output in the format written by pprof's callgrind report.
+	const data = `positions: instr line
+events: cpu(ms)
+
+ob=(1) /bin/app
+fl=(1) app.go
+fn=(1) main.main
+0x401000 10 20
+cfl=(1)
+cfn=(2) main.work
+calls=0 0x401100 20
+* * 80
+
+ob=(1)
+fl=(1)
+fn=(2)
++256 20 80
+`
+	p, err := ParseData([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+	if st := p.SampleType[0]; st.Type != "cpu" || st.Unit != "ms" {
+		t.Errorf("got sample type %s/%s, want cpu/ms", st.Type, st.Unit)
+	}
+	var total int64
+	for _, s := range p.Sample {
+		total += s.Value[0]
+		if stackString(s) == "main.main;main.work" {
+			if got, want := s.Location[0].Address, uint64(0x401100); got != want {
+				t.Errorf("got leaf address %#x, want %#x", got, want)
+			}
+		}
+	}
+	if total != 100 {
+		t.Errorf("got total %d, want 100", total)
+	}
+}
+
+func TestParseCallgrindErrors(t *testing.T) {
+	for _, data := range []string{
+		"events: Ir\nfn=(1)\n10 1\n",
+		"events: Ir\nfn=main\ncalls=1 10\n10 1\n",
+		"events: Ir\nfn=main\n10 x\n",
+		"positions: line\nfn=main\n10 1\n",
+		"# callgrind format\nfn=b\n1\nevents: x\nfn=a\ncfn=b\ncalls=1 1\n1 5\n",
+	} {
+		if _, err := ParseData([]byte(data)); err == nil {
+			t.Errorf("ParseData(%q): got no error", data)
+		}
+	}
+}
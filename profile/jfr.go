@@ -0,0 +1,852 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert Java Flight Recorder (JFR)
+// recordings into the profile.proto format.
+//
+// A recording is a sequence of self-contained chunks. Each chunk holds
+// a metadata event describing the layout of every event and constant
+// type, a linked list of constant pool events, and the events
+// themselves. Execution samples, allocation samples and monitor
+// contention events are converted into samples; everything else is
+// ignored.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	jfrMagic      = "FLR\x00"
+	jfrHeaderSize = 68
+
+	// Event type ids reserved for the metadata and constant pools.
+	jfrMetadataEvent     = 0
+	jfrConstantPoolEvent = 1
+
+	// jfrCompressedInts is the chunk feature flag indicating that
+	// integers are varint encoded.
+	jfrCompressedInts = 1
+
+	// jfrMaxDepth bounds the nesting of inline values, so malformed
+	// metadata cannot cause unbounded recursion.
+	jfrMaxDepth = 32
+)
+
+// Names of the events converted into samples.
+const (
+	jfrExecutionSample  = "jdk.ExecutionSample"
+	jfrAllocationSample = "jdk.ObjectAllocationSample"
+	jfrMonitorEnter     = "jdk.JavaMonitorEnter"
+	jfrActiveSetting    = "jdk.ActiveSetting"
+)
+
+func isJFR(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(jfrMagic))
+}
+
+// jfrClass describes a type declared in the chunk metadata.
+type jfrClass struct {
+	id     int64
+	name   string
+	fields []jfrField
+}
+
+type jfrField struct {
+	name         string
+	class        int64
+	constantPool bool
+	array        bool
+}
+
+// jfrObject is a decoded value of a non-primitive type.
+type jfrObject struct {
+	class  *jfrClass
+	values []interface{}
+}
+
+func (o *jfrObject) get(name string) interface{} {
+	for i, f := range o.class.fields {
+		if f.name == name {
+			return o.values[i]
+		}
+	}
+	return nil
+}
+
+// jfrRef is a reference to an entry in a constant pool.
+type jfrRef struct {
+	class, key int64
+}
+
+// jfrElement is a node of the metadata element tree.
+type jfrElement struct {
+	name     string
+	attrs    map[string]string
+	children []*jfrElement
+}
+
+// jfrChunk holds the state needed to decode a single chunk.
+type jfrChunk struct {
+	data       []byte // The chunk, starting with its header.
+	pos        int
+	compressed bool
+
+	startNanos, durationNanos int64
+	ticksPerSec               int64
+
+	classes map[int64]*jfrClass
+	byName  map[string]*jfrClass
+	pools   map[int64]map[int64]interface{}
+}
+
+var errJFRTruncated = fmt.Errorf("truncated JFR data")
+
+func (c *jfrChunk) readByte() (byte, error) {
+	if c.pos >= len(c.data) {
+		return 0, errJFRTruncated
+	}
+	b := c.data[c.pos]
+	c.pos++
+	return b, nil
+}
+
+func (c *jfrChunk) fixed(n int) ([]byte, error) {
+	if n < 0 || n > len(c.data)-c.pos {
+		return nil, errJFRTruncated
+	}
+	b := c.data[c.pos : c.pos+n]
+	c.pos += n
+	return b, nil
+}
+
+// varint decodes a JFR compressed integer: up to eight groups of seven
+// bits, least significant first, followed by a full ninth byte.
+func (c *jfrChunk) varint() (int64, error) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		b, err := c.readByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return int64(v), nil
+		}
+	}
+	b, err := c.readByte()
+	if err != nil {
+		return 0, err
+	}
+	return int64(v | uint64(b)<<56), nil
+}
+
+// integer reads an integer that occupies size bytes when the chunk
+// does not use compressed integers.
+func (c *jfrChunk) integer(size int) (int64, error) {
+	if c.compressed {
+		return c.varint()
+	}
+	b, err := c.fixed(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 2:
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 4:
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (c *jfrChunk) readInt() (int64, error)  { return c.integer(4) }
+func (c *jfrChunk) readLong() (int64, error) { return c.integer(8) }
+
+// count reads an element count, rejecting counts that cannot possibly
+// fit in the remaining data.
+func (c *jfrChunk) count() (int, error) {
+	n, err := c.readInt()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(len(c.data)-c.pos) {
+		return 0, fmt.Errorf("bad JFR count %d", n)
+	}
+	return int(n), nil
+}
+
+// readString reads a string value, which may be a reference to the
+// string constant pool.
+func (c *jfrChunk) readString() (interface{}, error) {
+	enc, err := c.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch enc {
+	case 0, 1: // null, empty
+		return "", nil
+	case 2: // constant pool reference
+		key, err := c.readLong()
+		if err != nil {
+			return nil, err
+		}
+		var id int64 = -1
+		if s := c.byName["java.lang.String"]; s != nil {
+			id = s.id
+		}
+		return jfrRef{id, key}, nil
+	case 3, 5: // UTF-8, Latin-1
+		n, err := c.count()
+		if err != nil {
+			return nil, err
+		}
+		b, err := c.fixed(n)
+		if err != nil {
+			return nil, err
+		}
+		if enc == 3 {
+			return string(b), nil
+		}
+		r := make([]rune, len(b))
+		for i, ch := range b {
+			r[i] = rune(ch)
+		}
+		return string(r), nil
+	case 4: // UTF-16 char array
+		n, err := c.count()
+		if err != nil {
+			return nil, err
+		}
+		u := make([]uint16, n)
+		for i := range u {
+			ch, err := c.integer(2)
+			if err != nil {
+				return nil, err
+			}
+			u[i] = uint16(ch)
+		}
+		return string(utf16.Decode(u)), nil
+	}
+	return nil, fmt.Errorf("unknown JFR string encoding %d", enc)
+}
+
+// value decodes a value of the given class.
+func (c *jfrChunk) value(class *jfrClass, depth int) (interface{}, error) {
+	switch class.name {
+	case "boolean", "byte":
+		b, err := c.readByte()
+		return int64(int8(b)), err
+	case "char", "short":
+		return c.integer(2)
+	case "int":
+		return c.readInt()
+	case "long":
+		return c.readLong()
+	case "float":
+		b, err := c.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case "double":
+		b, err := c.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case "java.lang.String":
+		return c.readString()
+	}
+	if depth > jfrMaxDepth {
+		return nil, fmt.Errorf("JFR type %s nested too deeply", class.name)
+	}
+	o := &jfrObject{class: class, values: make([]interface{}, len(class.fields))}
+	for i, f := range class.fields {
+		if !f.array {
+			v, err := c.fieldValue(f, depth)
+			if err != nil {
+				return nil, err
+			}
+			o.values[i] = v
+			continue
+		}
+		n, err := c.count()
+		if err != nil {
+			return nil, err
+		}
+		vs := make([]interface{}, n)
+		for j := range vs {
+			if vs[j], err = c.fieldValue(f, depth); err != nil {
+				return nil, err
+			}
+		}
+		o.values[i] = vs
+	}
+	return o, nil
+}
+
+func (c *jfrChunk) fieldValue(f jfrField, depth int) (interface{}, error) {
+	if f.constantPool {
+		key, err := c.readLong()
+		return jfrRef{f.class, key}, err
+	}
+	class := c.classes[f.class]
+	if class == nil {
+		return nil, fmt.Errorf("unknown JFR type %d for field %s", f.class, f.name)
+	}
+	return c.value(class, depth+1)
+}
+
+// parseMetadata parses the metadata event at the given chunk offset.
+func (c *jfrChunk) parseMetadata(offset int64) error {
+	if offset < jfrHeaderSize || offset >= int64(len(c.data)) {
+		return fmt.Errorf("bad JFR metadata offset %d", offset)
+	}
+	c.pos = int(offset)
+	if _, err := c.readInt(); err != nil { // size
+		return err
+	}
+	if typ, err := c.readLong(); err != nil || typ != jfrMetadataEvent {
+		return fmt.Errorf("bad JFR metadata event")
+	}
+	for i := 0; i < 3; i++ { // start time, duration, metadata id
+		if _, err := c.readLong(); err != nil {
+			return err
+		}
+	}
+	n, err := c.count()
+	if err != nil {
+		return err
+	}
+	strs := make([]string, n)
+	for i := range strs {
+		v, err := c.readString()
+		if err != nil {
+			return err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("bad JFR metadata string")
+		}
+		strs[i] = s
+	}
+	root, err := c.element(strs, 0)
+	if err != nil {
+		return err
+	}
+
+	c.classes = make(map[int64]*jfrClass)
+	c.byName = make(map[string]*jfrClass)
+	var walk func(e *jfrElement)
+	walk = func(e *jfrElement) {
+		if e.name != "class" {
+			for _, ch := range e.children {
+				walk(ch)
+			}
+			return
+		}
+		id, err := strconv.ParseInt(e.attrs["id"], 10, 64)
+		if err != nil {
+			return
+		}
+		class := &jfrClass{id: id, name: e.attrs["name"]}
+		for _, ch := range e.children {
+			if ch.name != "field" {
+				continue
+			}
+			fid, err := strconv.ParseInt(ch.attrs["class"], 10, 64)
+			if err != nil {
+				continue
+			}
+			class.fields = append(class.fields, jfrField{
+				name:         ch.attrs["name"],
+				class:        fid,
+				constantPool: ch.attrs["constantPool"] == "true",
+				array:        ch.attrs["dimension"] == "1",
+			})
+		}
+		c.classes[id] = class
+		c.byName[class.name] = class
+	}
+	walk(root)
+	return nil
+}
+
+func (c *jfrChunk) element(strs []string, depth int) (*jfrElement, error) {
+	if depth > jfrMaxDepth {
+		return nil, fmt.Errorf("JFR metadata nested too deeply")
+	}
+	str := func() (string, error) {
+		i, err := c.readInt()
+		if err != nil {
+			return "", err
+		}
+		if i < 0 || i >= int64(len(strs)) {
+			return "", fmt.Errorf("bad JFR metadata string index %d", i)
+		}
+		return strs[i], nil
+	}
+	name, err := str()
+	if err != nil {
+		return nil, err
+	}
+	e := &jfrElement{name: name, attrs: make(map[string]string)}
+	n, err := c.count()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		k, err := str()
+		if err != nil {
+			return nil, err
+		}
+		if e.attrs[k], err = str(); err != nil {
+			return nil, err
+		}
+	}
+	if n, err = c.count(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		ch, err := c.element(strs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		e.children = append(e.children, ch)
+	}
+	return e, nil
+}
+
+// parseConstantPools parses the chain of constant pool events starting
+// at the given chunk offset. Each event holds the offset of the next
+// one relative to itself; the last one has an offset of zero.
+func (c *jfrChunk) parseConstantPools(offset int64) error {
+	c.pools = make(map[int64]map[int64]interface{})
+	for seen := 0; ; seen++ {
+		if offset < jfrHeaderSize || offset >= int64(len(c.data)) || seen > len(c.data) {
+			return fmt.Errorf("bad JFR constant pool offset %d", offset)
+		}
+		c.pos = int(offset)
+		if _, err := c.readInt(); err != nil { // size
+			return err
+		}
+		if typ, err := c.readLong(); err != nil || typ != jfrConstantPoolEvent {
+			return fmt.Errorf("bad JFR constant pool event")
+		}
+		var delta int64
+		for i := 0; i < 3; i++ { // start time, duration, delta
+			var err error
+			if delta, err = c.readLong(); err != nil {
+				return err
+			}
+		}
+		if _, err := c.readByte(); err != nil { // flush
+			return err
+		}
+		n, err := c.count()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			id, err := c.readLong()
+			if err != nil {
+				return err
+			}
+			class := c.classes[id]
+			if class == nil {
+				return fmt.Errorf("unknown JFR constant pool type %d", id)
+			}
+			entries, err := c.count()
+			if err != nil {
+				return err
+			}
+			pool := c.pools[id]
+			if pool == nil {
+				pool = make(map[int64]interface{})
+				c.pools[id] = pool
+			}
+			for j := 0; j < entries; j++ {
+				key, err := c.readLong()
+				if err != nil {
+					return err
+				}
+				if pool[key], err = c.value(class, 0); err != nil {
+					return err
+				}
+			}
+		}
+		if delta == 0 {
+			return nil
+		}
+		offset += delta
+	}
+}
+
+// resolve follows constant pool references.
+func (c *jfrChunk) resolve(v interface{}) interface{} {
+	for i := 0; i < jfrMaxDepth; i++ {
+		r, ok := v.(jfrRef)
+		if !ok {
+			return v
+		}
+		v = c.pools[r.class][r.key]
+	}
+	return nil
+}
+
+// field returns the value found by following the named fields from v,
+// or nil if there is no such value.
+func (c *jfrChunk) field(v interface{}, names ...string) interface{} {
+	for _, name := range names {
+		o, ok := c.resolve(v).(*jfrObject)
+		if !ok {
+			return nil
+		}
+		v = o.get(name)
+	}
+	return c.resolve(v)
+}
+
+// str returns the string found by following the named fields from v.
+// Objects with a single field, like symbols, are unwrapped.
+func (c *jfrChunk) str(v interface{}, names ...string) string {
+	v = c.field(v, names...)
+	for i := 0; i < jfrMaxDepth; i++ {
+		o, ok := v.(*jfrObject)
+		if !ok || len(o.values) != 1 {
+			break
+		}
+		v = c.resolve(o.values[0])
+	}
+	s, _ := v.(string)
+	return s
+}
+
+func (c *jfrChunk) num(v interface{}, names ...string) int64 {
+	i, _ := c.field(v, names...).(int64)
+	return i
+}
+
+// nanos converts a tick count from the chunk to nanoseconds.
+func (c *jfrChunk) nanos(ticks int64) int64 {
+	if c.ticksPerSec <= 0 {
+		return ticks
+	}
+	return int64(float64(ticks) * 1e9 / float64(c.ticksPerSec))
+}
+
+// Kinds of samples extracted from a recording, in the order of their
+// sample types.
+const (
+	jfrCPU = iota
+	jfrAlloc
+	jfrLock
+	jfrKinds
+)
+
+// jfrParser accumulates samples from all the chunks of a recording.
+type jfrParser struct {
+	p         *Profile
+	samples   map[string]*Sample
+	kinds     map[*Sample]int
+	functions map[string]*Function
+	locations map[string]*Location
+
+	present [jfrKinds]bool
+	period  int64 // CPU sampling period, in nanoseconds.
+
+	start, end int64
+}
+
+// parseJFR parses a JFR recording, which is expected to contain CPU,
+// allocation or lock contention events.
+func parseJFR(b []byte) (*Profile, error) {
+	jp := &jfrParser{
+		p:         &Profile{},
+		samples:   make(map[string]*Sample),
+		kinds:     make(map[*Sample]int),
+		functions: make(map[string]*Function),
+		locations: make(map[string]*Location),
+	}
+	for len(b) > 0 {
+		n, err := jp.parseChunk(b)
+		if err != nil {
+			return nil, fmt.Errorf("parsing JFR recording: %v", err)
+		}
+		b = b[n:]
+	}
+	return jp.finish()
+}
+
+// parseChunk parses the chunk at the start of b, and returns its size.
+func (jp *jfrParser) parseChunk(b []byte) (int, error) {
+	if len(b) < jfrHeaderSize || !isJFR(b) {
+		return 0, errJFRTruncated
+	}
+	if major := binary.BigEndian.Uint16(b[4:]); major != 2 {
+		return 0, fmt.Errorf("unsupported JFR version %d.%d", major, binary.BigEndian.Uint16(b[6:]))
+	}
+	be := binary.BigEndian
+	size := int64(be.Uint64(b[8:]))
+	if size < jfrHeaderSize || size > int64(len(b)) {
+		return 0, fmt.Errorf("bad JFR chunk size %d", size)
+	}
+	c := &jfrChunk{
+		data:          b[:size],
+		startNanos:    int64(be.Uint64(b[32:])),
+		durationNanos: int64(be.Uint64(b[40:])),
+		ticksPerSec:   int64(be.Uint64(b[56:])),
+		compressed:    be.Uint32(b[64:])&jfrCompressedInts != 0,
+	}
+	if err := c.parseMetadata(int64(be.Uint64(b[24:]))); err != nil {
+		return 0, err
+	}
+	if err := c.parseConstantPools(int64(be.Uint64(b[16:]))); err != nil {
+		return 0, err
+	}
+	if jp.start == 0 || c.startNanos < jp.start {
+		jp.start = c.startNanos
+	}
+	if end := c.startNanos + c.durationNanos; end > jp.end {
+		jp.end = end
+	}
+
+	var cpuClass int64 = -1
+	if class := c.byName[jfrExecutionSample]; class != nil {
+		cpuClass = class.id
+	}
+	for pos := int64(jfrHeaderSize); pos < size; {
+		c.pos = int(pos)
+		n, err := c.readInt()
+		if err != nil {
+			return 0, err
+		}
+		if n <= 0 || n > size-pos {
+			return 0, fmt.Errorf("bad JFR event size %d", n)
+		}
+		pos += n
+		typ, err := c.readLong()
+		if err != nil {
+			return 0, err
+		}
+		class := c.classes[typ]
+		if class == nil || typ == jfrMetadataEvent || typ == jfrConstantPoolEvent {
+			continue
+		}
+		switch class.name {
+		case jfrExecutionSample, jfrAllocationSample, jfrMonitorEnter, jfrActiveSetting:
+		default:
+			continue
+		}
+		c.data = c.data[:pos]
+		ev, err := c.value(class, 0)
+		c.data = b[:size]
+		if err != nil {
+			return 0, fmt.Errorf("decoding %s: %v", class.name, err)
+		}
+		switch class.name {
+		case jfrExecutionSample:
+			jp.add(c, jfrCPU, ev, "sampledThread", 1, 0)
+		case jfrAllocationSample:
+			jp.add(c, jfrAlloc, ev, "eventThread", 1, c.num(ev, "weight"))
+		case jfrMonitorEnter:
+			jp.add(c, jfrLock, ev, "eventThread", 1, c.nanos(c.num(ev, "duration")))
+		case jfrActiveSetting:
+			if c.num(ev, "id") == cpuClass && c.str(ev, "name") == "period" {
+				if period := parseJFRDuration(c.str(ev, "value")); period > 0 {
+					jp.period = period
+				}
+			}
+		}
+	}
+	return int(size), nil
+}
+
+// parseJFRDuration parses a setting value such as "20 ms" and returns
+// it in nanoseconds, or 0 if it cannot be parsed.
+func parseJFRDuration(s string) int64 {
+	f := strings.Fields(s)
+	if len(f) != 2 {
+		return 0
+	}
+	n, err := strconv.ParseInt(f[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	switch f[1] {
+	case "ns":
+		return n
+	case "us":
+		return n * 1e3
+	case "ms":
+		return n * 1e6
+	case "s":
+		return n * 1e9
+	case "m":
+		return n * 60e9
+	case "h":
+		return n * 3600e9
+	case "d":
+		return n * 86400e9
+	}
+	return 0
+}
+
+// add records an event of the given kind. The thread name, found in
+// the given field of the event, is recorded as a label.
+func (jp *jfrParser) add(c *jfrChunk, kind int, ev interface{}, threadField string, count, value int64) {
+	thread := c.str(ev, threadField, "javaName")
+	if thread == "" {
+		thread = c.str(ev, threadField, "osName")
+	}
+	frames, _ := c.field(ev, "stackTrace", "frames").([]interface{})
+
+	locs := make([]*Location, 0, len(frames))
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d;%s", kind, thread)
+	for _, f := range frames {
+		l := jp.location(c, f)
+		locs = append(locs, l)
+		fmt.Fprintf(&key, ";%d", l.ID)
+	}
+	jp.present[kind] = true
+
+	s := jp.samples[key.String()]
+	if s == nil {
+		s = &Sample{
+			Location: locs,
+			Value:    make([]int64, 2),
+		}
+		if thread != "" {
+			s.Label = map[string][]string{"thread": {thread}}
+		}
+		jp.samples[key.String()] = s
+		jp.kinds[s] = kind
+		jp.p.Sample = append(jp.p.Sample, s)
+	}
+	s.Value[0] += count
+	s.Value[1] += value
+}
+
+// location returns the location for a stack frame. JFR frames have no
+// addresses, so locations are identified by their method and line.
+func (jp *jfrParser) location(c *jfrChunk, frame interface{}) *Location {
+	class := strings.ReplaceAll(c.str(frame, "method", "type", "name"), "/", ".")
+	method := c.str(frame, "method", "name")
+	name := method
+	if class != "" {
+		name = class + "." + method
+	}
+	sysName := name + c.str(frame, "method", "descriptor")
+	line := c.num(frame, "lineNumber")
+
+	key := sysName + ":" + strconv.FormatInt(line, 10)
+	if l := jp.locations[key]; l != nil {
+		return l
+	}
+	fn := jp.functions[sysName]
+	if fn == nil {
+		fn = &Function{
+			ID:         uint64(len(jp.p.Function) + 1),
+			Name:       name,
+			SystemName: sysName,
+		}
+		jp.functions[sysName] = fn
+		jp.p.Function = append(jp.p.Function, fn)
+	}
+	if line < 0 {
+		line = 0
+	}
+	l := &Location{
+		ID:   uint64(len(jp.p.Location) + 1),
+		Line: []Line{{Function: fn, Line: line}},
+	}
+	jp.locations[key] = l
+	jp.p.Location = append(jp.p.Location, l)
+	return l
+}
+
+// finish sets up the sample types for the kinds of events found, and
+// lays out the sample values accordingly.
+func (jp *jfrParser) finish() (*Profile, error) {
+	p := jp.p
+	var offset [jfrKinds]int
+	for kind, present := range jp.present {
+		if !present {
+			continue
+		}
+		offset[kind] = len(p.SampleType)
+		switch kind {
+		case jfrCPU:
+			p.SampleType = append(p.SampleType, &ValueType{Type: "samples", Unit: "count"})
+			if jp.period > 0 {
+				p.SampleType = append(p.SampleType, &ValueType{Type: "cpu", Unit: "nanoseconds"})
+			}
+		case jfrAlloc:
+			p.SampleType = append(p.SampleType,
+				&ValueType{Type: "alloc_samples", Unit: "count"},
+				&ValueType{Type: "alloc_space", Unit: "bytes"})
+		case jfrLock:
+			p.SampleType = append(p.SampleType,
+				&ValueType{Type: "contentions", Unit: "count"},
+				&ValueType{Type: "delay", Unit: "nanoseconds"})
+		}
+	}
+	if len(p.SampleType) == 0 {
+		return nil, fmt.Errorf("parsing JFR recording: no execution, allocation or monitor events")
+	}
+
+	switch {
+	case jp.present[jfrCPU] && jp.period > 0:
+		p.PeriodType = &ValueType{Type: "cpu", Unit: "nanoseconds"}
+		p.Period = jp.period
+		p.DefaultSampleType = "cpu"
+	case jp.present[jfrCPU]:
+		p.PeriodType = &ValueType{Type: "samples", Unit: "count"}
+		p.Period = 1
+		p.DefaultSampleType = "samples"
+	case jp.present[jfrAlloc]:
+		p.PeriodType = &ValueType{Type: "space", Unit: "bytes"}
+		p.DefaultSampleType = "alloc_space"
+	default:
+		p.PeriodType = &ValueType{Type: "contentions", Unit: "count"}
+		p.DefaultSampleType = "delay"
+	}
+
+	for _, s := range p.Sample {
+		kind := jp.kinds[s]
+		count, value := s.Value[0], s.Value[1]
+		s.Value = make([]int64, len(p.SampleType))
+		s.Value[offset[kind]] = count
+		if kind != jfrCPU {
+			s.Value[offset[kind]+1] = value
+		} else if jp.period > 0 {
+			s.Value[offset[kind]+1] = count * jp.period
+		}
+	}
+
+	p.TimeNanos = jp.start
+	if jp.end > jp.start {
+		p.DurationNanos = jp.end - jp.start
+	}
+	return p, nil
+}
@@ -0,0 +1,292 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+// jfrVarint appends v as a JFR compressed integer.
+func jfrVarint(b []byte, v int64) []byte {
+	u := uint64(v)
+	for i := 0; i < 8; i++ {
+		if u < 0x80 {
+			return append(b, byte(u))
+		}
+		b = append(b, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(b, byte(u))
+}
+
+func jfrVarints(vs ...int64) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = jfrVarint(b, v)
+	}
+	return b
+}
+
+// jfrUTF8 returns s encoded as an inline UTF-8 string.
+func jfrUTF8(s string) []byte {
+	return append(jfrVarint([]byte{3}, int64(len(s))), s...)
+}
+
+// jfrEvent prepends the size of body, padded to four bytes as done by
+// the JDK.
+func jfrEvent(body ...[]byte) []byte {
+	n := 4
+	for _, b := range body {
+		n += len(b)
+	}
+	ev := []byte{byte(n) | 0x80, byte(n>>7) | 0x80, byte(n>>14) | 0x80, byte(n >> 21)}
+	for _, b := range body {
+		ev = append(ev, b...)
+	}
+	return ev
+}
+
+// jfrTestField is a field declaration: name, type id and whether it
+// is a constant pool reference or an array.
+type jfrTestField struct {
+	name    string
+	class   int64
+	cp, arr bool
+}
+
+type jfrTestClass struct {
+	id     int64
+	name   string
+	fields []jfrTestField
+}
+
+var jfrTestClasses = []jfrTestClass{
+	{4, "long", nil},
+	{5, "int", nil},
+	{6, "boolean", nil},
+	{7, "java.lang.String", nil},
+	{20, "java.lang.Thread", []jfrTestField{{name: "javaName", class: 7}}},
+	{21, "jdk.types.Symbol", []jfrTestField{{name: "string", class: 7}}},
+	{22, "java.lang.Class", []jfrTestField{{name: "name", class: 21, cp: true}}},
+	{23, "jdk.types.Method", []jfrTestField{
+		{name: "type", class: 22, cp: true},
+		{name: "name", class: 21, cp: true},
+		{name: "descriptor", class: 21, cp: true},
+	}},
+	{24, "jdk.types.StackFrame", []jfrTestField{
+		{name: "method", class: 23, cp: true},
+		{name: "lineNumber", class: 5},
+	}},
+	{25, "jdk.types.StackTrace", []jfrTestField{
+		{name: "truncated", class: 6},
+		{name: "frames", class: 24, arr: true},
+	}},
+	{100, "jdk.ExecutionSample", []jfrTestField{
+		{name: "startTime", class: 4},
+		{name: "sampledThread", class: 20, cp: true},
+		{name: "stackTrace", class: 25, cp: true},
+	}},
+	{101, "jdk.ObjectAllocationSample", []jfrTestField{
+		{name: "startTime", class: 4},
+		{name: "eventThread", class: 20, cp: true},
+		{name: "stackTrace", class: 25, cp: true},
+		{name: "weight", class: 4},
+	}},
+	{102, "jdk.JavaMonitorEnter", []jfrTestField{
+		{name: "startTime", class: 4},
+		{name: "duration", class: 4},
+		{name: "eventThread", class: 20, cp: true},
+		{name: "stackTrace", class: 25, cp: true},
+	}},
+	{103, "jdk.ActiveSetting", []jfrTestField{
+		{name: "startTime", class: 4},
+		{name: "id", class: 4},
+		{name: "name", class: 7},
+		{name: "value", class: 7},
+	}},
+}
+
+// jfrMetadata returns the metadata event declaring jfrTestClasses.
+func jfrMetadata() []byte {
+	var strs []string
+	index := make(map[string]int64)
+	str := func(s string) int64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = int64(len(strs))
+		strs = append(strs, s)
+		return index[s]
+	}
+	type attr struct{ k, v string }
+	element := func(name string, attrs []attr, children ...[]byte) []byte {
+		b := jfrVarints(str(name), int64(len(attrs)))
+		for _, a := range attrs {
+			b = append(b, jfrVarints(str(a.k), str(a.v))...)
+		}
+		b = jfrVarint(b, int64(len(children)))
+		for _, c := range children {
+			b = append(b, c...)
+		}
+		return b
+	}
+	var classes [][]byte
+	for _, c := range jfrTestClasses {
+		var fields [][]byte
+		for _, f := range c.fields {
+			attrs := []attr{{"name", f.name}, {"class", strconv.FormatInt(f.class, 10)}}
+			if f.cp {
+				attrs = append(attrs, attr{"constantPool", "true"})
+			}
+			if f.arr {
+				attrs = append(attrs, attr{"dimension", "1"})
+			}
+			fields = append(fields, element("field", attrs))
+		}
+		attrs := []attr{{"id", strconv.FormatInt(c.id, 10)}, {"name", c.name}}
+		classes = append(classes, element("class", attrs, fields...))
+	}
+	root := element("root", nil, element("metadata", nil, classes...))
+
+	b := jfrVarints(jfrMetadataEvent, 0, 0, 1, int64(len(strs)))
+	for _, s := range strs {
+		b = append(b, jfrUTF8(s)...)
+	}
+	return jfrEvent(b, root)
+}
+
+// jfrConstants returns a constant pool event holding a single stack
+// trace, App.main calling App.work, on thread "main".
+func jfrConstants() []byte {
+	b := jfrVarints(jfrConstantPoolEvent, 0, 0, 0)
+	b = append(b, 0) // flush
+	b = jfrVarint(b, 5)
+	b = append(b, jfrVarints(20, 1, 1)...)
+	b = append(b, jfrUTF8("main")...)
+	b = append(b, jfrVarints(21, 5)...)
+	for i, s := range []string{"com/example/App", "main", "work", "([Ljava/lang/String;)V", "()V"} {
+		b = append(b, jfrVarint(nil, int64(i+1))...)
+		b = append(b, jfrUTF8(s)...)
+	}
+	b = append(b, jfrVarints(22, 1, 1, 1)...)
+	b = append(b, jfrVarints(23, 2, 1, 1, 2, 4, 2, 1, 3, 5)...)
+	b = append(b, jfrVarints(25, 1, 1)...)
+	b = append(b, 0) // truncated
+	b = append(b, jfrVarints(2, 2, 20, 1, 10)...)
+	return jfrEvent(b)
+}
+
+func jfrChunkData() []byte {
+	var events []byte
+	events = append(events, jfrEvent(jfrVarints(103, 0, 100), jfrUTF8("period"), jfrUTF8("10 ms"))...)
+	events = append(events, jfrEvent(jfrVarints(100, 10, 1, 1))...)
+	events = append(events, jfrEvent(jfrVarints(100, 20, 1, 1))...)
+	events = append(events, jfrEvent(jfrVarints(101, 30, 1, 1, 4096))...)
+	events = append(events, jfrEvent(jfrVarints(102, 40, 5000, 1, 1))...)
+	metadata := jfrMetadata()
+	constants := jfrConstants()
+
+	metaOffset := jfrHeaderSize + len(events)
+	cpOffset := metaOffset + len(metadata)
+	size := cpOffset + len(constants)
+
+	h := make([]byte, jfrHeaderSize)
+	copy(h, jfrMagic)
+	be := binary.BigEndian
+	be.PutUint16(h[4:], 2)
+	be.PutUint64(h[8:], uint64(size))
+	be.PutUint64(h[16:], uint64(cpOffset))
+	be.PutUint64(h[24:], uint64(metaOffset))
+	be.PutUint64(h[32:], 1e9)
+	be.PutUint64(h[40:], 2e9)
+	be.PutUint64(h[56:], 1e9)
+	be.PutUint32(h[64:], jfrCompressedInts)
+
+	data := append(h, events...)
+	data = append(data, metadata...)
+	return append(data, constants...)
+}
+
+func TestParseJFR(t *testing.T) {
+	p, err := ParseData(jfrChunkData())
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	want := []string{
+		"samples/count", "cpu/nanoseconds",
+		"alloc_samples/count", "alloc_space/bytes",
+		"contentions/count", "delay/nanoseconds",
+	}
+	if len(types) != len(want) {
+		t.Fatalf("got sample types %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("got sample types %v, want %v", types, want)
+		}
+	}
+	if p.Period != 10e6 || p.DefaultSampleType != "cpu" {
+		t.Errorf("got period %d default %q, want 10000000 cpu", p.Period, p.DefaultSampleType)
+	}
+	if p.TimeNanos != 1e9 || p.DurationNanos != 2e9 {
+		t.Errorf("got time %d duration %d, want 1e9 2e9", p.TimeNanos, p.DurationNanos)
+	}
+
+	wantValues := [][]int64{
+		{2, 20e6, 0, 0, 0, 0},
+		{0, 0, 1, 4096, 0, 0},
+		{0, 0, 0, 0, 1, 5000},
+	}
+	if len(p.Sample) != len(wantValues) {
+		t.Fatalf("got %d samples, want %d", len(p.Sample), len(wantValues))
+	}
+	for i, s := range p.Sample {
+		for j, v := range wantValues[i] {
+			if s.Value[j] != v {
+				t.Errorf("sample %d: got values %v, want %v", i, s.Value, wantValues[i])
+				break
+			}
+		}
+		if got := stackString(s); got != "com.example.App.main;com.example.App.work" {
+			t.Errorf("sample %d: got stack %q", i, got)
+		}
+		if got := s.Label["thread"]; len(got) != 1 || got[0] != "main" {
+			t.Errorf("sample %d: got thread label %v, want [main]", i, got)
+		}
+		if l := s.Location[0].Line[0]; l.Line != 20 || l.Function.SystemName != "com.example.App.work()V" {
+			t.Errorf("sample %d: got leaf %s:%d", i, l.Function.SystemName, l.Line)
+		}
+	}
+}
+
+func TestParseJFRMalformed(t *testing.T) {
+	data := jfrChunkData()
+	for _, n := range []int{4, jfrHeaderSize, len(data) - 1} {
+		if _, err := ParseData(data[:n]); err == nil {
+			t.Errorf("ParseData of %d byte prefix: got no error", n)
+		}
+	}
+	bad := append([]byte(nil), data...)
+	binary.BigEndian.PutUint64(bad[24:], uint64(len(data)+10))
+	if _, err := ParseData(bad); err == nil {
+		t.Errorf("ParseData with bad metadata offset: got no error")
+	}
+}
@@ -0,0 +1,1359 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements conversion between profile.proto and the
+// OpenTelemetry profiles signal, as defined by ProfilesData in
+// opentelemetry/proto/profiles/v1development/profiles.proto from
+// opentelemetry-proto v1.7.0: the lookup tables are shared by the profiles
+// in the ProfilesDictionary of ProfilesData, and the samples refer to
+// ranges of Profile.location_indices. The profiles signal is still in
+// development, and the later releases of the messages are not compatible.
+//
+// The OpenTelemetry messages are encoded and decoded by hand using the
+// helpers in proto.go, in the same way as the profile.proto messages.
+
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attribute keys used when converting to and from OpenTelemetry.
+const (
+	// otlpBuildIDAttr is the mapping attribute holding the build id,
+	// following the OpenTelemetry semantic conventions.
+	otlpBuildIDAttr = "process.executable.build_id.gnu"
+	// otlpBuildIDPrefix matches all the build id flavors defined by the
+	// OpenTelemetry semantic conventions.
+	otlpBuildIDPrefix = "process.executable.build_id."
+
+	otlpDropFramesAttr = "pprof.drop_frames"
+	otlpKeepFramesAttr = "pprof.keep_frames"
+
+	// Labels holding the trace and span ids of OpenTelemetry links.
+	otlpTraceIDLabel = "trace_id"
+	otlpSpanIDLabel  = "span_id"
+)
+
+// WriteOTLP writes the profile as an uncompressed marshaled
+// OpenTelemetry ProfilesData message holding a single profile.
+// String and numeric labels are converted to attributes, except for
+// the "trace_id" and "span_id" labels, which are converted to links.
+func (p *Profile) WriteOTLP(w io.Writer) error {
+	p.encodeMu.Lock()
+	data := marshal(p.toOTLP())
+	p.encodeMu.Unlock()
+	_, err := w.Write(data)
+	return err
+}
+
+// ParseOTLP parses a marshaled OpenTelemetry ProfilesData message,
+// which may be gzip-compressed, and returns a profile for each of the
+// profiles it contains. Resource and scope attributes are recorded as
+// comments, attributes as labels, and links as "trace_id" and
+// "span_id" labels.
+func ParseOTLP(data []byte) ([]*Profile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewBuffer(data))
+		if err == nil {
+			data, err = io.ReadAll(gz)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
+	}
+	pd := &otlpProfilesData{}
+	if err := unmarshal(data, pd); err != nil {
+		return nil, fmt.Errorf("parsing OTLP profiles: %v", err)
+	}
+	if pd.dictionary == nil {
+		pd.dictionary = &otlpDictionary{}
+	}
+	var profiles []*Profile
+	for _, rp := range pd.resourceProfiles {
+		for _, sp := range rp.scopeProfiles {
+			for _, op := range sp.profiles {
+				c := &otlpConverter{
+					d:         pd.dictionary,
+					functions: make(map[int64]*Function),
+					mappings:  make(map[int64]*Mapping),
+					locations: make(map[int64]*Location),
+				}
+				p, err := c.fromOTLP(op, rp, sp)
+				if err != nil {
+					return nil, fmt.Errorf("parsing OTLP profiles: %v", err)
+				}
+				if err := p.CheckValid(); err != nil {
+					return nil, fmt.Errorf("malformed profile: %v", err)
+				}
+				profiles = append(profiles, p)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+// otlpAttrKey identifies a unique attribute in the attribute table.
+type otlpAttrKey struct {
+	key   string
+	isNum bool
+	str   string
+	num   int64
+}
+
+// otlpEncoder holds the state needed to build the dictionary while
+// converting a profile.
+type otlpEncoder struct {
+	d         *otlpDictionary
+	strings   map[string]int
+	attrs     map[otlpAttrKey]int64
+	units     map[string]string
+	links     map[[2]string]int64
+	functions map[*Function]int64
+	mappings  map[*Mapping]int64
+	locations map[*Location]int64
+}
+
+func (p *Profile) toOTLP() *otlpProfilesData {
+	e := &otlpEncoder{
+		// As the string table starts with the empty string, the function
+		// table starts with the empty function, which the lines without a
+		// function refer to.
+		d:         &otlpDictionary{functionTable: []*otlpFunction{{}}},
+		strings:   make(map[string]int),
+		attrs:     make(map[otlpAttrKey]int64),
+		units:     make(map[string]string),
+		links:     make(map[[2]string]int64),
+		functions: make(map[*Function]int64),
+		mappings:  make(map[*Mapping]int64),
+		locations: make(map[*Location]int64),
+	}
+	addString(e.strings, "")
+
+	op := &otlpProfile{
+		timeNanos:     p.TimeNanos,
+		durationNanos: p.DurationNanos,
+		period:        p.Period,
+	}
+	// Without a default sample type, pprof uses the last one.
+	op.defaultSampleTypeIndex = int64(len(p.SampleType) - 1)
+	for i, st := range p.SampleType {
+		op.sampleType = append(op.sampleType, e.valueType(st))
+		if st.Type == p.DefaultSampleType {
+			op.defaultSampleTypeIndex = int64(i)
+		}
+	}
+	if p.PeriodType != nil {
+		op.periodType = e.valueType(p.PeriodType)
+	}
+	for _, c := range p.Comments {
+		op.commentStrindices = append(op.commentStrindices, addString(e.strings, c))
+	}
+	if p.DropFrames != "" {
+		op.attributeIndices = append(op.attributeIndices, e.attribute(otlpAttrKey{key: otlpDropFramesAttr, str: p.DropFrames}))
+	}
+	if p.KeepFrames != "" {
+		op.attributeIndices = append(op.attributeIndices, e.attribute(otlpAttrKey{key: otlpKeepFramesAttr, str: p.KeepFrames}))
+	}
+
+	for _, s := range p.Sample {
+		os := &otlpSample{
+			locationsStartIndex: int64(len(op.locationIndices)),
+			locationsLength:     int64(len(s.Location)),
+			value:               s.Value,
+		}
+		for _, l := range s.Location {
+			op.locationIndices = append(op.locationIndices, e.location(l))
+		}
+		var traceID, spanID string
+		for _, k := range sortedKeys(s.Label) {
+			for _, v := range s.Label[k] {
+				switch k {
+				case otlpTraceIDLabel:
+					traceID = v
+					continue
+				case otlpSpanIDLabel:
+					spanID = v
+					continue
+				}
+				os.attributeIndices = append(os.attributeIndices, e.attribute(otlpAttrKey{key: k, str: v}))
+			}
+		}
+		for _, k := range sortedKeys(s.NumLabel) {
+			units := s.NumUnit[k]
+			for i, v := range s.NumLabel[k] {
+				if i < len(units) && units[i] != "" {
+					e.units[k] = units[i]
+				}
+				os.attributeIndices = append(os.attributeIndices, e.attribute(otlpAttrKey{key: k, isNum: true, num: v}))
+			}
+		}
+		if traceID != "" || spanID != "" {
+			if idx, ok := e.link(traceID, spanID); ok {
+				os.linkIndex, os.hasLink = idx, true
+			} else {
+				// Keep ids that cannot be represented as a link as attributes.
+				if traceID != "" {
+					os.attributeIndices = append(os.attributeIndices, e.attribute(otlpAttrKey{key: otlpTraceIDLabel, str: traceID}))
+				}
+				if spanID != "" {
+					os.attributeIndices = append(os.attributeIndices, e.attribute(otlpAttrKey{key: otlpSpanIDLabel, str: spanID}))
+				}
+			}
+		}
+		op.sample = append(op.sample, os)
+	}
+
+	for _, k := range sortedKeys(e.units) {
+		e.d.attributeUnits = append(e.d.attributeUnits, &otlpAttributeUnit{
+			attributeKeyStrindex: addString(e.strings, k),
+			unitStrindex:         addString(e.strings, e.units[k]),
+		})
+	}
+	e.d.stringTable = make([]string, len(e.strings))
+	for s, i := range e.strings {
+		e.d.stringTable[i] = s
+	}
+
+	return &otlpProfilesData{
+		resourceProfiles: []*otlpResourceProfiles{{
+			scopeProfiles: []*otlpScopeProfiles{{
+				profiles: []*otlpProfile{op},
+			}},
+		}},
+		dictionary: e.d,
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e *otlpEncoder) valueType(vt *ValueType) *otlpValueType {
+	return &otlpValueType{
+		typeStrindex: addString(e.strings, vt.Type),
+		unitStrindex: addString(e.strings, vt.Unit),
+	}
+}
+
+func (e *otlpEncoder) attribute(k otlpAttrKey) int64 {
+	if i, ok := e.attrs[k]; ok {
+		return i
+	}
+	kv := &otlpKeyValue{key: k.key}
+	if k.isNum {
+		kv.value = otlpAnyValue{kind: otlpIntValue, intValue: k.num}
+	} else {
+		kv.value = otlpAnyValue{kind: otlpStringValue, stringValue: k.str}
+	}
+	i := int64(len(e.d.attributeTable))
+	e.d.attributeTable = append(e.d.attributeTable, kv)
+	e.attrs[k] = i
+	return i
+}
+
+// link returns the index of the link for the given hex-encoded trace
+// and span ids, or false if they are not valid OpenTelemetry ids.
+func (e *otlpEncoder) link(traceID, spanID string) (int64, bool) {
+	key := [2]string{traceID, spanID}
+	if i, ok := e.links[key]; ok {
+		return i, true
+	}
+	t, err := hex.DecodeString(traceID)
+	if err != nil || (len(t) != 16 && len(t) != 0) {
+		return 0, false
+	}
+	s, err := hex.DecodeString(spanID)
+	if err != nil || (len(s) != 8 && len(s) != 0) {
+		return 0, false
+	}
+	i := int64(len(e.d.linkTable))
+	e.d.linkTable = append(e.d.linkTable, &otlpLink{traceID: t, spanID: s})
+	e.links[key] = i
+	return i, true
+}
+
+func (e *otlpEncoder) function(f *Function) int64 {
+	if i, ok := e.functions[f]; ok {
+		return i
+	}
+	i := int64(len(e.d.functionTable))
+	e.d.functionTable = append(e.d.functionTable, &otlpFunction{
+		nameStrindex:       addString(e.strings, f.Name),
+		systemNameStrindex: addString(e.strings, f.SystemName),
+		filenameStrindex:   addString(e.strings, f.Filename),
+		startLine:          f.StartLine,
+	})
+	e.functions[f] = i
+	return i
+}
+
+func (e *otlpEncoder) mapping(m *Mapping) int64 {
+	if i, ok := e.mappings[m]; ok {
+		return i
+	}
+	om := &otlpMapping{
+		memoryStart:      m.Start,
+		memoryLimit:      m.Limit,
+		fileOffset:       m.Offset,
+		filenameStrindex: addString(e.strings, m.File),
+		hasFunctions:     m.HasFunctions,
+		hasFilenames:     m.HasFilenames,
+		hasLineNumbers:   m.HasLineNumbers,
+		hasInlineFrames:  m.HasInlineFrames,
+	}
+	if m.BuildID != "" {
+		om.attributeIndices = append(om.attributeIndices, e.attribute(otlpAttrKey{key: otlpBuildIDAttr, str: m.BuildID}))
+	}
+	i := int64(len(e.d.mappingTable))
+	e.d.mappingTable = append(e.d.mappingTable, om)
+	e.mappings[m] = i
+	return i
+}
+
+func (e *otlpEncoder) location(l *Location) int64 {
+	if i, ok := e.locations[l]; ok {
+		return i
+	}
+	ol := &otlpLocation{
+		address:  l.Address,
+		isFolded: l.IsFolded,
+	}
+	if l.Mapping != nil {
+		ol.mappingIndex, ol.hasMapping = e.mapping(l.Mapping), true
+	}
+	for _, ln := range l.Line {
+		var fn int64
+		if ln.Function != nil {
+			fn = e.function(ln.Function)
+		}
+		ol.line = append(ol.line, &otlpLine{
+			functionIndex: fn,
+			line:          ln.Line,
+			column:        ln.Column,
+		})
+	}
+	i := int64(len(e.d.locationTable))
+	e.d.locationTable = append(e.d.locationTable, ol)
+	e.locations[l] = i
+	return i
+}
+
+// otlpConverter holds the state needed to convert an OpenTelemetry
+// profile, which refers to a dictionary shared by all the profiles in
+// a ProfilesData message.
+type otlpConverter struct {
+	d *otlpDictionary
+	p *Profile
+
+	functions map[int64]*Function
+	mappings  map[int64]*Mapping
+	locations map[int64]*Location
+}
+
+func (c *otlpConverter) str(i int64) (string, error) {
+	if i < 0 || i >= int64(len(c.d.stringTable)) {
+		return "", fmt.Errorf("string index %d out of range", i)
+	}
+	return c.d.stringTable[i], nil
+}
+
+func (c *otlpConverter) attr(i int64) (*otlpKeyValue, error) {
+	if i < 0 || i >= int64(len(c.d.attributeTable)) {
+		return nil, fmt.Errorf("attribute index %d out of range", i)
+	}
+	return c.d.attributeTable[i], nil
+}
+
+func (c *otlpConverter) valueType(vt *otlpValueType) (*ValueType, error) {
+	t, err := c.str(vt.typeStrindex)
+	if err != nil {
+		return nil, err
+	}
+	u, err := c.str(vt.unitStrindex)
+	if err != nil {
+		return nil, err
+	}
+	return &ValueType{Type: t, Unit: u}, nil
+}
+
+func (c *otlpConverter) fromOTLP(op *otlpProfile, rp *otlpResourceProfiles, sp *otlpScopeProfiles) (*Profile, error) {
+	p := &Profile{
+		TimeNanos:     op.timeNanos,
+		DurationNanos: op.durationNanos,
+		Period:        op.period,
+		PeriodType:    &ValueType{},
+	}
+	c.p = p
+	var err error
+	for _, vt := range op.sampleType {
+		st, err := c.valueType(vt)
+		if err != nil {
+			return nil, err
+		}
+		p.SampleType = append(p.SampleType, st)
+	}
+	if i := op.defaultSampleTypeIndex; i >= 0 && i < int64(len(p.SampleType)) {
+		p.DefaultSampleType = p.SampleType[i].Type
+	}
+	if op.periodType != nil {
+		if p.PeriodType, err = c.valueType(op.periodType); err != nil {
+			return nil, err
+		}
+	}
+	if rp.resource != nil {
+		for _, kv := range rp.resource.attributes {
+			p.Comments = append(p.Comments, "resource: "+kv.key+"="+kv.value.String())
+		}
+	}
+	if sp.scope != nil && sp.scope.name != "" {
+		p.Comments = append(p.Comments, "scope: "+strings.TrimSpace(sp.scope.name+" "+sp.scope.version))
+	}
+	for _, i := range op.attributeIndices {
+		kv, err := c.attr(i)
+		if err != nil {
+			return nil, err
+		}
+		switch kv.key {
+		case otlpDropFramesAttr:
+			p.DropFrames = kv.value.String()
+		case otlpKeepFramesAttr:
+			p.KeepFrames = kv.value.String()
+		default:
+			p.Comments = append(p.Comments, kv.key+"="+kv.value.String())
+		}
+	}
+	for _, i := range op.commentStrindices {
+		s, err := c.str(i)
+		if err != nil {
+			return nil, err
+		}
+		p.Comments = append(p.Comments, s)
+	}
+
+	units := make(map[string]string)
+	for _, au := range c.d.attributeUnits {
+		k, err := c.str(au.attributeKeyStrindex)
+		if err != nil {
+			return nil, err
+		}
+		if units[k], err = c.str(au.unitStrindex); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, os := range op.sample {
+		s, err := c.sample(op, os, units)
+		if err != nil {
+			return nil, err
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p, nil
+}
+
+func (c *otlpConverter) sample(op *otlpProfile, os *otlpSample, units map[string]string) (*Sample, error) {
+	p := c.p
+	if len(os.value) != len(p.SampleType) {
+		return nil, fmt.Errorf("sample has %d values vs. %d types", len(os.value), len(p.SampleType))
+	}
+	start, n := os.locationsStartIndex, os.locationsLength
+	if start < 0 || n < 0 || start+n > int64(len(op.locationIndices)) {
+		return nil, fmt.Errorf("sample locations [%d,%d) out of range", start, start+n)
+	}
+	s := &Sample{Value: os.value}
+	for _, li := range op.locationIndices[start : start+n] {
+		l, err := c.location(li)
+		if err != nil {
+			return nil, err
+		}
+		s.Location = append(s.Location, l)
+	}
+	for _, i := range os.attributeIndices {
+		kv, err := c.attr(i)
+		if err != nil {
+			return nil, err
+		}
+		if kv.value.kind == otlpIntValue {
+			if s.NumLabel == nil {
+				s.NumLabel = make(map[string][]int64)
+			}
+			s.NumLabel[kv.key] = append(s.NumLabel[kv.key], kv.value.intValue)
+			if u, ok := units[kv.key]; ok {
+				if s.NumUnit == nil {
+					s.NumUnit = make(map[string][]string)
+				}
+				s.NumUnit[kv.key] = append(padStringArray(s.NumUnit[kv.key], len(s.NumLabel[kv.key])-1), u)
+			}
+			continue
+		}
+		if s.Label == nil {
+			s.Label = make(map[string][]string)
+		}
+		s.Label[kv.key] = append(s.Label[kv.key], kv.value.String())
+	}
+	for k, us := range s.NumUnit {
+		s.NumUnit[k] = padStringArray(us, len(s.NumLabel[k]))
+	}
+	if os.hasLink {
+		if os.linkIndex < 0 || os.linkIndex >= int64(len(c.d.linkTable)) {
+			return nil, fmt.Errorf("link index %d out of range", os.linkIndex)
+		}
+		link := c.d.linkTable[os.linkIndex]
+		for _, id := range []struct {
+			key string
+			id  []byte
+		}{{otlpTraceIDLabel, link.traceID}, {otlpSpanIDLabel, link.spanID}} {
+			if len(id.id) == 0 || bytes.Count(id.id, []byte{0}) == len(id.id) {
+				continue
+			}
+			if s.Label == nil {
+				s.Label = make(map[string][]string)
+			}
+			s.Label[id.key] = append(s.Label[id.key], hex.EncodeToString(id.id))
+		}
+	}
+	return s, nil
+}
+
+func (c *otlpConverter) location(i int64) (*Location, error) {
+	if l := c.locations[i]; l != nil {
+		return l, nil
+	}
+	if i < 0 || i >= int64(len(c.d.locationTable)) {
+		return nil, fmt.Errorf("location index %d out of range", i)
+	}
+	ol := c.d.locationTable[i]
+	l := &Location{
+		ID:       uint64(len(c.p.Location) + 1),
+		Address:  ol.address,
+		IsFolded: ol.isFolded,
+	}
+	if ol.hasMapping {
+		m, err := c.mapping(ol.mappingIndex)
+		if err != nil {
+			return nil, err
+		}
+		l.Mapping = m
+	}
+	for _, ln := range ol.line {
+		f, err := c.function(ln.functionIndex)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			// pprof lines need a function; the address remains.
+			continue
+		}
+		l.Line = append(l.Line, Line{Function: f, Line: ln.line, Column: ln.column})
+	}
+	c.locations[i] = l
+	c.p.Location = append(c.p.Location, l)
+	return l, nil
+}
+
+func (c *otlpConverter) mapping(i int64) (*Mapping, error) {
+	if m := c.mappings[i]; m != nil {
+		return m, nil
+	}
+	if i < 0 || i >= int64(len(c.d.mappingTable)) {
+		return nil, fmt.Errorf("mapping index %d out of range", i)
+	}
+	om := c.d.mappingTable[i]
+	file, err := c.str(om.filenameStrindex)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{
+		ID:              uint64(len(c.p.Mapping) + 1),
+		Start:           om.memoryStart,
+		Limit:           om.memoryLimit,
+		Offset:          om.fileOffset,
+		File:            file,
+		HasFunctions:    om.hasFunctions,
+		HasFilenames:    om.hasFilenames,
+		HasLineNumbers:  om.hasLineNumbers,
+		HasInlineFrames: om.hasInlineFrames,
+	}
+	for _, ai := range om.attributeIndices {
+		kv, err := c.attr(ai)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(kv.key, otlpBuildIDPrefix) && m.BuildID == "" {
+			m.BuildID = kv.value.String()
+		}
+	}
+	const prefix = "[kernel.kallsyms]"
+	if strings.HasPrefix(m.File, prefix) {
+		m.KernelRelocationSymbol = m.File[len(prefix):]
+	}
+	c.mappings[i] = m
+	c.p.Mapping = append(c.p.Mapping, m)
+	return m, nil
+}
+
+func (c *otlpConverter) function(i int64) (*Function, error) {
+	if f := c.functions[i]; f != nil {
+		return f, nil
+	}
+	if i < 0 || i >= int64(len(c.d.functionTable)) {
+		return nil, fmt.Errorf("function index %d out of range", i)
+	}
+	of := c.d.functionTable[i]
+	if i == 0 && *of == (otlpFunction{}) {
+		// The empty function of the lines without a function.
+		return nil, nil
+	}
+	f := &Function{
+		ID:        uint64(len(c.p.Function) + 1),
+		StartLine: of.startLine,
+	}
+	var err error
+	if f.Name, err = c.str(of.nameStrindex); err != nil {
+		return nil, err
+	}
+	if f.SystemName, err = c.str(of.systemNameStrindex); err != nil {
+		return nil, err
+	}
+	if f.Filename, err = c.str(of.filenameStrindex); err != nil {
+		return nil, err
+	}
+	c.functions[i] = f
+	c.p.Function = append(c.p.Function, f)
+	return f, nil
+}
+
+// The OpenTelemetry messages follow. Field comments give the field
+// definitions from the .proto files.
+
+// otlpProfilesData corresponds to profiles.v1development.ProfilesData.
+type otlpProfilesData struct {
+	resourceProfiles []*otlpResourceProfiles
+	dictionary       *otlpDictionary
+}
+
+func (p *otlpProfilesData) decoder() []decoder {
+	return otlpProfilesDataDecoder
+}
+
+func (p *otlpProfilesData) encode(b *buffer) {
+	for _, x := range p.resourceProfiles {
+		encodeMessage(b, 1, x)
+	}
+	if p.dictionary != nil {
+		encodeMessage(b, 2, p.dictionary)
+	}
+}
+
+var otlpProfilesDataDecoder = []decoder{
+	nil, // 0
+	// repeated ResourceProfiles resource_profiles = 1
+	func(b *buffer, m message) error {
+		x := new(otlpResourceProfiles)
+		pp := m.(*otlpProfilesData)
+		pp.resourceProfiles = append(pp.resourceProfiles, x)
+		return decodeMessage(b, x)
+	},
+	// ProfilesDictionary dictionary = 2
+	func(b *buffer, m message) error {
+		x := new(otlpDictionary)
+		m.(*otlpProfilesData).dictionary = x
+		return decodeMessage(b, x)
+	},
+}
+
+// otlpDictionary corresponds to profiles.v1development.ProfilesDictionary.
+type otlpDictionary struct {
+	mappingTable   []*otlpMapping
+	locationTable  []*otlpLocation
+	functionTable  []*otlpFunction
+	linkTable      []*otlpLink
+	stringTable    []string
+	attributeTable []*otlpKeyValue
+	attributeUnits []*otlpAttributeUnit
+}
+
+func (p *otlpDictionary) decoder() []decoder {
+	return otlpDictionaryDecoder
+}
+
+func (p *otlpDictionary) encode(b *buffer) {
+	for _, x := range p.mappingTable {
+		encodeMessage(b, 1, x)
+	}
+	for _, x := range p.locationTable {
+		encodeMessage(b, 2, x)
+	}
+	for _, x := range p.functionTable {
+		encodeMessage(b, 3, x)
+	}
+	for _, x := range p.linkTable {
+		encodeMessage(b, 4, x)
+	}
+	encodeStrings(b, 5, p.stringTable)
+	for _, x := range p.attributeTable {
+		encodeMessage(b, 6, x)
+	}
+	for _, x := range p.attributeUnits {
+		encodeMessage(b, 7, x)
+	}
+}
+
+var otlpDictionaryDecoder = []decoder{
+	nil, // 0
+	// repeated Mapping mapping_table = 1
+	func(b *buffer, m message) error {
+		x := new(otlpMapping)
+		pp := m.(*otlpDictionary)
+		pp.mappingTable = append(pp.mappingTable, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Location location_table = 2
+	func(b *buffer, m message) error {
+		x := new(otlpLocation)
+		pp := m.(*otlpDictionary)
+		pp.locationTable = append(pp.locationTable, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Function function_table = 3
+	func(b *buffer, m message) error {
+		x := new(otlpFunction)
+		pp := m.(*otlpDictionary)
+		pp.functionTable = append(pp.functionTable, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Link link_table = 4
+	func(b *buffer, m message) error {
+		x := new(otlpLink)
+		pp := m.(*otlpDictionary)
+		pp.linkTable = append(pp.linkTable, x)
+		return decodeMessage(b, x)
+	},
+	// repeated string string_table = 5
+	func(b *buffer, m message) error { return decodeStrings(b, &m.(*otlpDictionary).stringTable) },
+	// repeated KeyValue attribute_table = 6
+	func(b *buffer, m message) error {
+		x := new(otlpKeyValue)
+		pp := m.(*otlpDictionary)
+		pp.attributeTable = append(pp.attributeTable, x)
+		return decodeMessage(b, x)
+	},
+	// repeated AttributeUnit attribute_units = 7
+	func(b *buffer, m message) error {
+		x := new(otlpAttributeUnit)
+		pp := m.(*otlpDictionary)
+		pp.attributeUnits = append(pp.attributeUnits, x)
+		return decodeMessage(b, x)
+	},
+}
+
+// otlpResourceProfiles corresponds to profiles.v1development.ResourceProfiles.
+type otlpResourceProfiles struct {
+	resource      *otlpResource
+	scopeProfiles []*otlpScopeProfiles
+	schemaURL     string
+}
+
+func (p *otlpResourceProfiles) decoder() []decoder {
+	return otlpResourceProfilesDecoder
+}
+
+func (p *otlpResourceProfiles) encode(b *buffer) {
+	if p.resource != nil {
+		encodeMessage(b, 1, p.resource)
+	}
+	for _, x := range p.scopeProfiles {
+		encodeMessage(b, 2, x)
+	}
+	encodeStringOpt(b, 3, p.schemaURL)
+}
+
+var otlpResourceProfilesDecoder = []decoder{
+	nil, // 0
+	// Resource resource = 1
+	func(b *buffer, m message) error {
+		x := new(otlpResource)
+		m.(*otlpResourceProfiles).resource = x
+		return decodeMessage(b, x)
+	},
+	// repeated ScopeProfiles scope_profiles = 2
+	func(b *buffer, m message) error {
+		x := new(otlpScopeProfiles)
+		pp := m.(*otlpResourceProfiles)
+		pp.scopeProfiles = append(pp.scopeProfiles, x)
+		return decodeMessage(b, x)
+	},
+	// string schema_url = 3
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpResourceProfiles).schemaURL) },
+}
+
+// otlpResource corresponds to resource.v1.Resource.
+type otlpResource struct {
+	attributes []*otlpKeyValue
+}
+
+func (p *otlpResource) decoder() []decoder {
+	return otlpResourceDecoder
+}
+
+func (p *otlpResource) encode(b *buffer) {
+	for _, x := range p.attributes {
+		encodeMessage(b, 1, x)
+	}
+}
+
+var otlpResourceDecoder = []decoder{
+	nil, // 0
+	// repeated KeyValue attributes = 1
+	func(b *buffer, m message) error {
+		x := new(otlpKeyValue)
+		pp := m.(*otlpResource)
+		pp.attributes = append(pp.attributes, x)
+		return decodeMessage(b, x)
+	},
+}
+
+// otlpScopeProfiles corresponds to profiles.v1development.ScopeProfiles.
+type otlpScopeProfiles struct {
+	scope     *otlpScope
+	profiles  []*otlpProfile
+	schemaURL string
+}
+
+func (p *otlpScopeProfiles) decoder() []decoder {
+	return otlpScopeProfilesDecoder
+}
+
+func (p *otlpScopeProfiles) encode(b *buffer) {
+	if p.scope != nil {
+		encodeMessage(b, 1, p.scope)
+	}
+	for _, x := range p.profiles {
+		encodeMessage(b, 2, x)
+	}
+	encodeStringOpt(b, 3, p.schemaURL)
+}
+
+var otlpScopeProfilesDecoder = []decoder{
+	nil, // 0
+	// InstrumentationScope scope = 1
+	func(b *buffer, m message) error {
+		x := new(otlpScope)
+		m.(*otlpScopeProfiles).scope = x
+		return decodeMessage(b, x)
+	},
+	// repeated Profile profiles = 2
+	func(b *buffer, m message) error {
+		x := new(otlpProfile)
+		pp := m.(*otlpScopeProfiles)
+		pp.profiles = append(pp.profiles, x)
+		return decodeMessage(b, x)
+	},
+	// string schema_url = 3
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpScopeProfiles).schemaURL) },
+}
+
+// otlpScope corresponds to common.v1.InstrumentationScope.
+type otlpScope struct {
+	name, version string
+}
+
+func (p *otlpScope) decoder() []decoder {
+	return otlpScopeDecoder
+}
+
+func (p *otlpScope) encode(b *buffer) {
+	encodeStringOpt(b, 1, p.name)
+	encodeStringOpt(b, 2, p.version)
+}
+
+var otlpScopeDecoder = []decoder{
+	nil, // 0
+	// string name = 1
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpScope).name) },
+	// string version = 2
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpScope).version) },
+}
+
+// otlpProfile corresponds to profiles.v1development.Profile.
+type otlpProfile struct {
+	sampleType             []*otlpValueType
+	sample                 []*otlpSample
+	locationIndices        []int64
+	timeNanos              int64
+	durationNanos          int64
+	periodType             *otlpValueType
+	period                 int64
+	commentStrindices      []int64
+	defaultSampleTypeIndex int64
+	profileID              []byte
+	attributeIndices       []int64
+}
+
+func (p *otlpProfile) decoder() []decoder {
+	return otlpProfileDecoder
+}
+
+func (p *otlpProfile) encode(b *buffer) {
+	for _, x := range p.sampleType {
+		encodeMessage(b, 1, x)
+	}
+	for _, x := range p.sample {
+		encodeMessage(b, 2, x)
+	}
+	encodeInt64s(b, 3, p.locationIndices)
+	encodeInt64Opt(b, 4, p.timeNanos)
+	encodeInt64Opt(b, 5, p.durationNanos)
+	if p.periodType != nil {
+		encodeMessage(b, 6, p.periodType)
+	}
+	encodeInt64Opt(b, 7, p.period)
+	encodeInt64s(b, 8, p.commentStrindices)
+	encodeInt64Opt(b, 9, p.defaultSampleTypeIndex)
+	encodeStringOpt(b, 10, string(p.profileID))
+	encodeInt64s(b, 14, p.attributeIndices)
+}
+
+var otlpProfileDecoder = []decoder{
+	nil, // 0
+	// repeated ValueType sample_type = 1
+	func(b *buffer, m message) error {
+		x := new(otlpValueType)
+		pp := m.(*otlpProfile)
+		pp.sampleType = append(pp.sampleType, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Sample sample = 2
+	func(b *buffer, m message) error {
+		x := new(otlpSample)
+		pp := m.(*otlpProfile)
+		pp.sample = append(pp.sample, x)
+		return decodeMessage(b, x)
+	},
+	// repeated int32 location_indices = 3
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpProfile).locationIndices) },
+	// int64 time_nanos = 4
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpProfile).timeNanos) },
+	// int64 duration_nanos = 5
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpProfile).durationNanos) },
+	// ValueType period_type = 6
+	func(b *buffer, m message) error {
+		x := new(otlpValueType)
+		m.(*otlpProfile).periodType = x
+		return decodeMessage(b, x)
+	},
+	// int64 period = 7
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpProfile).period) },
+	// repeated int32 comment_strindices = 8
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpProfile).commentStrindices) },
+	// int32 default_sample_type_index = 9
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpProfile).defaultSampleTypeIndex) },
+	// bytes profile_id = 10
+	func(b *buffer, m message) error { return decodeBytes(b, &m.(*otlpProfile).profileID) },
+	nil, // uint32 dropped_attributes_count = 11
+	nil, // string original_payload_format = 12
+	nil, // bytes original_payload = 13
+	// repeated int32 attribute_indices = 14
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpProfile).attributeIndices) },
+}
+
+// otlpValueType corresponds to profiles.v1development.ValueType.
+type otlpValueType struct {
+	typeStrindex int64
+	unitStrindex int64
+}
+
+func (p *otlpValueType) decoder() []decoder {
+	return otlpValueTypeDecoder
+}
+
+func (p *otlpValueType) encode(b *buffer) {
+	encodeInt64Opt(b, 1, p.typeStrindex)
+	encodeInt64Opt(b, 2, p.unitStrindex)
+}
+
+var otlpValueTypeDecoder = []decoder{
+	nil, // 0
+	// int32 type_strindex = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpValueType).typeStrindex) },
+	// int32 unit_strindex = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpValueType).unitStrindex) },
+}
+
+// otlpSample corresponds to profiles.v1development.Sample.
+type otlpSample struct {
+	locationsStartIndex int64
+	locationsLength     int64
+	value               []int64
+	attributeIndices    []int64
+	linkIndex           int64
+	hasLink             bool
+}
+
+func (p *otlpSample) decoder() []decoder {
+	return otlpSampleDecoder
+}
+
+func (p *otlpSample) encode(b *buffer) {
+	encodeInt64Opt(b, 1, p.locationsStartIndex)
+	encodeInt64Opt(b, 2, p.locationsLength)
+	encodeInt64s(b, 3, p.value)
+	encodeInt64s(b, 4, p.attributeIndices)
+	if p.hasLink {
+		encodeInt64(b, 5, p.linkIndex)
+	}
+}
+
+var otlpSampleDecoder = []decoder{
+	nil, // 0
+	// int32 locations_start_index = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpSample).locationsStartIndex) },
+	// int32 locations_length = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpSample).locationsLength) },
+	// repeated int64 value = 3
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpSample).value) },
+	// repeated int32 attribute_indices = 4
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpSample).attributeIndices) },
+	// optional int32 link_index = 5
+	func(b *buffer, m message) error {
+		m.(*otlpSample).hasLink = true
+		return decodeInt64(b, &m.(*otlpSample).linkIndex)
+	},
+}
+
+// otlpMapping corresponds to profiles.v1development.Mapping.
+type otlpMapping struct {
+	memoryStart      uint64
+	memoryLimit      uint64
+	fileOffset       uint64
+	filenameStrindex int64
+	attributeIndices []int64
+	hasFunctions     bool
+	hasFilenames     bool
+	hasLineNumbers   bool
+	hasInlineFrames  bool
+}
+
+func (p *otlpMapping) decoder() []decoder {
+	return otlpMappingDecoder
+}
+
+func (p *otlpMapping) encode(b *buffer) {
+	encodeUint64Opt(b, 1, p.memoryStart)
+	encodeUint64Opt(b, 2, p.memoryLimit)
+	encodeUint64Opt(b, 3, p.fileOffset)
+	encodeInt64Opt(b, 4, p.filenameStrindex)
+	encodeInt64s(b, 5, p.attributeIndices)
+	encodeBoolOpt(b, 6, p.hasFunctions)
+	encodeBoolOpt(b, 7, p.hasFilenames)
+	encodeBoolOpt(b, 8, p.hasLineNumbers)
+	encodeBoolOpt(b, 9, p.hasInlineFrames)
+}
+
+var otlpMappingDecoder = []decoder{
+	nil, // 0
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpMapping).memoryStart) },      // uint64 memory_start = 1
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpMapping).memoryLimit) },      // uint64 memory_limit = 2
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpMapping).fileOffset) },       // uint64 file_offset = 3
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpMapping).filenameStrindex) },  // int32 filename_strindex = 4
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpMapping).attributeIndices) }, // repeated int32 attribute_indices = 5
+	func(b *buffer, m message) error { return decodeBool(b, &m.(*otlpMapping).hasFunctions) },       // bool has_functions = 6
+	func(b *buffer, m message) error { return decodeBool(b, &m.(*otlpMapping).hasFilenames) },       // bool has_filenames = 7
+	func(b *buffer, m message) error { return decodeBool(b, &m.(*otlpMapping).hasLineNumbers) },     // bool has_line_numbers = 8
+	func(b *buffer, m message) error { return decodeBool(b, &m.(*otlpMapping).hasInlineFrames) },    // bool has_inline_frames = 9
+}
+
+// otlpLocation corresponds to profiles.v1development.Location.
+type otlpLocation struct {
+	mappingIndex int64
+	hasMapping   bool
+	address      uint64
+	line         []*otlpLine
+	isFolded     bool
+}
+
+func (p *otlpLocation) decoder() []decoder {
+	return otlpLocationDecoder
+}
+
+func (p *otlpLocation) encode(b *buffer) {
+	if p.hasMapping {
+		encodeInt64(b, 1, p.mappingIndex)
+	}
+	encodeUint64Opt(b, 2, p.address)
+	for _, x := range p.line {
+		encodeMessage(b, 3, x)
+	}
+	encodeBoolOpt(b, 4, p.isFolded)
+}
+
+var otlpLocationDecoder = []decoder{
+	nil, // 0
+	// optional int32 mapping_index = 1
+	func(b *buffer, m message) error {
+		m.(*otlpLocation).hasMapping = true
+		return decodeInt64(b, &m.(*otlpLocation).mappingIndex)
+	},
+	// uint64 address = 2
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpLocation).address) },
+	// repeated Line line = 3
+	func(b *buffer, m message) error {
+		x := new(otlpLine)
+		pp := m.(*otlpLocation)
+		pp.line = append(pp.line, x)
+		return decodeMessage(b, x)
+	},
+	// bool is_folded = 4
+	func(b *buffer, m message) error { return decodeBool(b, &m.(*otlpLocation).isFolded) },
+}
+
+// otlpLine corresponds to profiles.v1development.Line.
+type otlpLine struct {
+	functionIndex int64
+	line          int64
+	column        int64
+}
+
+func (p *otlpLine) decoder() []decoder {
+	return otlpLineDecoder
+}
+
+func (p *otlpLine) encode(b *buffer) {
+	encodeInt64Opt(b, 1, p.functionIndex)
+	encodeInt64Opt(b, 2, p.line)
+	encodeInt64Opt(b, 3, p.column)
+}
+
+var otlpLineDecoder = []decoder{
+	nil, // 0
+	// int32 function_index = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpLine).functionIndex) },
+	// int64 line = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpLine).line) },
+	// int64 column = 3
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpLine).column) },
+}
+
+// otlpFunction corresponds to profiles.v1development.Function.
+type otlpFunction struct {
+	nameStrindex       int64
+	systemNameStrindex int64
+	filenameStrindex   int64
+	startLine          int64
+}
+
+func (p *otlpFunction) decoder() []decoder {
+	return otlpFunctionDecoder
+}
+
+func (p *otlpFunction) encode(b *buffer) {
+	encodeInt64Opt(b, 1, p.nameStrindex)
+	encodeInt64Opt(b, 2, p.systemNameStrindex)
+	encodeInt64Opt(b, 3, p.filenameStrindex)
+	encodeInt64Opt(b, 4, p.startLine)
+}
+
+var otlpFunctionDecoder = []decoder{
+	nil, // 0
+	// int32 name_strindex = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).nameStrindex) },
+	// int32 system_name_strindex = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).systemNameStrindex) },
+	// int32 filename_strindex = 3
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).filenameStrindex) },
+	// int64 start_line = 4
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).startLine) },
+}
+
+// otlpLink corresponds to profiles.v1development.Link.
+type otlpLink struct {
+	traceID, spanID []byte
+}
+
+func (p *otlpLink) decoder() []decoder {
+	return otlpLinkDecoder
+}
+
+func (p *otlpLink) encode(b *buffer) {
+	encodeStringOpt(b, 1, string(p.traceID))
+	encodeStringOpt(b, 2, string(p.spanID))
+}
+
+var otlpLinkDecoder = []decoder{
+	nil, // 0
+	// bytes trace_id = 1
+	func(b *buffer, m message) error { return decodeBytes(b, &m.(*otlpLink).traceID) },
+	// bytes span_id = 2
+	func(b *buffer, m message) error { return decodeBytes(b, &m.(*otlpLink).spanID) },
+}
+
+// otlpAttributeUnit corresponds to profiles.v1development.AttributeUnit.
+type otlpAttributeUnit struct {
+	attributeKeyStrindex int64
+	unitStrindex         int64
+}
+
+func (p *otlpAttributeUnit) decoder() []decoder {
+	return otlpAttributeUnitDecoder
+}
+
+func (p *otlpAttributeUnit) encode(b *buffer) {
+	encodeInt64Opt(b, 1, p.attributeKeyStrindex)
+	encodeInt64Opt(b, 2, p.unitStrindex)
+}
+
+var otlpAttributeUnitDecoder = []decoder{
+	nil, // 0
+	// int32 attribute_key_strindex = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpAttributeUnit).attributeKeyStrindex) },
+	// int32 unit_strindex = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpAttributeUnit).unitStrindex) },
+}
+
+// otlpKeyValue corresponds to common.v1.KeyValue.
+type otlpKeyValue struct {
+	key   string
+	value otlpAnyValue
+}
+
+func (p *otlpKeyValue) decoder() []decoder {
+	return otlpKeyValueDecoder
+}
+
+func (p *otlpKeyValue) encode(b *buffer) {
+	encodeString(b, 1, p.key)
+	encodeMessage(b, 2, &p.value)
+}
+
+var otlpKeyValueDecoder = []decoder{
+	nil, // 0
+	// string key = 1
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpKeyValue).key) },
+	// AnyValue value = 2
+	func(b *buffer, m message) error { return decodeMessage(b, &m.(*otlpKeyValue).value) },
+}
+
+// Kinds of values held by an otlpAnyValue.
+const (
+	otlpNoValue = iota
+	otlpStringValue
+	otlpBoolValue
+	otlpIntValue
+	otlpDoubleValue
+	otlpBytesValue
+	otlpOtherValue // Arrays and key-value lists, which are not decoded.
+)
+
+// otlpAnyValue corresponds to common.v1.AnyValue.
+type otlpAnyValue struct {
+	kind        int
+	stringValue string
+	boolValue   bool
+	intValue    int64
+	doubleValue float64
+	bytesValue  []byte
+}
+
+// String returns a textual representation of the value.
+func (p *otlpAnyValue) String() string {
+	switch p.kind {
+	case otlpStringValue:
+		return p.stringValue
+	case otlpBoolValue:
+		return strconv.FormatBool(p.boolValue)
+	case otlpIntValue:
+		return strconv.FormatInt(p.intValue, 10)
+	case otlpDoubleValue:
+		return strconv.FormatFloat(p.doubleValue, 'g', -1, 64)
+	case otlpBytesValue:
+		return hex.EncodeToString(p.bytesValue)
+	case otlpOtherValue:
+		return "?"
+	}
+	return ""
+}
+
+func (p *otlpAnyValue) decoder() []decoder {
+	return otlpAnyValueDecoder
+}
+
+func (p *otlpAnyValue) encode(b *buffer) {
+	switch p.kind {
+	case otlpStringValue:
+		encodeString(b, 1, p.stringValue)
+	case otlpBoolValue:
+		encodeBool(b, 2, p.boolValue)
+	case otlpIntValue:
+		encodeInt64(b, 3, p.intValue)
+	case otlpDoubleValue:
+		encodeVarint(b, 4<<3|1)
+		bits := math.Float64bits(p.doubleValue)
+		for i := 0; i < 8; i++ {
+			b.data = append(b.data, byte(bits>>(8*i)))
+		}
+	case otlpBytesValue:
+		encodeString(b, 7, string(p.bytesValue))
+	}
+}
+
+var otlpAnyValueDecoder = []decoder{
+	nil, // 0
+	// string string_value = 1
+	func(b *buffer, m message) error {
+		m.(*otlpAnyValue).kind = otlpStringValue
+		return decodeString(b, &m.(*otlpAnyValue).stringValue)
+	},
+	// bool bool_value = 2
+	func(b *buffer, m message) error {
+		m.(*otlpAnyValue).kind = otlpBoolValue
+		return decodeBool(b, &m.(*otlpAnyValue).boolValue)
+	},
+	// int64 int_value = 3
+	func(b *buffer, m message) error {
+		m.(*otlpAnyValue).kind = otlpIntValue
+		return decodeInt64(b, &m.(*otlpAnyValue).intValue)
+	},
+	// double double_value = 4
+	func(b *buffer, m message) error {
+		if err := checkType(b, 1); err != nil {
+			return err
+		}
+		m.(*otlpAnyValue).kind = otlpDoubleValue
+		m.(*otlpAnyValue).doubleValue = math.Float64frombits(b.u64)
+		return nil
+	},
+	// ArrayValue array_value = 5
+	func(b *buffer, m message) error { m.(*otlpAnyValue).kind = otlpOtherValue; return nil },
+	// KeyValueList kvlist_value = 6
+	func(b *buffer, m message) error { m.(*otlpAnyValue).kind = otlpOtherValue; return nil },
+	// bytes bytes_value = 7
+	func(b *buffer, m message) error {
+		m.(*otlpAnyValue).kind = otlpBytesValue
+		return decodeBytes(b, &m.(*otlpAnyValue).bytesValue)
+	},
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+func TestOTLPRoundTrip(t *testing.T) {
+	p := testProfile1.Copy()
+	p.DefaultSampleType = p.SampleType[0].Type
+	p.Comments = []string{"comment"}
+	p.Mapping[0].BuildID = "abcdef"
+	p.Sample[0].Label = map[string][]string{
+		"key1":     {"value1"},
+		"trace_id": {"0102030405060708090a0b0c0d0e0f10"},
+		"span_id":  {"0102030405060708"},
+	}
+	p.Sample[1].NumLabel = map[string][]int64{"bytes": {512}}
+	p.Sample[1].NumUnit = map[string][]string{"bytes": {"bytes"}}
+
+	var buf bytes.Buffer
+	if err := p.WriteOTLP(&buf); err != nil {
+		t.Fatalf("WriteOTLP: %v", err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(buf.Bytes())
+	zw.Close()
+
+	for _, data := range [][]byte{buf.Bytes(), gz.Bytes()} {
+		ps, err := ParseOTLP(data)
+		if err != nil {
+			t.Fatalf("ParseOTLP: %v", err)
+		}
+		if len(ps) != 1 {
+			t.Fatalf("got %d profiles, want 1", len(ps))
+		}
+		q := ps[0]
+		if !reflect.DeepEqual(q.SampleType, p.SampleType) {
+			t.Errorf("got sample types %v, want %v", q.SampleType, p.SampleType)
+		}
+		if q.DefaultSampleType != p.DefaultSampleType || q.Period != p.Period {
+			t.Errorf("got default type %q period %d, want %q %d", q.DefaultSampleType, q.Period, p.DefaultSampleType, p.Period)
+		}
+		if !reflect.DeepEqual(q.Comments, p.Comments) {
+			t.Errorf("got comments %v, want %v", q.Comments, p.Comments)
+		}
+		if len(q.Sample) != len(p.Sample) {
+			t.Fatalf("got %d samples, want %d", len(q.Sample), len(p.Sample))
+		}
+		for i, s := range q.Sample {
+			want := p.Sample[i]
+			if !reflect.DeepEqual(s.Value, want.Value) || !reflect.DeepEqual(s.Label, want.Label) ||
+				!reflect.DeepEqual(s.NumLabel, want.NumLabel) || !reflect.DeepEqual(s.NumUnit, want.NumUnit) {
+				t.Errorf("sample %d: got %s, want %s", i, s.string(), want.string())
+			}
+			if len(s.Location) != len(want.Location) {
+				t.Fatalf("sample %d: got %d locations, want %d", i, len(s.Location), len(want.Location))
+			}
+			for j, l := range s.Location {
+				wl := want.Location[j]
+				if l.Address != wl.Address || l.Mapping.File != wl.Mapping.File || l.Mapping.BuildID != wl.Mapping.BuildID {
+					t.Errorf("sample %d location %d: got %s, want %s", i, j, l.string(), wl.string())
+				}
+			}
+		}
+	}
+}
+
+func TestOTLPRoundTripLineWithoutFunction(t *testing.T) {
+	fn := &Function{ID: 1, Name: "main", Filename: "main.go"}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*Function{fn},
+		Location: []*Location{
+			{ID: 1, Address: 0x1000, Line: []Line{{Function: fn, Line: 10}}},
+			{ID: 2, Address: 0x2000, Line: []Line{{Line: 20}}},
+		},
+	}
+	p.Sample = []*Sample{{Location: p.Location, Value: []int64{1}}}
+
+	var buf bytes.Buffer
+	if err := p.WriteOTLP(&buf); err != nil {
+		t.Fatalf("WriteOTLP: %v", err)
+	}
+	ps, err := ParseOTLP(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseOTLP: %v", err)
+	}
+	locs := ps[0].Sample[0].Location
+	if len(locs) != 2 {
+		t.Fatalf("got %d locations, want 2", len(locs))
+	}
+	if f := locs[0].Line[0].Function; f == nil || f.Name != "main" {
+		t.Errorf("got function %v of the first line, want main", f)
+	}
+	if l := locs[1]; l.Address != 0x2000 || len(l.Line) != 0 {
+		t.Errorf("got second location %s, want address 0x2000 without lines", l.string())
+	}
+	if got := len(ps[0].Function); got != 1 {
+		t.Errorf("got %d functions, want 1", got)
+	}
+}
+
+func TestParseOTLPErrors(t *testing.T) {
+	p := testProfile1.Copy()
+	var buf bytes.Buffer
+	if err := p.WriteOTLP(&buf); err != nil {
+		t.Fatalf("WriteOTLP: %v", err)
+	}
+	d := &otlpProfilesData{}
+	if err := unmarshal(buf.Bytes(), d); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	d.resourceProfiles[0].scopeProfiles[0].profiles[0].locationIndices[0] = 1000
+	if _, err := ParseOTLP(marshal(d)); err == nil {
+		t.Errorf("ParseOTLP with out of range location: got no error")
+	}
+	if _, err := ParseOTLP([]byte{0xff, 0xff}); err == nil {
+		t.Errorf("ParseOTLP of garbage: got no error")
+	}
+}
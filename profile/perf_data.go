@@ -0,0 +1,895 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser for the perf.data file format written
+// by "perf record" on Linux, converting it into the profile.proto
+// format. Only the parts of the format needed to reconstruct sampled
+// callchains are decoded: the file header, the event attributes, the
+// mmap/mmap2/comm/fork records, PERF_RECORD_SAMPLE, and a few of the
+// optional feature sections.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+const (
+	perfMagic        = "PERFILE2"
+	perfMagicSwapped = "2ELIFREP"
+
+	perfFileHeaderSize = 104
+)
+
+// perf_event_attr.type values.
+const (
+	perfTypeHardware   = 0
+	perfTypeSoftware   = 1
+	perfTypeTracepoint = 2
+	perfTypeHWCache    = 3
+	perfTypeRaw        = 4
+	perfTypeBreakpoint = 5
+)
+
+// perf_event_attr.sample_type bits.
+const (
+	perfSampleIP         = 1 << 0
+	perfSampleTID        = 1 << 1
+	perfSampleTime       = 1 << 2
+	perfSampleAddr       = 1 << 3
+	perfSampleRead       = 1 << 4
+	perfSampleCallchain  = 1 << 5
+	perfSampleID         = 1 << 6
+	perfSampleCPU        = 1 << 7
+	perfSamplePeriod     = 1 << 8
+	perfSampleStreamID   = 1 << 9
+	perfSampleIdentifier = 1 << 16
+)
+
+// perf_event_attr.read_format bits.
+const (
+	perfFormatTotalTimeEnabled = 1 << 0
+	perfFormatTotalTimeRunning = 1 << 1
+	perfFormatID               = 1 << 2
+	perfFormatGroup            = 1 << 3
+	perfFormatLost             = 1 << 4
+)
+
+// perf_event_attr flag bits.
+const (
+	perfAttrFlagFreq = 1 << 10
+)
+
+// perf_event_header.type values.
+const (
+	perfRecordMmap   = 1
+	perfRecordComm   = 3
+	perfRecordFork   = 7
+	perfRecordSample = 9
+	perfRecordMmap2  = 10
+)
+
+// perf_event_header.misc bits.
+const (
+	perfRecordMiscCommExec    = 1 << 13
+	perfRecordMiscMmapBuildID = 1 << 14
+	perfRecordMiscBuildIDSize = 1 << 15
+)
+
+// Bits in the adds_features bitmap of the file header.
+const (
+	perfHeaderBuildID   = 2
+	perfHeaderHostname  = 3
+	perfHeaderOSRelease = 4
+	perfHeaderCmdline   = 11
+	perfHeaderEventDesc = 12
+)
+
+// perfContextMax is the lowest of the special PERF_CONTEXT_* markers
+// that perf interleaves with the addresses of a callchain.
+const perfContextMax = ^uint64(0) - 4095 + 1
+
+// perfKernelPID is the pid used by perf for kernel mmap records.
+const perfKernelPID = ^uint32(0)
+
+var perfHardwareEvents = []string{
+	"cycles", "instructions", "cache-references", "cache-misses",
+	"branch-instructions", "branch-misses", "bus-cycles",
+	"stalled-cycles-frontend", "stalled-cycles-backend", "ref-cycles",
+}
+
+var perfSoftwareEvents = []string{
+	"cpu-clock", "task-clock", "page-faults", "context-switches",
+	"cpu-migrations", "minor-faults", "major-faults", "alignment-faults",
+	"emulation-faults", "dummy", "bpf-output", "cgroup-switches",
+}
+
+// perfFileSection describes a region of the perf.data file.
+type perfFileSection struct {
+	offset, size uint64
+}
+
+// perfEventAttr holds the fields of a perf_event_attr relevant to
+// decoding samples.
+type perfEventAttr struct {
+	typ          uint32
+	config       uint64
+	samplePeriod uint64
+	sampleType   uint64
+	readFormat   uint64
+	flags        uint64
+	ids          []uint64
+
+	name  string
+	index int // Index of the first sample value for this event.
+}
+
+// perfMap is an address range mapped into a process.
+type perfMap struct {
+	start, limit, offset uint64
+	file                 string
+	buildID              string
+}
+
+// perfProcess tracks the address space of a process.
+type perfProcess struct {
+	maps []*perfMap
+	// locs caches the locations already resolved in this address space.
+	// It is reset whenever the address space changes.
+	locs map[uint64]*Location
+}
+
+// perfSampleKey identifies samples that can be aggregated together.
+type perfSampleKey struct {
+	attr     int
+	pid, tid uint32
+	stack    string
+}
+
+// perfParser holds the state needed while decoding a perf.data file.
+type perfParser struct {
+	b     []byte
+	order binary.ByteOrder
+
+	attrs    []*perfEventAttr
+	attrByID map[uint64]*perfEventAttr
+
+	buildIDs  map[string]string
+	procs     map[uint32]*perfProcess
+	comms     map[uint32]string
+	mappings  map[perfMap]*Mapping
+	samples   map[perfSampleKey]*Sample
+	nextLocID uint64
+
+	minTime, maxTime uint64
+
+	p *Profile
+}
+
+// isPerfData reports whether b starts with the perf.data file magic.
+func isPerfData(b []byte) bool {
+	if len(b) < len(perfMagic) {
+		return false
+	}
+	magic := string(b[:len(perfMagic)])
+	return magic == perfMagic || magic == perfMagicSwapped
+}
+
+// parsePerfData parses a perf.data file generated by "perf record"
+// and returns a newly populated Profile. Samples are attributed to
+// mappings reconstructed from the mmap records in the file, so they
+// can be symbolized later from local binaries.
+func parsePerfData(b []byte) (*Profile, error) {
+	if !isPerfData(b) {
+		return nil, errUnrecognized
+	}
+	pp := &perfParser{
+		b:        b,
+		order:    binary.LittleEndian,
+		attrByID: make(map[uint64]*perfEventAttr),
+		buildIDs: make(map[string]string),
+		procs:    make(map[uint32]*perfProcess),
+		comms:    make(map[uint32]string),
+		mappings: make(map[perfMap]*Mapping),
+		samples:  make(map[perfSampleKey]*Sample),
+		p:        &Profile{},
+	}
+	if string(b[:len(perfMagic)]) == perfMagicSwapped {
+		pp.order = binary.BigEndian
+	}
+	if err := pp.parse(); err != nil {
+		return nil, fmt.Errorf("perf.data: %v", err)
+	}
+	return pp.p, nil
+}
+
+func (pp *perfParser) parse() error {
+	if len(pp.b) < 16 {
+		return errMalformed
+	}
+	headerSize := pp.order.Uint64(pp.b[8:])
+	if headerSize != perfFileHeaderSize {
+		if headerSize == 16 {
+			return fmt.Errorf("pipe mode files are not supported")
+		}
+		return fmt.Errorf("unexpected header size %d", headerSize)
+	}
+	if len(pp.b) < perfFileHeaderSize {
+		return errMalformed
+	}
+	attrSize := pp.order.Uint64(pp.b[16:])
+	attrs := pp.section(pp.b[24:])
+	data := pp.section(pp.b[40:])
+	var features [4]uint64
+	for i := range features {
+		features[i] = pp.order.Uint64(pp.b[72+8*i:])
+	}
+
+	if err := pp.parseAttrs(attrs, attrSize); err != nil {
+		return err
+	}
+	if err := pp.parseFeatures(data, features); err != nil {
+		return err
+	}
+	pp.buildSampleTypes()
+	if err := pp.parseRecords(data); err != nil {
+		return err
+	}
+	pp.finish()
+	return nil
+}
+
+// section decodes a perf_file_section starting at b.
+func (pp *perfParser) section(b []byte) perfFileSection {
+	return perfFileSection{pp.order.Uint64(b), pp.order.Uint64(b[8:])}
+}
+
+// data returns the bytes covered by s, or nil if s is out of bounds.
+func (pp *perfParser) data(s perfFileSection) []byte {
+	if s.offset > uint64(len(pp.b)) || s.size > uint64(len(pp.b))-s.offset {
+		return nil
+	}
+	return pp.b[s.offset : s.offset+s.size]
+}
+
+// parseAttrs decodes the attribute section, which contains one
+// perf_event_attr per recorded event followed by the section holding
+// the sample ids assigned to that event.
+func (pp *perfParser) parseAttrs(s perfFileSection, attrSize uint64) error {
+	b := pp.data(s)
+	if b == nil || attrSize < 16+48 || s.size%attrSize != 0 {
+		return errMalformed
+	}
+	for len(b) > 0 {
+		entry := b[:attrSize]
+		b = b[attrSize:]
+		a, err := pp.parseAttr(entry[:attrSize-16])
+		if err != nil {
+			return err
+		}
+		ids := pp.data(pp.section(entry[attrSize-16:]))
+		if ids == nil {
+			return errMalformed
+		}
+		for ; len(ids) >= 8; ids = ids[8:] {
+			id := pp.order.Uint64(ids)
+			a.ids = append(a.ids, id)
+			pp.attrByID[id] = a
+		}
+		pp.attrs = append(pp.attrs, a)
+	}
+	if len(pp.attrs) == 0 {
+		return fmt.Errorf("no events recorded")
+	}
+	if len(pp.attrs) > 1 {
+		st := pp.attrs[0].sampleType
+		if st&(perfSampleID|perfSampleIdentifier) == 0 {
+			return fmt.Errorf("multiple events recorded without sample ids")
+		}
+		for _, a := range pp.attrs[1:] {
+			if a.sampleType != st {
+				return fmt.Errorf("events with different sample types are not supported")
+			}
+		}
+	}
+	return nil
+}
+
+// parseAttr decodes the leading fields of a perf_event_attr.
+func (pp *perfParser) parseAttr(b []byte) (*perfEventAttr, error) {
+	if len(b) < 48 {
+		return nil, errMalformed
+	}
+	a := &perfEventAttr{
+		typ:          pp.order.Uint32(b),
+		config:       pp.order.Uint64(b[8:]),
+		samplePeriod: pp.order.Uint64(b[16:]),
+		sampleType:   pp.order.Uint64(b[24:]),
+		readFormat:   pp.order.Uint64(b[32:]),
+		flags:        pp.order.Uint64(b[40:]),
+	}
+	a.name = perfEventName(a.typ, a.config)
+	return a, nil
+}
+
+// perfEventName returns a name for the event of the given type and
+// config, following the names used by the perf tool where possible.
+func perfEventName(typ uint32, config uint64) string {
+	switch typ {
+	case perfTypeHardware:
+		if config < uint64(len(perfHardwareEvents)) {
+			return perfHardwareEvents[config]
+		}
+	case perfTypeSoftware:
+		if config < uint64(len(perfSoftwareEvents)) {
+			return perfSoftwareEvents[config]
+		}
+	case perfTypeTracepoint:
+		return fmt.Sprintf("tracepoint-%d", config)
+	case perfTypeHWCache:
+		return fmt.Sprintf("cache-0x%x", config)
+	case perfTypeRaw:
+		return fmt.Sprintf("raw-0x%x", config)
+	case perfTypeBreakpoint:
+		return "breakpoint"
+	}
+	return fmt.Sprintf("event-%d-0x%x", typ, config)
+}
+
+// parseFeatures decodes the optional feature sections, which follow
+// the data section in the order of the bits set in the header bitmap.
+func (pp *perfParser) parseFeatures(data perfFileSection, features [4]uint64) error {
+	table := data.offset + data.size
+	for bit := 0; bit < 256; bit++ {
+		if features[bit/64]&(1<<(bit%64)) == 0 {
+			continue
+		}
+		if table+16 > uint64(len(pp.b)) {
+			return errMalformed
+		}
+		b := pp.data(pp.section(pp.b[table:]))
+		table += 16
+		if b == nil {
+			return errMalformed
+		}
+		switch bit {
+		case perfHeaderBuildID:
+			pp.parseBuildIDs(b)
+		case perfHeaderHostname:
+			if s, _ := pp.perfString(b); s != "" {
+				pp.p.Comments = append(pp.p.Comments, "hostname: "+s)
+			}
+		case perfHeaderOSRelease:
+			if s, _ := pp.perfString(b); s != "" {
+				pp.p.Comments = append(pp.p.Comments, "os release: "+s)
+			}
+		case perfHeaderCmdline:
+			pp.parseCmdline(b)
+		case perfHeaderEventDesc:
+			pp.parseEventDesc(b)
+		}
+	}
+	return nil
+}
+
+// perfString decodes a length-prefixed, NUL-padded string, returning
+// the string and the remaining bytes.
+func (pp *perfParser) perfString(b []byte) (string, []byte) {
+	if len(b) < 4 {
+		return "", nil
+	}
+	n := uint64(pp.order.Uint32(b))
+	b = b[4:]
+	if n > uint64(len(b)) {
+		return "", nil
+	}
+	return cString(b[:n]), b[n:]
+}
+
+// cString returns the contents of b up to the first NUL byte.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// parseBuildIDs decodes the build id feature section, a sequence of
+// build_id_event records associating file names with build ids.
+func (pp *perfParser) parseBuildIDs(b []byte) {
+	const buildIDOffset = 8 + 4 // header, pid
+	for len(b) >= 8 {
+		misc := pp.order.Uint16(b[4:])
+		size := int(pp.order.Uint16(b[6:]))
+		if size < buildIDOffset+24 || size > len(b) {
+			return
+		}
+		rec := b[:size]
+		b = b[size:]
+		id := rec[buildIDOffset : buildIDOffset+24]
+		n := 20
+		if misc&perfRecordMiscBuildIDSize != 0 && int(id[20]) <= 20 {
+			n = int(id[20])
+		}
+		if file := cString(rec[buildIDOffset+24:]); file != "" {
+			pp.buildIDs[file] = hex.EncodeToString(id[:n])
+		}
+	}
+}
+
+// parseCmdline decodes the command line used to record the profile
+// and adds it as a comment.
+func (pp *perfParser) parseCmdline(b []byte) {
+	if len(b) < 4 {
+		return
+	}
+	n := pp.order.Uint32(b)
+	b = b[4:]
+	var args []string
+	for i := uint32(0); i < n && b != nil; i++ {
+		var s string
+		s, b = pp.perfString(b)
+		args = append(args, s)
+	}
+	if len(args) > 0 {
+		cmd := args[0]
+		for _, a := range args[1:] {
+			cmd += " " + a
+		}
+		pp.p.Comments = append(pp.p.Comments, cmd)
+	}
+}
+
+// parseEventDesc decodes the event description feature section to
+// obtain the names of the recorded events as spelled by the user,
+// e.g. "cycles:u".
+func (pp *perfParser) parseEventDesc(b []byte) {
+	if len(b) < 8 {
+		return
+	}
+	n := pp.order.Uint32(b)
+	attrSize := uint64(pp.order.Uint32(b[4:]))
+	b = b[8:]
+	for i := uint32(0); i < n; i++ {
+		if uint64(len(b)) < attrSize+4 {
+			return
+		}
+		b = b[attrSize:]
+		nids := uint64(pp.order.Uint32(b))
+		var name string
+		if name, b = pp.perfString(b[4:]); b == nil || uint64(len(b)) < 8*nids {
+			return
+		}
+		var id uint64
+		if nids > 0 {
+			id = pp.order.Uint64(b)
+		}
+		b = b[8*nids:]
+		if name == "" {
+			continue
+		}
+		if a := pp.attrByID[id]; a != nil && nids > 0 {
+			a.name = name
+		} else if int(i) < len(pp.attrs) {
+			pp.attrs[i].name = name
+		}
+	}
+}
+
+// buildSampleTypes populates the sample types of the profile. Each
+// event contributes a sample count and the sum of the periods of its
+// samples.
+func (pp *perfParser) buildSampleTypes() {
+	p := pp.p
+	for i, a := range pp.attrs {
+		a.index = len(p.SampleType)
+		samples := &ValueType{Type: "samples", Unit: "count"}
+		if len(pp.attrs) > 1 {
+			samples.Type = a.name + "_samples"
+		}
+		unit := "count"
+		if a.typ == perfTypeSoftware && (a.config == 0 || a.config == 1) {
+			// cpu-clock and task-clock periods are in nanoseconds.
+			unit = "nanoseconds"
+		}
+		value := &ValueType{Type: a.name, Unit: unit}
+		p.SampleType = append(p.SampleType, samples, value)
+		if i == 0 {
+			p.PeriodType = &ValueType{Type: a.name, Unit: unit}
+			if a.flags&perfAttrFlagFreq == 0 {
+				p.Period = int64(a.samplePeriod)
+			}
+		}
+	}
+	if len(pp.attrs) > 1 {
+		p.DefaultSampleType = pp.attrs[0].name
+	}
+}
+
+// parseRecords decodes the records in the data section.
+func (pp *perfParser) parseRecords(s perfFileSection) error {
+	b := pp.data(s)
+	if b == nil {
+		return errMalformed
+	}
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return errMalformed
+		}
+		typ := pp.order.Uint32(b)
+		misc := pp.order.Uint16(b[4:])
+		size := int(pp.order.Uint16(b[6:]))
+		if size < 8 || size > len(b) {
+			return errMalformed
+		}
+		rec := b[8:size]
+		b = b[size:]
+
+		var err error
+		switch typ {
+		case perfRecordMmap:
+			err = pp.parseMmap(rec, misc, false)
+		case perfRecordMmap2:
+			err = pp.parseMmap(rec, misc, true)
+		case perfRecordComm:
+			err = pp.parseComm(rec, misc)
+		case perfRecordFork:
+			err = pp.parseFork(rec)
+		case perfRecordSample:
+			err = pp.parseSample(rec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// process returns the address space tracker for pid, creating one
+// if needed.
+func (pp *perfParser) process(pid uint32) *perfProcess {
+	proc := pp.procs[pid]
+	if proc == nil {
+		proc = &perfProcess{locs: make(map[uint64]*Location)}
+		pp.procs[pid] = proc
+	}
+	return proc
+}
+
+// parseMmap decodes a PERF_RECORD_MMAP or PERF_RECORD_MMAP2 record.
+func (pp *perfParser) parseMmap(b []byte, misc uint16, mmap2 bool) error {
+	if len(b) < 32 {
+		return errMalformed
+	}
+	pid := pp.order.Uint32(b)
+	m := &perfMap{
+		start:  pp.order.Uint64(b[8:]),
+		offset: pp.order.Uint64(b[24:]),
+	}
+	m.limit = m.start + pp.order.Uint64(b[16:])
+	b = b[32:]
+	if mmap2 {
+		if len(b) < 32 {
+			return errMalformed
+		}
+		if misc&perfRecordMiscMmapBuildID != 0 && b[0] <= 20 {
+			m.buildID = hex.EncodeToString(b[4 : 4+int(b[0])])
+		}
+		b = b[32:]
+	}
+	m.file = cString(b)
+	if m.buildID == "" {
+		m.buildID = pp.buildIDs[m.file]
+	}
+	proc := pp.process(pid)
+	proc.maps = append(proc.maps, m)
+	proc.locs = make(map[uint64]*Location)
+	return nil
+}
+
+// parseComm decodes a PERF_RECORD_COMM record, which names a thread.
+// If the record was generated by an exec, the address space of the
+// process is reset.
+func (pp *perfParser) parseComm(b []byte, misc uint16) error {
+	if len(b) < 8 {
+		return errMalformed
+	}
+	pid, tid := pp.order.Uint32(b), pp.order.Uint32(b[4:])
+	pp.comms[tid] = cString(b[8:])
+	if misc&perfRecordMiscCommExec != 0 && pid == tid {
+		delete(pp.procs, pid)
+	}
+	return nil
+}
+
+// parseFork decodes a PERF_RECORD_FORK record. A new process inherits
+// the address space and the name of its parent.
+func (pp *perfParser) parseFork(b []byte) error {
+	if len(b) < 16 {
+		return errMalformed
+	}
+	pid, ppid := pp.order.Uint32(b), pp.order.Uint32(b[4:])
+	tid, ptid := pp.order.Uint32(b[8:]), pp.order.Uint32(b[12:])
+	if comm, ok := pp.comms[ptid]; ok {
+		if _, ok := pp.comms[tid]; !ok {
+			pp.comms[tid] = comm
+		}
+	}
+	if pid == ppid {
+		return nil
+	}
+	if parent := pp.procs[ppid]; parent != nil {
+		child := pp.process(pid)
+		child.maps = append(append([]*perfMap(nil), parent.maps...), child.maps...)
+		child.locs = make(map[uint64]*Location)
+	}
+	return nil
+}
+
+// perfSample holds the decoded fields of a PERF_RECORD_SAMPLE.
+type perfSample struct {
+	attr      *perfEventAttr
+	ip        uint64
+	pid, tid  uint32
+	time      uint64
+	period    uint64
+	callchain []uint64
+}
+
+// parseSample decodes a PERF_RECORD_SAMPLE and adds it to the profile.
+func (pp *perfParser) parseSample(b []byte) error {
+	s, err := pp.decodeSample(b)
+	if err != nil {
+		return err
+	}
+	if s.attr == nil {
+		// Sample for an unknown event; ignore it.
+		return nil
+	}
+	if s.time != 0 {
+		if pp.minTime == 0 || s.time < pp.minTime {
+			pp.minTime = s.time
+		}
+		if s.time > pp.maxTime {
+			pp.maxTime = s.time
+		}
+	}
+
+	addrs := s.callchain
+	if len(addrs) == 0 {
+		addrs = []uint64{s.ip}
+	}
+	var locs []*Location
+	for _, addr := range addrs {
+		if addr >= perfContextMax {
+			continue
+		}
+		if len(locs) > 0 {
+			// Adjust caller frames by -1 to land on top of the call instruction.
+			addr--
+		}
+		locs = append(locs, pp.location(s.pid, addr))
+	}
+	if len(locs) == 0 {
+		return nil
+	}
+
+	key := perfSampleKey{attr: s.attr.index, pid: s.pid, tid: s.tid}
+	var stack []byte
+	for _, l := range locs {
+		stack = binary.LittleEndian.AppendUint64(stack, l.ID)
+	}
+	key.stack = string(stack)
+
+	sample := pp.samples[key]
+	if sample == nil {
+		sample = &Sample{
+			Location: locs,
+			Value:    make([]int64, len(pp.p.SampleType)),
+			NumLabel: map[string][]int64{
+				"pid": {int64(s.pid)},
+				"tid": {int64(s.tid)},
+			},
+		}
+		if comm, ok := pp.comms[s.tid]; ok {
+			sample.Label = map[string][]string{"comm": {comm}}
+		}
+		pp.samples[key] = sample
+		pp.p.Sample = append(pp.p.Sample, sample)
+	}
+	period := s.period
+	if s.attr.sampleType&perfSamplePeriod == 0 {
+		period = s.attr.samplePeriod
+	}
+	sample.Value[s.attr.index]++
+	sample.Value[s.attr.index+1] += int64(period)
+	return nil
+}
+
+// decodeSample decodes the fields of a PERF_RECORD_SAMPLE up to and
+// including the callchain, as described by the sample type of the
+// event it belongs to.
+func (pp *perfParser) decodeSample(b []byte) (*perfSample, error) {
+	st := pp.attrs[0].sampleType
+	s := &perfSample{}
+	if len(pp.attrs) == 1 {
+		s.attr = pp.attrs[0]
+	}
+	next := func() uint64 {
+		if len(b) < 8 {
+			b = nil
+			return 0
+		}
+		v := pp.order.Uint64(b)
+		b = b[8:]
+		return v
+	}
+	if st&perfSampleIdentifier != 0 {
+		s.attr = pp.attrByID[next()]
+	}
+	if st&perfSampleIP != 0 {
+		s.ip = next()
+	}
+	if st&perfSampleTID != 0 {
+		if len(b) < 8 {
+			return nil, errMalformed
+		}
+		s.pid, s.tid = pp.order.Uint32(b), pp.order.Uint32(b[4:])
+		b = b[8:]
+	}
+	if st&perfSampleTime != 0 {
+		s.time = next()
+	}
+	if st&perfSampleAddr != 0 {
+		next()
+	}
+	if st&perfSampleID != 0 {
+		id := next()
+		if st&perfSampleIdentifier == 0 && len(pp.attrs) > 1 {
+			s.attr = pp.attrByID[id]
+		}
+	}
+	if st&perfSampleStreamID != 0 {
+		next()
+	}
+	if st&perfSampleCPU != 0 {
+		next()
+	}
+	if st&perfSamplePeriod != 0 {
+		s.period = next()
+	}
+	if st&perfSampleRead != 0 {
+		pp.skipReadFormat(pp.attrs[0].readFormat, next)
+	}
+	if st&perfSampleCallchain != 0 {
+		n := next()
+		if b == nil || n > uint64(len(b)/8) {
+			return nil, errMalformed
+		}
+		s.callchain = make([]uint64, n)
+		for i := range s.callchain {
+			s.callchain[i] = next()
+		}
+	}
+	if b == nil {
+		return nil, errMalformed
+	}
+	return s, nil
+}
+
+// skipReadFormat consumes the counter values embedded in a sample by
+// PERF_SAMPLE_READ, whose layout depends on the read format.
+func (pp *perfParser) skipReadFormat(rf uint64, next func() uint64) {
+	perValue := 1
+	if rf&perfFormatID != 0 {
+		perValue++
+	}
+	if rf&perfFormatLost != 0 {
+		perValue++
+	}
+	n := uint64(1)
+	if rf&perfFormatGroup != 0 {
+		n = next()
+	}
+	if rf&perfFormatTotalTimeEnabled != 0 {
+		next()
+	}
+	if rf&perfFormatTotalTimeRunning != 0 {
+		next()
+	}
+	for i := uint64(0); i < n*uint64(perValue); i++ {
+		next()
+	}
+}
+
+// location returns the location for addr in the address space of pid,
+// resolving it through the mmap records seen so far.
+func (pp *perfParser) location(pid uint32, addr uint64) *Location {
+	proc := pp.process(pid)
+	if l := proc.locs[addr]; l != nil {
+		return l
+	}
+	var m *Mapping
+	if pm := findPerfMap(proc.maps, addr); pm != nil {
+		m = pp.mapping(pm)
+	} else if kernel := pp.procs[perfKernelPID]; kernel != nil {
+		if pm := findPerfMap(kernel.maps, addr); pm != nil {
+			m = pp.mapping(pm)
+		}
+	}
+	pp.nextLocID++
+	l := &Location{ID: pp.nextLocID, Mapping: m, Address: addr}
+	pp.p.Location = append(pp.p.Location, l)
+	proc.locs[addr] = l
+	return l
+}
+
+// findPerfMap returns the most recent map containing addr.
+func findPerfMap(maps []*perfMap, addr uint64) *perfMap {
+	for i := len(maps) - 1; i >= 0; i-- {
+		if m := maps[i]; m.start <= addr && addr < m.limit {
+			return m
+		}
+	}
+	return nil
+}
+
+// mapping returns the profile mapping for pm, shared across processes
+// that mapped the same file at the same address.
+func (pp *perfParser) mapping(pm *perfMap) *Mapping {
+	if m := pp.mappings[*pm]; m != nil {
+		return m
+	}
+	m := &Mapping{
+		ID:      uint64(len(pp.p.Mapping) + 1),
+		Start:   pm.start,
+		Limit:   pm.limit,
+		Offset:  pm.offset,
+		File:    pm.file,
+		BuildID: pm.buildID,
+	}
+	const kernelPrefix = "[kernel.kallsyms]"
+	if len(m.File) > len(kernelPrefix) && m.File[:len(kernelPrefix)] == kernelPrefix {
+		m.KernelRelocationSymbol = m.File[len(kernelPrefix):]
+	}
+	pp.mappings[*pm] = m
+	pp.p.Mapping = append(pp.p.Mapping, m)
+	return m
+}
+
+// finish completes the profile once all records have been decoded.
+func (pp *perfParser) finish() {
+	p := pp.p
+	if pp.maxTime > pp.minTime {
+		p.DurationNanos = int64(pp.maxTime - pp.minTime)
+	}
+	// Order mappings by number of samples so that the heuristics in
+	// massageMappings pick the most sampled binary as the main one.
+	counts := make(map[*Mapping]int)
+	for _, s := range p.Sample {
+		for _, l := range s.Location {
+			if l.Mapping != nil {
+				counts[l.Mapping]++
+			}
+		}
+	}
+	sort.SliceStable(p.Mapping, func(i, j int) bool {
+		return counts[p.Mapping[i]] > counts[p.Mapping[j]]
+	})
+	p.massageMappings()
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// perfDataBuilder assembles a little-endian perf.data file for tests.
+type perfDataBuilder struct {
+	sampleType uint64
+	period     uint64
+	records    bytes.Buffer
+	buildIDs   bytes.Buffer
+}
+
+func (b *perfDataBuilder) record(typ uint32, misc uint16, body []byte) {
+	var h [8]byte
+	binary.LittleEndian.PutUint32(h[0:], typ)
+	binary.LittleEndian.PutUint16(h[4:], misc)
+	binary.LittleEndian.PutUint16(h[6:], uint16(8+len(body)))
+	b.records.Write(h[:])
+	b.records.Write(body)
+}
+
+// padString returns s NUL-terminated and padded to a multiple of 8 bytes.
+func padString(s string) []byte {
+	buf := append([]byte(s), 0)
+	for len(buf)%8 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func u32s(vs ...uint32) []byte {
+	var buf []byte
+	for _, v := range vs {
+		buf = binary.LittleEndian.AppendUint32(buf, v)
+	}
+	return buf
+}
+
+func u64s(vs ...uint64) []byte {
+	var buf []byte
+	for _, v := range vs {
+		buf = binary.LittleEndian.AppendUint64(buf, v)
+	}
+	return buf
+}
+
+func (b *perfDataBuilder) mmap(pid uint32, start, length, pgoff uint64, file string) {
+	body := append(u32s(pid, pid), u64s(start, length, pgoff)...)
+	b.record(perfRecordMmap, 0, append(body, padString(file)...))
+}
+
+func (b *perfDataBuilder) comm(pid, tid uint32, name string) {
+	b.record(perfRecordComm, 0, append(u32s(pid, tid), padString(name)...))
+}
+
+func (b *perfDataBuilder) sample(pid, tid uint32, time, period uint64, stack ...uint64) {
+	body := u64s(stack[0])
+	body = append(body, u32s(pid, tid)...)
+	body = append(body, u64s(time, period, uint64(len(stack)))...)
+	body = append(body, u64s(stack...)...)
+	b.record(perfRecordSample, 0, body)
+}
+
+func (b *perfDataBuilder) buildID(file string, id []byte) {
+	body := u32s(0)
+	var padded [24]byte
+	copy(padded[:], id)
+	padded[20] = byte(len(id))
+	body = append(body, padded[:]...)
+	body = append(body, padString(file)...)
+	var h [8]byte
+	binary.LittleEndian.PutUint16(h[4:], perfRecordMiscBuildIDSize)
+	binary.LittleEndian.PutUint16(h[6:], uint16(8+len(body)))
+	b.buildIDs.Write(h[:])
+	b.buildIDs.Write(body)
+}
+
+func (b *perfDataBuilder) bytes() []byte {
+	const attrSize = 64 + 16
+	attrsOffset := uint64(perfFileHeaderSize)
+	idsOffset := attrsOffset + attrSize
+	dataOffset := idsOffset + 8
+	dataSize := uint64(b.records.Len())
+	featOffset := dataOffset + dataSize + 16
+
+	var buf bytes.Buffer
+	buf.WriteString(perfMagic)
+	buf.Write(u64s(perfFileHeaderSize, attrSize))
+	buf.Write(u64s(attrsOffset, attrSize, dataOffset, dataSize, 0, 0))
+	buf.Write(u64s(1<<perfHeaderBuildID, 0, 0, 0))
+
+	attr := make([]byte, 64)
+	binary.LittleEndian.PutUint32(attr[0:], perfTypeHardware)
+	binary.LittleEndian.PutUint32(attr[4:], 64)
+	binary.LittleEndian.PutUint64(attr[8:], 0) // cycles
+	binary.LittleEndian.PutUint64(attr[16:], b.period)
+	binary.LittleEndian.PutUint64(attr[24:], b.sampleType)
+	buf.Write(attr)
+	buf.Write(u64s(idsOffset, 8))
+	buf.Write(u64s(42))
+	buf.Write(b.records.Bytes())
+	buf.Write(u64s(featOffset, uint64(b.buildIDs.Len())))
+	buf.Write(b.buildIDs.Bytes())
+	return buf.Bytes()
+}
+
+func TestParsePerfData(t *testing.T) {
+	b := &perfDataBuilder{
+		sampleType: perfSampleIP | perfSampleTID | perfSampleTime | perfSamplePeriod | perfSampleCallchain,
+		period:     1000,
+	}
+	b.buildID("/bin/app", []byte{0xab, 0xcd, 0xef})
+	b.mmap(perfKernelPID, 0xffffffff81000000, 0x1000000, 0xffffffff81000000, "[kernel.kallsyms]_text")
+	b.mmap(100, 0x400000, 0x10000, 0, "/bin/app")
+	b.mmap(100, 0x7f0000000000, 0x20000, 0x1000, "/lib/libc.so.6")
+	b.comm(100, 101, "worker")
+	const userCtx = ^uint64(0) - 512 + 1
+	b.sample(100, 101, 1000, 2000, userCtx, 0x400100, 0x7f0000000200, 0x400050)
+	b.sample(100, 101, 3000, 3000, userCtx, 0x400100, 0x7f0000000200, 0x400050)
+	b.sample(100, 101, 5000, 1000, 0xffffffff81000100, userCtx, 0x400200)
+
+	p, err := ParseData(b.bytes())
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+
+	if got, want := len(p.SampleType), 2; got != want {
+		t.Fatalf("got %d sample types, want %d", got, want)
+	}
+	if st := p.SampleType[1]; st.Type != "cycles" || st.Unit != "count" {
+		t.Errorf("got sample type %s/%s, want cycles/count", st.Type, st.Unit)
+	}
+	if p.Period != 1000 {
+		t.Errorf("got period %d, want 1000", p.Period)
+	}
+	if p.DurationNanos != 4000 {
+		t.Errorf("got duration %d, want 4000", p.DurationNanos)
+	}
+
+	if got, want := len(p.Sample), 2; got != want {
+		t.Fatalf("got %d samples, want %d", got, want)
+	}
+	s := p.Sample[0]
+	if s.Value[0] != 2 || s.Value[1] != 5000 {
+		t.Errorf("got values %v, want [2 5000]", s.Value)
+	}
+	if got := s.Label["comm"]; len(got) != 1 || got[0] != "worker" {
+		t.Errorf("got comm label %v, want [worker]", got)
+	}
+	if got := s.NumLabel["tid"]; len(got) != 1 || got[0] != 101 {
+		t.Errorf("got tid label %v, want [101]", got)
+	}
+	wantAddrs := []uint64{0x400100, 0x7f00000001ff, 0x40004f}
+	if len(s.Location) != len(wantAddrs) {
+		t.Fatalf("got %d locations, want %d", len(s.Location), len(wantAddrs))
+	}
+	for i, l := range s.Location {
+		if l.Address != wantAddrs[i] {
+			t.Errorf("location %d: got address %#x, want %#x", i, l.Address, wantAddrs[i])
+		}
+	}
+	if m := s.Location[0].Mapping; m == nil || m.File != "/bin/app" || m.BuildID != "abcdef" {
+		t.Errorf("got mapping %v for main binary location", m)
+	}
+	if m := s.Location[1].Mapping; m == nil || m.File != "/lib/libc.so.6" || m.Offset != 0x1000 {
+		t.Errorf("got mapping %v for library location", m)
+	}
+	if m := p.Sample[1].Location[0].Mapping; m == nil || m.KernelRelocationSymbol != "_text" {
+		t.Errorf("got mapping %v for kernel location", m)
+	}
+	if p.Mapping[0].File != "/bin/app" {
+		t.Errorf("got main mapping %q, want /bin/app", p.Mapping[0].File)
+	}
+}
+
+func TestParsePerfDataMalformed(t *testing.T) {
+	b := &perfDataBuilder{sampleType: perfSampleIP, period: 1}
+	b.sample(1, 1, 0, 0, 0x1000)
+	data := b.bytes()
+	for _, n := range []int{8, 16, perfFileHeaderSize, len(data) - 20} {
+		if _, err := ParseData(data[:n]); err == nil {
+			t.Errorf("ParseData of %d byte prefix: got no error", n)
+		}
+	}
+}
@@ -188,8 +188,14 @@ func ParseData(data []byte) (*Profile, error) {
 			return nil, fmt.Errorf("decompressing profile: %v", err)
 		}
 	}
-	if p, err = ParseUncompressed(data); err != nil && err != errNoData && err != errConcatProfile {
-		p, err = parseLegacy(data)
+	if isPerfData(data) {
+		p, err = parsePerfData(data)
+	} else if isJFR(data) {
+		p, err = parseJFR(data)
+	} else if p, err = ParseUncompressed(data); err != nil && err != errNoData && err != errConcatProfile {
+		if p, err = parseLegacy(data); err == errUnrecognized {
+			p, err = parseCallgrind(data)
+		}
 	}
 
 	if err != nil {
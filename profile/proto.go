@@ -143,6 +143,13 @@ func encodeString(b *buffer, tag int, x string) {
 	b.data = append(b.data, x...)
 }
 
+func encodeStringOpt(b *buffer, tag int, x string) {
+	if x == "" {
+		return
+	}
+	encodeString(b, tag, x)
+}
+
 func encodeStrings(b *buffer, tag int, x []string) {
 	for _, s := range x {
 		encodeString(b, tag, s)
@@ -354,6 +361,14 @@ func decodeStrings(b *buffer, x *[]string) error {
 	return nil
 }
 
+func decodeBytes(b *buffer, x *[]byte) error {
+	if err := checkType(b, 2); err != nil {
+		return err
+	}
+	*x = append([]byte(nil), b.data...)
+	return nil
+}
+
 func decodeBool(b *buffer, x *bool) error {
 	if err := checkType(b, 0); err != nil {
 		return err